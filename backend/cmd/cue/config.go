@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// resolveConfig fills in flags not given on the command line, first from
+// CUE_* environment variables (CUE_TOKEN_TTL for -token-ttl) and then from
+// the -config file, which may itself come from CUE_CONFIG. Precedence is
+// flags > environment > config file > defaults.
+func resolveConfig(fs *flag.FlagSet, getenv func(string) string) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] {
+			return
+		}
+		key := envName(f.Name)
+		v := getenv(key)
+		if v == "" {
+			return
+		}
+		if setErr := fs.Set(f.Name, v); setErr != nil {
+			err = fmt.Errorf("%s=%q: invalid value for -%s: %w", key, v, f.Name, setErr)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if path := fs.Lookup("config").Value.String(); path != "" {
+		return applyConfigFile(fs, path)
+	}
+	return nil
+}
+
+// envName returns the environment variable for a flag: -token-ttl is
+// CUE_TOKEN_TTL.
+func envName(flagName string) string {
+	return "CUE_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyConfigFile sets flags from a JSON object whose keys are flag names,
+// e.g. {"addr": ":8443", "token-ttl": "48h", "rate-limit": 5}. Flags already
+// set, on the command line or from the environment, keep their values.
+// Unknown keys, nested values, and values a flag rejects are errors, so a
+// typo cannot be silently ignored.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var values map[string]any
+	if err := dec.Decode(&values); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	// Sorted so the first error reported is deterministic
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if key == "config" || fs.Lookup(key) == nil {
+			return fmt.Errorf("%s: unknown key %q", path, key)
+		}
+		var s string
+		switch v := values[key].(type) {
+		case string:
+			s = v
+		case json.Number:
+			s = v.String()
+		case bool:
+			s = fmt.Sprint(v)
+		default:
+			return fmt.Errorf("%s: %q must be a string, number, or boolean", path, key)
+		}
+		if explicit[key] {
+			continue
+		}
+		if err := fs.Set(key, s); err != nil {
+			return fmt.Errorf("%s: %q: %w", path, key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFlags registers flags like main's, covering each value type.
+func testFlags() (*flag.FlagSet, *string, *string, *time.Duration, *float64, *bool) {
+	fs := flag.NewFlagSet("cue", flag.ContinueOnError)
+	fs.String("config", "", "")
+	addr := fs.String("addr", ":31337", "")
+	db := fs.String("db", "cue.db", "")
+	ttl := fs.Duration("token-ttl", 720*time.Hour, "")
+	rate := fs.Float64("rate-limit", 10, "")
+	proxy := fs.Bool("trust-proxy", false, "")
+	return fs, addr, db, ttl, rate, proxy
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cue.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApplyConfigFile(t *testing.T) {
+	path := writeConfig(t, `{
+		"addr": ":8443",
+		"db": "/var/lib/cue/cue.db",
+		"token-ttl": "48h",
+		"rate-limit": 2.5,
+		"trust-proxy": true
+	}`)
+
+	fs, addr, db, ttl, rate, proxy := testFlags()
+	// Command-line flags win over the file
+	if err := fs.Parse([]string{"-config", path, "-db", "override.db"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfigFile(fs, path); err != nil {
+		t.Fatalf("applyConfigFile: %v", err)
+	}
+
+	if *addr != ":8443" || *db != "override.db" || *ttl != 48*time.Hour || *rate != 2.5 || !*proxy {
+		t.Errorf("addr = %q, db = %q, token-ttl = %v, rate-limit = %v, trust-proxy = %v", *addr, *db, *ttl, *rate, *proxy)
+	}
+}
+
+func TestApplyConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"unknown key", `{"adr": ":8443"}`, `unknown key "adr"`},
+		{"config key", `{"config": "other.json"}`, `unknown key "config"`},
+		{"nested value", `{"addr": {"host": "x"}}`, "must be a string, number, or boolean"},
+		{"bad duration", `{"token-ttl": "soon"}`, `"token-ttl"`},
+		{"not an object", `["addr"]`, "cannot unmarshal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, _, _, _, _, _ := testFlags()
+			err := applyConfigFile(fs, writeConfig(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+
+	fs, _, _, _, _, _ := testFlags()
+	if err := applyConfigFile(fs, filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+// env returns a getenv func backed by a map.
+func env(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+func TestResolveConfigPrecedence(t *testing.T) {
+	path := writeConfig(t, `{"addr": ":8443", "db": "file.db", "token-ttl": "48h"}`)
+
+	fs, addr, db, ttl, rate, proxy := testFlags()
+	if err := fs.Parse([]string{"-db", "flag.db"}); err != nil {
+		t.Fatal(err)
+	}
+	err := resolveConfig(fs, env(map[string]string{
+		"CUE_CONFIG":      path,
+		"CUE_DB":          "env.db",
+		"CUE_TOKEN_TTL":   "2h",
+		"CUE_TRUST_PROXY": "true",
+	}))
+	if err != nil {
+		t.Fatalf("resolveConfig: %v", err)
+	}
+
+	// flag > env > file > default
+	if *db != "flag.db" {
+		t.Errorf("db = %q, want flag value", *db)
+	}
+	if *ttl != 2*time.Hour || !*proxy {
+		t.Errorf("token-ttl = %v, trust-proxy = %v, want env values", *ttl, *proxy)
+	}
+	if *addr != ":8443" {
+		t.Errorf("addr = %q, want file value", *addr)
+	}
+	if *rate != 10 {
+		t.Errorf("rate-limit = %v, want default", *rate)
+	}
+}
+
+func TestResolveConfigNoEnv(t *testing.T) {
+	fs, addr, _, ttl, _, _ := testFlags()
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := resolveConfig(fs, env(nil)); err != nil {
+		t.Fatalf("resolveConfig: %v", err)
+	}
+	if *addr != ":31337" || *ttl != 720*time.Hour {
+		t.Errorf("addr = %q, token-ttl = %v, want defaults", *addr, *ttl)
+	}
+}
+
+func TestResolveConfigMalformedEnv(t *testing.T) {
+	tests := []struct {
+		key, value string
+	}{
+		{"CUE_TOKEN_TTL", "soon"},
+		{"CUE_TOKEN_TTL", "30"},
+		{"CUE_RATE_LIMIT", "fast"},
+		{"CUE_TRUST_PROXY", "maybe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			fs, _, _, _, _, _ := testFlags()
+			if err := fs.Parse(nil); err != nil {
+				t.Fatal(err)
+			}
+			err := resolveConfig(fs, env(map[string]string{tt.key: tt.value}))
+			if err == nil || !strings.Contains(err.Error(), tt.key) {
+				t.Errorf("err = %v, want it to name %s", err, tt.key)
+			}
+		})
+	}
+
+	// A malformed variable is ignored when the flag is given explicitly
+	fs, _, _, ttl, _, _ := testFlags()
+	if err := fs.Parse([]string{"-token-ttl", "1h"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := resolveConfig(fs, env(map[string]string{"CUE_TOKEN_TTL": "soon"})); err != nil {
+		t.Errorf("resolveConfig: %v", err)
+	}
+	if *ttl != time.Hour {
+		t.Errorf("token-ttl = %v, want 1h", *ttl)
+	}
+}
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"embed"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/alanp/cue/internal/api"
@@ -34,20 +40,99 @@ func main() {
 		os.Exit(0)
 	}
 
+	flag.String("config", "", "JSON file of flag values, keyed by flag name (command-line flags and CUE_* variables take precedence)")
 	addr := flag.String("addr", ":"+DefaultPort, "listen address")
 	dbPath := flag.String("db", "cue.db", "database path")
 	certFile := flag.String("cert", "", "TLS certificate file")
 	keyFile := flag.String("key", "", "TLS key file")
 	caFile := flag.String("ca", "", "CA certificate for client verification (enables auth)")
+	crlFile := flag.String("crl", "", "CRL file of revoked client certificates (reloaded on SIGHUP)")
+	cnAllowlist := flag.String("cn-allowlist", "", "file of client certificate CNs allowed to authenticate, one per line (reloaded on SIGHUP)")
+	adminOU := flag.String("admin-ou", "", "client certificate organizational unit required for /api/admin/* and token management (empty allows any cert)")
+	certIdentity := flag.String("cert-identity", string(auth.IdentityCN), "client certificate field naming the user: cn, email (first email SAN), or uri-san (first URI SAN)")
+	accessLog := flag.String("access-log", "stderr", "access log destination: stderr, stdout, a file path, or off")
 	securityLog := flag.String("security-log", "security.log", "security audit log file")
+	securityLogMaxSize := flag.Int64("security-log-max-size", 0, "rotate the security log at this many bytes (0 disables)")
+	securityLogBackups := flag.Int("security-log-backups", 5, "rotated security logs to keep")
 	tokenTTL := flag.Duration("token-ttl", 720*time.Hour, "default token expiration")
 	tokenMaxTTL := flag.Duration("token-max-ttl", 8760*time.Hour, "maximum token expiration")
+	maxTokensPerUser := flag.Int("max-tokens-per-user", api.DefaultMaxTokensPerUser, "max unexpired tokens per user (0 = unlimited)")
+	tokenCleanupInterval := flag.Duration("token-cleanup-interval", time.Hour, "how often to delete expired tokens (0 disables)")
+	tokenExpiryWarning := flag.Duration("token-expiry-warning", auth.DefaultTokenExpiryWarning, "warn token clients this long before expiry (negative disables)")
+	tokenFormat := flag.String("token-format", string(auth.TokenFormatCue), "encoding for new API tokens: cue, or jwt for a standard HS256 JWT; both are accepted")
+	tokenLeeway := flag.Duration("token-leeway", auth.DefaultTokenLeeway, "clock skew tolerated in token issue and expiry times (negative disables)")
+	maxVersions := flag.Int("max-versions", store.DefaultMaxVersions, "item versions retained per item (0 keeps all)")
+	dbMaxOpenConns := flag.Int("db-max-open-conns", 0, "maximum open database connections (0 = unlimited)")
+	dbMaxIdleConns := flag.Int("db-max-idle-conns", 0, "idle database connections kept open (0 = database/sql default of 2)")
+	dbConnMaxLifetime := flag.Duration("db-conn-max-lifetime", 0, "close database connections after this long (0 keeps them)")
+	vacuumInterval := flag.Duration("vacuum-interval", 0, "how often to VACUUM the database, blocking writes while it runs (0 disables)")
+	backupDir := flag.String("backup-dir", "backups", "directory for database backups")
+	rateLimit := flag.Float64("rate-limit", 10, "sustained requests per second per user or IP (0 disables)")
+	rateBurst := flag.Int("rate-burst", 40, "maximum request burst per user or IP")
+	corsOrigins := flag.String("cors-origins", "", "comma-separated origins allowed cross-origin API access, or * (empty disables)")
+	gzipMinSize := flag.Int("gzip-min-size", api.DefaultGzipMinSize, "compress API responses of at least this many bytes (negative disables)")
+	requestTimeout := flag.Duration("request-timeout", api.DefaultRequestTimeout, "cancel API requests whose store calls run longer than this (0 disables)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 15*time.Second, "time to drain in-flight requests on shutdown")
+	maxContentBytes := flag.Int("max-content-bytes", api.DefaultMaxContentBytes, "largest item content accepted, in bytes")
+	maxTitleLength := flag.Int("max-title-length", api.DefaultMaxTitleLength, "longest item title accepted, in characters")
+	maxImportBytes := flag.Int("max-import-bytes", api.DefaultMaxImportBytes, "largest import request body accepted, in bytes")
+	userItemQuota := flag.Int("user-item-quota", 0, "max live items per authenticated user (0 = unlimited; ignored in single-user mode)")
+	searchDiacritics := flag.String("search-diacritics", "fold", "search matching of accented letters: fold (cafe finds café) or exact")
+	feedSize := flag.Int("feed-size", api.DefaultFeedSize, "entries in the Atom feed at /api/feed.atom")
+	defaultListLimit := flag.Int("default-list-limit", store.DefaultListLimit, "items per page when GET /api/items has no limit")
+	defaultSearchLimit := flag.Int("default-search-limit", store.DefaultSearchLimit, "results per page when GET /api/search has no limit")
+	idempotencyWindow := flag.Duration("idempotency-window", api.DefaultIdempotencyWindow, "how long an Idempotency-Key on item creation is remembered")
+	maxListLimit := flag.Int("max-list-limit", api.MaxPageLimit, "largest page size for list and search; bigger limits are clamped")
+	readOnly := flag.Bool("read-only", false, "reject every API request that would change data with 403 and open the database read-only")
+	allowQueryToken := flag.Bool("allow-query-token", false, "accept API tokens in an access_token query parameter on every path, not just the feed")
+	healthDetail := flag.String("health-detail", api.HealthMinimal, "health endpoint payload: minimal or full")
 	flag.Parse()
 
+	if err := resolveConfig(flag.CommandLine, os.Getenv); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
 	// Validate flag combinations
 	if *caFile != "" && (*certFile == "" || *keyFile == "") {
 		log.Fatal("Error: -ca requires -cert and -key for mTLS")
 	}
+	if *crlFile != "" && *caFile == "" {
+		log.Fatal("Error: -crl requires -ca")
+	}
+	if *cnAllowlist != "" && *caFile == "" {
+		log.Fatal("Error: -cn-allowlist requires -ca")
+	}
+	if *adminOU != "" && *caFile == "" {
+		log.Fatal("Error: -admin-ou requires -ca")
+	}
+	identityField, err := auth.ParseIdentityField(*certIdentity)
+	if err != nil {
+		log.Fatalf("Error: -cert-identity: %v", err)
+	}
+	tokenFmt, err := auth.ParseTokenFormat(*tokenFormat)
+	if err != nil {
+		log.Fatalf("Error: -token-format: %v", err)
+	}
+	if *healthDetail != api.HealthMinimal && *healthDetail != api.HealthFull {
+		log.Fatalf("Error: -health-detail must be %q or %q", api.HealthMinimal, api.HealthFull)
+	}
+	if *maxContentBytes <= 0 || *maxTitleLength <= 0 || *maxImportBytes <= 0 {
+		log.Fatal("Error: -max-content-bytes, -max-title-length, and -max-import-bytes must be positive")
+	}
+	tokenizers := map[string]string{"fold": store.TokenizerFolded, "exact": store.TokenizerExact}
+	tokenizer, ok := tokenizers[*searchDiacritics]
+	if !ok {
+		log.Fatal(`Error: -search-diacritics must be "fold" or "exact"`)
+	}
+	if *feedSize <= 0 {
+		log.Fatal("Error: -feed-size must be positive")
+	}
+	if *userItemQuota < 0 {
+		log.Fatal("Error: -user-item-quota must not be negative")
+	}
+	if *maxTokensPerUser < 0 {
+		log.Fatal("Error: -max-tokens-per-user must not be negative")
+	}
 
 	// Ensure db directory exists
 	if dir := filepath.Dir(*dbPath); dir != "." && dir != "" {
@@ -56,17 +141,28 @@ func main() {
 		}
 	}
 
-	s, err := store.New(*dbPath)
+	s, err := store.NewWithConfig(*dbPath, store.Config{
+		MaxOpenConns:    *dbMaxOpenConns,
+		MaxIdleConns:    *dbMaxIdleConns,
+		ConnMaxLifetime: *dbConnMaxLifetime,
+		ReadOnly:        *readOnly,
+	})
 	if err != nil {
 		log.Fatalf("Failed to open database: %v", err)
 	}
 	defer s.Close()
+	s.SetMaxVersions(*maxVersions)
+	if err := s.SetTokenizer(tokenizer); err != nil {
+		log.Fatalf("Failed to configure search tokenizer: %v", err)
+	}
 
 	// Auth configuration
 	authEnabled := *caFile != ""
 	var authCfg api.AuthConfig
 	var secLogger *auth.FileSecurityLogger
 	var caCertPool *x509.CertPool
+	var revocation *auth.RevocationChecker
+	var allowlist *auth.CNAllowlist
 
 	if authEnabled {
 		// Load CA certificate (once, reused for TLS config)
@@ -79,6 +175,21 @@ func main() {
 			log.Fatal("Failed to parse CA certificate")
 		}
 
+		if *crlFile != "" {
+			revocation, err = auth.NewRevocationChecker(*crlFile)
+			if err != nil {
+				log.Fatalf("Failed to load CRL: %v", err)
+			}
+			log.Printf("Checking client certificates against CRL %s", *crlFile)
+		}
+		if *cnAllowlist != "" {
+			allowlist, err = auth.NewCNAllowlist(*cnAllowlist)
+			if err != nil {
+				log.Fatalf("Failed to load CN allowlist: %v", err)
+			}
+			log.Printf("Restricting client certificates to CN allowlist %s", *cnAllowlist)
+		}
+
 		// Get or create token secret
 		secret, err := s.GetOrCreateTokenSecret()
 		if err != nil {
@@ -86,18 +197,22 @@ func main() {
 		}
 
 		// Setup security logger
-		secLogger, err = auth.NewFileSecurityLogger(*securityLog)
+		secLogger, err = auth.NewRotatingFileSecurityLogger(*securityLog, *securityLogMaxSize, *securityLogBackups)
 		if err != nil {
 			log.Fatalf("Failed to open security log: %v", err)
 		}
 		defer secLogger.Close()
 
 		authCfg = api.AuthConfig{
-			Enabled:    true,
-			Secret:     secret,
-			DefaultTTL: *tokenTTL,
-			MaxTTL:     *tokenMaxTTL,
-			Logger:     secLogger,
+			Enabled:          true,
+			Secret:           secret,
+			DefaultTTL:       *tokenTTL,
+			MaxTTL:           *tokenMaxTTL,
+			Logger:           secLogger,
+			AdminOU:          *adminOU,
+			MaxTokensPerUser: *maxTokensPerUser,
+			TokenFormat:      tokenFmt,
+			TokenLeeway:      *tokenLeeway,
 		}
 
 		secLogger.LogServerStart("authenticated", *caFile)
@@ -106,17 +221,44 @@ func main() {
 		log.Printf("WARNING: Authentication disabled - running in development mode")
 	}
 
-	apiServer := api.NewWithAuth(s, authCfg, version)
+	apiServer := api.NewWithConfig(s, authCfg, api.Config{
+		HealthDetail:    *healthDetail,
+		BackupDir:       *backupDir,
+		UserItemQuota:   *userItemQuota,
+		MaxContentBytes: *maxContentBytes,
+		MaxTitleLength:  *maxTitleLength,
+		MaxImportBytes:  *maxImportBytes,
+		FeedSize:        *feedSize,
+
+		DefaultListLimit:   *defaultListLimit,
+		DefaultSearchLimit: *defaultSearchLimit,
+		MaxListLimit:       *maxListLimit,
+		IdempotencyWindow:  *idempotencyWindow,
+	}, version)
 
 	// Create main mux
 	mux := http.NewServeMux()
 
+	// The deadline starts once a request has cleared auth and rate limiting
+	var apiHandler http.Handler = api.Timeout(*requestTimeout)(apiServer)
+	if *readOnly {
+		apiHandler = api.ReadOnly(apiHandler)
+		log.Printf("Read-only mode: API writes are rejected")
+	}
+
+	// Rate limiting runs inside auth so buckets are keyed by CN where known
+	apiHandler = auth.RateLimit(auth.RateLimitConfig{
+		Rate:  *rateLimit,
+		Burst: *rateBurst,
+	})(apiHandler)
+
 	// Apply auth middleware if enabled
-	var apiHandler http.Handler = apiServer
 	if authEnabled {
-		tokenValidator := func(token string) (string, error) {
+		// The database expiry check must allow the same skew as the claims
+		leeway := auth.EffectiveTokenLeeway(*tokenLeeway)
+		tokenValidator := func(token, sourceIP string) (string, error) {
 			hash := auth.HashToken(token)
-			return s.ValidateTokenHash(hash)
+			return s.ValidateTokenHashWithLeeway(hash, sourceIP, leeway)
 		}
 
 		middlewareCfg := auth.MiddlewareConfig{
@@ -124,15 +266,36 @@ func main() {
 			TokenValidator: tokenValidator,
 			Logger:         secLogger,
 			AuthEnabled:    true,
+			Revocation:     revocation,
+			IdentityField:  identityField,
+			Allowlist:      allowlist,
+
+			// Feed readers cannot send an Authorization header
+			QueryTokenPaths: []string{"/api/feed.atom"},
+			AllowQueryToken: *allowQueryToken,
+
+			TokenExpiryWarning: *tokenExpiryWarning,
+			TokenLeeway:        *tokenLeeway,
 		}
 
-		apiHandler = auth.Middleware(middlewareCfg)(apiServer)
+		apiHandler = auth.Middleware(middlewareCfg)(apiHandler)
 	}
 
 	// Public API routes (no auth required - used by load balancers)
 	mux.HandleFunc("GET /api/health", apiServer.HandleHealth)
+	mux.HandleFunc("GET /api/ready", apiServer.HandleReady)
 	mux.HandleFunc("GET /api/status", apiServer.HandleStatus)
 
+	if *gzipMinSize >= 0 {
+		apiHandler = api.Gzip(*gzipMinSize)(apiHandler)
+	}
+
+	// CORS wraps auth: browser preflights carry no credentials
+	apiHandler = api.CORS(api.CORSConfig{
+		Origins:          api.ParseCORSOrigins(*corsOrigins),
+		AllowCredentials: authEnabled,
+	})(apiHandler)
+
 	// Protected API routes
 	mux.Handle("/api/", apiHandler)
 
@@ -163,9 +326,35 @@ func main() {
 		fileServer.ServeHTTP(w, r)
 	})
 
-	log.Printf("Starting server on %s", *addr)
+	var rootHandler http.Handler = mux
+	if *accessLog != "off" {
+		w, closeLog, err := openAccessLog(*accessLog)
+		if err != nil {
+			log.Fatalf("Failed to open access log: %v", err)
+		}
+		defer closeLog()
+		rootHandler = api.AccessLog(api.AccessLogConfig{Writer: w})(rootHandler)
+	}
+	rootHandler = auth.AssignRequestID(rootHandler)
+	// Outermost, so panics in the middleware above are caught too
+	rootHandler = api.Recover(log.Default())(rootHandler)
+
+	// Track in-flight requests so a timed-out drain can report them
+	var inFlight atomic.Int64
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+		rootHandler.ServeHTTP(w, r)
+	})
 
-	if *certFile != "" && *keyFile != "" {
+	server := &http.Server{
+		Addr:    *addr,
+		Handler: handler,
+	}
+	server.RegisterOnShutdown(apiServer.CloseStreams)
+
+	useTLS := *certFile != "" && *keyFile != ""
+	if useTLS {
 		tlsConfig := &tls.Config{
 			MinVersion: tls.VersionTLS12,
 		}
@@ -175,19 +364,186 @@ func main() {
 			tlsConfig.ClientCAs = caCertPool
 			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
 		}
-
-		server := &http.Server{
-			Addr:      *addr,
-			Handler:   mux,
-			TLSConfig: tlsConfig,
-		}
+		server.TLSConfig = tlsConfig
 
 		log.Printf("TLS enabled with cert=%s key=%s", *certFile, *keyFile)
 		if authEnabled {
 			log.Printf("mTLS enabled: client certificates will be verified against %s", *caFile)
 		}
-		log.Fatal(server.ListenAndServeTLS(*certFile, *keyFile))
+	}
+
+	// Expired tokens are useless but kept forever unless something deletes them
+	stopCleanup := make(chan struct{})
+	var cleanupDone sync.WaitGroup
+	if authEnabled && *tokenCleanupInterval > 0 && !*readOnly {
+		cleanupDone.Add(1)
+		go func() {
+			defer cleanupDone.Done()
+			runTokenCleanup(s, secLogger, *tokenCleanupInterval, auth.EffectiveTokenLeeway(*tokenLeeway), stopCleanup)
+		}()
+	}
+	if *vacuumInterval > 0 && !*readOnly {
+		cleanupDone.Add(1)
+		go func() {
+			defer cleanupDone.Done()
+			runVacuum(s, *vacuumInterval, stopCleanup)
+		}()
+	}
+
+	log.Printf("Starting server on %s", *addr)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if useTLS {
+			serveErr <- server.ListenAndServeTLS(*certFile, *keyFile)
+		} else {
+			serveErr <- server.ListenAndServe()
+		}
+	}()
+
+	// SIGHUP reopens the security log after logrotate moves it and
+	// reloads the CRL and CN allowlist
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for range hupCh {
+			reopenSecurityLog(secLogger, *securityLog)
+			reloadCRL(revocation)
+			reloadAllowlist(allowlist)
+		}
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	var reason string
+	select {
+	case err := <-serveErr:
+		if secLogger != nil {
+			secLogger.LogServerStop("listen error")
+		}
+		log.Fatalf("Server error: %v", err)
+	case sig := <-sigCh:
+		reason = sig.String()
+	}
+
+	// Stop accepting connections and wait for in-flight requests. Deferred
+	// closes for the store and security log run only after this returns.
+	log.Printf("Received %s, draining requests (timeout %s)", reason, *shutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	close(stopCleanup)
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Shutdown timed out with %d request(s) in flight: %v", inFlight.Load(), err)
+		server.Close()
 	} else {
-		log.Fatal(http.ListenAndServe(*addr, mux))
+		log.Printf("Server stopped")
+	}
+
+	cleanupDone.Wait()
+
+	if secLogger != nil {
+		secLogger.LogServerStop(reason)
+	}
+}
+
+// runTokenCleanup deletes tokens more than leeway past their expiry every
+// interval until stop is closed. Passes that delete nothing are not logged.
+func runTokenCleanup(s *store.Store, secLogger *auth.FileSecurityLogger, interval, leeway time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			n, err := s.DeleteExpiredTokensWithLeeway(leeway)
+			if err != nil {
+				log.Printf("Expired token cleanup failed: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("Deleted %d expired token(s)", n)
+				secLogger.LogTokensPurged(n)
+			}
+		}
+	}
+}
+
+// runVacuum compacts the database every interval until stop is closed,
+// logging each run since writes block while it runs.
+func runVacuum(s *store.Store, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			before, _ := s.Size(context.Background())
+			log.Printf("Scheduled vacuum started (database %d bytes)", before)
+			start := time.Now()
+			if err := s.Vacuum(); err != nil {
+				log.Printf("Scheduled vacuum failed: %v", err)
+				continue
+			}
+			after, _ := s.Size(context.Background())
+			log.Printf("Scheduled vacuum finished in %s, reclaimed %d bytes", time.Since(start).Round(time.Millisecond), before-after)
+		}
+	}
+}
+
+// reopenSecurityLog handles SIGHUP. It does nothing when auth is disabled
+// and there is no security log.
+// openAccessLog returns the -access-log destination and a func to close it.
+func openAccessLog(dest string) (io.Writer, func() error, error) {
+	switch dest {
+	case "stderr":
+		return os.Stderr, func() error { return nil }, nil
+	case "stdout":
+		return os.Stdout, func() error { return nil }, nil
+	}
+	f, err := os.OpenFile(dest, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
+
+func reopenSecurityLog(secLogger *auth.FileSecurityLogger, path string) {
+	if secLogger == nil {
+		return
+	}
+	if err := secLogger.Reopen(); err != nil {
+		log.Printf("Failed to reopen security log %s: %v", path, err)
+		return
+	}
+	log.Printf("Reopened security log %s", path)
+}
+
+// reloadCRL handles SIGHUP for the revocation list. It does nothing when
+// no -crl was given.
+func reloadCRL(revocation *auth.RevocationChecker) {
+	if revocation == nil {
+		return
+	}
+	if err := revocation.Reload(); err != nil {
+		log.Printf("Failed to reload CRL %s, keeping previous list: %v", revocation.Path(), err)
+		return
+	}
+	log.Printf("Reloaded CRL %s", revocation.Path())
+}
+
+// reloadAllowlist handles SIGHUP for the CN allowlist. It does nothing
+// when no -cn-allowlist was given.
+func reloadAllowlist(allowlist *auth.CNAllowlist) {
+	if allowlist == nil {
+		return
+	}
+	if err := allowlist.Reload(); err != nil {
+		log.Printf("Failed to reload CN allowlist %s, keeping previous list: %v", allowlist.Path(), err)
+		return
 	}
+	log.Printf("Reloaded CN allowlist %s", allowlist.Path())
 }
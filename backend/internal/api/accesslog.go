@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alanp/cue/internal/auth"
+)
+
+// AccessLogConfig configures AccessLog.
+type AccessLogConfig struct {
+	Writer     io.Writer // Destination for JSON lines
+	TrustProxy bool      // Take the source IP from X-Forwarded-For/X-Real-IP
+}
+
+// accessEntry is one access log line.
+type accessEntry struct {
+	Timestamp  string  `json:"ts"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	DurationMS float64 `json:"duration_ms"`
+	Bytes      int64   `json:"bytes"`
+	SourceIP   string  `json:"ip,omitempty"`
+	UserCN     string  `json:"user,omitempty"`
+	RequestID  string  `json:"request_id,omitempty"`
+}
+
+// AccessLog writes one JSON line per request once it completes. It is
+// separate from the security log, which records authentication events
+// only. The query string is left out so tokens passed as access_token never
+// reach the log. The user comes from the X-Auth-User header that
+// auth.Middleware sets, so AccessLog can wrap it; the request ID comes from
+// auth.AssignRequestID, which must wrap AccessLog.
+func AccessLog(cfg AccessLogConfig) func(http.Handler) http.Handler {
+	var mu sync.Mutex
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &statusRecorder{ResponseWriter: w}
+			start := time.Now()
+			completed := false
+			defer func() {
+				status := rec.status
+				switch {
+				case status != 0:
+				case completed:
+					status = http.StatusOK
+				default:
+					// A panic is unwinding; Recover answers 500
+					status = http.StatusInternalServerError
+				}
+				line, _ := json.Marshal(accessEntry{
+					Timestamp:  start.UTC().Format(time.RFC3339Nano),
+					Method:     r.Method,
+					Path:       r.URL.Path,
+					Status:     status,
+					DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+					Bytes:      rec.bytes,
+					SourceIP:   auth.ExtractSourceIP(r, cfg.TrustProxy),
+					UserCN:     rec.Header().Get("X-Auth-User"),
+					RequestID:  auth.RequestID(r.Context()),
+				})
+				mu.Lock()
+				cfg.Writer.Write(append(line, '\n'))
+				mu.Unlock()
+			}()
+			next.ServeHTTP(rec, r)
+			completed = true
+		})
+	}
+}
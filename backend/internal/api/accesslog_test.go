@@ -0,0 +1,74 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alanp/cue/internal/auth"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	h := AccessLog(AccessLogConfig{Writer: &buf})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Auth-User", "alice")
+		io.WriteString(w, "hello")
+	}))
+
+	req := httptest.NewRequest("GET", "/api/feed.atom?access_token=secret", nil)
+	req.RemoteAddr = "192.0.2.1:4321"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/missing", nil))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), buf.String())
+	}
+	if strings.Contains(lines[0], "secret") {
+		t.Errorf("line leaks the query string: %s", lines[0])
+	}
+
+	var ok, missing accessEntry
+	if err := json.Unmarshal([]byte(lines[0]), &ok); err != nil {
+		t.Fatalf("decode %s: %v", lines[0], err)
+	}
+	json.Unmarshal([]byte(lines[1]), &missing)
+
+	if ok.Method != "GET" || ok.Path != "/api/feed.atom" || ok.Status != http.StatusOK || ok.Bytes != 5 ||
+		ok.SourceIP != "192.0.2.1:4321" || ok.UserCN != "alice" || ok.Timestamp == "" || ok.DurationMS < 0 {
+		t.Errorf("entry = %+v", ok)
+	}
+	if missing.Method != "POST" || missing.Status != http.StatusNotFound || missing.UserCN != "" {
+		t.Errorf("entry = %+v, want POST 404 without a user", missing)
+	}
+}
+
+func TestAccessLogThroughServer(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	var buf bytes.Buffer
+	h := auth.AssignRequestID(AccessLog(AccessLogConfig{Writer: &buf})(srv))
+	req := httptest.NewRequest("POST", "/api/items", strings.NewReader(`{"title": "Logged"}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	var entry accessEntry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("decode %q: %v", buf.String(), err)
+	}
+	if entry.Status != http.StatusCreated || entry.Path != "/api/items" || entry.Bytes == 0 {
+		t.Errorf("entry = %+v, want 201 for /api/items", entry)
+	}
+	if id := w.Header().Get(auth.RequestIDHeader); id == "" || entry.RequestID != id {
+		t.Errorf("request_id = %q, want the X-Request-ID sent to the client (%q)", entry.RequestID, id)
+	}
+}
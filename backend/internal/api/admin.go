@@ -0,0 +1,198 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alanp/cue/internal/auth"
+)
+
+// requireAdmin rejects requests that may not run admin operations. With
+// auth enabled that means certificate users only; tokens cannot be used.
+// what names the operation in the error message.
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request, what string) bool {
+	user := auth.GetUser(r.Context())
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, CodeUnauthorized, "Unauthorized")
+		return false
+	}
+	if s.authCfg.Enabled && user.AuthMethod != "cert" && user.AuthMethod != "none" {
+		writeError(w, http.StatusUnauthorized, CodeCertRequired, "Client certificate required for "+what)
+		return false
+	}
+	return true
+}
+
+type backupResponse struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+// handleBackup writes a hot snapshot of the database into the configured
+// backup directory. Only one backup runs at a time.
+func (s *Server) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r, "backups") {
+		return
+	}
+
+	if !s.backupRunning.CompareAndSwap(false, true) {
+		writeError(w, http.StatusConflict, CodeBackupRunning, "backup already in progress")
+		return
+	}
+	defer s.backupRunning.Store(false)
+
+	if err := os.MkdirAll(s.cfg.BackupDir, 0700); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "failed to create backup directory")
+		return
+	}
+
+	filename := "cue-backup-" + time.Now().UTC().Format("20060102T150405Z") + ".db"
+	dest := filepath.Join(s.cfg.BackupDir, filename)
+	if err := s.store.Backup(dest); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+
+	info, err := os.Stat(dest)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(backupResponse{Filename: filename, Size: info.Size()})
+}
+
+type integrityResponse struct {
+	OK       bool     `json:"ok"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// handleIntegrity checks the database and search index for corruption.
+func (s *Server) handleIntegrity(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r, "integrity checks") {
+		return
+	}
+
+	problems, err := s.store.IntegrityCheck()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(integrityResponse{OK: len(problems) == 0, Problems: problems})
+}
+
+type reindexResponse struct {
+	Indexed int `json:"indexed"`
+}
+
+// handleReindex rebuilds the full-text search index from the items table.
+func (s *Server) handleReindex(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r, "reindexing") {
+		return
+	}
+
+	n, err := s.store.RebuildFTS()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reindexResponse{Indexed: n})
+}
+
+type vacuumResponse struct {
+	SizeBefore int64 `json:"size_before"`
+	SizeAfter  int64 `json:"size_after"`
+	Reclaimed  int64 `json:"reclaimed"`
+}
+
+// handleVacuum compacts the database file. VACUUM blocks writers until it
+// finishes, so start and end are logged for operators to match against
+// slow or failed requests.
+func (s *Server) handleVacuum(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r, "vacuum") {
+		return
+	}
+
+	before, err := s.store.Size(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	log.Printf("Vacuum started (database %d bytes)", before)
+	start := time.Now()
+	if err := s.store.Vacuum(); err != nil {
+		log.Printf("Vacuum failed: %v", err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	after, err := s.store.Size(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	log.Printf("Vacuum finished in %s, reclaimed %d bytes", time.Since(start).Round(time.Millisecond), before-after)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(vacuumResponse{SizeBefore: before, SizeAfter: after, Reclaimed: before - after})
+}
+
+type poolStats struct {
+	MaxOpen           int    `json:"max_open"`
+	Open              int    `json:"open"`
+	InUse             int    `json:"in_use"`
+	Idle              int    `json:"idle"`
+	WaitCount         int64  `json:"wait_count"`
+	WaitDuration      string `json:"wait_duration"`
+	MaxIdleClosed     int64  `json:"max_idle_closed"`
+	MaxLifetimeClosed int64  `json:"max_lifetime_closed"`
+}
+
+type adminInfoResponse struct {
+	Version      string    `json:"version"`
+	Uptime       string    `json:"uptime"`
+	DatabaseSize int64     `json:"database_size"`
+	Pool         poolStats `json:"pool"`
+}
+
+// handleAdminInfo reports the server version, database size, and connection
+// pool statistics. WaitCount climbing under load means the pool is capped
+// too low for the readers it serves.
+func (s *Server) handleAdminInfo(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r, "server info") {
+		return
+	}
+
+	size, err := s.store.Size(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	stats := s.store.Stats()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(adminInfoResponse{
+		Version:      s.version,
+		Uptime:       time.Since(s.started).Round(time.Second).String(),
+		DatabaseSize: size,
+		Pool: poolStats{
+			MaxOpen:           stats.MaxOpenConnections,
+			Open:              stats.OpenConnections,
+			InUse:             stats.InUse,
+			Idle:              stats.Idle,
+			WaitCount:         stats.WaitCount,
+			WaitDuration:      stats.WaitDuration.String(),
+			MaxIdleClosed:     stats.MaxIdleClosed,
+			MaxLifetimeClosed: stats.MaxLifetimeClosed,
+		},
+	})
+}
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alanp/cue/internal/auth"
+	"github.com/alanp/cue/internal/store"
+)
+
+// setupAdminServer creates a server with auth enabled and a temp backup dir.
+func setupAdminServer(t *testing.T) (*Server, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	s, err := store.New(filepath.Join(dir, "cue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	backupDir := filepath.Join(dir, "backups")
+	srv := NewWithConfig(s, AuthConfig{Enabled: true}, Config{BackupDir: backupDir}, "dev")
+	return srv, backupDir
+}
+
+// withUser attaches an authenticated user to the request, as the auth
+// middleware would.
+func withUser(req *http.Request, method string) *http.Request {
+	return req.WithContext(auth.WithUser(req.Context(), &auth.UserContext{CN: "admin", AuthMethod: method}))
+}
+
+func TestBackupEndpoint(t *testing.T) {
+	srv, backupDir := setupAdminServer(t)
+
+	req := withUser(httptest.NewRequest("POST", "/api/admin/backup", nil), "cert")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	var resp backupResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	info, err := os.Stat(filepath.Join(backupDir, resp.Filename))
+	if err != nil {
+		t.Fatalf("backup file: %v", err)
+	}
+	if info.Size() != resp.Size || resp.Size == 0 {
+		t.Errorf("size = %d, file size = %d", resp.Size, info.Size())
+	}
+}
+
+func TestBackupEndpointRequiresCert(t *testing.T) {
+	srv, _ := setupAdminServer(t)
+
+	req := withUser(httptest.NewRequest("POST", "/api/admin/backup", nil), "token")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("token status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/admin/backup", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestBackupEndpointConflict(t *testing.T) {
+	srv, _ := setupAdminServer(t)
+	srv.backupRunning.Store(true)
+
+	req := withUser(httptest.NewRequest("POST", "/api/admin/backup", nil), "cert")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestIntegrityEndpoint(t *testing.T) {
+	srv, _ := setupAdminServer(t)
+	srv.store.Create("Healthy", "indexed", nil, nil, "")
+
+	req := withUser(httptest.NewRequest("GET", "/api/admin/integrity", nil), "cert")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var resp integrityResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if !resp.OK || len(resp.Problems) != 0 {
+		t.Errorf("resp = %+v, want ok", resp)
+	}
+
+	req = withUser(httptest.NewRequest("GET", "/api/admin/integrity", nil), "token")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("token status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestReindexEndpoint(t *testing.T) {
+	srv, _ := setupAdminServer(t)
+	srv.store.Create("One", "", nil, nil, "")
+	srv.store.Create("Two", "", nil, nil, "")
+
+	req := withUser(httptest.NewRequest("POST", "/api/admin/reindex", nil), "cert")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var resp reindexResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Indexed != 2 {
+		t.Errorf("indexed = %d, want 2", resp.Indexed)
+	}
+
+	req = withUser(httptest.NewRequest("POST", "/api/admin/reindex", nil), "token")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("token status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestAdminOU(t *testing.T) {
+	dir := t.TempDir()
+	s, err := store.New(filepath.Join(dir, "cue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	srv := NewWithConfig(s, AuthConfig{Enabled: true, Secret: []byte("test-secret-32-bytes-long-key!!"), AdminOU: "admins"}, Config{BackupDir: filepath.Join(dir, "backups")}, "dev")
+
+	as := func(req *http.Request, ous ...string) *http.Request {
+		return req.WithContext(auth.WithUser(req.Context(), &auth.UserContext{CN: "alice", AuthMethod: "cert", OUs: ous}))
+	}
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want int
+	}{
+		{"integrity in OU", as(httptest.NewRequest("GET", "/api/admin/integrity", nil), "admins"), http.StatusOK},
+		{"integrity outside OU", as(httptest.NewRequest("GET", "/api/admin/integrity", nil), "ops"), http.StatusForbidden},
+		{"reindex without OU", as(httptest.NewRequest("POST", "/api/admin/reindex", nil)), http.StatusForbidden},
+		{"backup outside OU", as(httptest.NewRequest("POST", "/api/admin/backup", nil), "ops"), http.StatusForbidden},
+		{"token create in OU", as(httptest.NewRequest("POST", "/api/tokens", strings.NewReader(`{"name": "ci"}`)), "admins"), http.StatusCreated},
+		{"token create outside OU", as(httptest.NewRequest("POST", "/api/tokens", strings.NewReader(`{"name": "ci"}`)), "ops"), http.StatusForbidden},
+		{"list tokens in OU", as(httptest.NewRequest("GET", "/api/tokens", nil), "admins"), http.StatusOK},
+		{"list tokens outside OU", as(httptest.NewRequest("GET", "/api/tokens", nil), "ops"), http.StatusForbidden},
+		{"rename token outside OU", as(httptest.NewRequest("PATCH", "/api/tokens/1", strings.NewReader(`{"name": "x"}`)), "ops"), http.StatusForbidden},
+		{"revoke token outside OU", as(httptest.NewRequest("DELETE", "/api/tokens/1", nil), "ops"), http.StatusForbidden},
+		{"revoke all outside OU", as(httptest.NewRequest("DELETE", "/api/tokens", nil), "ops"), http.StatusForbidden},
+		{"introspect outside OU", as(httptest.NewRequest("POST", "/api/tokens/introspect", strings.NewReader(`{"token": "x"}`)), "ops"), http.StatusForbidden},
+		// Other routes are unaffected
+		{"list items outside OU", as(httptest.NewRequest("GET", "/api/items", nil), "ops"), http.StatusOK},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, tc.req)
+		if w.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d: %s", tc.name, w.Code, tc.want, w.Body.String())
+		}
+		if w.Code == http.StatusForbidden {
+			if code := decodeError(t, w).Code; code != CodeForbidden {
+				t.Errorf("%s: code = %q, want %q", tc.name, code, CodeForbidden)
+			}
+		}
+	}
+}
+
+func TestVacuumEndpoint(t *testing.T) {
+	srv, _ := setupAdminServer(t)
+	for i := 0; i < 50; i++ {
+		item, _ := srv.store.Create("Item "+strings.Repeat("x", i), strings.Repeat("filler ", 200), nil, nil, "")
+		srv.store.Delete(item.ID)
+	}
+	srv.store.PurgeDeleted(0)
+
+	req := withUser(httptest.NewRequest("POST", "/api/admin/vacuum", nil), "cert")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var resp vacuumResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.SizeBefore <= 0 || resp.Reclaimed != resp.SizeBefore-resp.SizeAfter {
+		t.Errorf("resp = %+v", resp)
+	}
+
+	req = withUser(httptest.NewRequest("POST", "/api/admin/vacuum", nil), "token")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("token status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestAdminInfo(t *testing.T) {
+	srv, _ := setupAdminServer(t)
+
+	req := withUser(httptest.NewRequest("GET", "/api/admin/info", nil), "cert")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var resp adminInfoResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Version == "" || resp.DatabaseSize <= 0 || resp.Pool.Open < 1 {
+		t.Errorf("resp = %+v", resp)
+	}
+
+	req = withUser(httptest.NewRequest("GET", "/api/admin/info", nil), "token")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("token status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
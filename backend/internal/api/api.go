@@ -1,14 +1,27 @@
 package api
 
 import (
+	"context"
 	"database/sql"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/alanp/cue/internal/auth"
+	"github.com/alanp/cue/internal/clock"
+	"github.com/alanp/cue/internal/diff"
+	"github.com/alanp/cue/internal/render"
 	"github.com/alanp/cue/internal/store"
 )
 
@@ -20,13 +33,86 @@ type AuthConfig struct {
 	MaxTTL     time.Duration            // Maximum token expiration
 	Logger     *auth.FileSecurityLogger // Security logger
 	TrustProxy bool                     // Whether to trust X-Forwarded-For headers
+	AdminOU    string                   // If set, admin endpoints and token management need a cert in this OU
+
+	// MaxTokensPerUser caps each user's unexpired tokens; creating another
+	// gets a 403. Zero disables the limit.
+	MaxTokensPerUser int
+
+	// TokenLeeway is the clock skew introspection allows, with the meaning
+	// of auth.MiddlewareConfig.TokenLeeway; set both to the same value.
+	TokenLeeway time.Duration
+
+	// TokenFormat encodes new tokens; empty means auth.TokenFormatCue.
+	// Tokens in either format validate whichever is set.
+	TokenFormat auth.TokenFormat
+
+	// Clock stamps new tokens; nil means clock.Real. Use the same clock
+	// as auth.MiddlewareConfig.Clock.
+	Clock clock.Clock
+}
+
+// Health detail levels for the health endpoint.
+const (
+	HealthMinimal = "minimal" // {"status":"ok"} only
+	HealthFull    = "full"    // Adds version, uptime, and DB ping result
+)
+
+// Config holds general (non-auth) configuration for the API server.
+type Config struct {
+	HealthDetail string // HealthMinimal (default) or HealthFull
+	BackupDir    string // Directory for POST /api/admin/backup (default "backups")
+
+	MaxContentBytes int // Largest item content accepted (default DefaultMaxContentBytes)
+	MaxTitleLength  int // Longest title accepted, in characters (default DefaultMaxTitleLength)
+	MaxImportBytes  int // Largest POST /api/import body (default DefaultMaxImportBytes)
+
+	// UserItemQuota caps the live items each authenticated user may create.
+	// Zero disables the quota; it never applies in single-user mode.
+	UserItemQuota int
+
+	FeedSize int // Entries in GET /api/feed.atom (default DefaultFeedSize)
+
+	// Page sizes for GET /api/items and GET /api/search. A larger ?limit=
+	// is clamped to MaxListLimit, which also caps the defaults.
+	DefaultListLimit   int // Default store.DefaultListLimit
+	DefaultSearchLimit int // Default store.DefaultSearchLimit
+	MaxListLimit       int // Default MaxPageLimit
+
+	// IdempotencyWindow is how long an Idempotency-Key on POST /api/items
+	// is remembered (default DefaultIdempotencyWindow).
+	IdempotencyWindow time.Duration
 }
 
+// Default item size limits; see Config.
+const (
+	DefaultMaxContentBytes = 1 << 20 // 1 MiB
+	DefaultMaxTitleLength  = 500
+	DefaultMaxImportBytes  = 64 << 20 // 64 MiB
+)
+
+// DefaultMaxTokensPerUser is the -max-tokens-per-user default.
+const DefaultMaxTokensPerUser = 50
+
+// DefaultIdempotencyWindow is the default Config.IdempotencyWindow.
+const DefaultIdempotencyWindow = 24 * time.Hour
+
 type Server struct {
 	store   *store.Store
 	mux     *http.ServeMux
+	long    *http.ServeMux // Routes under longPathPrefixes; see ServeHTTP
 	authCfg AuthConfig
+	cfg     Config
 	version string
+	started time.Time // When the Server was created; uptime counts from here
+
+	backupRunning atomic.Bool
+
+	patterns []string // Registered route patterns, in registration order
+
+	// closing is closed by CloseStreams to end long-lived responses
+	closing   chan struct{}
+	closeOnce sync.Once
 }
 
 func New(s *store.Store) *Server {
@@ -34,193 +120,1534 @@ func New(s *store.Store) *Server {
 }
 
 func NewWithAuth(s *store.Store, authCfg AuthConfig, version string) *Server {
+	return NewWithConfig(s, authCfg, Config{}, version)
+}
+
+func NewWithConfig(s *store.Store, authCfg AuthConfig, cfg Config, version string) *Server {
 	if authCfg.DefaultTTL == 0 {
 		authCfg.DefaultTTL = 720 * time.Hour // 30 days
 	}
 	if authCfg.MaxTTL == 0 {
 		authCfg.MaxTTL = 8760 * time.Hour // 1 year
 	}
+	if authCfg.Clock == nil {
+		authCfg.Clock = clock.Real
+	}
 	if version == "" {
 		version = "dev"
 	}
-	srv := &Server{store: s, mux: http.NewServeMux(), authCfg: authCfg, version: version}
+	if cfg.HealthDetail == "" {
+		cfg.HealthDetail = HealthMinimal
+	}
+	if cfg.BackupDir == "" {
+		cfg.BackupDir = "backups"
+	}
+	if cfg.MaxContentBytes <= 0 {
+		cfg.MaxContentBytes = DefaultMaxContentBytes
+	}
+	if cfg.MaxTitleLength <= 0 {
+		cfg.MaxTitleLength = DefaultMaxTitleLength
+	}
+	if cfg.MaxImportBytes <= 0 {
+		cfg.MaxImportBytes = DefaultMaxImportBytes
+	}
+	if cfg.FeedSize <= 0 {
+		cfg.FeedSize = DefaultFeedSize
+	}
+	if cfg.MaxListLimit <= 0 {
+		cfg.MaxListLimit = MaxPageLimit
+	}
+	if cfg.DefaultListLimit <= 0 {
+		cfg.DefaultListLimit = store.DefaultListLimit
+	}
+	if cfg.DefaultSearchLimit <= 0 {
+		cfg.DefaultSearchLimit = store.DefaultSearchLimit
+	}
+	cfg.DefaultListLimit = min(cfg.DefaultListLimit, cfg.MaxListLimit)
+	cfg.DefaultSearchLimit = min(cfg.DefaultSearchLimit, cfg.MaxListLimit)
+	if cfg.IdempotencyWindow <= 0 {
+		cfg.IdempotencyWindow = DefaultIdempotencyWindow
+	}
+	srv := &Server{store: s, mux: http.NewServeMux(), long: http.NewServeMux(), authCfg: authCfg, cfg: cfg, version: version, started: time.Now(), closing: make(chan struct{})}
 	srv.routes()
 	return srv
 }
 
+// CloseStreams ends open event streams so http.Server.Shutdown can finish
+// draining. Register it with http.Server.RegisterOnShutdown.
+func (s *Server) CloseStreams() {
+	s.closeOnce.Do(func() { close(s.closing) })
+}
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.mux.ServeHTTP(w, r)
+	s.instrument(s.routeMux(r)).ServeHTTP(w, r)
+}
+
+// longPathPrefixes are served from Server.long. Their routes would conflict
+// with /api/items/{id} patterns in a single ServeMux, which cannot say which
+// is more specific: a {name...} wildcard under /api/items/, or a literal
+// GET route beside HEAD /api/items/{id}.
+var longPathPrefixes = []string{"/api/items/by-title/", "/api/items/check-title", "/api/items/by-link"}
+
+// routeMux returns the mux that serves r.
+func (s *Server) routeMux(r *http.Request) *http.ServeMux {
+	for _, prefix := range longPathPrefixes {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return s.long
+		}
+	}
+	return s.mux
 }
 
 func (s *Server) routes() {
-	s.mux.HandleFunc("GET /api/status", s.HandleStatus)
-	s.mux.HandleFunc("GET /api/health", s.HandleHealth)
-	s.mux.HandleFunc("GET /api/items", s.handleListItems)
-	s.mux.HandleFunc("POST /api/items", s.handleCreateItem)
-	s.mux.HandleFunc("GET /api/items/{id}", s.handleGetItem)
-	s.mux.HandleFunc("PUT /api/items/{id}", s.handleUpdateItem)
-	s.mux.HandleFunc("DELETE /api/items/{id}", s.handleDeleteItem)
-	s.mux.HandleFunc("GET /api/search", s.handleSearch)
+	s.handle("GET /api/status", s.HandleStatus)
+	s.handle("GET /api/health", s.HandleHealth)
+	s.handle("GET /api/ready", s.HandleReady)
+	s.handle("GET /api/info", s.handleInfo)
+	read := func(h http.HandlerFunc) http.HandlerFunc { return requireScope(auth.ScopeItemsRead, h) }
+	write := func(h http.HandlerFunc) http.HandlerFunc { return requireScope(auth.ScopeItemsWrite, h) }
+	admin := func(h http.HandlerFunc) http.HandlerFunc { return auth.RequireOU(s.authCfg.AdminOU)(h).ServeHTTP }
+
+	s.handle("GET /api/items", read(s.handleListItems))
+	s.handle("POST /api/items", write(s.handleCreateItem))
+	s.handle("POST /api/items/delete", write(s.handleDeleteItems))
+	s.handle("POST /api/items/batch-get", read(s.handleBatchGetItems))
+	s.handle("GET /api/items/{id}", read(s.handleGetItem))
+	s.handleLong("GET /api/items/check-title", read(s.handleCheckTitle))
+	s.handleLong("GET /api/items/by-link", read(s.handleFindByLink))
+	s.handleLong("GET /api/items/by-title/{title...}", read(s.handleGetItemByTitle))
+	s.handle("HEAD /api/items/{id}", read(s.handleHeadItem))
+	s.handle("PUT /api/items/{id}", write(s.handleUpdateItem))
+	s.handle("PATCH /api/items/{id}", write(s.handlePatchItem))
+	s.handle("DELETE /api/items/{id}", write(s.handleDeleteItem))
+	s.handle("POST /api/items/{id}/restore", write(s.handleRestoreItem))
+	s.handle("POST /api/items/{id}/duplicate", write(s.handleDuplicateItem))
+	s.handle("POST /api/items/{id}/pin", write(s.handleSetPinned(true)))
+	s.handle("DELETE /api/items/{id}/pin", write(s.handleSetPinned(false)))
+	s.handle("POST /api/items/{id}/archive", write(s.handleSetArchived(true)))
+	s.handle("DELETE /api/items/{id}/archive", write(s.handleSetArchived(false)))
+	s.handle("GET /api/items/{id}/render", read(s.handleRenderItem))
+	s.handle("GET /api/items/{id}/backlinks", read(s.handleBacklinks))
+	s.handle("GET /api/items/{id}/relations", read(s.handleListRelations))
+	s.handle("POST /api/items/{id}/relations", write(s.handleAddRelation))
+	s.handle("DELETE /api/items/{id}/relations", write(s.handleRemoveRelation))
+	s.handle("GET /api/items/{id}/versions", read(s.handleListVersions))
+	s.handle("GET /api/items/{id}/versions/{n}", read(s.handleGetVersion))
+	s.handle("GET /api/items/{id}/versions/{n}/diff", read(s.handleDiffVersion))
+	s.handle("POST /api/items/{id}/versions/{n}/restore", write(s.handleRestoreVersion))
+	s.handle("GET /api/search", read(s.handleSearch))
+	s.handle("GET /api/suggest", read(s.handleSuggest))
+	s.handle("GET /api/stats", read(s.handleStats))
+	s.handle("GET /api/export", read(s.handleExport))
+	s.handle("GET /api/export.csv", read(s.handleExportCSV))
+	s.handle("GET /api/feed.atom", read(s.handleFeed))
+	s.handle("POST /api/import", write(s.handleImport))
+	s.handle("GET /api/events", read(s.handleEvents))
 
 	// Auth endpoints
-	s.mux.HandleFunc("GET /api/whoami", s.handleWhoAmI)
-	s.mux.HandleFunc("POST /api/tokens", s.handleCreateToken)
-	s.mux.HandleFunc("GET /api/tokens", s.handleListTokens)
-	s.mux.HandleFunc("DELETE /api/tokens/{id}", s.handleDeleteToken)
+	s.handle("GET /api/whoami", s.handleWhoAmI)
+	s.handle("POST /api/tokens", admin(s.handleCreateToken))
+	s.handle("GET /api/tokens", admin(requireScope(auth.ScopeTokensManage, s.handleListTokens)))
+	s.handle("PATCH /api/tokens/{id}", admin(s.handleUpdateToken))
+	s.handle("DELETE /api/tokens", admin(requireScope(auth.ScopeTokensManage, s.handleDeleteAllTokens)))
+	s.handle("POST /api/tokens/introspect", admin(requireScope(auth.ScopeTokensManage, s.handleIntrospectToken)))
+	s.handle("DELETE /api/tokens/{id}", admin(s.handleDeleteToken))
+
+	// Admin endpoints
+	s.handle("POST /api/admin/backup", admin(s.handleBackup))
+	s.handle("GET /api/admin/integrity", admin(s.handleIntegrity))
+	s.handle("POST /api/admin/reindex", admin(s.handleReindex))
+	s.handle("POST /api/admin/vacuum", admin(s.handleVacuum))
+	s.handle("GET /api/admin/info", admin(s.handleAdminInfo))
+	s.handle("GET /api/metrics", s.handleMetrics)
+	s.handle("GET /api/openapi.json", s.handleOpenAPI)
+
+	// Anything else under /api/ gets a JSON error rather than whatever the
+	// caller's outer mux would serve, such as the SPA's index.html
+	s.mux.HandleFunc(catchAllPattern, s.handleUnmatched)
+	s.long.HandleFunc(catchAllPattern, s.handleUnmatched)
+}
+
+// catchAllPattern is the route handleUnmatched is registered under.
+const catchAllPattern = "/api/"
+
+// probeMethods are the methods handleUnmatched tries when deciding between
+// 404 and 405.
+var probeMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// handleUnmatched answers requests no route matched: 405 with an Allow header
+// when the path exists under other methods, 404 otherwise.
+func (s *Server) handleUnmatched(w http.ResponseWriter, r *http.Request) {
+	mux := s.routeMux(r)
+	var allow []string
+	for _, method := range probeMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		if _, pattern := mux.Handler(probe); pattern != catchAllPattern {
+			allow = append(allow, method)
+		}
+	}
+	if len(allow) > 0 {
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		writeError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" not allowed on "+r.URL.Path)
+		return
+	}
+	writeError(w, http.StatusNotFound, CodeNotFound, "no API route for "+r.URL.Path)
+}
+
+// handle registers a route and records its pattern for the OpenAPI drift
+// test.
+func (s *Server) handle(pattern string, h http.HandlerFunc) {
+	s.patterns = append(s.patterns, pattern)
+	s.mux.HandleFunc(pattern, h)
+}
+
+// handleLong registers a route under one of longPathPrefixes.
+func (s *Server) handleLong(pattern string, h http.HandlerFunc) {
+	s.patterns = append(s.patterns, pattern)
+	s.long.HandleFunc(pattern, h)
+}
+
+// requireScope rejects token-authenticated requests whose token lacks scope.
+// Certificate and single-user requests always pass.
+func requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if user := auth.GetUser(r.Context()); user != nil && !user.HasScope(scope) {
+			writeError(w, http.StatusForbidden, CodeForbidden, "token lacks required scope: "+scope)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"version": s.version,
+		"status":  "ok",
+	})
+}
+
+type healthResponse struct {
+	Status        string `json:"status"`
+	Version       string `json:"version,omitempty"`
+	Uptime        string `json:"uptime,omitempty"`
+	UptimeSeconds int64  `json:"uptime_seconds,omitempty"`
+	DB            string `json:"db,omitempty"`
+}
+
+func (s *Server) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if s.cfg.HealthDetail != HealthFull {
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+		return
+	}
+
+	uptime := time.Since(s.started)
+	resp := healthResponse{
+		Status:        "ok",
+		Version:       s.version,
+		Uptime:        uptime.Round(time.Second).String(),
+		UptimeSeconds: int64(uptime.Seconds()),
+		DB:            "ok",
+	}
+	if err := s.store.Ping(r.Context()); err != nil {
+		resp.Status = "error"
+		resp.DB = err.Error()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// infoResponse is the body of GET /api/info.
+type infoResponse struct {
+	Version       string `json:"version"`
+	GoVersion     string `json:"go_version"`
+	Uptime        string `json:"uptime"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+	Items         int    `json:"items"`
+	Tokens        int    `json:"tokens"`
+	DBSizeBytes   int64  `json:"db_size_bytes"`
+}
+
+// handleInfo reports build and database statistics for dashboards. Unlike
+// /api/health it queries the store, so it sits behind authentication.
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	items, err := s.store.CountContext(r.Context(), store.ListOptions{})
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	tokens, err := s.store.CountTokens()
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	size, err := s.store.Size(r.Context())
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+
+	uptime := time.Since(s.started)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infoResponse{
+		Version:       s.version,
+		GoVersion:     runtime.Version(),
+		Uptime:        uptime.Round(time.Second).String(),
+		UptimeSeconds: int64(uptime.Seconds()),
+		Items:         items,
+		Tokens:        tokens,
+		DBSizeBytes:   size,
+	})
+}
+
+// readyTimeout bounds the database check behind /api/ready.
+const readyTimeout = 2 * time.Second
+
+type readyResponse struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// HandleReady is the readiness check: unlike HandleHealth (liveness), it
+// fails with 503 when the database can't be reached.
+func (s *Server) HandleReady(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+	if err := s.store.Ping(ctx); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(readyResponse{Status: "unavailable", Reason: "database unreachable: " + err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(readyResponse{Status: "ready"})
+}
+
+func (s *Server) handleListItems(w http.ResponseWriter, r *http.Request) {
+	limit, err := s.queryLimit(r, s.cfg.DefaultListLimit)
+	if err != nil {
+		writeParamError(w, err)
+		return
+	}
+	offset, err := queryCount(r, "offset")
+	if err != nil {
+		writeParamError(w, err)
+		return
+	}
+	trashed, _ := strconv.ParseBool(r.URL.Query().Get("trashed"))
+	mine, _ := strconv.ParseBool(r.URL.Query().Get("mine"))
+	includeArchived, _ := strconv.ParseBool(r.URL.Query().Get("include_archived"))
+	archivedOnly, _ := strconv.ParseBool(r.URL.Query().Get("archived"))
+	sort, err := store.ParseSortOption(r.URL.Query().Get("sort"))
+	if err != nil {
+		writeParamError(w, &paramError{Param: "sort", Message: err.Error()})
+		return
+	}
+	fields, err := queryFields(r)
+	if err != nil {
+		writeParamError(w, err)
+		return
+	}
+	if r.URL.Query().Has("since") {
+		s.listSince(w, r, limit, offset, fields)
+		return
+	}
+	if r.URL.Query().Has("cursor") {
+		s.listAfter(w, r, limit, fields)
+		return
+	}
+
+	opts := store.ListOptions{
+		Limit:           limit,
+		Offset:          offset,
+		Tags:            r.URL.Query()["tag"],
+		Trashed:         trashed,
+		Sort:            sort,
+		IncludeArchived: includeArchived,
+		ArchivedOnly:    archivedOnly,
+	}
+	if mine {
+		opts.CreatedBy = requestOwner(r)
+	}
+	items, err := s.store.ListContext(r.Context(), opts)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+
+	if items == nil {
+		items = []store.Item{}
+	}
+
+	if !wantsListMeta(r) {
+		fields.writeList(w, items)
+		return
+	}
+
+	total, err := s.store.CountContext(r.Context(), opts)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	fields.writeList(w, listResponse{
+		Items:  items,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// listSince serves GET /api/items?since=, the incremental sync feed. It
+// has its own ordering and includes trashed items, so the list filters do
+// not apply.
+func (s *Server) listSince(w http.ResponseWriter, r *http.Request, limit, offset int, fields fieldMask) {
+	q := r.URL.Query()
+	since, err := time.Parse(time.RFC3339, q.Get("since"))
+	if err != nil {
+		writeParamError(w, &paramError{Param: "since", Message: "since must be an RFC 3339 timestamp"})
+		return
+	}
+	for _, name := range []string{"tag", "trashed", "mine", "sort", "include_archived", "archived"} {
+		if q.Has(name) {
+			writeParamError(w, &paramError{Param: name, Message: name + " cannot be combined with since"})
+			return
+		}
+	}
+
+	items, err := s.store.ListSinceContext(r.Context(), since, limit, offset)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if items == nil {
+		items = []store.Item{}
+	}
+
+	if !wantsListMeta(r) {
+		fields.writeList(w, items)
+		return
+	}
+	total, err := s.store.CountSinceContext(r.Context(), since)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	fields.writeList(w, listResponse{Items: items, Total: total, Limit: limit, Offset: offset})
+}
+
+// listAfter serves GET /api/items?cursor=, keyset pagination in
+// updated_desc order. An empty cursor starts at the front; each page's
+// next_cursor continues it and is omitted on the last page. It only pages
+// the live list, so the other list parameters are rejected.
+func (s *Server) listAfter(w http.ResponseWriter, r *http.Request, limit int, fields fieldMask) {
+	q := r.URL.Query()
+	var cursor store.Cursor
+	if v := q.Get("cursor"); v != "" {
+		var err error
+		if cursor, err = decodeCursor(v); err != nil {
+			writeParamError(w, &paramError{Param: "cursor", Message: "invalid cursor"})
+			return
+		}
+	}
+	for _, name := range []string{"offset", "tag", "trashed", "mine", "sort", "include_archived", "archived"} {
+		if q.Has(name) {
+			writeParamError(w, &paramError{Param: name, Message: name + " cannot be combined with cursor"})
+			return
+		}
+	}
+
+	// One extra item says whether there is a next page
+	items, err := s.store.ListAfterContext(r.Context(), cursor, limit+1)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	resp := cursorResponse{Items: items, Limit: limit}
+	if len(items) > limit {
+		resp.Items = items[:limit]
+		last := resp.Items[limit-1]
+		resp.NextCursor = encodeCursor(store.Cursor{UpdatedAt: last.UpdatedAt, ID: last.ID})
+	}
+	if resp.Items == nil {
+		resp.Items = []store.Item{}
+	}
+
+	fields.writeList(w, resp)
+}
+
+// cursorResponse is a page of GET /api/items?cursor=.
+type cursorResponse struct {
+	Items      []store.Item `json:"items"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+	Limit      int          `json:"limit"`
+}
+
+// listResponse wraps a page of items with pagination metadata.
+type listResponse struct {
+	Items  []store.Item `json:"items"`
+	Total  int          `json:"total"`
+	Limit  int          `json:"limit"`
+	Offset int          `json:"offset"`
+}
+
+// wantsListMeta reports whether the client asked for a wrapped list
+// response, via ?meta=true or an Accept parameter such as
+// "application/json; meta=true". The bare array remains the default.
+func wantsListMeta(r *http.Request) bool {
+	if meta, _ := strconv.ParseBool(r.URL.Query().Get("meta")); meta {
+		return true
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		if meta, _ := strconv.ParseBool(params["meta"]); meta {
+			return true
+		}
+	}
+	return false
+}
+
+// requestOwner returns the CN recorded as created_by for items the request
+// creates: the authenticated user, or store.DefaultOwner without one.
+func requestOwner(r *http.Request) string {
+	if user := auth.GetUser(r.Context()); user != nil && user.CN != "" {
+		return user.CN
+	}
+	return store.DefaultOwner
+}
+
+// userQuota returns the Config.UserItemQuota that applies to the request:
+// zero, meaning none, in single-user mode.
+func (s *Server) userQuota(r *http.Request) int {
+	if user := auth.GetUser(r.Context()); user == nil || user.AuthMethod == "none" {
+		return 0
+	}
+	return s.cfg.UserItemQuota
+}
+
+// checkQuota reports whether the requesting user may create another item,
+// writing a 403 if they are at Config.UserItemQuota.
+func (s *Server) checkQuota(w http.ResponseWriter, r *http.Request) bool {
+	quota := s.userQuota(r)
+	if quota <= 0 {
+		return true
+	}
+	n, err := s.store.CountByUserContext(r.Context(), requestOwner(r))
+	if err != nil {
+		storeError(w, r, err)
+		return false
+	}
+	if n >= quota {
+		writeError(w, http.StatusForbidden, CodeQuotaExceeded,
+			fmt.Sprintf("item quota exceeded: %d of %d items used", n, quota))
+		return false
+	}
+	return true
+}
+
+// decodeItemBody decodes an item create or update body into v. The body is
+// capped well above MaxContentBytes, leaving room for JSON escaping, so a
+// huge upload is cut off while reading rather than buffered whole.
+func (s *Server) decodeItemBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	return decodeLimitedBody(w, r, v, int64(2*s.cfg.MaxContentBytes+64<<10))
+}
+
+// decodeLimitedBody decodes a JSON body of at most limit bytes into v,
+// answering a longer one with 413.
+func decodeLimitedBody(w http.ResponseWriter, r *http.Request, v any, limit int64) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit)).Decode(v)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, CodeContentTooLarge,
+			fmt.Sprintf("request body exceeds %d bytes", limit))
+		return false
+	case err != nil:
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "invalid JSON")
+		return false
+	}
+	return true
+}
+
+// checkItemSize enforces Config.MaxTitleLength and Config.MaxContentBytes.
+func (s *Server) checkItemSize(w http.ResponseWriter, title, content string) bool {
+	if n := utf8.RuneCountInString(store.CleanTitle(title)); n > s.cfg.MaxTitleLength {
+		writeError(w, http.StatusRequestEntityTooLarge, CodeTitleTooLong,
+			fmt.Sprintf("title is %d characters; the limit is %d", n, s.cfg.MaxTitleLength))
+		return false
+	}
+	if len(content) > s.cfg.MaxContentBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, CodeContentTooLarge,
+			fmt.Sprintf("content is %d bytes; the limit is %d", len(content), s.cfg.MaxContentBytes))
+		return false
+	}
+	return true
+}
+
+type createItemRequest struct {
+	Title   string   `json:"title"`
+	Content string   `json:"content"`
+	Link    *string  `json:"link,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Color   string   `json:"color,omitempty"` // One of store.Colors
+}
+
+// checkColor rejects a color outside store.Colors with 400, reporting
+// whether the request may go ahead.
+func checkColor(w http.ResponseWriter, color string) bool {
+	if !store.ValidColor(color) {
+		writeError(w, http.StatusBadRequest, CodeInvalidColor, store.ErrInvalidColor.Error())
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleCreateItem(w http.ResponseWriter, r *http.Request) {
+	var req createItemRequest
+	if !s.decodeItemBody(w, r, &req) {
+		return
+	}
+
+	if store.CleanTitle(req.Title) == "" {
+		writeError(w, http.StatusBadRequest, CodeTitleRequired, "title is required")
+		return
+	}
+	if !s.checkItemSize(w, req.Title, req.Content) {
+		return
+	}
+	if !checkColor(w, req.Color) {
+		return
+	}
+
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key != "" {
+		if !validIdempotencyKey(key) {
+			writeParamError(w, &paramError{Param: IdempotencyKeyHeader, Message: fmt.Sprintf("%s must be 1-%d printable ASCII characters", IdempotencyKeyHeader, maxIdempotencyKeyLength)})
+			return
+		}
+		// A retry is answered before the quota check, which the
+		// original request may have filled
+		item, err := s.store.IdempotentItemContext(r.Context(), requestOwner(r), key, s.cfg.IdempotencyWindow)
+		if err == nil {
+			s.writeReplayed(w, item)
+			return
+		}
+		if err != sql.ErrNoRows {
+			storeError(w, r, err)
+			return
+		}
+	}
+	if warn, _ := strconv.ParseBool(r.URL.Query().Get("warn_duplicate_content")); warn {
+		item, err := s.store.FindByContentHashContext(r.Context(), store.ContentHash(req.Content))
+		if err == nil {
+			w.Header().Set("Content-Type", "application/json")
+			setItemValidators(w, item)
+			json.NewEncoder(w).Encode(duplicateResponse{Item: item, Duplicate: true})
+			return
+		}
+		if err != sql.ErrNoRows {
+			storeError(w, r, err)
+			return
+		}
+	}
+	if !s.checkQuota(w, r) {
+		return
+	}
+
+	var item *store.Item
+	var err error
+	if key == "" {
+		item, err = s.store.CreateWithColorContext(r.Context(), req.Title, req.Content, req.Link, req.Tags, requestOwner(r), req.Color)
+	} else {
+		var created bool
+		item, created, err = s.store.CreateIdempotentContext(r.Context(), key, s.cfg.IdempotencyWindow, req.Title, req.Content, req.Link, req.Tags, requestOwner(r), req.Color)
+		if err == nil && !created {
+			s.writeReplayed(w, item)
+			return
+		}
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint") {
+			writeError(w, http.StatusConflict, CodeTitleConflict, "title already exists")
+			return
+		}
+		storeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setItemValidators(w, item)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(item)
+}
+
+// duplicateResponse answers POST /api/items?warn_duplicate_content=true
+// with the existing item whose content matches, instead of creating one.
+type duplicateResponse struct {
+	*store.Item
+	Duplicate bool `json:"duplicate"`
+}
+
+// IdempotencyKeyHeader lets a client retry POST /api/items safely: a repeat
+// with the same key returns the item the first request created.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const maxIdempotencyKeyLength = 255
+
+// validIdempotencyKey accepts 1-255 printable ASCII characters.
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return key != ""
+}
+
+// writeReplayed answers a repeated Idempotency-Key with the original item
+// and 200, marked with Idempotent-Replayed so clients can tell.
+func (s *Server) writeReplayed(w http.ResponseWriter, item *store.Item) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	setItemValidators(w, item)
+	json.NewEncoder(w).Encode(item)
+}
+
+// handleGetItemByTitle looks up a live item by its exact title. The
+// rest of the path is the title, so it may contain slashes, raw or encoded
+// as %2F; other special characters arrive percent-encoded.
+func (s *Server) handleGetItemByTitle(w http.ResponseWriter, r *http.Request) {
+	title := store.CleanTitle(r.PathValue("title"))
+	if title == "" {
+		writeParamError(w, &paramError{Param: "title", Message: "title is required"})
+		return
+	}
+
+	item, err := s.store.GetByTitleContext(r.Context(), title)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, CodeNotFound, "not found")
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+
+	setItemValidators(w, item)
+	if notModified(r, item) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
+// titleCheck is the response of GET /api/items/check-title.
+type titleCheck struct {
+	Available  bool   `json:"available"`
+	ExistingID string `json:"existing_id,omitempty"`
+	Trashed    bool   `json:"trashed,omitempty"` // The existing item is in the trash
+}
+
+// handleCheckTitle reports whether creating an item with ?title= would
+// succeed, so forms can warn before submitting. Every user can already read
+// every item, so naming the one holding the title leaks nothing.
+func (s *Server) handleCheckTitle(w http.ResponseWriter, r *http.Request) {
+	title := store.CleanTitle(r.URL.Query().Get("title"))
+	if title == "" {
+		writeParamError(w, &paramError{Param: "title", Message: "title is required"})
+		return
+	}
+
+	resp := titleCheck{Available: true}
+	id, trashed, err := s.store.TitleOwnerContext(r.Context(), title)
+	switch {
+	case err == nil:
+		resp = titleCheck{ExistingID: id, Trashed: trashed}
+	case err != sql.ErrNoRows:
+		storeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleFindByLink lists the items whose link is ?url=, compared after
+// store.NormalizeLink rather than tokenized like search.
+func (s *Server) handleFindByLink(w http.ResponseWriter, r *http.Request) {
+	link := r.URL.Query().Get("url")
+	if strings.TrimSpace(link) == "" {
+		writeParamError(w, &paramError{Param: "url", Message: "url is required"})
+		return
+	}
+	fields, err := queryFields(r)
+	if err != nil {
+		writeParamError(w, err)
+		return
+	}
+
+	items, err := s.store.FindByLinkContext(r.Context(), link)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	fields.writeList(w, items)
+}
+
+func (s *Server) handleGetItem(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	fields, err := queryFields(r)
+	if err != nil {
+		writeParamError(w, err)
+		return
+	}
+
+	item, err := s.store.GetContext(r.Context(), id)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, CodeNotFound, "not found")
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+
+	setItemValidators(w, item)
+	if notModified(r, item) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	fields.writeItem(w, item)
+}
+
+// handleRenderItem returns the item's markdown content as sanitized HTML.
+// The raw markdown stays available from GET /api/items/{id}.
+func (s *Server) handleRenderItem(w http.ResponseWriter, r *http.Request) {
+	if format := r.URL.Query().Get("format"); format != "" && format != "html" {
+		writeParamError(w, &paramError{Param: "format", Message: "format must be html"})
+		return
+	}
+
+	item, err := s.store.GetContext(r.Context(), r.PathValue("id"))
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, CodeNotFound, "not found")
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+
+	html, err := render.HTML(item.Content)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// The fragment is sanitized, but a browser opening it directly should
+	// still run nothing
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src *; style-src 'unsafe-inline'")
+	io.WriteString(w, html)
+}
+
+type updateItemRequest struct {
+	Title   string   `json:"title"`
+	Content string   `json:"content"`
+	Link    *string  `json:"link,omitempty"`
+	Tags    []string `json:"tags"`          // Omitted keeps existing tags; [] clears them
+	Color   *string  `json:"color"`         // Omitted keeps the color; "" clears it
+	Rev     int      `json:"rev,omitempty"` // If set, 409 unless it matches the stored rev
+}
+
+// handleHeadItem answers whether an item exists, with its validators but
+// without encoding the body.
+func (s *Server) handleHeadItem(w http.ResponseWriter, r *http.Request) {
+	item, err := s.store.GetContext(r.Context(), r.PathValue("id"))
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, CodeNotFound, "not found")
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	setItemValidators(w, item)
+	if notModified(r, item) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleUpdateItem(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var req updateItemRequest
+	if !s.decodeItemBody(w, r, &req) {
+		return
+	}
+
+	if store.CleanTitle(req.Title) == "" {
+		writeError(w, http.StatusBadRequest, CodeTitleRequired, "title is required")
+		return
+	}
+	if !s.checkItemSize(w, req.Title, req.Content) {
+		return
+	}
+	if req.Color != nil && !checkColor(w, *req.Color) {
+		return
+	}
+	matchedRev, ok := s.checkIfMatch(w, r, id)
+	if !ok {
+		return
+	}
+
+	rev, precondition := expectedRev(req.Rev, matchedRev)
+	item, err := s.store.UpdateWithColorContext(r.Context(), id, req.Title, req.Content, req.Link, req.Tags, req.Color, rev)
+	s.writeUpdated(w, r, item, err, rev, precondition)
+}
+
+// patchItemRequest is the body of PATCH /api/items/{id}. Absent fields are
+// left unchanged. Link and tags are raw so an explicit null (which clears
+// them) can be told apart from an absent field; a null title, content, or
+// color counts as absent. An empty color clears it.
+type patchItemRequest struct {
+	Title   *string         `json:"title"`
+	Content *string         `json:"content"`
+	Color   *string         `json:"color"`
+	Link    json.RawMessage `json:"link"`
+	Tags    json.RawMessage `json:"tags"`
+	Rev     int             `json:"rev,omitempty"` // If set, 409 unless it matches the stored rev
+}
+
+func (s *Server) handlePatchItem(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var req patchItemRequest
+	if !s.decodeItemBody(w, r, &req) {
+		return
+	}
+
+	fields := store.PatchFields{Title: req.Title, Content: req.Content, Color: req.Color, ExpectedRev: req.Rev}
+	if req.Link != nil {
+		fields.SetLink = true
+		if err := json.Unmarshal(req.Link, &fields.Link); err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidJSON, "link must be a string or null")
+			return
+		}
+	}
+	if req.Tags != nil {
+		if err := json.Unmarshal(req.Tags, &fields.Tags); err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidJSON, "tags must be an array of strings or null")
+			return
+		}
+		if fields.Tags == nil {
+			fields.Tags = []string{}
+		}
+	}
+
+	var title, content string
+	if req.Title != nil {
+		if title = *req.Title; store.CleanTitle(title) == "" {
+			writeError(w, http.StatusBadRequest, CodeTitleRequired, "title is required")
+			return
+		}
+	}
+	if req.Content != nil {
+		content = *req.Content
+	}
+	if !s.checkItemSize(w, title, content) {
+		return
+	}
+	if req.Color != nil && !checkColor(w, *req.Color) {
+		return
+	}
+	matchedRev, ok := s.checkIfMatch(w, r, id)
+	if !ok {
+		return
+	}
+
+	var precondition bool
+	fields.ExpectedRev, precondition = expectedRev(req.Rev, matchedRev)
+	item, err := s.store.PatchContext(r.Context(), id, fields)
+	s.writeUpdated(w, r, item, err, fields.ExpectedRev, precondition)
+}
+
+// checkIfMatch enforces an If-Match header on a write, guarding against
+// overwriting an edit the client hasn't seen. It reports whether the write
+// may go ahead, having sent 404 or 412 if not, and returns the rev of the
+// item the header matched, or 0 without one. The write must expect that rev
+// (see expectedRev) so an update landing in between is caught atomically.
+func (s *Server) checkIfMatch(w http.ResponseWriter, r *http.Request, id string) (int, bool) {
+	im := r.Header.Get("If-Match")
+	if im == "" {
+		return 0, true
+	}
+	current, err := s.store.GetContext(r.Context(), id)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, CodeNotFound, "not found")
+		return 0, false
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return 0, false
+	}
+	if !etagMatches(im, itemETag(current)) {
+		writeError(w, http.StatusPreconditionFailed, CodePreconditionFailed, "item has changed")
+		return 0, false
+	}
+	return current.Rev, true
+}
+
+// expectedRev picks the rev a write must find: the one in the body, else the
+// one If-Match matched. It also reports whether a conflict means the If-Match
+// precondition failed (412) rather than a stale body rev (409).
+func expectedRev(bodyRev, matchedRev int) (int, bool) {
+	if bodyRev != 0 {
+		return bodyRev, bodyRev == matchedRev
+	}
+	return matchedRev, matchedRev != 0
+}
+
+// writeUpdated sends the result of an update or patch: the item, or the
+// error mapped to 404, 409, 412 when precondition is set, or a store error.
+func (s *Server) writeUpdated(w http.ResponseWriter, r *http.Request, item *store.Item, err error, rev int, precondition bool) {
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, CodeNotFound, "not found")
+		return
+	}
+	if errors.Is(err, store.ErrRevConflict) && precondition {
+		writeError(w, http.StatusPreconditionFailed, CodePreconditionFailed, "item has changed")
+		return
+	}
+	if errors.Is(err, store.ErrRevConflict) {
+		writeError(w, http.StatusConflict, CodeRevConflict, "item was modified since rev "+strconv.Itoa(rev))
+		return
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint") {
+			writeError(w, http.StatusConflict, CodeTitleConflict, "title already exists")
+			return
+		}
+		storeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setItemValidators(w, item)
+	json.NewEncoder(w).Encode(item)
+}
+
+func (s *Server) handleDeleteItem(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	err := s.store.DeleteContext(r.Context(), id)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, CodeNotFound, "not found")
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type deleteItemsRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// handleDeleteItems moves a batch of items to the trash and reports which
+// IDs did not exist.
+func (s *Server) handleDeleteItems(w http.ResponseWriter, r *http.Request) {
+	var req deleteItemsRequest
+	if !decodeLimitedBody(w, r, &req, s.idsBodyLimit()) {
+		return
+	}
+	if len(req.IDs) == 0 {
+		writeError(w, http.StatusBadRequest, CodeIDsRequired, "ids is required")
+		return
+	}
+	if len(req.IDs) > s.cfg.MaxListLimit {
+		writeError(w, http.StatusBadRequest, CodeTooManyIDs, fmt.Sprintf("at most %d ids per request", s.cfg.MaxListLimit))
+		return
+	}
+
+	result, err := s.store.DeleteManyContext(r.Context(), req.IDs)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// idsBodyLimit caps an {"ids": [...]} body at room for MaxListLimit ids of
+// generous length, so an oversized list is cut off before it is decoded.
+func (s *Server) idsBodyLimit() int64 {
+	return int64(s.cfg.MaxListLimit)*256 + 1<<10
+}
+
+type batchGetRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// handleBatchGetItems returns the live items among up to MaxListLimit ids,
+// in request order. Missing ids are left out rather than failing the batch.
+func (s *Server) handleBatchGetItems(w http.ResponseWriter, r *http.Request) {
+	var req batchGetRequest
+	if !decodeLimitedBody(w, r, &req, s.idsBodyLimit()) {
+		return
+	}
+	if len(req.IDs) == 0 {
+		writeError(w, http.StatusBadRequest, CodeIDsRequired, "ids is required")
+		return
+	}
+	if len(req.IDs) > s.cfg.MaxListLimit {
+		writeError(w, http.StatusBadRequest, CodeTooManyIDs, fmt.Sprintf("at most %d ids per request", s.cfg.MaxListLimit))
+		return
+	}
+
+	items, err := s.store.GetManyContext(r.Context(), req.IDs)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+func (s *Server) handleRestoreItem(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	item, err := s.store.Restore(id)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, CodeNotFound, "not found")
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
+// handleDuplicateItem copies an item under "<title> (copy)" or the title
+// given in ?title=.
+func (s *Server) handleDuplicateItem(w http.ResponseWriter, r *http.Request) {
+	title := ""
+	if r.URL.Query().Has("title") {
+		title = store.CleanTitle(r.URL.Query().Get("title"))
+		if title == "" {
+			writeError(w, http.StatusBadRequest, CodeTitleRequired, "title is required")
+			return
+		}
+	} else {
+		// Derive the title here so the limit applies to it too
+		src, err := s.store.GetContext(r.Context(), r.PathValue("id"))
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, CodeNotFound, "not found")
+			return
+		}
+		if err != nil {
+			storeError(w, r, err)
+			return
+		}
+		title = store.CopyTitle(src.Title)
+	}
+	if !s.checkItemSize(w, title, "") {
+		return
+	}
+
+	if !s.checkQuota(w, r) {
+		return
+	}
+
+	item, err := s.store.Duplicate(r.PathValue("id"), title, requestOwner(r))
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, CodeNotFound, "not found")
+		return
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint") {
+			writeError(w, http.StatusConflict, CodeTitleConflict, "title already exists")
+			return
+		}
+		storeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setItemValidators(w, item)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(item)
+}
+
+// handleSetPinned returns a handler that pins or unpins an item and
+// responds with the updated item.
+func (s *Server) handleSetPinned(pinned bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		err := s.store.SetPinned(id, pinned)
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, CodeNotFound, "not found")
+			return
+		}
+		if err != nil {
+			storeError(w, r, err)
+			return
+		}
+
+		item, err := s.store.GetContext(r.Context(), id)
+		if err != nil {
+			storeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		setItemValidators(w, item)
+		json.NewEncoder(w).Encode(item)
+	}
+}
+
+// handleSetArchived returns a handler that archives or unarchives an item
+// and responds with the updated item.
+func (s *Server) handleSetArchived(archived bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		err := s.store.SetArchived(id, archived)
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, CodeNotFound, "not found")
+			return
+		}
+		if err != nil {
+			storeError(w, r, err)
+			return
+		}
+
+		item, err := s.store.GetContext(r.Context(), id)
+		if err != nil {
+			storeError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		setItemValidators(w, item)
+		json.NewEncoder(w).Encode(item)
+	}
+}
+
+func (s *Server) handleBacklinks(w http.ResponseWriter, r *http.Request) {
+	fields, err := queryFields(r)
+	if err != nil {
+		writeParamError(w, err)
+		return
+	}
+
+	items, err := s.store.BacklinksContext(r.Context(), r.PathValue("id"))
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, CodeNotFound, "not found")
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	fields.writeList(w, items)
 }
 
-func (s *Server) HandleStatus(w http.ResponseWriter, r *http.Request) {
+// handleStats reports aggregate figures about the live items.
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.store.CorpusStatsContext(r.Context(), s.store.Now())
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"version": s.version,
-		"status":  "ok",
-	})
+	json.NewEncoder(w).Encode(stats)
 }
 
-func (s *Server) HandleHealth(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleListRelations(w http.ResponseWriter, r *http.Request) {
+	relations, err := s.store.ListRelationsContext(r.Context(), r.PathValue("id"))
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, CodeNotFound, "not found")
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(relations)
 }
 
-func (s *Server) handleListItems(w http.ResponseWriter, r *http.Request) {
-	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
-	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+type addRelationRequest struct {
+	To   string `json:"to"`
+	Type string `json:"type,omitempty"` // Default store.DefaultRelationType
+}
 
-	items, err := s.store.List(limit, offset)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// handleAddRelation relates the item to the one named by "to". Adding a
+// relation that already exists answers 200 rather than 201.
+func (s *Server) handleAddRelation(w http.ResponseWriter, r *http.Request) {
+	var req addRelationRequest
+	if !s.decodeItemBody(w, r, &req) {
 		return
 	}
-
-	if items == nil {
-		items = []store.Item{}
+	if req.To == "" {
+		writeError(w, http.StatusBadRequest, CodeInvalidRelation, "to is required")
+		return
 	}
 
+	rel, created, err := s.store.AddRelationContext(r.Context(), r.PathValue("id"), req.To, req.Type)
+	if !writeRelationError(w, r, err) {
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(items)
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(rel)
 }
 
-type createItemRequest struct {
-	Title   string  `json:"title"`
-	Content string  `json:"content"`
-	Link    *string `json:"link,omitempty"`
+// handleRemoveRelation deletes the relation to ?to= of ?type= (default
+// store.DefaultRelationType). Only relations from this item are removed.
+func (s *Server) handleRemoveRelation(w http.ResponseWriter, r *http.Request) {
+	to := r.URL.Query().Get("to")
+	if to == "" {
+		writeParamError(w, &paramError{Param: "to", Message: "to is required"})
+		return
+	}
+	err := s.store.RemoveRelationContext(r.Context(), r.PathValue("id"), to, r.URL.Query().Get("type"))
+	if !writeRelationError(w, r, err) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func (s *Server) handleCreateItem(w http.ResponseWriter, r *http.Request) {
-	var req createItemRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid JSON", http.StatusBadRequest)
-		return
+// writeRelationError maps a relation store error to 400, 404, or a store
+// error, reporting whether err was nil.
+func writeRelationError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case err == sql.ErrNoRows:
+		writeError(w, http.StatusNotFound, CodeNotFound, "not found")
+	case errors.Is(err, store.ErrSelfRelation), errors.Is(err, store.ErrInvalidRelationType):
+		writeError(w, http.StatusBadRequest, CodeInvalidRelation, err.Error())
+	default:
+		storeError(w, r, err)
 	}
+	return false
+}
 
-	if strings.TrimSpace(req.Title) == "" {
-		http.Error(w, "title is required", http.StatusBadRequest)
+func (s *Server) handleListVersions(w http.ResponseWriter, r *http.Request) {
+	versions, err := s.store.ListVersions(r.PathValue("id"))
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, CodeNotFound, "not found")
 		return
 	}
-
-	item, err := s.store.Create(req.Title, req.Content, req.Link)
 	if err != nil {
-		if strings.Contains(err.Error(), "UNIQUE constraint") {
-			http.Error(w, "title already exists", http.StatusConflict)
-			return
-		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(item)
+	json.NewEncoder(w).Encode(versions)
 }
 
-func (s *Server) handleGetItem(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
+// versionParam parses the {n} path value, writing a 400 if it is invalid.
+func versionParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	n, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil || n <= 0 {
+		writeError(w, http.StatusBadRequest, CodeInvalidParam, "invalid version")
+		return 0, false
+	}
+	return n, true
+}
 
-	item, err := s.store.Get(id)
+func (s *Server) handleGetVersion(w http.ResponseWriter, r *http.Request) {
+	n, ok := versionParam(w, r)
+	if !ok {
+		return
+	}
+
+	version, err := s.store.GetVersion(r.PathValue("id"), n)
 	if err == sql.ErrNoRows {
-		http.Error(w, "not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, CodeNotFound, "not found")
 		return
 	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(item)
+	json.NewEncoder(w).Encode(version)
 }
 
-type updateItemRequest struct {
-	Title   string  `json:"title"`
-	Content string  `json:"content"`
-	Link    *string `json:"link,omitempty"`
+type versionDiffResponse struct {
+	ItemID    string      `json:"itemId"`
+	Version   int         `json:"version"`
+	FromTitle string      `json:"fromTitle"`
+	ToTitle   string      `json:"toTitle"`
+	Lines     []diff.Line `json:"lines"` // Content diff from the version to the current item
 }
 
-func (s *Server) handleUpdateItem(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleDiffVersion(w http.ResponseWriter, r *http.Request) {
+	n, ok := versionParam(w, r)
+	if !ok {
+		return
+	}
 	id := r.PathValue("id")
 
-	var req updateItemRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid JSON", http.StatusBadRequest)
+	version, err := s.store.GetVersion(id, n)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, CodeNotFound, "not found")
 		return
 	}
-
-	if strings.TrimSpace(req.Title) == "" {
-		http.Error(w, "title is required", http.StatusBadRequest)
+	if err != nil {
+		storeError(w, r, err)
 		return
 	}
 
-	item, err := s.store.Update(id, req.Title, req.Content, req.Link)
+	// The item may be trashed between the two reads
+	item, err := s.store.GetContext(r.Context(), id)
 	if err == sql.ErrNoRows {
-		http.Error(w, "not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, CodeNotFound, "not found")
 		return
 	}
 	if err != nil {
-		if strings.Contains(err.Error(), "UNIQUE constraint") {
-			http.Error(w, "title already exists", http.StatusConflict)
-			return
-		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		storeError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(item)
+	json.NewEncoder(w).Encode(versionDiffResponse{
+		ItemID:    id,
+		Version:   n,
+		FromTitle: version.Title,
+		ToTitle:   item.Title,
+		Lines:     diff.Lines(version.Content, item.Content),
+	})
 }
 
-func (s *Server) handleDeleteItem(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
+func (s *Server) handleRestoreVersion(w http.ResponseWriter, r *http.Request) {
+	n, ok := versionParam(w, r)
+	if !ok {
+		return
+	}
 
-	err := s.store.Delete(id)
+	item, err := s.store.RestoreVersion(r.PathValue("id"), n)
 	if err == sql.ErrNoRows {
-		http.Error(w, "not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, CodeNotFound, "not found")
 		return
 	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if strings.Contains(err.Error(), "UNIQUE constraint") {
+			writeError(w, http.StatusConflict, CodeTitleConflict, "title already exists")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
 }
 
 func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query().Get("q")
 	if query == "" {
-		http.Error(w, "q parameter required", http.StatusBadRequest)
+		writeParamError(w, &paramError{Param: "q", Message: "q parameter required"})
 		return
 	}
 
-	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	limit, err := s.queryLimit(r, s.cfg.DefaultSearchLimit)
+	if err != nil {
+		writeParamError(w, err)
+		return
+	}
+	offset, err := queryCount(r, "offset")
+	if err != nil {
+		writeParamError(w, err)
+		return
+	}
+	dedupe, _ := strconv.ParseBool(r.URL.Query().Get("dedupe"))
+	prefix, _ := strconv.ParseBool(r.URL.Query().Get("prefix"))
+	snippetLen, _ := strconv.Atoi(r.URL.Query().Get("snippet_len"))
+	highlights, _ := strconv.ParseBool(r.URL.Query().Get("highlights"))
+	excludeArchived, _ := strconv.ParseBool(r.URL.Query().Get("exclude_archived"))
+	// Snippets are on unless explicitly turned off
+	snippet, err := strconv.ParseBool(r.URL.Query().Get("snippet"))
+	omitSnippet := err == nil && !snippet
+
+	snippetColumn := store.SnippetContent
+	if field := r.URL.Query().Get("snippet_field"); field != "" {
+		var ok bool
+		if snippetColumn, ok = snippetFields[field]; !ok {
+			writeParamError(w, &paramError{Param: "snippet_field", Message: "invalid snippet_field (use title, content, or link)"})
+			return
+		}
+	}
 
-	results, err := s.store.Search(query, limit)
+	opts := store.SearchOptions{
+		Limit:         limit,
+		Offset:        offset,
+		Tags:          r.URL.Query()["tag"],
+		Dedupe:        dedupe,
+		Prefix:        prefix,
+		SnippetTokens: snippetLen,
+		SnippetColumn: snippetColumn,
+		MarkOpen:      r.URL.Query().Get("mark_open"),
+		MarkClose:     r.URL.Query().Get("mark_close"),
+		Highlights:    highlights,
+		Columns:       r.URL.Query()["in"],
+		Mode:          r.URL.Query().Get("mode"),
+
+		ExcludeArchived: excludeArchived,
+		OmitSnippet:     omitSnippet,
+	}
+	results, err := s.store.SearchContext(r.Context(), query, opts)
 	if err != nil {
-		// FTS5 query syntax errors
-		if strings.Contains(err.Error(), "fts5") {
-			http.Error(w, "invalid search query", http.StatusBadRequest)
+		if errors.Is(err, store.ErrUnknownSearchField) {
+			writeError(w, http.StatusBadRequest, CodeSearchSyntax, err.Error())
+			return
+		}
+		if errors.Is(err, store.ErrInvalidMarker) {
+			writeError(w, http.StatusBadRequest, CodeInvalidParam, err.Error())
 			return
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if errors.Is(err, store.ErrInvalidSearchColumn) {
+			writeParamError(w, &paramError{Param: "in", Message: err.Error()})
+			return
+		}
+		if errors.Is(err, store.ErrInvalidQueryMode) {
+			writeParamError(w, &paramError{Param: "mode", Message: err.Error() + " (use simple or raw)"})
+			return
+		}
+		if errors.Is(err, store.ErrSearchSyntax) {
+			writeError(w, http.StatusBadRequest, CodeSearchSyntax, err.Error())
+			return
+		}
+		storeError(w, r, err)
 		return
 	}
 
@@ -229,7 +1656,199 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(results)
+
+	if !wantsListMeta(r) {
+		json.NewEncoder(w).Encode(results)
+		return
+	}
+
+	total, err := s.store.CountSearchContext(r.Context(), query, opts)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(searchResponse{
+		Results: results,
+		Total:   total,
+		Limit:   limit,
+		Offset:  offset,
+	})
+}
+
+// handleSuggest returns up to limit titles matching the ?q= prefix as a
+// JSON array of strings, for type-ahead in the title box.
+func (s *Server) handleSuggest(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("q")
+	if strings.TrimSpace(prefix) == "" {
+		writeParamError(w, &paramError{Param: "q", Message: "q parameter required"})
+		return
+	}
+	limit, err := queryCount(r, "limit")
+	if err != nil {
+		writeParamError(w, err)
+		return
+	}
+
+	titles, err := s.store.SuggestTitlesContext(r.Context(), prefix, limit)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(titles)
+}
+
+// snippetFields maps ?snippet_field= values to store snippet columns.
+var snippetFields = map[string]int{
+	"title":   store.SnippetTitle,
+	"content": store.SnippetContent,
+	"link":    store.SnippetLink,
+}
+
+// searchResponse wraps search results with the total match count.
+type searchResponse struct {
+	Results []store.SearchResult `json:"results"`
+	Total   int                  `json:"total"` // Matching items before limit, offset, and dedupe
+	Limit   int                  `json:"limit"`
+	Offset  int                  `json:"offset"`
+}
+
+// handleExport streams every item as a JSON array, one element at a time,
+// so large databases are never buffered in memory.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	filename := "cue-export-" + time.Now().UTC().Format("2006-01-02") + ".json"
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	io.WriteString(w, "[")
+	first := true
+	err := s.store.ExportEachContext(r.Context(), func(item store.Item) error {
+		b, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		if !first {
+			io.WriteString(w, ",")
+		}
+		first = false
+		_, err = w.Write(b)
+		return err
+	})
+	if err != nil {
+		// Headers are already sent; abandon the response so the client
+		// sees truncated (invalid) JSON rather than a silent partial export.
+		panic(http.ErrAbortHandler)
+	}
+	io.WriteString(w, "]\n")
+}
+
+// csvContentLimit caps the content column of the CSV export in characters,
+// staying under the 32,767-character cell limit of common spreadsheets.
+const csvContentLimit = 32000
+
+// csvHeader names the columns of the CSV export.
+var csvHeader = []string{"id", "title", "link", "created_at", "updated_at", "content"}
+
+// handleExportCSV streams every live item as CSV for spreadsheets. Content
+// longer than csvContentLimit is cut off with a trailing "…".
+func (s *Server) handleExportCSV(w http.ResponseWriter, r *http.Request) {
+	filename := "cue-export-" + time.Now().UTC().Format("2006-01-02") + ".csv"
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	err := s.store.ExportEachContext(r.Context(), func(item store.Item) error {
+		link := ""
+		if item.Link != nil {
+			link = *item.Link
+		}
+		return cw.Write([]string{
+			item.ID,
+			csvCell(item.Title),
+			csvCell(link),
+			item.CreatedAt.UTC().Format(time.RFC3339),
+			item.UpdatedAt.UTC().Format(time.RFC3339),
+			csvCell(truncateRunes(item.Content, csvContentLimit)),
+		})
+	})
+	if err == nil {
+		cw.Flush()
+		err = cw.Error()
+	}
+	if err != nil {
+		// As in handleExport, a cut-off file beats a silently partial one
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// csvCell guards a free-text CSV value against formula injection: text
+// starting with =, +, -, @, tab, or carriage return is prefixed with a
+// single quote so spreadsheets show it rather than evaluate it.
+func csvCell(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+// truncateRunes shortens s to at most n characters, ending in "…" when cut.
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	mode := store.ConflictMode(r.URL.Query().Get("on_conflict"))
+	switch mode {
+	case "":
+		mode = store.ConflictSkip
+	case store.ConflictSkip, store.ConflictReplace, store.ConflictFail:
+	default:
+		writeParamError(w, &paramError{Param: "on_conflict", Message: "on_conflict must be skip, replace, or fail"})
+		return
+	}
+
+	var items []store.Item
+	if !decodeLimitedBody(w, r, &items, int64(s.cfg.MaxImportBytes)) {
+		return
+	}
+
+	owner := requestOwner(r)
+	for i := range items {
+		if store.CleanTitle(items[i].Title) == "" {
+			writeError(w, http.StatusBadRequest, CodeTitleRequired, "title is required")
+			return
+		}
+		if !s.checkItemSize(w, items[i].Title, items[i].Content) {
+			return
+		}
+		// New items belong to the importer, whatever the file says
+		items[i].CreatedBy = owner
+	}
+
+	importItems := s.store.ImportItemsWithQuota
+	if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run")); dryRun {
+		importItems = s.store.DryRunImportWithQuota
+	}
+	result, err := importItems(items, mode, s.userQuota(r))
+	if errors.Is(err, store.ErrImportConflict) {
+		writeError(w, http.StatusConflict, CodeTitleConflict, err.Error())
+		return
+	}
+	if errors.Is(err, store.ErrImportQuota) {
+		writeError(w, http.StatusForbidden, CodeQuotaExceeded, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
 }
 
 // Auth handlers
@@ -243,6 +1862,14 @@ type whoAmIResponse struct {
 type userInfo struct {
 	CN         string `json:"cn"`
 	AuthMethod string `json:"auth_method"`
+
+	// Token auth only
+	TokenID   string     `json:"token_id,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Scopes    []string   `json:"scopes,omitempty"` // Effective scopes; all scopes for legacy tokens
+
+	// Cert auth only
+	NotAfter *time.Time `json:"not_after,omitempty"`
 }
 
 func (s *Server) handleWhoAmI(w http.ResponseWriter, r *http.Request) {
@@ -263,7 +1890,7 @@ func (s *Server) handleWhoAmI(w http.ResponseWriter, r *http.Request) {
 			})
 			return
 		}
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeError(w, http.StatusUnauthorized, CodeUnauthorized, "Unauthorized")
 		return
 	}
 
@@ -272,19 +1899,40 @@ func (s *Server) handleWhoAmI(w http.ResponseWriter, r *http.Request) {
 		mode = "single-user"
 	}
 
+	info := &userInfo{
+		CN:         user.CN,
+		AuthMethod: user.AuthMethod,
+	}
+	switch {
+	case user.AuthMethod == "token" && user.TokenID != "":
+		tok, err := s.store.GetTokenByID(user.TokenID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+			return
+		}
+		if tok != nil {
+			info.TokenID = tok.ID
+			info.ExpiresAt = &tok.ExpiresAt
+			info.Scopes = tok.Scopes
+			if len(info.Scopes) == 0 {
+				info.Scopes = auth.AllScopes
+			}
+		}
+	case user.AuthMethod == "cert" && !user.NotAfter.IsZero():
+		info.NotAfter = &user.NotAfter
+	}
+
 	json.NewEncoder(w).Encode(whoAmIResponse{
 		Authenticated: true,
-		User: &userInfo{
-			CN:         user.CN,
-			AuthMethod: user.AuthMethod,
-		},
-		Mode: mode,
+		User:          info,
+		Mode:          mode,
 	})
 }
 
 type createTokenRequest struct {
-	Name      string `json:"name"`
-	ExpiresIn string `json:"expires_in,omitempty"` // e.g., "720h"
+	Name      string   `json:"name"`
+	ExpiresIn string   `json:"expires_in,omitempty"` // e.g., "720h"
+	Scopes    []string `json:"scopes,omitempty"`     // Defaults to all scopes
 }
 
 type createTokenResponse struct {
@@ -293,29 +1941,30 @@ type createTokenResponse struct {
 	Token     string    `json:"token"` // Only shown once
 	CreatedAt time.Time `json:"created_at"`
 	ExpiresAt time.Time `json:"expires_at"`
+	Scopes    []string  `json:"scopes"`
 }
 
 func (s *Server) handleCreateToken(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r.Context())
 	if user == nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeError(w, http.StatusUnauthorized, CodeUnauthorized, "Unauthorized")
 		return
 	}
 
 	// In auth-enabled mode, require certificate auth for token creation
 	if s.authCfg.Enabled && user.AuthMethod != "cert" && user.AuthMethod != "none" {
-		http.Error(w, "Client certificate required to create tokens", http.StatusUnauthorized)
+		writeError(w, http.StatusUnauthorized, CodeCertRequired, "Client certificate required to create tokens")
 		return
 	}
 
 	var req createTokenRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "invalid JSON")
 		return
 	}
 
 	if strings.TrimSpace(req.Name) == "" {
-		http.Error(w, "name is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeNameRequired, "name is required")
 		return
 	}
 
@@ -324,11 +1973,11 @@ func (s *Server) handleCreateToken(w http.ResponseWriter, r *http.Request) {
 	if req.ExpiresIn != "" {
 		parsed, err := time.ParseDuration(req.ExpiresIn)
 		if err != nil {
-			http.Error(w, "invalid expires_in duration", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, CodeInvalidExpiry, "invalid expires_in duration")
 			return
 		}
 		if parsed <= 0 {
-			http.Error(w, "expires_in must be positive", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, CodeInvalidExpiry, "expires_in must be positive")
 			return
 		}
 		ttl = parsed
@@ -339,29 +1988,51 @@ func (s *Server) handleCreateToken(w http.ResponseWriter, r *http.Request) {
 		ttl = s.authCfg.MaxTTL
 	}
 
+	scopes := req.Scopes
+	if len(scopes) == 0 {
+		scopes = auth.AllScopes
+	}
+	if err := auth.ValidateScopes(scopes); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidScope, err.Error())
+		return
+	}
+
+	if limit := s.authCfg.MaxTokensPerUser; limit > 0 {
+		n, err := s.store.CountActiveTokens(user.CN)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, "failed to count tokens")
+			return
+		}
+		if n >= limit {
+			writeError(w, http.StatusForbidden, CodeTokenLimit,
+				fmt.Sprintf("token limit reached: %d of %d active tokens; revoke one first", n, limit))
+			return
+		}
+	}
+
 	// Generate token
 	tokenID, err := auth.GenerateTokenID()
 	if err != nil {
-		http.Error(w, "failed to generate token ID", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "failed to generate token ID")
 		return
 	}
 
-	token, expiresAt, err := auth.GenerateToken(user.CN, ttl, s.authCfg.Secret)
+	token, expiresAt, err := auth.GenerateTokenWithFormat(s.authCfg.Clock, s.authCfg.TokenFormat, user.CN, ttl, s.authCfg.Secret, scopes)
 	if err != nil {
-		http.Error(w, "failed to generate token", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "failed to generate token")
 		return
 	}
 
 	// Store token hash
 	tokenHash := auth.HashToken(token)
-	if err := s.store.CreateToken(tokenID, user.CN, req.Name, tokenHash, expiresAt); err != nil {
-		http.Error(w, "failed to store token", http.StatusInternalServerError)
+	if err := s.store.CreateToken(tokenID, user.CN, req.Name, tokenHash, expiresAt, scopes); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "failed to store token")
 		return
 	}
 
 	// Log token creation
 	if s.authCfg.Logger != nil {
-		s.authCfg.Logger.LogTokenCreated(user.CN, tokenID, req.Name, expiresAt.Format(time.RFC3339), auth.ExtractSourceIP(r, s.authCfg.TrustProxy))
+		s.authCfg.Logger.LogTokenCreated(r.Context(), user.CN, tokenID, req.Name, expiresAt.Format(time.RFC3339), auth.ExtractSourceIP(r, s.authCfg.TrustProxy))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -370,21 +2041,22 @@ func (s *Server) handleCreateToken(w http.ResponseWriter, r *http.Request) {
 		ID:        tokenID,
 		Name:      req.Name,
 		Token:     token,
-		CreatedAt: time.Now().UTC(),
+		CreatedAt: s.authCfg.Clock.Now().UTC(),
 		ExpiresAt: expiresAt,
+		Scopes:    scopes,
 	})
 }
 
 func (s *Server) handleListTokens(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r.Context())
 	if user == nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeError(w, http.StatusUnauthorized, CodeUnauthorized, "Unauthorized")
 		return
 	}
 
 	tokens, err := s.store.ListTokens(user.CN)
 	if err != nil {
-		http.Error(w, "failed to list tokens", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "failed to list tokens")
 		return
 	}
 
@@ -396,16 +2068,70 @@ func (s *Server) handleListTokens(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(tokens)
 }
 
+type updateTokenRequest struct {
+	Name string `json:"name"`
+}
+
+func (s *Server) handleUpdateToken(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r.Context())
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	// In auth-enabled mode, require certificate auth for token changes
+	if s.authCfg.Enabled && user.AuthMethod != "cert" && user.AuthMethod != "none" {
+		writeError(w, http.StatusUnauthorized, CodeCertRequired, "Client certificate required to update tokens")
+		return
+	}
+
+	var req updateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "invalid JSON")
+		return
+	}
+
+	if strings.TrimSpace(req.Name) == "" {
+		writeError(w, http.StatusBadRequest, CodeNameRequired, "name is required")
+		return
+	}
+
+	tokenID := r.PathValue("id")
+
+	err := s.store.UpdateTokenName(tokenID, user.CN, req.Name)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, CodeNotFound, "token not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "failed to update token")
+		return
+	}
+
+	tok, err := s.store.GetTokenByID(tokenID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "failed to load token")
+		return
+	}
+
+	if s.authCfg.Logger != nil {
+		s.authCfg.Logger.LogTokenUpdated(r.Context(), user.CN, tokenID, req.Name, auth.ExtractSourceIP(r, s.authCfg.TrustProxy))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tok)
+}
+
 func (s *Server) handleDeleteToken(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r.Context())
 	if user == nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeError(w, http.StatusUnauthorized, CodeUnauthorized, "Unauthorized")
 		return
 	}
 
 	// In auth-enabled mode, require certificate auth for token deletion
 	if s.authCfg.Enabled && user.AuthMethod != "cert" && user.AuthMethod != "none" {
-		http.Error(w, "Client certificate required to delete tokens", http.StatusUnauthorized)
+		writeError(w, http.StatusUnauthorized, CodeCertRequired, "Client certificate required to delete tokens")
 		return
 	}
 
@@ -413,18 +2139,96 @@ func (s *Server) handleDeleteToken(w http.ResponseWriter, r *http.Request) {
 
 	err := s.store.DeleteToken(tokenID, user.CN)
 	if err == sql.ErrNoRows {
-		http.Error(w, "token not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, CodeNotFound, "token not found")
 		return
 	}
 	if err != nil {
-		http.Error(w, "failed to delete token", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "failed to delete token")
 		return
 	}
 
 	// Log token revocation
 	if s.authCfg.Logger != nil {
-		s.authCfg.Logger.LogTokenRevoked(user.CN, tokenID, auth.ExtractSourceIP(r, s.authCfg.TrustProxy))
+		s.authCfg.Logger.LogTokenRevoked(r.Context(), user.CN, tokenID, auth.ExtractSourceIP(r, s.authCfg.TrustProxy))
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// revokeAllTokensResponse reports how many tokens DELETE /api/tokens revoked.
+type revokeAllTokensResponse struct {
+	Revoked int `json:"revoked"`
+}
+
+// handleDeleteAllTokens revokes every token the user holds, for when one
+// may have leaked. Unlike revoking a single token it also accepts token
+// auth with tokens:manage: a stolen token can only force the owner to
+// issue new ones with their certificate, and the security log marks such
+// requests. The token making the request is revoked too.
+func (s *Server) handleDeleteAllTokens(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r.Context())
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	n, err := s.store.DeleteAllTokens(user.CN)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "failed to revoke tokens")
+		return
+	}
+
+	if s.authCfg.Logger != nil {
+		s.authCfg.Logger.LogTokensRevokedAll(r.Context(), user, n, auth.ExtractSourceIP(r, s.authCfg.TrustProxy))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revokeAllTokensResponse{Revoked: n})
+}
+
+type introspectTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// introspectTokenResponse follows RFC 7662: an inactive token reports only
+// active=false, without saying why.
+type introspectTokenResponse struct {
+	Active bool     `json:"active"`
+	CN     string   `json:"cn,omitempty"`
+	EXP    int64    `json:"exp,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// handleIntrospectToken reports whether a token would authenticate, for
+// gateways that do not hold the signing secret: the signature and expiry
+// must check out, with the middleware's leeway, and the token must not
+// have been revoked. Introspecting is not a use of the token, so its
+// last-use time and IP are left alone.
+func (s *Server) handleIntrospectToken(w http.ResponseWriter, r *http.Request) {
+	if auth.GetUser(r.Context()) == nil {
+		writeError(w, http.StatusUnauthorized, CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req introspectTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "invalid JSON")
+		return
+	}
+
+	var resp introspectTokenResponse
+	leeway := auth.EffectiveTokenLeeway(s.authCfg.TokenLeeway)
+	claims, err := auth.ValidateTokenWithLeeway(s.authCfg.Clock, req.Token, s.authCfg.Secret, leeway)
+	if err == nil {
+		if _, err := s.store.LookupTokenHash(auth.HashToken(req.Token), leeway); err == nil {
+			resp = introspectTokenResponse{Active: true, CN: claims.CN, EXP: claims.EXP, Scopes: claims.Scopes}
+			if len(resp.Scopes) == 0 {
+				// Tokens from before scopes existed have full access
+				resp.Scopes = auth.AllScopes
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
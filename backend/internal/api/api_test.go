@@ -2,12 +2,26 @@ package api
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode/utf8"
 
+	"github.com/alanp/cue/internal/auth"
+	"github.com/alanp/cue/internal/clock"
 	"github.com/alanp/cue/internal/store"
 )
 
@@ -55,6 +69,44 @@ func TestIntegrationHealth(t *testing.T) {
 	}
 }
 
+func TestIntegrationReady(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-api-ready-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, err := store.New(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New(s)
+
+	check := func(wantCode int, wantStatus string) map[string]string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/ready", nil))
+		var resp map[string]string
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != wantCode || resp["status"] != wantStatus {
+			t.Errorf("ready = %d %v, want %d %q", w.Code, resp, wantCode, wantStatus)
+		}
+		return resp
+	}
+
+	check(http.StatusOK, "ready")
+
+	// Liveness stays up while readiness fails
+	s.Close()
+	resp := check(http.StatusServiceUnavailable, "unavailable")
+	if !strings.Contains(resp["reason"], "database") {
+		t.Errorf("reason = %q", resp["reason"])
+	}
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/health", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("health after close = %d, want 200", w.Code)
+	}
+}
+
 func TestIntegrationStatus(t *testing.T) {
 	srv, cleanup := setupTestServer(t)
 	defer cleanup()
@@ -158,6 +210,100 @@ func TestIntegrationCreateItemDuplicateTitle(t *testing.T) {
 	}
 }
 
+func TestIntegrationCreateItemWarnDuplicateContent(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	original, _ := srv.store.Create("Article", "Same words.", nil, nil, "")
+
+	create := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("POST", path, bytes.NewBufferString(body)))
+		return w
+	}
+
+	w := create("/api/items?warn_duplicate_content=true", `{"title": "Article (again)", "content": "Same words.\n"}`)
+	var resp struct {
+		store.Item
+		Duplicate bool `json:"duplicate"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || !resp.Duplicate || resp.ID != original.ID {
+		t.Fatalf("duplicate: status = %d, resp = %+v, want 200 with %s", w.Code, resp, original.ID)
+	}
+	if n, _ := srv.store.Count(); n != 1 {
+		t.Errorf("count = %d, want no new item", n)
+	}
+
+	w = create("/api/items?warn_duplicate_content=true", `{"title": "Other", "content": "Different words."}`)
+	resp.Duplicate = false
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusCreated || resp.Duplicate {
+		t.Errorf("non-match: status = %d, duplicate = %v, want 201", w.Code, resp.Duplicate)
+	}
+
+	// Without the parameter, duplicates are created as before
+	w = create("/api/items", `{"title": "Article copy", "content": "Same words."}`)
+	if w.Code != http.StatusCreated {
+		t.Errorf("default: status = %d, want 201", w.Code)
+	}
+}
+
+func TestIntegrationCreateItemIdempotencyKey(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	create := func(key, title string) (*httptest.ResponseRecorder, store.Item) {
+		req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "`+title+`", "content": "c"}`))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		var item store.Item
+		json.Unmarshal(w.Body.Bytes(), &item)
+		return w, item
+	}
+
+	w, first := create("retry-1", "Flaky")
+	if w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("first: status = %d, replayed = %q, want 201", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+
+	// The retry gets the original back rather than a title conflict
+	w, again := create("retry-1", "Flaky")
+	if w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "true" || again.ID != first.ID {
+		t.Errorf("repeat: status = %d, replayed = %q, id = %s; want 200 with %s", w.Code, w.Header().Get("Idempotent-Replayed"), again.ID, first.ID)
+	}
+	if w.Header().Get("ETag") == "" {
+		t.Error("repeat: missing ETag")
+	}
+
+	w, second := create("retry-2", "Steady")
+	if w.Code != http.StatusCreated || second.ID == first.ID {
+		t.Errorf("distinct key: status = %d, id = %s; want 201 with a new item", w.Code, second.ID)
+	}
+
+	items, _ := srv.store.List(0, 0)
+	if len(items) != 2 {
+		t.Errorf("items = %d, want 2", len(items))
+	}
+
+	for _, key := range []string{strings.Repeat("k", 256), "tab\there"} {
+		req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "Bad key", "content": "c"}`))
+		req.Header.Set(IdempotencyKeyHeader, key)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("key %q: status = %d, want 400", key, w.Code)
+			continue
+		}
+		if e := decodeError(t, w); e.Code != CodeInvalidParam || e.Param != IdempotencyKeyHeader {
+			t.Errorf("key %q: error = %+v", key, e)
+		}
+	}
+}
+
 func TestIntegrationGetItem(t *testing.T) {
 	srv, cleanup := setupTestServer(t)
 	defer cleanup()
@@ -329,6 +475,46 @@ func TestIntegrationSearch(t *testing.T) {
 	}
 }
 
+func TestIntegrationSuggest(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for _, body := range []string{`{"title": "SQLite Tips"}`, `{"title": "Learning SQL"}`, `{"title": "Postgres"}`} {
+		req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(body))
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req := httptest.NewRequest("GET", "/api/suggest?q=sq", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	var titles []string
+	json.NewDecoder(w.Body).Decode(&titles)
+	if w.Code != http.StatusOK || !slices.Equal(titles, []string{"SQLite Tips", "Learning SQL"}) {
+		t.Errorf("status = %d, titles = %q", w.Code, titles)
+	}
+
+	req = httptest.NewRequest("GET", "/api/suggest?q=nothing", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if body := strings.TrimSpace(w.Body.String()); w.Code != http.StatusOK || body != "[]" {
+		t.Errorf("no match: status = %d, body = %s, want 200 with []", w.Code, body)
+	}
+
+	for _, url := range []string{"/api/suggest", "/api/suggest?q=", "/api/suggest?q=%20", "/api/suggest?q=sq&limit=-1"} {
+		req := httptest.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", url, w.Code)
+			continue
+		}
+		if e := decodeError(t, w); e.Code != CodeInvalidParam {
+			t.Errorf("%s: code = %q, want %q", url, e.Code, CodeInvalidParam)
+		}
+	}
+}
+
 func TestIntegrationSearchMissingQuery(t *testing.T) {
 	srv, cleanup := setupTestServer(t)
 	defer cleanup()
@@ -607,3 +793,2422 @@ func TestIntegrationContentTypeHeader(t *testing.T) {
 		t.Errorf("Content-Type = %q, want application/json", ct)
 	}
 }
+
+func TestIntegrationSearchDedupe(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for _, title := range []string{"Runbook", "Runbook!", "Runbook."} {
+		body := `{"title": "` + title + `", "content": "outage runbook"}`
+		req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(body))
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req := httptest.NewRequest("GET", "/api/search?q=outage", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	var results []store.SearchResult
+	json.NewDecoder(w.Body).Decode(&results)
+	if len(results) != 3 {
+		t.Errorf("default len = %d, want 3", len(results))
+	}
+
+	req = httptest.NewRequest("GET", "/api/search?q=outage&dedupe=true", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	results = nil
+	json.NewDecoder(w.Body).Decode(&results)
+	if len(results) != 1 {
+		t.Fatalf("deduped len = %d, want 1", len(results))
+	}
+	if results[0].DuplicateCount != 2 {
+		t.Errorf("duplicate_count = %d, want 2", results[0].DuplicateCount)
+	}
+}
+
+func TestIntegrationHealthFull(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-api-health-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, err := store.New(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	srv := NewWithConfig(s, AuthConfig{}, Config{HealthDetail: HealthFull}, "1.2.3")
+
+	req := httptest.NewRequest("GET", "/api/health", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var resp healthResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Status != "ok" {
+		t.Errorf("status = %q, want %q", resp.Status, "ok")
+	}
+	if resp.Version != "1.2.3" {
+		t.Errorf("version = %q, want %q", resp.Version, "1.2.3")
+	}
+	if resp.DB != "ok" {
+		t.Errorf("db = %q, want %q", resp.DB, "ok")
+	}
+	if resp.Uptime == "" {
+		t.Error("expected uptime")
+	}
+
+	// A closed database should report unhealthy
+	s.Close()
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/health", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("closed db status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestIntegrationInfo(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	srv.store.Create("One", "", nil, nil, "")
+	srv.store.Create("Two", "", nil, nil, "")
+	gone, _ := srv.store.Create("Gone", "", nil, nil, "")
+	srv.store.Delete(gone.ID)
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/info", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+
+	var fields map[string]json.RawMessage
+	json.NewDecoder(bytes.NewReader(w.Body.Bytes())).Decode(&fields)
+	for _, name := range []string{"version", "go_version", "uptime", "uptime_seconds", "items", "tokens", "db_size_bytes"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("missing %q in %s", name, w.Body.String())
+		}
+	}
+
+	var resp infoResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Items != 2 {
+		t.Errorf("items = %d, want 2 live items", resp.Items)
+	}
+	if resp.GoVersion != runtime.Version() {
+		t.Errorf("go_version = %q, want %q", resp.GoVersion, runtime.Version())
+	}
+	if resp.DBSizeBytes <= 0 {
+		t.Errorf("db_size_bytes = %d, want > 0", resp.DBSizeBytes)
+	}
+}
+
+func TestIntegrationTags(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for _, body := range []string{
+		`{"title": "One", "content": "tagged note", "tags": ["go", "work"]}`,
+		`{"title": "Two", "content": "tagged note", "tags": ["go"]}`,
+		`{"title": "Three", "content": "tagged note"}`,
+	} {
+		req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create status = %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	list := func(query string) []store.Item {
+		req := httptest.NewRequest("GET", "/api/items"+query, nil)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		var items []store.Item
+		json.NewDecoder(w.Body).Decode(&items)
+		return items
+	}
+
+	if items := list("?tag=go"); len(items) != 2 {
+		t.Errorf("tag=go len = %d, want 2", len(items))
+	}
+	if items := list("?tag=go&tag=work"); len(items) != 1 || items[0].Title != "One" {
+		t.Errorf("tag=go&tag=work = %v, want only One", items)
+	}
+	if items := list("?tag=missing"); len(items) != 0 {
+		t.Errorf("tag=missing len = %d, want 0", len(items))
+	}
+
+	req := httptest.NewRequest("GET", "/api/search?q=tagged&tag=work", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var results []store.SearchResult
+	json.NewDecoder(w.Body).Decode(&results)
+	if len(results) != 1 {
+		t.Errorf("search tag=work len = %d, want 1", len(results))
+	}
+
+	// PUT without tags keeps them; explicit tags replace them
+	one := list("?tag=work")[0]
+	body := `{"title": "One", "content": "edited"}`
+	req = httptest.NewRequest("PUT", "/api/items/"+one.ID, bytes.NewBufferString(body))
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var item store.Item
+	json.NewDecoder(w.Body).Decode(&item)
+	if len(item.Tags) != 2 {
+		t.Errorf("tags after PUT without tags = %q, want 2", item.Tags)
+	}
+
+	body = `{"title": "One", "content": "edited", "tags": ["home"]}`
+	req = httptest.NewRequest("PUT", "/api/items/"+one.ID, bytes.NewBufferString(body))
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	json.NewDecoder(w.Body).Decode(&item)
+	if len(item.Tags) != 1 || item.Tags[0] != "home" {
+		t.Errorf("tags after PUT = %q, want [home]", item.Tags)
+	}
+}
+
+func TestIntegrationTrashAndRestore(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "Oops", "content": "content"}`))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var item store.Item
+	json.NewDecoder(w.Body).Decode(&item)
+
+	req = httptest.NewRequest("DELETE", "/api/items/"+item.ID, nil)
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "/api/items?trashed=true", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var trashed []store.Item
+	json.NewDecoder(w.Body).Decode(&trashed)
+	if len(trashed) != 1 || trashed[0].ID != item.ID {
+		t.Fatalf("trashed = %v, want the deleted item", trashed)
+	}
+
+	req = httptest.NewRequest("POST", "/api/items/"+item.ID+"/restore", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("restore status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	req = httptest.NewRequest("GET", "/api/items/"+item.ID, nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("get after restore status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	req = httptest.NewRequest("POST", "/api/items/"+item.ID+"/restore", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("restore live item status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestIntegrationExport(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for _, body := range []string{
+		`{"title": "Alpha", "content": "a", "tags": ["x"]}`,
+		`{"title": "Beta", "content": "b"}`,
+	} {
+		req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(body))
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req := httptest.NewRequest("GET", "/api/export", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	cd := w.Header().Get("Content-Disposition")
+	if !strings.HasPrefix(cd, `attachment; filename="cue-export-`) || !strings.HasSuffix(cd, `.json"`) {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	var items []store.Item
+	if err := json.NewDecoder(w.Body).Decode(&items); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("len = %d, want 2", len(items))
+	}
+	for _, item := range items {
+		if item.Title == "Alpha" && (len(item.Tags) != 1 || item.Tags[0] != "x") {
+			t.Errorf("Alpha tags = %q, want [x]", item.Tags)
+		}
+	}
+}
+
+func TestIntegrationExportEmpty(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("GET", "/api/export", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	if strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("body = %q, want []", w.Body.String())
+	}
+}
+
+func TestIntegrationExportCSV(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	body := `{"title": "Notes, \"quoted\"", "link": "https://example.com/a,b", "content": "line one\nline two, with comma\n\"and quotes\""}`
+	req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var created store.Item
+	json.NewDecoder(w.Body).Decode(&created)
+
+	long := strings.Repeat("é", csvContentLimit+10)
+	for _, body := range []string{
+		`{"title": "=HYPERLINK(\"x\")", "content": "@sum"}`,
+		`{"title": "Long", "content": "` + long + `"}`,
+	} {
+		req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(body))
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req = httptest.NewRequest("GET", "/api/export.csv", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("Content-Type = %q, want text/csv", ct)
+	}
+	cd := w.Header().Get("Content-Disposition")
+	if !strings.HasPrefix(cd, `attachment; filename="cue-export-`) || !strings.HasSuffix(cd, `.csv"`) {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("parse CSV: %v", err)
+	}
+	if len(records) != 4 || !slices.Equal(records[0], csvHeader) {
+		t.Fatalf("records = %d, header = %q", len(records), records[0])
+	}
+
+	var row []string
+	for _, r := range records[1:] {
+		if r[0] == created.ID {
+			row = r
+		}
+	}
+	want := []string{
+		created.ID,
+		created.Title,
+		*created.Link,
+		created.CreatedAt.UTC().Format(time.RFC3339),
+		created.UpdatedAt.UTC().Format(time.RFC3339),
+		created.Content,
+	}
+	if !slices.Equal(row, want) {
+		t.Errorf("row = %q\nwant  %q", row, want)
+	}
+
+	for _, r := range records[1:] {
+		switch {
+		case strings.Contains(r[1], "HYPERLINK"):
+			if r[1] != `'=HYPERLINK("x")` || r[5] != "'@sum" {
+				t.Errorf("formula cells = %q, %q, want a leading quote", r[1], r[5])
+			}
+		case r[1] == "Long":
+			if n := utf8.RuneCountInString(r[5]); n != csvContentLimit || !strings.HasSuffix(r[5], "…") {
+				t.Errorf("long content = %d characters, want %d ending in …", n, csvContentLimit)
+			}
+		}
+	}
+}
+
+func TestIntegrationImport(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "Taken", "content": "mine"}`))
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+
+	payload := `[{"title": "Taken", "content": "theirs"}, {"title": "New One", "content": "fresh", "tags": ["a"]}]`
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		want   store.ImportResult
+	}{
+		{"dry run", "?dry_run=true", http.StatusOK, store.ImportResult{Created: 1, Skipped: 1}},
+		{"dry run fail", "?on_conflict=fail&dry_run=true", http.StatusConflict, store.ImportResult{}},
+		{"fail conflicts", "?on_conflict=fail", http.StatusConflict, store.ImportResult{}},
+		{"default skips", "", http.StatusOK, store.ImportResult{Created: 1, Skipped: 1}},
+		{"replace", "?on_conflict=replace", http.StatusOK, store.ImportResult{Replaced: 2}},
+		{"bad mode", "?on_conflict=merge", http.StatusBadRequest, store.ImportResult{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/import"+tc.query, bytes.NewBufferString(payload))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, req)
+			if w.Code != tc.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tc.status, w.Body.String())
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+			var got store.ImportResult
+			json.NewDecoder(w.Body).Decode(&got)
+			if got != tc.want {
+				t.Errorf("result = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+
+	req = httptest.NewRequest("POST", "/api/import", bytes.NewBufferString(`[{"title": ""}]`))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("empty title status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestIntegrationListMeta(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for i := 0; i < 5; i++ {
+		tag := "odd"
+		if i%2 == 0 {
+			tag = "even"
+		}
+		body := fmt.Sprintf(`{"title": "Item %d", "content": "c", "tags": [%q]}`, i, tag)
+		req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(body))
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	t.Run("QueryParam", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/items?meta=true&limit=2&offset=1", nil)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+
+		var resp listResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.Total != 5 || resp.Limit != 2 || resp.Offset != 1 || len(resp.Items) != 2 {
+			t.Errorf("resp = total %d limit %d offset %d items %d", resp.Total, resp.Limit, resp.Offset, len(resp.Items))
+		}
+	})
+
+	t.Run("AcceptParam", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/items?tag=even", nil)
+		req.Header.Set("Accept", "application/json; meta=true")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+
+		var resp listResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.Total != 3 {
+			t.Errorf("total = %d, want 3", resp.Total)
+		}
+		if resp.Limit != store.DefaultListLimit {
+			t.Errorf("limit = %d, want %d", resp.Limit, store.DefaultListLimit)
+		}
+	})
+
+	t.Run("DefaultBareArray", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/items", nil)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+
+		var items []store.Item
+		if err := json.NewDecoder(w.Body).Decode(&items); err != nil {
+			t.Fatalf("default response should be an array: %v", err)
+		}
+	})
+}
+
+func TestIntegrationListSort(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for _, title := range []string{"beta", "Alpha", "Gamma"} {
+		body := `{"title": "` + title + `", "content": "c"}`
+		req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(body))
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req := httptest.NewRequest("GET", "/api/items?sort=title_asc", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	var items []store.Item
+	json.NewDecoder(w.Body).Decode(&items)
+	if len(items) != 3 || items[0].Title != "Alpha" || items[1].Title != "beta" || items[2].Title != "Gamma" {
+		t.Errorf("items = %v, want Alpha, beta, Gamma", items)
+	}
+
+	req = httptest.NewRequest("GET", "/api/items?sort=sideways", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unknown sort status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestIntegrationVersions(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "Notes", "content": "a\nb"}`))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var item store.Item
+	json.NewDecoder(w.Body).Decode(&item)
+
+	req = httptest.NewRequest("PUT", "/api/items/"+item.ID, bytes.NewBufferString(`{"title": "Notes", "content": "a\nc"}`))
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "/api/items/"+item.ID+"/versions", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var versions []store.ItemVersion
+	json.NewDecoder(w.Body).Decode(&versions)
+	if len(versions) != 1 || versions[0].Content != "a\nb" {
+		t.Fatalf("versions = %+v, want one with original content", versions)
+	}
+
+	req = httptest.NewRequest("GET", "/api/items/"+item.ID+"/versions/1", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("get version status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	req = httptest.NewRequest("GET", "/api/items/"+item.ID+"/versions/1/diff", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var d versionDiffResponse
+	json.NewDecoder(w.Body).Decode(&d)
+	if len(d.Lines) != 3 || d.Lines[1].Op != "delete" || d.Lines[2].Op != "insert" {
+		t.Errorf("diff = %+v", d.Lines)
+	}
+
+	req = httptest.NewRequest("POST", "/api/items/"+item.ID+"/versions/1/restore", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	json.NewDecoder(w.Body).Decode(&item)
+	if item.Content != "a\nb" {
+		t.Errorf("restored content = %q", item.Content)
+	}
+
+	for _, path := range []string{"/versions/abc", "/versions/0"} {
+		req = httptest.NewRequest("GET", "/api/items/"+item.ID+path, nil)
+		w = httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s status = %d, want %d", path, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/api/items/missing/versions", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing item status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// A trashed item has no versions to diff
+	srv.store.Delete(item.ID)
+	req = httptest.NewRequest("GET", "/api/items/"+item.ID+"/versions/1/diff", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("trashed item diff status = %d, want %d: %s", w.Code, http.StatusNotFound, w.Body.String())
+	}
+}
+
+func TestIntegrationETag(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "Cached", "content": "v1"}`))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var created store.Item
+	json.NewDecoder(w.Body).Decode(&created)
+	createETag := w.Header().Get("ETag")
+
+	req = httptest.NewRequest("GET", "/api/items/"+created.ID, nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	etag := w.Header().Get("ETag")
+	if etag == "" || etag != createETag {
+		t.Fatalf("GET ETag = %q, create ETag = %q", etag, createETag)
+	}
+
+	// Matching If-None-Match returns 304 with no body
+	req = httptest.NewRequest("GET", "/api/items/"+created.ID, nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match status = %d, want %d", w.Code, http.StatusNotModified)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("304 body = %q, want empty", w.Body.String())
+	}
+
+	// A stale ETag gets the full item
+	req = httptest.NewRequest("GET", "/api/items/"+created.ID, nil)
+	req.Header.Set("If-None-Match", `"stale"`)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("stale If-None-Match status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestIntegrationLastModified(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	item, _ := srv.store.Create("Dated", "v1", nil, nil, "")
+	modified := item.UpdatedAt.UTC()
+
+	get := func(header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/items/"+item.ID, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get(nil)
+	lastModified := w.Header().Get("Last-Modified")
+	if lastModified != modified.Format(http.TimeFormat) {
+		t.Fatalf("Last-Modified = %q, want %q", lastModified, modified.Format(http.TimeFormat))
+	}
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   int
+	}{
+		{"matching", map[string]string{"If-Modified-Since": lastModified}, http.StatusNotModified},
+		{"later", map[string]string{"If-Modified-Since": modified.Add(time.Hour).Format(http.TimeFormat)}, http.StatusNotModified},
+		{"earlier", map[string]string{"If-Modified-Since": modified.Add(-time.Second).Format(http.TimeFormat)}, http.StatusOK},
+		{"malformed", map[string]string{"If-Modified-Since": "yesterday"}, http.StatusOK},
+		// If-None-Match wins when both are sent
+		{"stale etag", map[string]string{"If-Modified-Since": lastModified, "If-None-Match": `"stale"`}, http.StatusOK},
+		{"current etag", map[string]string{"If-Modified-Since": modified.Add(-time.Hour).Format(http.TimeFormat), "If-None-Match": itemETag(item)}, http.StatusNotModified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(tt.header)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("304 body = %q, want empty", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestIntegrationIfMatch(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "Shared", "content": "v1"}`))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var created store.Item
+	json.NewDecoder(w.Body).Decode(&created)
+	etag := w.Header().Get("ETag")
+
+	put := func(body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/items/"+created.ID, bytes.NewBufferString(body))
+		req.Header.Set("If-Match", ifMatch)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	// First editor wins with the current ETag
+	w = put(`{"title": "Shared", "content": "alice"}`, etag)
+	if w.Code != http.StatusOK {
+		t.Fatalf("first PUT status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w.Header().Get("ETag") == etag {
+		t.Error("ETag should change after update")
+	}
+
+	// Second editor still holds the old ETag
+	w = put(`{"title": "Shared", "content": "bob"}`, etag)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale PUT status = %d, want %d", w.Code, http.StatusPreconditionFailed)
+	}
+
+	got, _ := srv.store.Get(created.ID)
+	if got.Content != "alice" {
+		t.Errorf("content = %q, want %q", got.Content, "alice")
+	}
+
+	// If-Match on a missing item is a 404
+	req = httptest.NewRequest("PUT", "/api/items/missing", bytes.NewBufferString(`{"title": "x"}`))
+	req.Header.Set("If-Match", etag)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing item status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// Concurrent editors holding the same ETag: the rev check in the write
+	// lets exactly one through, even when all pass the ETag comparison
+	etag = put(`{"title": "Shared", "content": "base"}`, "*").Header().Get("ETag")
+	codes := make(chan int, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes <- put(fmt.Sprintf(`{"title": "Shared", "content": "editor %d"}`, i), etag).Code
+		}(i)
+	}
+	wg.Wait()
+	close(codes)
+	won := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			won++
+		case http.StatusPreconditionFailed:
+		default:
+			t.Errorf("concurrent PUT status = %d, want 200 or 412", code)
+		}
+	}
+	if won != 1 {
+		t.Errorf("%d concurrent PUTs succeeded with the same ETag, want 1", won)
+	}
+}
+
+func TestExpectedRev(t *testing.T) {
+	tests := []struct {
+		body, matched, want int
+		precondition        bool
+	}{
+		{0, 0, 0, false}, // No check at all
+		{3, 0, 3, false}, // Body rev only: a conflict is a 409
+		{0, 3, 3, true},  // If-Match only: the write expects the matched rev
+		{3, 3, 3, true},
+		{2, 3, 2, false}, // Stale body rev fails on its own
+	}
+	for _, tt := range tests {
+		got, precondition := expectedRev(tt.body, tt.matched)
+		if got != tt.want || precondition != tt.precondition {
+			t.Errorf("expectedRev(%d, %d) = %d, %v, want %d, %v", tt.body, tt.matched, got, precondition, tt.want, tt.precondition)
+		}
+	}
+}
+
+func TestIntegrationUpdateRevConflict(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "Shared note", "content": "v1"}`))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var created store.Item
+	json.NewDecoder(w.Body).Decode(&created)
+
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/items/"+created.ID, bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	w = put(fmt.Sprintf(`{"title": "Shared note", "content": "alice", "rev": %d}`, created.Rev))
+	if w.Code != http.StatusOK {
+		t.Fatalf("first PUT status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var updated store.Item
+	json.NewDecoder(w.Body).Decode(&updated)
+	if updated.Rev != created.Rev+1 {
+		t.Errorf("rev = %d, want %d", updated.Rev, created.Rev+1)
+	}
+
+	// Second editor started from the same rev
+	w = put(fmt.Sprintf(`{"title": "Shared note", "content": "bob", "rev": %d}`, created.Rev))
+	if w.Code != http.StatusConflict {
+		t.Errorf("stale PUT status = %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	// Without rev the update is unconditional
+	w = put(`{"title": "Shared note", "content": "carol"}`)
+	if w.Code != http.StatusOK {
+		t.Errorf("PUT without rev status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestIntegrationPatchItem(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	tests := []struct {
+		name        string
+		patch       string
+		wantTitle   string // Empty means unchanged
+		wantContent string
+		wantLink    string // Empty means no link
+		wantTags    []string
+	}{
+		{"title only", `{"title": "Renamed"}`, "Renamed", "body", "https://a", []string{"x"}},
+		{"content only", `{"content": "new"}`, "", "new", "https://a", []string{"x"}},
+		{"link only", `{"link": "https://b"}`, "", "body", "https://b", []string{"x"}},
+		{"null link clears", `{"link": null}`, "", "body", "", []string{"x"}},
+		{"tags only", `{"tags": ["y", "Z"]}`, "", "body", "https://a", []string{"y", "z"}},
+		{"empty tags clear", `{"tags": []}`, "", "body", "https://a", []string{}},
+		{"null tags clear", `{"tags": null}`, "", "body", "https://a", []string{}},
+		{"null title and content ignored", `{"title": null, "content": null}`, "", "body", "https://a", []string{"x"}},
+		{"content and null link", `{"content": "c", "link": null}`, "", "c", "", []string{"x"}},
+		{"all fields", `{"title": "All", "content": "c", "link": "l", "tags": ["t"]}`, "All", "c", "l", []string{"t"}},
+		{"empty object", `{}`, "", "body", "https://a", []string{"x"}},
+	}
+	for i, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			title := fmt.Sprintf("Patch %d", i)
+			body := fmt.Sprintf(`{"title": %q, "content": "body", "link": "https://a", "tags": ["x"]}`, title)
+			req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(body))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, req)
+			var created store.Item
+			json.NewDecoder(w.Body).Decode(&created)
+
+			req = httptest.NewRequest("PATCH", "/api/items/"+created.ID, bytes.NewBufferString(tc.patch))
+			w = httptest.NewRecorder()
+			srv.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+			}
+			if w.Header().Get("ETag") == "" {
+				t.Error("missing ETag")
+			}
+
+			var got store.Item
+			json.NewDecoder(w.Body).Decode(&got)
+			if tc.wantTitle == "" {
+				tc.wantTitle = title
+			}
+			link := ""
+			if got.Link != nil {
+				link = *got.Link
+			}
+			if got.Title != tc.wantTitle || got.Content != tc.wantContent || link != tc.wantLink || !slices.Equal(got.Tags, tc.wantTags) {
+				t.Errorf("got title %q, content %q, link %q, tags %q; want %q, %q, %q, %q",
+					got.Title, got.Content, link, got.Tags, tc.wantTitle, tc.wantContent, tc.wantLink, tc.wantTags)
+			}
+		})
+	}
+}
+
+func TestIntegrationPatchItemErrors(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for _, body := range []string{`{"title": "Taken"}`, `{"title": "Mine", "content": "v1"}`} {
+		req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(body))
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	req := httptest.NewRequest("GET", "/api/items?sort=title_asc", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var items []store.Item
+	json.NewDecoder(w.Body).Decode(&items)
+	mine := items[0]
+
+	tests := []struct {
+		name     string
+		id       string
+		body     string
+		ifMatch  string
+		wantCode int
+		wantErr  string
+	}{
+		{"empty title", mine.ID, `{"title": "  "}`, "", http.StatusBadRequest, CodeTitleRequired},
+		{"wrong link type", mine.ID, `{"link": 5}`, "", http.StatusBadRequest, CodeInvalidJSON},
+		{"wrong tags type", mine.ID, `{"tags": "x"}`, "", http.StatusBadRequest, CodeInvalidJSON},
+		{"malformed", mine.ID, `{"title":`, "", http.StatusBadRequest, CodeInvalidJSON},
+		{"title taken", mine.ID, `{"title": "taken"}`, "", http.StatusConflict, CodeTitleConflict},
+		{"stale rev", mine.ID, `{"content": "v2", "rev": 7}`, "", http.StatusConflict, CodeRevConflict},
+		{"stale If-Match", mine.ID, `{"content": "v2"}`, `"stale"`, http.StatusPreconditionFailed, CodePreconditionFailed},
+		{"missing item", "no-such-id", `{"content": "v2"}`, "", http.StatusNotFound, CodeNotFound},
+		{"missing item, empty patch", "no-such-id", `{}`, "", http.StatusNotFound, CodeNotFound},
+		{"title too long", mine.ID, `{"title": "` + strings.Repeat("t", DefaultMaxTitleLength+1) + `"}`, "", http.StatusRequestEntityTooLarge, CodeTitleTooLong},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("PATCH", "/api/items/"+tc.id, bytes.NewBufferString(tc.body))
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, req)
+			if w.Code != tc.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tc.wantCode)
+			}
+			if e := decodeError(t, w); e.Code != tc.wantErr {
+				t.Errorf("code = %q, want %q", e.Code, tc.wantErr)
+			}
+		})
+	}
+
+	// Nothing above changed the item
+	got, _ := srv.store.Get(mine.ID)
+	if got.Title != "Mine" || got.Content != "v1" || got.Rev != mine.Rev {
+		t.Errorf("item = %+v, want it untouched", got)
+	}
+}
+
+func TestIntegrationGetItemByTitle(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for _, title := range []string{"Plain", "C++ & Rust? 100%", "src/main.go", "Trashed"} {
+		body, _ := json.Marshal(map[string]string{"title": title})
+		req := httptest.NewRequest("POST", "/api/items", bytes.NewReader(body))
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	trashed, _ := srv.store.GetByTitle("Trashed")
+	srv.store.Delete(trashed.ID)
+
+	tests := []struct {
+		path      string
+		wantCode  int
+		wantTitle string
+	}{
+		{"/api/items/by-title/Plain", http.StatusOK, "Plain"},
+		{"/api/items/by-title/plain", http.StatusNotFound, ""},
+		{"/api/items/by-title/" + url.PathEscape("C++ & Rust? 100%"), http.StatusOK, "C++ & Rust? 100%"},
+		{"/api/items/by-title/src%2Fmain.go", http.StatusOK, "src/main.go"},
+		{"/api/items/by-title/src/main.go", http.StatusOK, "src/main.go"},
+		{"/api/items/by-title/Missing", http.StatusNotFound, ""},
+		{"/api/items/by-title/Trashed", http.StatusNotFound, ""},
+		{"/api/items/by-title/", http.StatusBadRequest, ""},
+		{"/api/items/by-title/%20%20", http.StatusBadRequest, ""},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+
+		if w.Code != tc.wantCode {
+			t.Errorf("%s: status = %d, want %d", tc.path, w.Code, tc.wantCode)
+			continue
+		}
+		if tc.wantCode != http.StatusOK {
+			if e := decodeError(t, w); e.Code == "" {
+				t.Errorf("%s: missing error code", tc.path)
+			}
+			continue
+		}
+		var item store.Item
+		json.NewDecoder(w.Body).Decode(&item)
+		if item.Title != tc.wantTitle || w.Header().Get("ETag") != itemETag(&item) {
+			t.Errorf("%s: title = %q, ETag = %q, want %q", tc.path, item.Title, w.Header().Get("ETag"), tc.wantTitle)
+		}
+	}
+}
+
+func TestIntegrationSearchPrefix(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "SQLite Tips", "content": "wal mode"}`))
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+
+	for _, tc := range []struct {
+		url  string
+		want int
+	}{
+		{"/api/search?q=sqli", 0},
+		{"/api/search?q=sqli&prefix=true", 1},
+		{"/api/search?q=%22sqli%22&prefix=true", 0},
+	} {
+		req := httptest.NewRequest("GET", tc.url, nil)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+
+		var results []store.SearchResult
+		json.NewDecoder(w.Body).Decode(&results)
+		if w.Code != http.StatusOK || len(results) != tc.want {
+			t.Errorf("%s: status = %d, len = %d, want 200 with %d", tc.url, w.Code, len(results), tc.want)
+		}
+	}
+}
+
+func TestIntegrationSearchFields(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "SQLite Tips", "content": "postgres notes"}`))
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+
+	for _, tc := range []struct {
+		url  string
+		want int
+	}{
+		{"/api/search?q=title:sqlite", 1},
+		{"/api/search?q=content:sqlite", 0},
+		{"/api/search?q=content:postgres", 1},
+	} {
+		req := httptest.NewRequest("GET", tc.url, nil)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+
+		var results []store.SearchResult
+		json.NewDecoder(w.Body).Decode(&results)
+		if w.Code != http.StatusOK || len(results) != tc.want {
+			t.Errorf("%s: status = %d, len = %d, want 200 with %d", tc.url, w.Code, len(results), tc.want)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/api/search?q=author:alan", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown field status = %d, want 400", w.Code)
+	}
+	if e := decodeError(t, w); e.Code != CodeSearchSyntax || !strings.Contains(e.Message, `unknown search field "author"`) {
+		t.Errorf("error = %+v, want unknown field message", e)
+	}
+}
+
+func TestIntegrationSearchColumns(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	link := "https://docs.example.com/sqlite"
+	srv.store.Create("SQLite Tips", "", nil, nil, "")
+	srv.store.Create("Bookmarks", "", &link, nil, "")
+
+	for _, tc := range []struct {
+		url  string
+		want int
+	}{
+		{"/api/search?q=sqlite", 2},
+		{"/api/search?q=sqlite&in=title&in=content", 1},
+		{"/api/search?q=sqlite&in=link", 1},
+		{"/api/search?q=sqlite&in=content&meta=true", 0},
+	} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", tc.url, nil))
+		var n int
+		if strings.Contains(tc.url, "meta=true") {
+			var resp searchResponse
+			json.NewDecoder(w.Body).Decode(&resp)
+			n = resp.Total
+		} else {
+			var results []store.SearchResult
+			json.NewDecoder(w.Body).Decode(&results)
+			n = len(results)
+		}
+		if w.Code != http.StatusOK || n != tc.want {
+			t.Errorf("%s: status = %d, got %d, want 200 with %d", tc.url, w.Code, n, tc.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/search?q=sqlite&in=tags", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown column status = %d, want 400", w.Code)
+	}
+	if e := decodeError(t, w); e.Code != CodeInvalidParam || e.Param != "in" {
+		t.Errorf("error = %+v, want invalid_param for in", e)
+	}
+}
+
+func TestIntegrationSearchModes(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	srv.store.Create("SQLite FTS", "", nil, nil, "")
+	srv.store.Create("SQLite WAL", "", nil, nil, "")
+
+	for _, tc := range []struct {
+		query string
+		want  int
+	}{
+		{"q=sqlite+and+fts", 2},
+		{"q=sqlite+and+fts&mode=simple", 1},
+		{"q=sqlite+NOT+fts&mode=raw", 1},
+		// Pasted text never fails to parse in simple mode
+		{"q=owner:me+%22sqlite+(fts*+%5E&mode=simple", 1},
+	} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/search?"+tc.query, nil))
+		var results []store.SearchResult
+		json.NewDecoder(w.Body).Decode(&results)
+		if w.Code != http.StatusOK || len(results) != tc.want {
+			t.Errorf("%s: status = %d, len = %d, want 200 with %d", tc.query, w.Code, len(results), tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		query string
+		code  string
+	}{
+		{"q=sqlite&mode=fancy", CodeInvalidParam},
+		{"q=sqlite+AND&mode=raw", CodeSearchSyntax},
+		{"q=%22unclosed&mode=raw", CodeSearchSyntax},
+	} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/search?"+tc.query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", tc.query, w.Code)
+			continue
+		}
+		if e := decodeError(t, w); e.Code != tc.code {
+			t.Errorf("%s: code = %q, want %q", tc.query, e.Code, tc.code)
+		}
+	}
+}
+
+func TestIntegrationSearchSnippetAndTotal(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for i := 0; i < 3; i++ {
+		body := fmt.Sprintf(`{"title": "Deploy %d", "content": "deploy notes"}`, i)
+		req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(body))
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req := httptest.NewRequest("GET", "/api/search?q=deploy&limit=2&meta=true&snippet_field=title", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Results []store.SearchResult `json:"results"`
+		Total   int                  `json:"total"`
+		Limit   int                  `json:"limit"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Results) != 2 || resp.Total != 3 || resp.Limit != 2 {
+		t.Errorf("got %d results, total %d, limit %d; want 2, 3, 2", len(resp.Results), resp.Total, resp.Limit)
+	}
+	for _, r := range resp.Results {
+		if !strings.HasPrefix(r.Snippet, "<mark>Deploy</mark>") {
+			t.Errorf("snippet = %q, want title snippet", r.Snippet)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/api/search?q=deploy&snippet_field=tags", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid snippet_field status = %d, want 400", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/search?q=deploy&snippet=false", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var results []store.SearchResult
+	json.NewDecoder(w.Body).Decode(&results)
+	if w.Code != http.StatusOK || len(results) != 3 {
+		t.Fatalf("snippet=false: status = %d, %d results", w.Code, len(results))
+	}
+	for _, r := range results {
+		if r.Snippet != "" || r.Item.Title == "" {
+			t.Errorf("snippet=false: result = %+v, want item without snippet", r)
+		}
+	}
+}
+
+func TestIntegrationSearchMarkers(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "Notes", "content": "sqlite tips"}`))
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "/api/search?q=sqlite&mark_open=%3Cem%3E&mark_close=%3C/em%3E", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var results []store.SearchResult
+	json.NewDecoder(w.Body).Decode(&results)
+	if w.Code != http.StatusOK || len(results) != 1 || results[0].Snippet != "<em>sqlite</em> tips" {
+		t.Fatalf("status = %d, results = %+v", w.Code, results)
+	}
+
+	req = httptest.NewRequest("GET", "/api/search?q=sqlite&highlights=true", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	results = nil
+	json.NewDecoder(w.Body).Decode(&results)
+	if len(results) != 1 || results[0].Snippet != "sqlite tips" || len(results[0].Highlights) != 1 || results[0].Highlights[0] != (store.HighlightSpan{Start: 0, End: 6}) {
+		t.Errorf("highlights results = %+v", results)
+	}
+
+	req = httptest.NewRequest("GET", "/api/search?q=sqlite&mark_open=%22%3E", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid marker status = %d, want 400", w.Code)
+	}
+}
+
+func TestIntegrationRequestTimeout(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "Notes", "content": "sqlite tips"}`))
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+
+	// A deadline that has already passed when the store is called
+	h := Timeout(time.Nanosecond)(srv)
+	for _, path := range []string{"/api/items", "/api/search?q=sqlite"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("GET %s status = %d, want 503", path, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	Timeout(time.Minute)(srv).ServeHTTP(w, httptest.NewRequest("GET", "/api/items", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("status with generous timeout = %d, want 200", w.Code)
+	}
+
+	// Exports stream for as long as the client reads and are not cut off
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/export", nil))
+	var exported []store.Item
+	if err := json.NewDecoder(w.Body).Decode(&exported); err != nil || w.Code != http.StatusOK || len(exported) != 1 {
+		t.Errorf("export past timeout: status = %d, %d items, err = %v", w.Code, len(exported), err)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/export.csv", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "sqlite tips") {
+		t.Errorf("CSV export past timeout: status = %d, body = %q", w.Code, w.Body.String())
+	}
+}
+
+func TestIntegrationQueryParamValidation(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	tests := []struct {
+		path  string
+		param string
+	}{
+		{"/api/items?limit=abc", "limit"},
+		{"/api/items?limit=-1", "limit"},
+		{"/api/items?offset=abc", "offset"},
+		{"/api/items?offset=-5", "offset"},
+		{"/api/search?q=x&limit=abc", "limit"},
+		{"/api/search?q=x&limit=-1", "limit"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s status = %d, want 400", tt.path, w.Code)
+			continue
+		}
+		if e := decodeError(t, w); e.Code != CodeInvalidParam || e.Param != tt.param || e.Message == "" {
+			t.Errorf("GET %s error = %+v, want param %q", tt.path, e, tt.param)
+		}
+	}
+
+	for _, path := range []string{"/api/items?limit=500&offset=0", "/api/search?q=x&limit=500"} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s status = %d, want 200", path, w.Code)
+		}
+	}
+}
+
+func TestIntegrationPageLimits(t *testing.T) {
+	s, err := store.New(filepath.Join(t.TempDir(), "cue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	srv := NewWithConfig(s, AuthConfig{}, Config{DefaultListLimit: 2, DefaultSearchLimit: 1, MaxListLimit: 3}, "dev")
+
+	for i := range 5 {
+		if _, err := s.Create(fmt.Sprintf("Page %d", i), "paging content", nil, nil, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path      string
+		wantLimit int
+	}{
+		{"/api/items?meta=true", 2},
+		{"/api/items?meta=true&limit=0", 2},
+		{"/api/items?meta=true&limit=100000", 3},
+		{"/api/search?q=paging&meta=true", 1},
+		{"/api/search?q=paging&meta=true&limit=100000", 3},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s status = %d, want 200: %s", tt.path, w.Code, w.Body.String())
+			continue
+		}
+		var resp struct {
+			Items   []json.RawMessage `json:"items"`
+			Results []json.RawMessage `json:"results"`
+			Limit   int               `json:"limit"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if got := len(resp.Items) + len(resp.Results); got != tt.wantLimit || resp.Limit != tt.wantLimit {
+			t.Errorf("GET %s returned %d with limit %d, want %d", tt.path, got, resp.Limit, tt.wantLimit)
+		}
+	}
+}
+
+// decodeError checks that w holds a JSON error response and returns its
+// detail.
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) errorDetail {
+	t.Helper()
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("error Content-Type = %q, want application/json", ct)
+	}
+	var body errorBody
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decode error body: %v", err)
+	}
+	return body.Error
+}
+
+func TestIntegrationErrorResponses(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "Taken"}`)))
+	var item store.Item
+	json.NewDecoder(w.Body).Decode(&item)
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		code               string
+	}{
+		{"GET", "/api/items?sort=bogus", "", http.StatusBadRequest, CodeInvalidParam},
+		{"POST", "/api/items", "{", http.StatusBadRequest, CodeInvalidJSON},
+		{"POST", "/api/items", `{"title": " "}`, http.StatusBadRequest, CodeTitleRequired},
+		{"POST", "/api/items", `{"title": "Taken"}`, http.StatusConflict, CodeTitleConflict},
+		{"POST", "/api/items", `{"title": "TAKEN"}`, http.StatusConflict, CodeTitleConflict},
+		{"POST", "/api/items", `{"title": "  Taken "}`, http.StatusConflict, CodeTitleConflict},
+		{"POST", "/api/items", `{"title": "\t\n"}`, http.StatusBadRequest, CodeTitleRequired},
+		{"GET", "/api/items/missing", "", http.StatusNotFound, CodeNotFound},
+		{"PUT", "/api/items/" + item.ID, `{"title": "Taken", "rev": 7}`, http.StatusConflict, CodeRevConflict},
+		{"DELETE", "/api/items/missing", "", http.StatusNotFound, CodeNotFound},
+		{"POST", "/api/items/missing/restore", "", http.StatusNotFound, CodeNotFound},
+		{"GET", "/api/items/" + item.ID + "/versions/x", "", http.StatusBadRequest, CodeInvalidParam},
+		{"GET", "/api/search", "", http.StatusBadRequest, CodeInvalidParam},
+		{"GET", "/api/search?q=author:alan", "", http.StatusBadRequest, CodeSearchSyntax},
+		{"POST", "/api/import?on_conflict=merge", "[]", http.StatusBadRequest, CodeInvalidParam},
+		{"POST", "/api/tokens", `{"name": "x"}`, http.StatusUnauthorized, CodeUnauthorized},
+	}
+	for _, tt := range tests {
+		var body io.Reader
+		if tt.body != "" {
+			body = bytes.NewBufferString(tt.body)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, body))
+		if w.Code != tt.status {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.path, w.Code, tt.status)
+			continue
+		}
+		if e := decodeError(t, w); e.Code != tt.code || e.Message == "" {
+			t.Errorf("%s %s error = %+v, want code %q", tt.method, tt.path, e, tt.code)
+		}
+	}
+}
+
+func TestIntegrationRenderItem(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	body := `{"title": "Rendered", "content": "# Heading\n\n**bold** <script>alert(1)</script>"}`
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(body)))
+	var item store.Item
+	json.NewDecoder(w.Body).Decode(&item)
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/items/"+item.ID+"/render", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	html := w.Body.String()
+	if !strings.Contains(html, "<h1>Heading</h1>") || !strings.Contains(html, "<strong>bold</strong>") {
+		t.Errorf("html = %q, want rendered markdown", html)
+	}
+	if strings.Contains(html, "<script") || strings.Contains(html, "alert(1)") {
+		t.Errorf("html = %q, script was not stripped", html)
+	}
+
+	// The item endpoint still returns the raw markdown
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/items/"+item.ID, nil))
+	var raw store.Item
+	json.NewDecoder(w.Body).Decode(&raw)
+	if !strings.Contains(raw.Content, "<script>") {
+		t.Errorf("raw content = %q, want markdown unchanged", raw.Content)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/items/"+item.ID+"/render?format=pdf", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("format=pdf status = %d, want 400", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/items/missing/render", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing item status = %d, want 404", w.Code)
+	}
+}
+
+func TestIntegrationPinItem(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	var ids []string
+	for _, title := range []string{"Reference", "Scratch"} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "`+title+`"}`)))
+		var item store.Item
+		json.NewDecoder(w.Body).Decode(&item)
+		ids = append(ids, item.ID)
+	}
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/items/"+ids[0]+"/pin", nil))
+	var item store.Item
+	json.NewDecoder(w.Body).Decode(&item)
+	if w.Code != http.StatusOK || !item.Pinned {
+		t.Fatalf("pin status = %d, item = %+v", w.Code, item)
+	}
+
+	// Touch the unpinned item so it is the most recently updated
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("PUT", "/api/items/"+ids[1], bytes.NewBufferString(`{"title": "Scratch", "content": "edited"}`)))
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/items", nil))
+	var items []store.Item
+	json.NewDecoder(w.Body).Decode(&items)
+	if len(items) != 2 || items[0].ID != ids[0] || !items[0].Pinned {
+		t.Errorf("list = %+v, want pinned item first", items)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/items/"+ids[0]+"/pin", nil))
+	item = store.Item{}
+	json.NewDecoder(w.Body).Decode(&item)
+	if w.Code != http.StatusOK || item.Pinned {
+		t.Errorf("unpin status = %d, item = %+v", w.Code, item)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/items/missing/pin", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("pin missing status = %d, want 404", w.Code)
+	}
+}
+
+func TestIntegrationArchive(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	active, _ := srv.store.Create("Active", "", nil, nil, "")
+	old, _ := srv.store.Create("Old", "", nil, nil, "")
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/items/"+old.ID+"/archive", nil))
+	var item store.Item
+	json.NewDecoder(w.Body).Decode(&item)
+	if w.Code != http.StatusOK || !item.Archived {
+		t.Fatalf("archive status = %d, item = %+v", w.Code, item)
+	}
+
+	list := func(query string) []string {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/items"+query, nil))
+		var items []store.Item
+		json.NewDecoder(w.Body).Decode(&items)
+		var ids []string
+		for _, it := range items {
+			ids = append(ids, it.ID)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	both := []string{active.ID, old.ID}
+	slices.Sort(both)
+	if got := list(""); !slices.Equal(got, []string{active.ID}) {
+		t.Errorf("default list = %v, want only %s", got, active.ID)
+	}
+	if got := list("?include_archived=true"); !slices.Equal(got, both) {
+		t.Errorf("include_archived list = %v, want %v", got, both)
+	}
+	if got := list("?archived=true"); !slices.Equal(got, []string{old.ID}) {
+		t.Errorf("archived list = %v, want only %s", got, old.ID)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/items/"+old.ID+"/archive", nil))
+	item = store.Item{}
+	json.NewDecoder(w.Body).Decode(&item)
+	if w.Code != http.StatusOK || item.Archived {
+		t.Errorf("unarchive status = %d, item = %+v", w.Code, item)
+	}
+	if got := list(""); !slices.Equal(got, both) {
+		t.Errorf("list after unarchive = %v, want %v", got, both)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/items/missing/archive", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("archive missing status = %d, want 404", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/items?cursor=&archived=true", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("cursor with archived status = %d, want 400", w.Code)
+	}
+}
+
+func TestIntegrationBulkDelete(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	var ids []string
+	for _, title := range []string{"One", "Two"} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "`+title+`"}`)))
+		var item store.Item
+		json.NewDecoder(w.Body).Decode(&item)
+		ids = append(ids, item.ID)
+	}
+
+	body := `{"ids": ["` + ids[0] + `", "` + ids[1] + `", "nope"]}`
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/items/delete", bytes.NewBufferString(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	var res store.DeleteResult
+	json.NewDecoder(w.Body).Decode(&res)
+	if res.Deleted != 2 || len(res.NotFound) != 1 || res.NotFound[0] != "nope" {
+		t.Errorf("result = %+v, want 2 deleted and [nope] not found", res)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/items", nil))
+	if strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("items after bulk delete = %s, want []", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/items/delete", bytes.NewBufferString(`{"ids": []}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("empty ids status = %d, want 400", w.Code)
+	}
+
+	tooMany := make([]string, srv.cfg.MaxListLimit+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("id-%d", i)
+	}
+	tooManyBody, _ := json.Marshal(deleteItemsRequest{IDs: tooMany})
+	hugeBody, _ := json.Marshal(deleteItemsRequest{IDs: []string{strings.Repeat("x", int(srv.idsBodyLimit()))}})
+	for _, tc := range []struct {
+		name   string
+		body   []byte
+		status int
+		code   string
+	}{
+		{"too many ids", tooManyBody, http.StatusBadRequest, CodeTooManyIDs},
+		{"body over cap", hugeBody, http.StatusRequestEntityTooLarge, CodeContentTooLarge},
+	} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/items/delete", bytes.NewReader(tc.body)))
+		if w.Code != tc.status {
+			t.Errorf("%s: status = %d, want %d", tc.name, w.Code, tc.status)
+			continue
+		}
+		if e := decodeError(t, w); e.Code != tc.code {
+			t.Errorf("%s: code = %q, want %q", tc.name, e.Code, tc.code)
+		}
+	}
+}
+
+func TestIntegrationDuplicateItem(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "Template", "content": "body", "tags": ["t"]}`)))
+	var src store.Item
+	json.NewDecoder(w.Body).Decode(&src)
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/items/"+src.ID+"/duplicate", nil))
+	var cp store.Item
+	json.NewDecoder(w.Body).Decode(&cp)
+	if w.Code != http.StatusCreated || cp.Title != "Template (copy)" || cp.Content != "body" || len(cp.Tags) != 1 {
+		t.Fatalf("status = %d, copy = %+v", w.Code, cp)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/items/"+src.ID+"/duplicate", nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("derived title collision status = %d, want 409", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/items/"+src.ID+"/duplicate?title=Template+v2", nil))
+	cp = store.Item{}
+	json.NewDecoder(w.Body).Decode(&cp)
+	if w.Code != http.StatusCreated || cp.Title != "Template v2" {
+		t.Errorf("override status = %d, title = %q", w.Code, cp.Title)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/items/"+src.ID+"/duplicate?title=+", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("blank title status = %d, want 400", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/items/missing/duplicate", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing status = %d, want 404", w.Code)
+	}
+}
+
+func TestIntegrationSearchOffset(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for i := 0; i < 3; i++ {
+		body := fmt.Sprintf(`{"title": "Note %d", "content": "paging"}`, i)
+		srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(body)))
+	}
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/search?q=paging&limit=2&offset=2&meta=true", nil))
+	var resp searchResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || len(resp.Results) != 1 || resp.Total != 3 || resp.Offset != 2 {
+		t.Errorf("status = %d, resp = %+v", w.Code, resp)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/search?q=paging&offset=-1", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("negative offset status = %d, want 400", w.Code)
+	}
+}
+
+func TestIntegrationUserItemQuota(t *testing.T) {
+	dir := t.TempDir()
+	s, err := store.New(filepath.Join(dir, "cue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	srv := NewWithConfig(s, AuthConfig{Enabled: true}, Config{UserItemQuota: 2}, "dev")
+
+	as := func(cn, method string, req *http.Request) *httptest.ResponseRecorder {
+		req = req.WithContext(auth.WithUser(req.Context(), &auth.UserContext{CN: cn, AuthMethod: method}))
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	create := func(cn, method, title string) *httptest.ResponseRecorder {
+		body := strings.NewReader(`{"title":"` + title + `"}`)
+		return as(cn, method, httptest.NewRequest("POST", "/api/items", body))
+	}
+
+	var first store.Item
+	for i, title := range []string{"Alice 1", "Alice 2"} {
+		w := create("alice", "cert", title)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create %d: status = %d: %s", i, w.Code, w.Body.String())
+		}
+		if i == 0 {
+			json.NewDecoder(w.Body).Decode(&first)
+		}
+	}
+
+	w := create("alice", "cert", "Alice 3")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("over quota: status = %d, want 403: %s", w.Code, w.Body.String())
+	}
+	if e := decodeError(t, w); e.Code != CodeQuotaExceeded {
+		t.Errorf("code = %q, want %q", e.Code, CodeQuotaExceeded)
+	}
+	if w := as("alice", "cert", httptest.NewRequest("POST", "/api/items/"+first.ID+"/duplicate", nil)); w.Code != http.StatusForbidden {
+		t.Errorf("duplicate over quota: status = %d, want 403", w.Code)
+	}
+
+	// Quotas are per user
+	if w := create("bob", "cert", "Bob 1"); w.Code != http.StatusCreated {
+		t.Errorf("bob: status = %d, want 201", w.Code)
+	}
+
+	// Trashing an item frees a slot
+	if w := as("alice", "cert", httptest.NewRequest("DELETE", "/api/items/"+first.ID, nil)); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: status = %d", w.Code)
+	}
+	if w := create("alice", "cert", "Alice 3"); w.Code != http.StatusCreated {
+		t.Errorf("after delete: status = %d, want 201: %s", w.Code, w.Body.String())
+	}
+
+	// Single-user mode is exempt
+	for _, title := range []string{"Solo 1", "Solo 2", "Solo 3"} {
+		if w := create("single-user-mode", "none", title); w.Code != http.StatusCreated {
+			t.Errorf("single-user %q: status = %d, want 201", title, w.Code)
+		}
+	}
+
+	// Imports count the items they would create, which the importer owns
+	importBody := func(titles ...string) io.Reader {
+		var items []store.Item
+		for _, title := range titles {
+			items = append(items, store.Item{Title: title, CreatedBy: "mallory"})
+		}
+		data, _ := json.Marshal(items)
+		return bytes.NewReader(data)
+	}
+	w = as("bob", "cert", httptest.NewRequest("POST", "/api/import", importBody("Bob 2", "Bob 3")))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("import over quota: status = %d, want 403: %s", w.Code, w.Body.String())
+	}
+	if e := decodeError(t, w); e.Code != CodeQuotaExceeded {
+		t.Errorf("import code = %q, want %q", e.Code, CodeQuotaExceeded)
+	}
+	// Bob 1 already exists and is skipped, so only Bob 2 is new
+	if w := as("bob", "cert", httptest.NewRequest("POST", "/api/import", importBody("Bob 1", "Bob 2"))); w.Code != http.StatusOK {
+		t.Fatalf("import within quota: status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+	if n, _ := s.CountByUser("bob"); n != 2 {
+		t.Errorf("bob owns %d items after import, want 2", n)
+	}
+	if n, _ := s.CountByUser("mallory"); n != 0 {
+		t.Errorf("mallory owns %d items, want 0", n)
+	}
+}
+
+func TestIntegrationItemSizeLimits(t *testing.T) {
+	s, err := store.New(filepath.Join(t.TempDir(), "cue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	srv := NewWithConfig(s, AuthConfig{}, Config{MaxContentBytes: 100, MaxTitleLength: 10}, "dev")
+
+	send := func(method, path string, body any) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(data)))
+		return w
+	}
+
+	// At the limits
+	w := send("POST", "/api/items", map[string]string{"title": strings.Repeat("é", 10), "content": strings.Repeat("x", 100)})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("at limits: status = %d, want 201: %s", w.Code, w.Body.String())
+	}
+	var item store.Item
+	json.NewDecoder(w.Body).Decode(&item)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   map[string]any
+		code   string
+	}{
+		{"create title over", "POST", "/api/items", map[string]any{"title": strings.Repeat("t", 11)}, CodeTitleTooLong},
+		{"create content over", "POST", "/api/items", map[string]any{"title": "Big", "content": strings.Repeat("x", 101)}, CodeContentTooLarge},
+		{"update title over", "PUT", "/api/items/" + item.ID, map[string]any{"title": strings.Repeat("t", 11)}, CodeTitleTooLong},
+		{"update content over", "PUT", "/api/items/" + item.ID, map[string]any{"title": "Big", "content": strings.Repeat("x", 101)}, CodeContentTooLarge},
+		// Bodies past the read cap are cut off before decoding finishes
+		{"body over cap", "POST", "/api/items", map[string]any{"title": "Big", "padding": strings.Repeat("x", 70<<10)}, CodeContentTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := send(tt.method, tt.path, tt.body)
+			if w.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("status = %d, want 413: %s", w.Code, w.Body.String())
+			}
+			if e := decodeError(t, w); e.Code != tt.code {
+				t.Errorf("code = %q, want %q", e.Code, tt.code)
+			}
+		})
+	}
+
+	// Collapsed whitespace does not count against the title limit
+	w = send("PUT", "/api/items/"+item.ID, map[string]string{"title": "  five   five  ", "content": strings.Repeat("y", 100)})
+	if w.Code != http.StatusOK {
+		t.Errorf("update at limits: status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+
+	// Duplicates check the title they would store, given or derived
+	w = send("POST", "/api/items", map[string]string{"title": "abc"})
+	var short store.Item
+	json.NewDecoder(w.Body).Decode(&short)
+	dupTests := []struct {
+		name, path string
+		status     int
+	}{
+		{"derived title at limit", "/api/items/" + short.ID + "/duplicate", http.StatusCreated},
+		{"derived title over", "/api/items/" + item.ID + "/duplicate", http.StatusRequestEntityTooLarge},
+		{"given title at limit", "/api/items/" + item.ID + "/duplicate?title=" + strings.Repeat("d", 10), http.StatusCreated},
+		{"given title over", "/api/items/" + item.ID + "/duplicate?title=" + strings.Repeat("d", 11), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range dupTests {
+		w := send("POST", tt.path, nil)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.status, w.Body.String())
+			continue
+		}
+		if tt.status == http.StatusRequestEntityTooLarge {
+			if e := decodeError(t, w); e.Code != CodeTitleTooLong {
+				t.Errorf("%s: code = %q, want %q", tt.name, e.Code, CodeTitleTooLong)
+			}
+		}
+	}
+
+	// Imports check every item, and their body has its own cap
+	importTests := []struct {
+		name string
+		body any
+		code string
+	}{
+		{"import title over", []map[string]string{{"title": "Fine"}, {"title": strings.Repeat("t", 11)}}, CodeTitleTooLong},
+		{"import content over", []map[string]string{{"title": "Big", "content": strings.Repeat("x", 101)}}, CodeContentTooLarge},
+		{"import body over cap", []map[string]string{{"title": "Big", "padding": strings.Repeat("x", 1<<10)}}, CodeContentTooLarge},
+	}
+	srv = NewWithConfig(s, AuthConfig{}, Config{MaxContentBytes: 100, MaxTitleLength: 10, MaxImportBytes: 1 << 10}, "dev")
+	for _, tt := range importTests {
+		w := send("POST", "/api/import", tt.body)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: status = %d, want 413: %s", tt.name, w.Code, w.Body.String())
+			continue
+		}
+		if e := decodeError(t, w); e.Code != tt.code {
+			t.Errorf("%s: code = %q, want %q", tt.name, e.Code, tt.code)
+		}
+	}
+	if n, _ := s.Count(); n != 4 {
+		t.Errorf("count = %d after rejected imports, want 4", n)
+	}
+}
+
+func TestIntegrationListSince(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	srv.store.Create("One", "", nil, nil, "")
+	srv.store.Create("Two", "", nil, nil, "")
+	trashed, _ := srv.store.Create("Trashed", "", nil, nil, "")
+	srv.store.Delete(trashed.ID)
+	past := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+	future := time.Now().UTC().Add(time.Hour).Format(time.RFC3339)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/items?"+query, nil))
+		return w
+	}
+
+	w := get("since=" + past)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	var items []store.Item
+	json.NewDecoder(w.Body).Decode(&items)
+	tombstones := 0
+	for _, item := range items {
+		if item.DeletedAt != nil {
+			tombstones++
+		}
+	}
+	if len(items) != 3 || tombstones != 1 {
+		t.Errorf("got %d items with %d tombstones, want 3 with 1", len(items), tombstones)
+	}
+
+	w = get("since=" + future)
+	items = nil
+	json.NewDecoder(w.Body).Decode(&items)
+	if w.Code != http.StatusOK || len(items) != 0 {
+		t.Errorf("future since: status = %d, %d items, want 200 with none", w.Code, len(items))
+	}
+
+	w = get("meta=true&limit=2&since=" + past)
+	var page listResponse
+	json.NewDecoder(w.Body).Decode(&page)
+	if page.Total != 3 || len(page.Items) != 2 {
+		t.Errorf("total = %d, page = %d items, want 3 and 2", page.Total, len(page.Items))
+	}
+
+	for _, query := range []string{"since=yesterday", "since=2024-01-01", "since=" + past + "&sort=title_asc", "since=" + past + "&trashed=true"} {
+		w := get(query)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, w.Code)
+			continue
+		}
+		if e := decodeError(t, w); e.Code != CodeInvalidParam {
+			t.Errorf("%s: code = %q, want %q", query, e.Code, CodeInvalidParam)
+		}
+	}
+}
+
+func TestIntegrationHeadItem(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	item, _ := srv.store.Create("Exists", "some content", nil, nil, "")
+
+	req := httptest.NewRequest("HEAD", "/api/items/"+item.ID, nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("body = %q, want empty", w.Body.String())
+	}
+	if got := w.Header().Get("ETag"); got != itemETag(item) {
+		t.Errorf("ETag = %q, want %q", got, itemETag(item))
+	}
+	if got := w.Header().Get("Last-Modified"); got != item.UpdatedAt.UTC().Format(http.TimeFormat) {
+		t.Errorf("Last-Modified = %q", got)
+	}
+
+	req = httptest.NewRequest("HEAD", "/api/items/missing", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing: status = %d, want 404", w.Code)
+	}
+}
+
+func TestIntegrationListCursor(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	want := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		item, _ := srv.store.Create(fmt.Sprintf("Item %d", i), "", nil, nil, "")
+		want[item.ID] = true
+	}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/items?"+query, nil))
+		return w
+	}
+
+	seen := map[string]bool{}
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("paging did not terminate")
+		}
+		w := get("limit=2&cursor=" + cursor)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+		}
+		var page cursorResponse
+		json.NewDecoder(w.Body).Decode(&page)
+		for _, item := range page.Items {
+			if seen[item.ID] {
+				t.Errorf("%s returned twice", item.Title)
+			}
+			seen[item.ID] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+
+		// Inserts between pages must not shift the rest of the list
+		srv.store.Create(fmt.Sprintf("Inserted %d", pages), "", nil, nil, "")
+	}
+	for id := range want {
+		if !seen[id] {
+			t.Errorf("item %s was skipped", id)
+		}
+	}
+
+	for _, query := range []string{"cursor=!!", "cursor=" + base64.RawURLEncoding.EncodeToString([]byte("nope")), "cursor=&offset=2", "cursor=&sort=title_asc", "cursor=&trashed=true"} {
+		w := get(query)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, w.Code)
+			continue
+		}
+		if e := decodeError(t, w); e.Code != CodeInvalidParam {
+			t.Errorf("%s: code = %q, want %q", query, e.Code, CodeInvalidParam)
+		}
+	}
+}
+
+func TestIntegrationUnknownAPIRoute(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for _, path := range []string{"/api/does-not-exist", "/api/items/x/nope", "/api/"} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want 404", path, w.Code)
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: Content-Type = %q, want application/json", path, ct)
+		}
+		if e := decodeError(t, w); e.Code != CodeNotFound {
+			t.Errorf("%s: code = %q, want %q", path, e.Code, CodeNotFound)
+		}
+	}
+
+	// Known paths with the wrong method still get 405
+	for _, tc := range []struct{ method, path, allow string }{
+		{"POST", "/api/status", "GET, HEAD"},
+		{"DELETE", "/api/items", "GET, HEAD, POST"},
+		{"POST", "/api/items/by-title/x", "GET, HEAD"},
+	} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s: status = %d, want 405", tc.method, tc.path, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Allow"); got != tc.allow {
+			t.Errorf("%s %s: Allow = %q, want %q", tc.method, tc.path, got, tc.allow)
+		}
+		if e := decodeError(t, w); e.Code != CodeMethodNotAllowed {
+			t.Errorf("%s %s: code = %q, want %q", tc.method, tc.path, e.Code, CodeMethodNotAllowed)
+		}
+	}
+}
+
+func TestIntegrationMethodNotAllowed(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	item, _ := srv.store.Create("Item", "", nil, nil, "")
+	for _, tc := range []struct{ method, path, allow string }{
+		{"POST", "/api/items/" + item.ID, "GET, HEAD, PUT, PATCH, DELETE"},
+		{"POST", "/api/items/" + item.ID + "/render", "GET, HEAD"},
+		{"GET", "/api/items/" + item.ID + "/restore", "POST"},
+		{"PUT", "/api/items/" + item.ID + "/pin", "POST, DELETE"},
+		{"PUT", "/api/items/by-title/Item", "GET, HEAD"},
+		{"DELETE", "/api/search", "GET, HEAD"},
+		{"PUT", "/api/tokens/abc", "PATCH, DELETE"},
+		{"GET", "/api/admin/backup", "POST"},
+	} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s: status = %d, want 405", tc.method, tc.path, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Allow"); got != tc.allow {
+			t.Errorf("%s %s: Allow = %q, want %q", tc.method, tc.path, got, tc.allow)
+		}
+	}
+
+	// The item itself is untouched
+	if got, err := srv.store.Get(item.ID); err != nil || got.Title != "Item" {
+		t.Errorf("item after rejected requests = %v, %v", got, err)
+	}
+}
+
+func TestIntegrationCheckTitle(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	taken, _ := srv.store.Create("Taken Title", "", nil, nil, "")
+	gone, _ := srv.store.Create("Gone", "", nil, nil, "")
+	srv.store.Delete(gone.ID)
+
+	check := func(title string) (*httptest.ResponseRecorder, titleCheck) {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/items/check-title?title="+url.QueryEscape(title), nil))
+		var resp titleCheck
+		if w.Code == http.StatusOK {
+			json.NewDecoder(bytes.NewReader(w.Body.Bytes())).Decode(&resp)
+		}
+		return w, resp
+	}
+
+	w, resp := check("Fresh")
+	if w.Code != http.StatusOK || !resp.Available || resp.ExistingID != "" {
+		t.Errorf("available: status = %d, %+v", w.Code, resp)
+	}
+
+	// Normalized the same way as create
+	w, resp = check("  taken   TITLE ")
+	if w.Code != http.StatusOK || resp.Available || resp.ExistingID != taken.ID {
+		t.Errorf("taken: status = %d, %+v, want existing_id %s", w.Code, resp, taken.ID)
+	}
+
+	// Trashed titles stay reserved
+	w, resp = check("Gone")
+	if resp.Available || resp.ExistingID != gone.ID || !resp.Trashed {
+		t.Errorf("trashed: status = %d, %+v", w.Code, resp)
+	}
+
+	for _, title := range []string{"", "   "} {
+		w, _ := check(title)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want 400", title, w.Code)
+			continue
+		}
+		if e := decodeError(t, w); e.Code != CodeInvalidParam || e.Param != "title" {
+			t.Errorf("%q: error = %+v, want invalid_param for title", title, e)
+		}
+	}
+}
+
+func TestIntegrationBatchGetItems(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	a, _ := srv.store.Create("A", "", nil, nil, "")
+	b, _ := srv.store.Create("B", "", nil, nil, "")
+	c, _ := srv.store.Create("C", "", nil, nil, "")
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/items/batch-get", strings.NewReader(body)))
+		return w
+	}
+
+	w := post(fmt.Sprintf(`{"ids": [%q, "missing", %q, %q]}`, c.ID, a.ID, b.ID))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	var items []store.Item
+	json.NewDecoder(w.Body).Decode(&items)
+	var got []string
+	for _, item := range items {
+		got = append(got, item.Title)
+	}
+	if !slices.Equal(got, []string{"C", "A", "B"}) {
+		t.Errorf("titles = %v, want [C A B]", got)
+	}
+
+	// Nothing found is still a success
+	w = post(`{"ids": ["missing"]}`)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("no matches: status = %d, body = %s, want 200 []", w.Code, w.Body.String())
+	}
+
+	tooMany := make([]string, srv.cfg.MaxListLimit+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("id-%d", i)
+	}
+	body, _ := json.Marshal(batchGetRequest{IDs: tooMany})
+	for _, tc := range []struct{ body, code string }{
+		{`{"ids": []}`, CodeIDsRequired},
+		{`not json`, CodeInvalidJSON},
+		{string(body), CodeTooManyIDs},
+	} {
+		w := post(tc.body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%.20s: status = %d, want 400", tc.body, w.Code)
+			continue
+		}
+		if e := decodeError(t, w); e.Code != tc.code {
+			t.Errorf("%.20s: code = %q, want %q", tc.body, e.Code, tc.code)
+		}
+	}
+}
+
+func TestIntegrationFindByLink(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	link := "https://example.com/post/"
+	sibling := "https://example.com/post/2"
+	item, _ := srv.store.Create("Post", "", &link, nil, "")
+	srv.store.Create("Sibling", "", &sibling, nil, "")
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/items/by-link"+query, nil))
+		return w
+	}
+
+	// The trailing slash and host case are normalized away
+	w := get("?url=" + url.QueryEscape("https://EXAMPLE.com/post"))
+	var items []store.Item
+	json.NewDecoder(w.Body).Decode(&items)
+	if w.Code != http.StatusOK || len(items) != 1 || items[0].ID != item.ID {
+		t.Fatalf("status = %d, items = %+v, want only %s", w.Code, items, item.ID)
+	}
+
+	w = get("?url=" + url.QueryEscape("https://example.com/elsewhere"))
+	items = nil
+	json.NewDecoder(w.Body).Decode(&items)
+	if w.Code != http.StatusOK || items == nil || len(items) != 0 {
+		t.Errorf("no match: status = %d, items = %v, want []", w.Code, items)
+	}
+
+	w = get("")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("missing url: status = %d, want 400", w.Code)
+	}
+	if e := decodeError(t, w); e.Code != CodeInvalidParam || e.Param != "url" {
+		t.Errorf("missing url: error = %+v", e)
+	}
+}
+
+func TestIntegrationItemColor(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	w := send("POST", "/api/items", `{"title":"Colored","color":"blue"}`)
+	var item store.Item
+	json.NewDecoder(w.Body).Decode(&item)
+	if w.Code != http.StatusCreated || item.Color != "blue" {
+		t.Fatalf("create: status = %d, color = %q, want 201 blue", w.Code, item.Color)
+	}
+	w = send("GET", "/api/items/"+item.ID, "")
+	var got store.Item
+	json.NewDecoder(w.Body).Decode(&got)
+	if got.Color != "blue" {
+		t.Errorf("GET color = %q, want blue", got.Color)
+	}
+
+	for _, tc := range []struct{ method, path, body string }{
+		{"POST", "/api/items", `{"title":"Bad","color":"#0000ff"}`},
+		{"PUT", "/api/items/" + item.ID, `{"title":"Colored","color":"teal"}`},
+		{"PATCH", "/api/items/" + item.ID, `{"color":"Blue"}`},
+	} {
+		w = send(tc.method, tc.path, tc.body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: status = %d, want 400", tc.method, tc.path, w.Code)
+			continue
+		}
+		if e := decodeError(t, w); e.Code != CodeInvalidColor {
+			t.Errorf("%s %s: code = %q, want %q", tc.method, tc.path, e.Code, CodeInvalidColor)
+		}
+	}
+
+	// Omitting the color on PUT keeps it; an empty one clears it
+	w = send("PUT", "/api/items/"+item.ID, `{"title":"Colored","content":"v2"}`)
+	got = store.Item{}
+	json.NewDecoder(w.Body).Decode(&got)
+	if w.Code != http.StatusOK || got.Color != "blue" {
+		t.Errorf("PUT without color: status = %d, color = %q, want blue", w.Code, got.Color)
+	}
+	w = send("PUT", "/api/items/"+item.ID, `{"title":"Colored","content":"v3","color":""}`)
+	got = store.Item{}
+	json.NewDecoder(w.Body).Decode(&got)
+	if w.Code != http.StatusOK || got.Color != "" {
+		t.Errorf("PUT clearing color: status = %d, color = %q, want none", w.Code, got.Color)
+	}
+	if stored, _ := srv.store.Get(item.ID); stored.Color != "" {
+		t.Errorf("stored color = %q after clearing", stored.Color)
+	}
+
+	w = send("PATCH", "/api/items/"+item.ID, `{"color":"red"}`)
+	got = store.Item{}
+	json.NewDecoder(w.Body).Decode(&got)
+	if w.Code != http.StatusOK || got.Color != "red" || got.Content != "v3" {
+		t.Errorf("PATCH color: status = %d, item = %+v", w.Code, got)
+	}
+}
+
+func TestIntegrationRelations(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	a, _ := srv.store.Create("A", "", nil, nil, "")
+	b, _ := srv.store.Create("B", "", nil, nil, "")
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	relations := "/api/items/" + a.ID + "/relations"
+
+	w := send("POST", relations, `{"to":"`+b.ID+`"}`)
+	var rel store.Relation
+	json.NewDecoder(w.Body).Decode(&rel)
+	if w.Code != http.StatusCreated || rel.ToID != b.ID || rel.Type != store.DefaultRelationType {
+		t.Fatalf("add: status = %d, relation = %+v", w.Code, rel)
+	}
+	if w = send("POST", relations, `{"to":"`+b.ID+`","type":"related"}`); w.Code != http.StatusOK {
+		t.Errorf("re-add: status = %d, want 200", w.Code)
+	}
+
+	for _, tc := range []struct {
+		body string
+		code int
+	}{
+		{`{}`, http.StatusBadRequest},
+		{`{"to":"` + a.ID + `"}`, http.StatusBadRequest},
+		{`{"to":"` + b.ID + `","type":"see also"}`, http.StatusBadRequest},
+		{`{"to":"missing"}`, http.StatusNotFound},
+	} {
+		if w = send("POST", relations, tc.body); w.Code != tc.code {
+			t.Errorf("POST %s: status = %d, want %d", tc.body, w.Code, tc.code)
+		}
+	}
+
+	// B lists the relation too, from the other end
+	w = send("GET", "/api/items/"+b.ID+"/relations", "")
+	var rels []store.Relation
+	json.NewDecoder(w.Body).Decode(&rels)
+	if w.Code != http.StatusOK || len(rels) != 1 || rels[0].FromID != a.ID {
+		t.Errorf("list B: status = %d, relations = %+v", w.Code, rels)
+	}
+	if w = send("GET", "/api/items/missing/relations", ""); w.Code != http.StatusNotFound {
+		t.Errorf("list missing: status = %d, want 404", w.Code)
+	}
+
+	if w = send("DELETE", relations, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("delete without to: status = %d, want 400", w.Code)
+	}
+	if w = send("DELETE", relations+"?to="+b.ID, ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete: status = %d, want 204", w.Code)
+	}
+	if w = send("DELETE", relations+"?to="+b.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("delete again: status = %d, want 404", w.Code)
+	}
+	w = send("GET", relations, "")
+	rels = nil
+	json.NewDecoder(w.Body).Decode(&rels)
+	if rels == nil || len(rels) != 0 {
+		t.Errorf("list after delete = %v, want []", rels)
+	}
+}
+
+func TestIntegrationBacklinks(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	target, _ := srv.store.Create("Target", "", nil, nil, "")
+	get := func(id string) ([]store.Item, int) {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/items/"+id+"/backlinks", nil))
+		var items []store.Item
+		json.NewDecoder(w.Body).Decode(&items)
+		return items, w.Code
+	}
+
+	if items, code := get(target.ID); code != http.StatusOK || items == nil || len(items) != 0 {
+		t.Errorf("no backlinks: status = %d, items = %v, want []", code, items)
+	}
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("POST", "/api/items", strings.NewReader(`{"title":"Notes","content":"Builds on [[target]]"}`)))
+	var ref store.Item
+	json.NewDecoder(w.Body).Decode(&ref)
+
+	items, code := get(target.ID)
+	if code != http.StatusOK || len(items) != 1 || items[0].ID != ref.ID {
+		t.Errorf("status = %d, items = %+v, want only %s", code, items, ref.ID)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/items/"+ref.ID, nil))
+	if items, _ := get(target.ID); len(items) != 0 {
+		t.Errorf("after deleting the referencer: %+v, want none", items)
+	}
+	if _, code := get("missing"); code != http.StatusNotFound {
+		t.Errorf("missing item: status = %d, want 404", code)
+	}
+}
+
+func TestIntegrationStats(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+	// Buckets follow the store's clock, not the wall clock
+	now := time.Date(2024, 2, 3, 12, 0, 0, 0, time.UTC)
+	srv.store.SetClock(clock.NewFake(now))
+
+	srv.store.Create("One", "abcd", nil, []string{"go"}, "")
+	srv.store.Create("Two", "ab", nil, []string{"go", "sql"}, "")
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/api/stats", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	var stats store.CorpusStats
+	json.NewDecoder(w.Body).Decode(&stats)
+	if stats.Items != 2 || stats.ContentBytes != 6 || stats.AvgContentLength != 3 {
+		t.Errorf("totals = %+v", stats)
+	}
+	today := stats.CreatedPerDay[len(stats.CreatedPerDay)-1]
+	if len(stats.CreatedPerDay) != store.StatsDays || today.Date != now.Format(time.DateOnly) || today.Count != 2 {
+		t.Errorf("created_per_day = %+v", stats.CreatedPerDay)
+	}
+	if len(stats.TopTags) != 2 || stats.TopTags[0] != (store.TagCount{Tag: "go", Count: 2}) {
+		t.Errorf("top_tags = %+v", stats.TopTags)
+	}
+}
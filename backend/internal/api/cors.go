@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// CORSConfig configures cross-origin access to the API.
+type CORSConfig struct {
+	Origins          []string // Allowed origins, or "*" for any; empty disables CORS
+	AllowCredentials bool     // Send Allow-Credentials for explicitly listed origins (never for "*")
+}
+
+const (
+	corsAllowMethods  = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	corsAllowHeaders  = "Authorization, Content-Type, Idempotency-Key, If-Match, If-None-Match, X-Request-ID"
+	corsExposeHeaders = "ETag, Idempotent-Replayed, Warning, X-Request-ID, X-Token-Expires-In"
+	corsMaxAge        = "600"
+)
+
+// ParseCORSOrigins splits a comma-separated -cors-origins value.
+func ParseCORSOrigins(v string) []string {
+	var origins []string
+	for _, o := range strings.Split(v, ",") {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
+// CORS adds CORS headers for allowed origins and answers preflight
+// requests. It must wrap the auth middleware, since browsers send
+// preflights without credentials.
+func CORS(cfg CORSConfig) func(http.Handler) http.Handler {
+	if len(cfg.Origins) == 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	wildcard := slices.Contains(cfg.Origins, "*")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			listed := slices.Contains(cfg.Origins, origin)
+			switch {
+			case listed:
+				h.Set("Access-Control-Allow-Origin", origin)
+				if cfg.AllowCredentials {
+					h.Set("Access-Control-Allow-Credentials", "true")
+				}
+			case wildcard:
+				h.Set("Access-Control-Allow-Origin", "*")
+			default:
+				if preflight {
+					writeError(w, http.StatusForbidden, CodeForbidden, "origin not allowed")
+					return
+				}
+				// Serve without CORS headers; the browser blocks the response
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if preflight {
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+				h.Set("Access-Control-Allow-Methods", corsAllowMethods)
+				h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+				h.Set("Access-Control-Max-Age", corsMaxAge)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			h.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
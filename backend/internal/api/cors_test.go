@@ -0,0 +1,107 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var corsOK = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+func corsRequest(method, origin string) *http.Request {
+	req := httptest.NewRequest(method, "/api/items", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if method == http.MethodOptions {
+		req.Header.Set("Access-Control-Request-Method", "PUT")
+		req.Header.Set("Access-Control-Request-Headers", "content-type")
+	}
+	return req
+}
+
+func TestCORSPreflight(t *testing.T) {
+	h := CORS(CORSConfig{Origins: []string{"https://app.example.com"}, AllowCredentials: true})(corsOK)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, corsRequest(http.MethodOptions, "https://app.example.com"))
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	for header, want := range map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     corsAllowMethods,
+		"Access-Control-Allow-Headers":     corsAllowHeaders,
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestCORSDisallowedOrigin(t *testing.T) {
+	h := CORS(CORSConfig{Origins: []string{"https://app.example.com"}, AllowCredentials: true})(corsOK)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, corsRequest(http.MethodOptions, "https://evil.example.com"))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("preflight status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, corsRequest(http.MethodGet, "https://evil.example.com"))
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Allow-Origin = %q, want none", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Allow-Credentials = %q, want none", got)
+	}
+}
+
+func TestCORSWildcard(t *testing.T) {
+	h := CORS(CORSConfig{Origins: []string{"*", "https://trusted.example.com"}, AllowCredentials: true})(corsOK)
+
+	// Unlisted origins get "*" and never credentials
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, corsRequest(http.MethodGet, "https://other.example.com"))
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Allow-Origin = %q, want *", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Allow-Credentials = %q, want none for wildcard", got)
+	}
+
+	// Explicitly listed origins are echoed with credentials
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, corsRequest(http.MethodGet, "https://trusted.example.com"))
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://trusted.example.com" {
+		t.Errorf("Allow-Origin = %q, want echoed origin", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Allow-Credentials = %q, want true", got)
+	}
+}
+
+func TestCORSDisabled(t *testing.T) {
+	h := CORS(CORSConfig{})(corsOK)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, corsRequest(http.MethodGet, "https://app.example.com"))
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Allow-Origin = %q, want none when disabled", got)
+	}
+}
+
+func TestParseCORSOrigins(t *testing.T) {
+	got := ParseCORSOrigins(" https://a.example.com/, ,https://b.example.com ")
+	want := []string{"https://a.example.com", "https://b.example.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseCORSOrigins = %v, want %v", got, want)
+	}
+	if got := ParseCORSOrigins(""); got != nil {
+		t.Errorf("ParseCORSOrigins(\"\") = %v, want nil", got)
+	}
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Error codes sent in the "code" field of JSON error responses. Clients
+// should branch on these rather than on the message text.
+const (
+	CodeInvalidJSON        = "invalid_json"
+	CodeInvalidParam       = "invalid_param"
+	CodeTitleRequired      = "title_required"
+	CodeTitleConflict      = "title_conflict"
+	CodeNameRequired       = "name_required"
+	CodeIDsRequired        = "ids_required"
+	CodeTooManyIDs         = "too_many_ids"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeRevConflict        = "rev_conflict"
+	CodePreconditionFailed = "precondition_failed"
+	CodeSearchSyntax       = "search_syntax"
+	CodeInvalidScope       = "invalid_scope"
+	CodeInvalidExpiry      = "invalid_expires_in"
+	CodeInvalidColor       = "invalid_color"
+	CodeInvalidRelation    = "invalid_relation"
+	CodeUnauthorized       = "unauthorized"
+	CodeCertRequired       = "cert_required"
+	CodeForbidden          = "forbidden"
+	CodeQuotaExceeded      = "quota_exceeded"
+	CodeTokenLimit         = "token_limit"
+	CodeBackupRunning      = "backup_running"
+	CodeReadOnly           = "read_only"
+	CodeContentTooLarge    = "content_too_large"
+	CodeTitleTooLong       = "title_too_long"
+	CodeTimeout            = "timeout"
+	CodeInternal           = "internal"
+)
+
+// errorBody is the JSON shape of every API error:
+// {"error": {"code": "...", "message": "..."}}.
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Param   string `json:"param,omitempty"` // Set for CodeInvalidParam
+}
+
+// writeError sends a JSON error response with a stable code.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeErrorDetail(w, status, errorDetail{Code: code, Message: message})
+}
+
+func writeErrorDetail(w http.ResponseWriter, status int, detail errorDetail) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorBody{Error: detail})
+}
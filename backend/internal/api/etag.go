@@ -0,0 +1,62 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alanp/cue/internal/store"
+)
+
+// itemETag returns a strong ETag for an item. It hashes the item's fields
+// rather than using updated_at alone, which is stored with second
+// precision and would miss two edits within the same second.
+func itemETag(item *store.Item) string {
+	h := sha256.New()
+	link := ""
+	if item.Link != nil {
+		link = *item.Link
+	}
+	for _, f := range []string{item.ID, strconv.Itoa(item.Rev), item.Title, link, item.Content, strings.Join(item.Tags, ","), strconv.FormatBool(item.Pinned), strconv.FormatBool(item.Archived), item.Color, item.UpdatedAt.UTC().Format(time.RFC3339)} {
+		h.Write([]byte(f))
+		h.Write([]byte{0})
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// setItemValidators sets the ETag and Last-Modified headers for item.
+func setItemValidators(w http.ResponseWriter, item *store.Item) {
+	w.Header().Set("ETag", itemETag(item))
+	w.Header().Set("Last-Modified", item.UpdatedAt.UTC().Format(http.TimeFormat))
+}
+
+// notModified reports whether a conditional GET can be answered with 304.
+// If-None-Match takes precedence, as in RFC 9110; If-Modified-Since is only
+// consulted without it, since Last-Modified has one-second resolution and
+// can miss a second edit within the same second.
+func notModified(r *http.Request, item *store.Item) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, itemETag(item))
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !item.UpdatedAt.Truncate(time.Second).After(ims)
+}
+
+// etagMatches reports whether etag satisfies an If-Match or If-None-Match
+// header value: "*" or a comma-separated list of tags. Weak tags compare
+// by their opaque value.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
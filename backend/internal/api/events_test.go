@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventsStream(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/api/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /api/events: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	// Headers are flushed after subscribing, so this create is observed
+	post, _ := http.Post(ts.URL+"/api/items", "application/json", bytes.NewBufferString(`{"title": "Live"}`))
+	post.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	var lines []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" && len(lines) > 0 {
+			break
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) != 2 || lines[0] != "event: created" {
+		t.Fatalf("event lines = %q", lines)
+	}
+	if !strings.HasPrefix(lines[1], "data: ") || !strings.Contains(lines[1], `"title":"Live"`) {
+		t.Errorf("data line = %q", lines[1])
+	}
+}
+
+func TestEventsHeartbeat(t *testing.T) {
+	old := sseHeartbeat
+	sseHeartbeat = 10 * time.Millisecond
+	defer func() { sseHeartbeat = old }()
+
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/api/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /api/events: %v", err)
+	}
+	defer resp.Body.Close()
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if line != ": heartbeat\n" {
+		t.Errorf("line = %q, want heartbeat comment", line)
+	}
+}
+
+func TestEventsCloseStreams(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/events")
+	if err != nil {
+		t.Fatalf("GET /api/events: %v", err)
+	}
+	defer resp.Body.Close()
+
+	srv.CloseStreams()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, resp.Body)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream still open after CloseStreams")
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/alanp/cue/internal/render"
+	"github.com/alanp/cue/internal/store"
+)
+
+// DefaultFeedSize is the default Config.FeedSize.
+const DefaultFeedSize = 20
+
+// atomFeed and the types below are the subset of RFC 4287 the feed uses.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     atomPerson     `xml:"author"`
+	Link       atomLink       `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// handleFeed serves the Config.FeedSize most recently updated items as an
+// Atom feed. Entry content is the item's rendered, sanitized HTML, and each
+// entry links to GET /api/items/{id}/render.
+func (s *Server) handleFeed(w http.ResponseWriter, r *http.Request) {
+	items, err := s.store.ListContext(r.Context(), store.ListOptions{
+		Limit: s.cfg.FeedSize,
+		Sort:  store.SortUpdatedDesc,
+	})
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+
+	base := requestBaseURL(r)
+	feed := atomFeed{
+		ID:      base + "/api/feed.atom",
+		Title:   "cue",
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Links:   []atomLink{{Rel: "self", Type: "application/atom+xml", Href: base + "/api/feed.atom"}},
+		Entries: make([]atomEntry, 0, len(items)),
+	}
+	if len(items) > 0 {
+		feed.Updated = items[0].UpdatedAt.UTC().Format(time.RFC3339)
+	}
+
+	for _, item := range items {
+		html, err := render.HTML(item.Content)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+			return
+		}
+		entry := atomEntry{
+			ID:        "urn:uuid:" + item.ID,
+			Title:     item.Title,
+			Published: item.CreatedAt.UTC().Format(time.RFC3339),
+			Updated:   item.UpdatedAt.UTC().Format(time.RFC3339),
+			Author:    atomPerson{Name: item.CreatedBy},
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: base + "/api/items/" + item.ID + "/render"},
+			Content:   atomContent{Type: "html", Body: html},
+		}
+		for _, tag := range item.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(feed)
+}
+
+// requestBaseURL returns the scheme and host the request was sent to, for
+// building the absolute links a feed needs.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
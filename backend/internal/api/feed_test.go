@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alanp/cue/internal/store"
+)
+
+func TestIntegrationFeed(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+	srv.cfg.FeedSize = 2
+
+	// Imported so updated times, and therefore the order, are fixed
+	ids := []string{
+		"00000000-0000-4000-8000-000000000001",
+		"00000000-0000-4000-8000-000000000002",
+		"00000000-0000-4000-8000-000000000003",
+	}
+	var items []store.Item
+	for i, item := range []store.Item{
+		{Title: "Oldest", Content: "zero"},
+		{Title: "Middle", Content: "*one*", Tags: []string{"go"}},
+		{Title: "Newest & <best>", Content: "two <script>alert(1)</script>"},
+	} {
+		item.ID = ids[i]
+		item.UpdatedAt = time.Date(2024, 1, 1+i, 0, 0, 0, 0, time.UTC)
+		items = append(items, item)
+	}
+	if _, err := srv.store.ImportItems(items, store.ConflictFail); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/api/feed.atom", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/atom+xml") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	var feed atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("parse feed: %v\n%s", err, w.Body.String())
+	}
+	if feed.XMLName.Space != "http://www.w3.org/2005/Atom" || feed.XMLName.Local != "feed" {
+		t.Errorf("root = %v, want Atom feed", feed.XMLName)
+	}
+	if feed.ID == "" || feed.Title == "" || feed.Updated != "2024-01-03T00:00:00Z" {
+		t.Errorf("feed id = %q, title = %q, updated = %q", feed.ID, feed.Title, feed.Updated)
+	}
+	if len(feed.Links) != 1 || feed.Links[0].Rel != "self" || feed.Links[0].Href != "http://example.com/api/feed.atom" {
+		t.Errorf("links = %+v", feed.Links)
+	}
+
+	if len(feed.Entries) != 2 {
+		t.Fatalf("entries = %d, want FeedSize 2", len(feed.Entries))
+	}
+	newest, middle := feed.Entries[0], feed.Entries[1]
+	if newest.Title != "Newest & <best>" || middle.Title != "Middle" {
+		t.Errorf("titles = %q, %q, want newest first", newest.Title, middle.Title)
+	}
+	if newest.ID != "urn:uuid:"+ids[2] || newest.Updated != "2024-01-03T00:00:00Z" || newest.Published == "" {
+		t.Errorf("entry id = %q, updated = %q, published = %q", newest.ID, newest.Updated, newest.Published)
+	}
+	if want := fmt.Sprintf("http://example.com/api/items/%s/render", ids[2]); newest.Link.Href != want || newest.Link.Rel != "alternate" {
+		t.Errorf("link = %+v, want alternate %s", newest.Link, want)
+	}
+	if newest.Author.Name == "" {
+		t.Error("entry has no author")
+	}
+	if newest.Content.Type != "html" || strings.Contains(newest.Content.Body, "<script>") {
+		t.Errorf("content = %+v, want sanitized html", newest.Content)
+	}
+	if !strings.Contains(middle.Content.Body, "<em>one</em>") {
+		t.Errorf("content = %q, want rendered markdown", middle.Content.Body)
+	}
+	if len(middle.Categories) != 1 || middle.Categories[0].Term != "go" {
+		t.Errorf("categories = %+v, want [go]", middle.Categories)
+	}
+}
+
+func TestIntegrationFeedEmpty(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("GET", "/api/feed.atom", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	var feed atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("parse feed: %v", err)
+	}
+	if w.Code != http.StatusOK || len(feed.Entries) != 0 || feed.Updated == "" {
+		t.Errorf("status = %d, entries = %d, updated = %q", w.Code, len(feed.Entries), feed.Updated)
+	}
+}
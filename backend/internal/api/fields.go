@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/alanp/cue/internal/store"
+)
+
+// itemFields are the JSON names of store.Item's fields, the names ?fields=
+// accepts.
+var itemFields = func() map[string]bool {
+	names := map[string]bool{}
+	t := reflect.TypeOf(store.Item{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}()
+
+// fieldMask is the set of item fields a client asked for with ?fields=. A
+// nil mask keeps every field.
+type fieldMask map[string]bool
+
+// queryFields parses ?fields=id,title,... The parameter may repeat; unknown
+// names are rejected.
+func queryFields(r *http.Request) (fieldMask, error) {
+	values := r.URL.Query()["fields"]
+	if len(values) == 0 {
+		return nil, nil
+	}
+	mask := fieldMask{}
+	for _, v := range values {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if !itemFields[name] {
+				return nil, &paramError{Param: "fields", Message: "unknown field " + name}
+			}
+			mask[name] = true
+		}
+	}
+	if len(mask) == 0 {
+		return nil, &paramError{Param: "fields", Message: "fields must name at least one field"}
+	}
+	return mask, nil
+}
+
+// writeItem encodes a single item, keeping only the masked fields.
+func (m fieldMask) writeItem(w http.ResponseWriter, item *store.Item) {
+	w.Header().Set("Content-Type", "application/json")
+	if m == nil {
+		json.NewEncoder(w).Encode(item)
+		return
+	}
+	obj, err := m.filter(item)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(obj)
+}
+
+// writeList encodes a list response, either a bare []store.Item or a struct
+// wrapping one in "items", keeping only the masked fields of each item. The
+// wrapper's own fields are left alone.
+func (m fieldMask) writeList(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if m == nil {
+		json.NewEncoder(w).Encode(v)
+		return
+	}
+	out, err := m.filterList(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+func (m fieldMask) filterList(v any) (any, error) {
+	if items, ok := v.([]store.Item); ok {
+		return m.filterItems(items)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var wrapper map[string]json.RawMessage
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return nil, err
+	}
+	var items []store.Item
+	if err := json.Unmarshal(wrapper["items"], &items); err != nil {
+		return nil, err
+	}
+	filtered, err := m.filterItems(items)
+	if err != nil {
+		return nil, err
+	}
+	if wrapper["items"], err = json.Marshal(filtered); err != nil {
+		return nil, err
+	}
+	return wrapper, nil
+}
+
+func (m fieldMask) filterItems(items []store.Item) ([]map[string]json.RawMessage, error) {
+	out := make([]map[string]json.RawMessage, len(items))
+	for i := range items {
+		obj, err := m.filter(&items[i])
+		if err != nil {
+			return nil, err
+		}
+		out[i] = obj
+	}
+	return out, nil
+}
+
+// filter marshals item and drops the fields outside the mask. Fields the
+// item omits (an unset link, say) stay absent even when asked for.
+func (m fieldMask) filter(item *store.Item) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	for name := range obj {
+		if !m[name] {
+			delete(obj, name)
+		}
+	}
+	return obj, nil
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestIntegrationFields(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	item, _ := srv.store.Create("Sparse", "a large body", nil, nil, "")
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	keys := func(obj map[string]json.RawMessage) []string {
+		var names []string
+		for name := range obj {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return names
+	}
+	want := []string{"id", "title", "updatedAt"}
+
+	w := get("/api/items/" + item.ID + "?fields=id,title,updatedAt")
+	if w.Code != http.StatusOK {
+		t.Fatalf("get: status = %d: %s", w.Code, w.Body.String())
+	}
+	var obj map[string]json.RawMessage
+	json.NewDecoder(w.Body).Decode(&obj)
+	if got := keys(obj); !slices.Equal(got, want) {
+		t.Errorf("get: fields = %v, want %v", got, want)
+	}
+
+	w = get("/api/items?fields=id,title&fields=updatedAt")
+	var list []map[string]json.RawMessage
+	json.NewDecoder(w.Body).Decode(&list)
+	if w.Code != http.StatusOK || len(list) != 1 {
+		t.Fatalf("list: status = %d, %d items", w.Code, len(list))
+	}
+	if got := keys(list[0]); !slices.Equal(got, want) {
+		t.Errorf("list: fields = %v, want %v", got, want)
+	}
+
+	// Wrapped pages keep their metadata
+	w = get("/api/items?meta=true&fields=title")
+	var page struct {
+		Items []map[string]json.RawMessage `json:"items"`
+		Total int                          `json:"total"`
+	}
+	json.NewDecoder(w.Body).Decode(&page)
+	if page.Total != 1 || len(page.Items) != 1 || !slices.Equal(keys(page.Items[0]), []string{"title"}) {
+		t.Errorf("meta: total = %d, items = %v", page.Total, page.Items)
+	}
+
+	// Without fields, the whole item is returned
+	w = get("/api/items/" + item.ID)
+	obj = nil
+	json.NewDecoder(w.Body).Decode(&obj)
+	if _, ok := obj["content"]; !ok {
+		t.Errorf("default: fields = %v, want content included", keys(obj))
+	}
+
+	for _, path := range []string{"/api/items?fields=id,bogus", "/api/items/" + item.ID + "?fields=Title", "/api/items?fields=,"} {
+		w := get(path)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", path, w.Code)
+			continue
+		}
+		if e := decodeError(t, w); e.Code != CodeInvalidParam || e.Param != "fields" {
+			t.Errorf("%s: error = %+v, want invalid_param for fields", path, e)
+		}
+	}
+}
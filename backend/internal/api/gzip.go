@@ -0,0 +1,151 @@
+package api
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultGzipMinSize is the response size below which Gzip sends the body
+// uncompressed; the gzip framing isn't worth it for tiny JSON replies.
+const DefaultGzipMinSize = 1024
+
+// Gzip compresses responses for clients that accept gzip. Bodies shorter
+// than minSize, event streams, and responses that already carry a
+// Content-Encoding are passed through unchanged.
+func Gzip(minSize int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			gw := &gzipResponseWriter{ResponseWriter: w, minSize: minSize}
+			next.ServeHTTP(gw, r)
+			// Not deferred: if the handler panics (e.g. http.ErrAbortHandler
+			// mid-export), a gzip footer would make a truncated body look
+			// complete.
+			gw.finish()
+		})
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		v, err := strconv.ParseFloat(q, 64)
+		return err == nil && v > 0
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the first minSize bytes to decide whether to
+// compress, then either streams through a gzip.Writer or passes writes
+// straight to the underlying writer.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize int
+
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if g.decided {
+		g.ResponseWriter.WriteHeader(code)
+		return
+	}
+	g.status = code
+
+	// Bodiless statuses and streams can't wait for the buffer to fill
+	h := g.Header()
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified ||
+		h.Get("Content-Encoding") != "" || strings.HasPrefix(h.Get("Content-Type"), "text/event-stream") {
+		g.decide(false)
+	}
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.decided {
+		g.buf = append(g.buf, b...)
+		if len(g.buf) >= g.minSize {
+			g.decide(true)
+		}
+		return len(b), nil
+	}
+	if g.gz != nil {
+		return g.gz.Write(b)
+	}
+	return g.ResponseWriter.Write(b)
+}
+
+// decide commits the headers and any buffered bytes, compressed or not.
+func (g *gzipResponseWriter) decide(compress bool) {
+	g.decided = true
+	h := g.Header()
+
+	if compress && h.Get("Content-Encoding") == "" {
+		// Sniff from the uncompressed bytes before the encoding hides them
+		if h.Get("Content-Type") == "" {
+			h.Set("Content-Type", http.DetectContentType(g.buf))
+		}
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+
+	if g.status != 0 {
+		g.ResponseWriter.WriteHeader(g.status)
+	}
+
+	buf := g.buf
+	g.buf = nil
+	if len(buf) == 0 {
+		return
+	}
+	if g.gz != nil {
+		g.gz.Write(buf)
+	} else {
+		g.ResponseWriter.Write(buf)
+	}
+}
+
+func (g *gzipResponseWriter) Flush() {
+	if !g.decided {
+		g.decide(len(g.buf) >= g.minSize)
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// finish sends a short buffered body uncompressed and closes the gzip stream.
+func (g *gzipResponseWriter) finish() {
+	if !g.decided {
+		g.decide(false)
+	}
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
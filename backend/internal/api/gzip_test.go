@@ -0,0 +1,125 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serveGzip(h http.Handler, acceptEncoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/api/items", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	Gzip(64)(h).ServeHTTP(w, req)
+	return w
+}
+
+func TestGzipCompressesLargeResponses(t *testing.T) {
+	body := `[` + strings.Repeat(`{"title":"item"},`, 20) + `{}]`
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "999")
+		io.WriteString(w, body)
+	})
+
+	w := serveGzip(h, "br, gzip;q=0.8")
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	if got := w.Header().Get("Content-Length"); got != "" {
+		t.Errorf("Content-Length = %q, want unset", got)
+	}
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+		t.Errorf("sniffed Content-Type = %q, want text/plain from uncompressed body", got)
+	}
+
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	decoded, _ := io.ReadAll(zr)
+	if string(decoded) != body {
+		t.Errorf("decoded body = %q", decoded)
+	}
+}
+
+func TestGzipSkipsSmallResponses(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"ok":true}`)
+	})
+
+	w := serveGzip(h, "gzip")
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q, want none", got)
+	}
+	if w.Code != http.StatusCreated {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusCreated)
+	}
+	if w.Body.String() != `{"ok":true}` {
+		t.Errorf("body = %q", w.Body.String())
+	}
+	if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("Vary = %q, want Accept-Encoding", got)
+	}
+}
+
+func TestGzipRespectsAcceptEncoding(t *testing.T) {
+	body := strings.Repeat("x", 200)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	})
+
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"br", ""},
+		{"gzip;q=0", ""},
+		{"gzip", "gzip"},
+		{"deflate, GZIP", "gzip"},
+	}
+	for _, tc := range tests {
+		w := serveGzip(h, tc.accept)
+		if got := w.Header().Get("Content-Encoding"); got != tc.want {
+			t.Errorf("Accept-Encoding %q: Content-Encoding = %q, want %q", tc.accept, got, tc.want)
+		}
+	}
+}
+
+func TestGzipSkipsEventStreams(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, strings.Repeat(": heartbeat\n\n", 20))
+		http.NewResponseController(w).Flush()
+	})
+
+	w := serveGzip(h, "gzip")
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q, want none for SSE", got)
+	}
+	if !w.Flushed {
+		t.Error("expected flush to reach the underlying writer")
+	}
+}
+
+func TestGzipNotModified(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	})
+
+	w := serveGzip(h, "gzip")
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("status = %d body = %q, want bare 304", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q, want none", got)
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alanp/cue/internal/auth"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cue_http_requests_total",
+		Help: "HTTP requests by route pattern, method, and status code.",
+	}, []string{"route", "method", "status"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cue_http_request_duration_seconds",
+		Help:    "HTTP request latency by route pattern and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	itemsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cue_items",
+		Help: "Live (non-trashed) items.",
+	})
+
+	tokensGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cue_tokens",
+		Help: "Stored API tokens.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal, requestDuration, itemsGauge, tokensGauge)
+}
+
+// statusRecorder captures the response status and body size for metrics
+// and access logs.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// instrument records request counts and latency, labelled by the mux route
+// pattern rather than the raw path so IDs don't explode cardinality.
+func (s *Server) instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" || route == catchAllPattern {
+			route = "unmatched"
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		defer func() {
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+			requestsTotal.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		}()
+		mux.ServeHTTP(rec, r)
+	})
+}
+
+// handleMetrics serves Prometheus metrics. Item and token gauges are
+// refreshed from the store on each scrape.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	// In auth-enabled mode, keep metrics off the public surface
+	if s.authCfg.Enabled {
+		if user := auth.GetUser(r.Context()); user == nil || user.AuthMethod != "cert" {
+			writeError(w, http.StatusUnauthorized, CodeCertRequired, "Client certificate required for metrics")
+			return
+		}
+	}
+
+	if n, err := s.store.Count(); err == nil {
+		itemsGauge.Set(float64(n))
+	}
+	if n, err := s.store.CountTokens(); err == nil {
+		tokensGauge.Set(float64(n))
+	}
+	promhttp.Handler().ServeHTTP(w, r)
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	srv, _ := setupAdminServer(t)
+
+	req := withUser(httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "Metric"}`)), "cert")
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+	req = withUser(httptest.NewRequest("GET", "/api/items/missing-id", nil), "cert")
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = withUser(httptest.NewRequest("GET", "/api/metrics", nil), "cert")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		"cue_items 1",
+		`cue_http_requests_total{method="POST",route="POST /api/items",status="201"}`,
+		`cue_http_requests_total{method="GET",route="GET /api/items/{id}",status="404"}`,
+		`cue_http_request_duration_seconds_count{method="POST",route="POST /api/items"}`,
+		`cue_store_operations_total{op="create"}`,
+		"cue_tokens 0",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
+
+func TestMetricsEndpointRequiresCert(t *testing.T) {
+	srv, _ := setupAdminServer(t)
+
+	req := withUser(httptest.NewRequest("GET", "/api/metrics", nil), "token")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
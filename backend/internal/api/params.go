@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alanp/cue/internal/store"
+)
+
+// MaxPageLimit is the default Config.MaxListLimit.
+const MaxPageLimit = 500
+
+// paramError describes a query parameter that failed validation.
+type paramError struct {
+	Param   string
+	Message string
+}
+
+func (e *paramError) Error() string {
+	return e.Param + ": " + e.Message
+}
+
+// queryCount parses a non-negative integer query parameter. An absent or
+// empty parameter is 0.
+func queryCount(r *http.Request, name string) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, &paramError{Param: name, Message: fmt.Sprintf("%s must be an integer", name)}
+	}
+	if n < 0 {
+		return 0, &paramError{Param: name, Message: fmt.Sprintf("%s must not be negative", name)}
+	}
+	return n, nil
+}
+
+// queryLimit parses the limit parameter. An absent or zero limit is def,
+// and anything above Config.MaxListLimit is clamped to it.
+func (s *Server) queryLimit(r *http.Request, def int) (int, error) {
+	limit, err := queryCount(r, "limit")
+	if err != nil {
+		return 0, err
+	}
+	if limit == 0 {
+		return def, nil
+	}
+	return min(limit, s.cfg.MaxListLimit), nil
+}
+
+// encodeCursor turns a list position into the opaque ?cursor= token: the
+// URL-safe base64 of "<updated_at>|<id>".
+func encodeCursor(c store.Cursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.UpdatedAt.UTC().Format(time.RFC3339) + "|" + c.ID))
+}
+
+// decodeCursor parses a token from encodeCursor.
+func decodeCursor(token string) (store.Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return store.Cursor{}, err
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return store.Cursor{}, errors.New("malformed cursor")
+	}
+	updatedAt, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return store.Cursor{}, err
+	}
+	return store.Cursor{UpdatedAt: updatedAt, ID: id}, nil
+}
+
+// writeParamError sends a 400 invalid_param error naming the offending
+// parameter. Errors that are not a *paramError are reported without one.
+func writeParamError(w http.ResponseWriter, err error) {
+	var pe *paramError
+	if !errors.As(err, &pe) {
+		writeError(w, http.StatusBadRequest, CodeInvalidParam, err.Error())
+		return
+	}
+	writeErrorDetail(w, http.StatusBadRequest, errorDetail{
+		Code:    CodeInvalidParam,
+		Message: pe.Message,
+		Param:   pe.Param,
+	})
+}
@@ -0,0 +1,29 @@
+package api
+
+import "net/http"
+
+// readOnlyAllowed are the POST routes that change nothing: batch-get reads
+// items, a backup only reads the database, and introspection checks a
+// token.
+var readOnlyAllowed = map[string]bool{
+	"/api/items/batch-get":   true,
+	"/api/admin/backup":      true,
+	"/api/tokens/introspect": true,
+}
+
+// ReadOnly rejects requests that would change data with 403, for serving a
+// read-only mirror. GET, HEAD, and OPTIONS pass, as do the POST routes in
+// readOnlyAllowed; POST, PUT, PATCH, and DELETE everywhere else do not.
+func ReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if r.Method != http.MethodPost || !readOnlyAllowed[r.URL.Path] {
+				writeError(w, http.StatusForbidden, CodeReadOnly, "server is read-only")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadOnly(t *testing.T) {
+	srv, cleanup := setupTestServer(t)
+	defer cleanup()
+	item, _ := srv.store.Create("Mirrored", "searchable content", nil, nil, "")
+	h := ReadOnly(srv)
+
+	rejected := []struct{ method, path, body string }{
+		{"POST", "/api/items", `{"title":"New"}`},
+		{"PUT", "/api/items/" + item.ID, `{"title":"Changed"}`},
+		{"PATCH", "/api/items/" + item.ID, `{"title":"Changed"}`},
+		{"DELETE", "/api/items/" + item.ID, ""},
+		{"POST", "/api/items/" + item.ID + "/pin", ""},
+		{"POST", "/api/items/delete", `{"ids":["` + item.ID + `"]}`},
+		{"POST", "/api/import", `[]`},
+		{"POST", "/api/tokens", `{"name":"ci"}`},
+		{"DELETE", "/api/tokens/abc", ""},
+		{"POST", "/api/admin/vacuum", ""},
+	}
+	for _, tc := range rejected {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s status = %d, want %d", tc.method, tc.path, w.Code, http.StatusForbidden)
+			continue
+		}
+		if e := decodeError(t, w); e.Code != CodeReadOnly {
+			t.Errorf("%s %s code = %q, want %q", tc.method, tc.path, e.Code, CodeReadOnly)
+		}
+	}
+	if got, err := srv.store.Get(item.ID); err != nil || got.Title != "Mirrored" || got.Pinned {
+		t.Errorf("item after rejected writes = %+v, %v", got, err)
+	}
+
+	allowed := []struct{ method, path, body string }{
+		{"GET", "/api/items", ""},
+		{"GET", "/api/items/" + item.ID, ""},
+		{"HEAD", "/api/items/" + item.ID, ""},
+		{"GET", "/api/search?q=searchable", ""},
+		{"GET", "/api/export", ""},
+		{"POST", "/api/items/batch-get", `{"ids":["` + item.ID + `"]}`},
+	}
+	for _, tc := range allowed {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s %s status = %d, want %d: %s", tc.method, tc.path, w.Code, http.StatusOK, w.Body.String())
+		}
+	}
+}
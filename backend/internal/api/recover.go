@@ -0,0 +1,45 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/alanp/cue/internal/auth"
+)
+
+// Recover turns a panic anywhere below it into a logged stack trace and a
+// 500 JSON error, so one bad request cannot take down the server or vanish
+// without a trace. It should be the outermost middleware so it also covers
+// the others. The panic value passes through auth.RedactSecrets, since it may
+// quote a token; the stack itself holds only frames and pointers. http.ErrAbortHandler is re-raised:
+// handlers use it to abandon a response on purpose. If the handler had
+// already started its response, the connection is aborted instead, as
+// writing an error body then would corrupt it.
+func Recover(logger *log.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &statusRecorder{ResponseWriter: w}
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+
+				requestID := w.Header().Get(auth.RequestIDHeader)
+				logger.Printf("panic serving %s %s (request %s): %s\n%s",
+					r.Method, r.URL.Path, requestID, auth.RedactSecrets(fmt.Sprint(p)), debug.Stack())
+
+				if rec.status != 0 {
+					panic(http.ErrAbortHandler)
+				}
+				writeError(w, http.StatusInternalServerError, CodeInternal, "internal server error")
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
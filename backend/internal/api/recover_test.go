@@ -0,0 +1,78 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	const secret = "0123456789abcdef0123456789abcdef"
+	var logBuf, accessBuf bytes.Buffer
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/boom" {
+			panic("token " + secret + " rejected")
+		}
+		io.WriteString(w, "ok")
+	})
+	ts := httptest.NewServer(Recover(log.New(&logBuf, "", 0))(AccessLog(AccessLogConfig{Writer: &accessBuf})(inner)))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/boom")
+	if err != nil {
+		t.Fatalf("GET /boom: %v", err)
+	}
+	var body errorBody
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError || body.Error.Code != CodeInternal {
+		t.Errorf("status = %d, code = %q, want 500 %q", resp.StatusCode, body.Error.Code, CodeInternal)
+	}
+
+	logged := logBuf.String()
+	if !strings.Contains(logged, "panic serving GET /boom") || !strings.Contains(logged, "recover_test.go") {
+		t.Errorf("log lacks the request or stack trace:\n%s", logged)
+	}
+	if strings.Contains(logged, secret) {
+		t.Errorf("log leaks the secret:\n%s", logged)
+	}
+	if !strings.Contains(accessBuf.String(), `"status":500`) {
+		t.Errorf("access log = %s, want status 500", accessBuf.String())
+	}
+
+	// The server is still up
+	resp, err = http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatalf("GET / after panic: %v", err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(got) != "ok" {
+		t.Errorf("status = %d, body = %q, want 200 ok", resp.StatusCode, got)
+	}
+}
+
+func TestRecoverAfterHeaders(t *testing.T) {
+	var logBuf bytes.Buffer
+	h := Recover(log.New(&logBuf, "", 0))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		panic("late")
+	}))
+
+	// The response is already under way, so the connection is aborted
+	// rather than given a second status line
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", p)
+		}
+		if !strings.Contains(logBuf.String(), "late") {
+			t.Errorf("log = %q, want the panic value", logBuf.String())
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
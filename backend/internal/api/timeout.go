@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// DefaultRequestTimeout bounds how long a single API request may spend in
+// the store before it is cancelled.
+const DefaultRequestTimeout = 30 * time.Second
+
+// Timeout gives each request a context that expires after d. Store calls
+// made with that context are interrupted when it fires, and the handler
+// answers 503 (see storeError). Requests to untimedPaths keep the unbounded
+// context. A non-positive d disables the deadline.
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if untimedPaths[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// untimedPaths are long-lived by design, running as long as the client keeps
+// reading: the event stream, admin backups, and exports, which stream every
+// item and would be cut off partway through by a deadline.
+var untimedPaths = map[string]bool{
+	"/api/events":       true,
+	"/api/admin/backup": true,
+	"/api/export":       true,
+	"/api/export.csv":   true,
+}
+
+// storeError reports a failed store call: 503 when the request deadline
+// cancelled it, 500 otherwise. The request context is checked rather than
+// err because SQLite surfaces an interrupted query as its own error.
+func storeError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+		writeError(w, http.StatusServiceUnavailable, CodeTimeout, "request timed out")
+		return
+	}
+	writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+}
@@ -0,0 +1,480 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alanp/cue/internal/auth"
+	"github.com/alanp/cue/internal/clock"
+	"github.com/alanp/cue/internal/store"
+)
+
+// setupAuthServer returns an auth-enabled server wrapped in the token
+// middleware, plus the bare server for cert-authenticated calls.
+func setupAuthServer(t *testing.T) (http.Handler, *Server) {
+	t.Helper()
+
+	s, err := store.New(filepath.Join(t.TempDir(), "cue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	secret := []byte("test-secret-32-bytes-long-key!!")
+	srv := NewWithAuth(s, AuthConfig{Enabled: true, Secret: secret}, "dev")
+	handler := auth.Middleware(auth.MiddlewareConfig{
+		AuthEnabled: true,
+		Secret:      secret,
+		TokenValidator: func(token, sourceIP string) (string, error) {
+			return s.ValidateTokenHashWithLeeway(auth.HashToken(token), sourceIP, auth.EffectiveTokenLeeway(0))
+		},
+	})(srv)
+	return handler, srv
+}
+
+// createToken issues a token as a cert-authenticated user.
+func createToken(t *testing.T, srv *Server, body string) (int, createTokenResponse) {
+	t.Helper()
+	req := withUser(httptest.NewRequest("POST", "/api/tokens", bytes.NewBufferString(body)), "cert")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var resp createTokenResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	return w.Code, resp
+}
+
+func TestTokenScopesEnforced(t *testing.T) {
+	handler, srv := setupAuthServer(t)
+
+	code, tok := createToken(t, srv, `{"name": "reader", "scopes": ["items:read"]}`)
+	if code != http.StatusCreated {
+		t.Fatalf("create token status = %d", code)
+	}
+	if len(tok.Scopes) != 1 || tok.Scopes[0] != auth.ScopeItemsRead {
+		t.Errorf("scopes = %v, want [items:read]", tok.Scopes)
+	}
+
+	do := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+tok.Token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := do("GET", "/api/items", ""); code != http.StatusOK {
+		t.Errorf("GET status = %d, want %d", code, http.StatusOK)
+	}
+	if code := do("POST", "/api/items", `{"title": "x"}`); code != http.StatusForbidden {
+		t.Errorf("POST status = %d, want %d", code, http.StatusForbidden)
+	}
+	if code := do("PUT", "/api/items/some-id", `{"title": "x"}`); code != http.StatusForbidden {
+		t.Errorf("PUT status = %d, want %d", code, http.StatusForbidden)
+	}
+	if code := do("DELETE", "/api/items/some-id", ""); code != http.StatusForbidden {
+		t.Errorf("DELETE status = %d, want %d", code, http.StatusForbidden)
+	}
+	if code := do("GET", "/api/tokens", ""); code != http.StatusForbidden {
+		t.Errorf("GET tokens status = %d, want %d", code, http.StatusForbidden)
+	}
+}
+
+func TestTokenScopesDefaultFullAccess(t *testing.T) {
+	handler, srv := setupAuthServer(t)
+
+	_, tok := createToken(t, srv, `{"name": "full"}`)
+	if len(tok.Scopes) != len(auth.AllScopes) {
+		t.Errorf("scopes = %v, want all scopes", tok.Scopes)
+	}
+
+	req := httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "Allowed"}`))
+	req.Header.Set("Authorization", "Bearer "+tok.Token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Errorf("POST status = %d, want %d", w.Code, http.StatusCreated)
+	}
+}
+
+func TestTokenUnknownScope(t *testing.T) {
+	_, srv := setupAuthServer(t)
+
+	code, _ := createToken(t, srv, `{"name": "bad", "scopes": ["items:everything"]}`)
+	if code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestItemOwnership(t *testing.T) {
+	handler, srv := setupAuthServer(t)
+
+	// Cert auth records the certificate CN
+	req := withUser(httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "By cert"}`)), "cert")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var item store.Item
+	json.NewDecoder(w.Body).Decode(&item)
+	if w.Code != http.StatusCreated || item.CreatedBy != "admin" {
+		t.Errorf("cert create: status = %d, createdBy = %q, want admin", w.Code, item.CreatedBy)
+	}
+
+	// Token auth records the token owner's CN
+	_, tok := createToken(t, srv, `{"name": "writer"}`)
+	req = httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "By token"}`))
+	req.Header.Set("Authorization", "Bearer "+tok.Token)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	item = store.Item{}
+	json.NewDecoder(w.Body).Decode(&item)
+	if w.Code != http.StatusCreated || item.CreatedBy != "admin" {
+		t.Errorf("token create: status = %d, createdBy = %q, want admin", w.Code, item.CreatedBy)
+	}
+
+	other := &auth.UserContext{CN: "other", AuthMethod: "cert"}
+	req = httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"title": "By other"}`))
+	srv.ServeHTTP(httptest.NewRecorder(), req.WithContext(auth.WithUser(req.Context(), other)))
+
+	list := func(req *http.Request) []store.Item {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		var items []store.Item
+		json.NewDecoder(w.Body).Decode(&items)
+		return items
+	}
+	if items := list(withUser(httptest.NewRequest("GET", "/api/items", nil), "cert")); len(items) != 3 {
+		t.Errorf("all items = %d, want 3", len(items))
+	}
+	if items := list(withUser(httptest.NewRequest("GET", "/api/items?mine=true", nil), "cert")); len(items) != 2 {
+		t.Errorf("admin's items = %d, want 2", len(items))
+	}
+	req = httptest.NewRequest("GET", "/api/items?mine=true", nil)
+	items := list(req.WithContext(auth.WithUser(req.Context(), other)))
+	if len(items) != 1 || items[0].Title != "By other" {
+		t.Errorf("other's items = %v, want [By other]", items)
+	}
+}
+
+func TestWhoAmICredentialDetails(t *testing.T) {
+	handler, srv := setupAuthServer(t)
+
+	whoami := func(h http.Handler, req *http.Request) map[string]any {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("whoami status = %d", w.Code)
+		}
+		var resp struct {
+			User map[string]any `json:"user"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.User
+	}
+
+	_, tok := createToken(t, srv, `{"name": "bot", "scopes": ["items:read"]}`)
+	req := httptest.NewRequest("GET", "/api/whoami", nil)
+	req.Header.Set("Authorization", "Bearer "+tok.Token)
+	user := whoami(handler, req)
+	if user["token_id"] != tok.ID {
+		t.Errorf("token_id = %v, want %s", user["token_id"], tok.ID)
+	}
+	if user["expires_at"] != tok.ExpiresAt.UTC().Format(time.RFC3339) {
+		t.Errorf("expires_at = %v, want %s", user["expires_at"], tok.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	if scopes, _ := user["scopes"].([]any); len(scopes) != 1 || scopes[0] != auth.ScopeItemsRead {
+		t.Errorf("scopes = %v, want [items:read]", user["scopes"])
+	}
+	if _, ok := user["not_after"]; ok {
+		t.Error("token response should not include not_after")
+	}
+
+	notAfter := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	req = httptest.NewRequest("GET", "/api/whoami", nil)
+	req = req.WithContext(auth.WithUser(req.Context(), &auth.UserContext{CN: "admin", AuthMethod: "cert", NotAfter: notAfter}))
+	user = whoami(srv, req)
+	if user["not_after"] != notAfter.Format(time.RFC3339) {
+		t.Errorf("not_after = %v, want %s", user["not_after"], notAfter.Format(time.RFC3339))
+	}
+	for _, key := range []string{"token_id", "expires_at", "scopes"} {
+		if _, ok := user[key]; ok {
+			t.Errorf("cert response should not include %s", key)
+		}
+	}
+}
+
+func TestUpdateTokenName(t *testing.T) {
+	_, srv := setupAuthServer(t)
+	_, tok := createToken(t, srv, `{"name": "mislabeled"}`)
+
+	patch := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	newReq := func(body string) *http.Request {
+		return httptest.NewRequest("PATCH", "/api/tokens/"+tok.ID, bytes.NewBufferString(body))
+	}
+
+	w := patch(withUser(newReq(`{"name": "deploy bot"}`), "cert"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("rename status = %d: %s", w.Code, w.Body.String())
+	}
+	var info store.TokenInfo
+	json.NewDecoder(w.Body).Decode(&info)
+	if info.ID != tok.ID || info.Name != "deploy bot" {
+		t.Errorf("renamed token = %+v", info)
+	}
+
+	if w := patch(withUser(newReq(`{"name": "  "}`), "cert")); w.Code != http.StatusBadRequest {
+		t.Errorf("empty name status = %d, want 400", w.Code)
+	}
+
+	req := newReq(`{"name": "hijacked"}`)
+	req = req.WithContext(auth.WithUser(req.Context(), &auth.UserContext{CN: "mallory", AuthMethod: "cert"}))
+	if w := patch(req); w.Code != http.StatusNotFound {
+		t.Errorf("other user status = %d, want 404", w.Code)
+	}
+
+	if w := patch(withUser(newReq(`{"name": "via token"}`), "token")); w.Code != http.StatusUnauthorized {
+		t.Errorf("token auth status = %d, want 401", w.Code)
+	}
+
+	tokens, _ := srv.store.ListTokens("admin")
+	if len(tokens) != 1 || tokens[0].Name != "deploy bot" {
+		t.Errorf("stored tokens = %+v", tokens)
+	}
+}
+
+func TestTokenLastUsedIP(t *testing.T) {
+	handler, srv := setupAuthServer(t)
+	_, tok := createToken(t, srv, `{"name": "roaming"}`)
+
+	for _, addr := range []string{"192.0.2.1:40000", "198.51.100.7:51234"} {
+		req := httptest.NewRequest("GET", "/api/items", nil)
+		req.RemoteAddr = addr
+		req.Header.Set("Authorization", "Bearer "+tok.Token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d", w.Code)
+		}
+	}
+
+	info, err := srv.store.GetTokenByID(tok.ID)
+	if err != nil {
+		t.Fatalf("GetTokenByID: %v", err)
+	}
+	if info.LastUsedIP != "198.51.100.7" {
+		t.Errorf("last_used_ip = %q, want 198.51.100.7", info.LastUsedIP)
+	}
+}
+
+func TestTokenLimitPerUser(t *testing.T) {
+	_, srv := setupAuthServer(t)
+	srv.authCfg.MaxTokensPerUser = 2
+
+	// Expired tokens do not count against the limit
+	srv.store.CreateToken("tok_old", "admin", "old", []byte("hash-old"), time.Now().Add(-time.Hour), nil)
+
+	for i := 0; i < 2; i++ {
+		if code, _ := createToken(t, srv, `{"name": "ci"}`); code != http.StatusCreated {
+			t.Fatalf("token %d: status = %d, want %d", i, code, http.StatusCreated)
+		}
+	}
+
+	req := withUser(httptest.NewRequest("POST", "/api/tokens", bytes.NewBufferString(`{"name": "one too many"}`)), "cert")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("over limit: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if e := decodeError(t, w); e.Code != CodeTokenLimit {
+		t.Errorf("over limit: code = %q, want %q", e.Code, CodeTokenLimit)
+	}
+
+	// Revoking a token frees a slot
+	tokens, _ := srv.store.ListTokens("admin")
+	for _, tok := range tokens {
+		if tok.ID != "tok_old" {
+			srv.store.DeleteToken(tok.ID, "admin")
+			break
+		}
+	}
+	if code, _ := createToken(t, srv, `{"name": "replacement"}`); code != http.StatusCreated {
+		t.Errorf("after revoke: status = %d, want %d", code, http.StatusCreated)
+	}
+}
+
+func TestDeleteAllTokens(t *testing.T) {
+	handler, srv := setupAuthServer(t)
+	var logBuf bytes.Buffer
+	srv.authCfg.Logger = auth.NewSecurityLogger(&logBuf)
+
+	_, readOnly := createToken(t, srv, `{"name": "reader", "scopes": ["items:read"]}`)
+	_, full := createToken(t, srv, `{"name": "laptop"}`)
+	srv.store.CreateToken("tok_bob", "bob", "bob", []byte("hash-bob"), time.Now().Add(time.Hour), nil)
+
+	revokeAll := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("DELETE", "/api/tokens", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := revokeAll(readOnly.Token); w.Code != http.StatusForbidden {
+		t.Fatalf("without tokens:manage: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	w := revokeAll(full.Token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	var resp revokeAllTokensResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Revoked != 2 {
+		t.Errorf("revoked = %d, want 2", resp.Revoked)
+	}
+
+	if tokens, _ := srv.store.ListTokens("admin"); len(tokens) != 0 {
+		t.Errorf("admin still holds %d tokens", len(tokens))
+	}
+	if _, err := srv.store.GetTokenByID("tok_bob"); err != nil {
+		t.Errorf("other user's token was revoked: %v", err)
+	}
+	if w := revokeAll(full.Token); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token reused: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	logged := logBuf.String()
+	for _, want := range []string{`"event":"tokens_revoked_all"`, `"method":"token"`, `"token_id":"` + full.ID + `"`, `"reason":"revoked_via_token"`, `"details":"count=2"`} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("security log missing %s: %s", want, logged)
+		}
+	}
+
+	// Certificate users can revoke too, even with nothing left
+	req := withUser(httptest.NewRequest("DELETE", "/api/tokens", nil), "cert")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp.Revoked != 0 {
+		t.Errorf("cert revoke = %d, %+v", w.Code, resp)
+	}
+}
+
+func TestJWTTokenFormat(t *testing.T) {
+	handler, srv := setupAuthServer(t)
+	srv.authCfg.TokenFormat = auth.TokenFormatJWT
+
+	code, tok := createToken(t, srv, `{"name": "jwt"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("create status = %d", code)
+	}
+	if n := strings.Count(tok.Token, "."); n != 2 {
+		t.Fatalf("token has %d dots, want 2: %s", n, tok.Token)
+	}
+
+	req := httptest.NewRequest("GET", "/api/items", nil)
+	req.Header.Set("Authorization", "Bearer "+tok.Token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("JWT auth status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestIntrospectToken(t *testing.T) {
+	handler, srv := setupAuthServer(t)
+	_, caller := createToken(t, srv, `{"name": "gateway"}`)
+	_, active := createToken(t, srv, `{"name": "client", "scopes": ["items:read"]}`)
+	_, revoked := createToken(t, srv, `{"name": "leaked", "scopes": ["items:write"]}`)
+	srv.store.DeleteToken(revoked.ID, "admin")
+
+	// Signed and stored, but past its expiry
+	secret := []byte("test-secret-32-bytes-long-key!!")
+	expired, _, _ := auth.GenerateToken("admin", -time.Hour, secret, nil)
+	srv.store.CreateToken("tok_expired", "admin", "expired", auth.HashToken(expired), time.Now().Add(time.Hour), nil)
+
+	introspect := func(bearer, token string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(introspectTokenRequest{Token: token})
+		req := httptest.NewRequest("POST", "/api/tokens/introspect", bytes.NewReader(body))
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := introspect(caller.Token, active.Token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	var resp introspectTokenResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if !resp.Active || resp.CN != "admin" || resp.EXP != active.ExpiresAt.Unix() || len(resp.Scopes) != 1 || resp.Scopes[0] != auth.ScopeItemsRead {
+		t.Errorf("active token = %+v", resp)
+	}
+	// Introspection is not a use of the token
+	if info, _ := srv.store.GetTokenByID(active.ID); info.LastUsedAt != nil || info.LastUsedIP != "" {
+		t.Errorf("introspection recorded a use: last_used_at = %v, last_used_ip = %q", info.LastUsedAt, info.LastUsedIP)
+	}
+
+	for name, token := range map[string]string{
+		"expired": expired,
+		"revoked": revoked.Token,
+		"forged":  active.Token[:len(active.Token)-2] + "xx",
+		"garbage": "not-a-token",
+	} {
+		w := introspect(caller.Token, token)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d", name, w.Code, http.StatusOK)
+			continue
+		}
+		if body := strings.TrimSpace(w.Body.String()); body != `{"active":false}` {
+			t.Errorf("%s: body = %s, want {\"active\":false}", name, body)
+		}
+	}
+
+	if w := introspect("", active.Token); w.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := introspect(active.Token, caller.Token); w.Code != http.StatusForbidden {
+		t.Errorf("without tokens:manage: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestIntrospectTokenLeeway(t *testing.T) {
+	_, srv := setupAuthServer(t)
+	_, tok := createToken(t, srv, `{"name": "skewed", "expires_in": "1h"}`)
+
+	for _, tc := range []struct {
+		at     time.Time
+		active bool
+	}{
+		{tok.ExpiresAt.Add(auth.DefaultTokenLeeway), true},
+		{tok.ExpiresAt.Add(auth.DefaultTokenLeeway + time.Second), false},
+	} {
+		c := clock.NewFake(tc.at)
+		srv.authCfg.Clock = c
+		srv.store.SetClock(c)
+
+		body, _ := json.Marshal(introspectTokenRequest{Token: tok.Token})
+		req := withUser(httptest.NewRequest("POST", "/api/tokens/introspect", bytes.NewReader(body)), "cert")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+
+		var resp introspectTokenResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp.Active != tc.active {
+			t.Errorf("at expiry%+v: active = %v, want %v", tc.at.Sub(tok.ExpiresAt), resp.Active, tc.active)
+		}
+	}
+}
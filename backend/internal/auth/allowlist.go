@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// CNAllowlist limits certificate authentication to listed users, for CAs
+// that also sign service certificates. The file holds one CN per line;
+// blank lines and lines starting with # are ignored. Entries are matched
+// against UserContext.CN, so they follow MiddlewareConfig.IdentityField.
+type CNAllowlist struct {
+	path string
+
+	mu  sync.RWMutex
+	cns map[string]struct{}
+}
+
+// NewCNAllowlist loads the allowlist at path.
+func NewCNAllowlist(path string) (*CNAllowlist, error) {
+	al := &CNAllowlist{path: path}
+	if err := al.Reload(); err != nil {
+		return nil, err
+	}
+	return al, nil
+}
+
+// Reload re-reads the allowlist file. On error the previously loaded list
+// stays in effect.
+func (al *CNAllowlist) Reload() error {
+	data, err := os.ReadFile(al.path)
+	if err != nil {
+		return fmt.Errorf("read cn allowlist: %w", err)
+	}
+
+	cns := make(map[string]struct{})
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		cns[line] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("parse cn allowlist: %w", err)
+	}
+
+	al.mu.Lock()
+	al.cns = cns
+	al.mu.Unlock()
+	return nil
+}
+
+// Path returns the allowlist file.
+func (al *CNAllowlist) Path() string {
+	return al.path
+}
+
+// Allows reports whether cn may authenticate. A nil allowlist allows
+// everyone.
+func (al *CNAllowlist) Allows(cn string) bool {
+	if al == nil {
+		return true
+	}
+	al.mu.RLock()
+	defer al.mu.RUnlock()
+	_, ok := al.cns[cn]
+	return ok
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCNAllowlist_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowlist")
+	os.WriteFile(path, []byte("# operators\nalice\n\n  bob  \n"), 0600)
+
+	al, err := NewCNAllowlist(path)
+	if err != nil {
+		t.Fatalf("NewCNAllowlist: %v", err)
+	}
+	for cn, want := range map[string]bool{"alice": true, "bob": true, "carol": false, "# operators": false} {
+		if got := al.Allows(cn); got != want {
+			t.Errorf("Allows(%q) = %v, want %v", cn, got, want)
+		}
+	}
+
+	os.WriteFile(path, []byte("carol\n"), 0600)
+	if err := al.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if al.Allows("alice") || !al.Allows("carol") {
+		t.Error("reload should replace the list")
+	}
+
+	// A missing file keeps the previous list
+	os.Remove(path)
+	if err := al.Reload(); err == nil {
+		t.Error("expected error reloading missing allowlist")
+	}
+	if !al.Allows("carol") {
+		t.Error("failed reload should keep the previous list")
+	}
+
+	var nilList *CNAllowlist
+	if !nilList.Allows("anyone") {
+		t.Error("nil allowlist should allow everyone")
+	}
+}
+
+func TestMiddleware_CNAllowlist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowlist")
+	os.WriteFile(path, []byte("alice\n"), 0600)
+	al, err := NewCNAllowlist(path)
+	if err != nil {
+		t.Fatalf("NewCNAllowlist: %v", err)
+	}
+
+	var logBuf bytes.Buffer
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	cfg := MiddlewareConfig{
+		AuthEnabled: true,
+		Secret:      []byte("test-secret-32-bytes-long-key!!"),
+		Logger:      NewSecurityLogger(&logBuf),
+	}
+	open := cfg
+	cfg.Allowlist = al
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		cn      string
+		want    int
+	}{
+		{"allowed", Middleware(cfg)(ok), "alice", http.StatusOK},
+		{"denied", Middleware(cfg)(ok), "mallory", http.StatusForbidden},
+		{"denied cert-only", RequireCertAuth(cfg)(ok), "mallory", http.StatusForbidden},
+		{"no allowlist", Middleware(open)(ok), "mallory", http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logBuf.Reset()
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{generateTestCertForMiddleware(t, tc.cn)}}
+			rec := httptest.NewRecorder()
+			tc.handler.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("expected %d, got %d", tc.want, rec.Code)
+			}
+
+			if tc.want == http.StatusForbidden {
+				var event SecurityEvent
+				json.Unmarshal(logBuf.Bytes(), &event)
+				if event.Event != "auth_failure" || event.Reason != "cn_not_allowed" || event.Details != "cn="+tc.cn {
+					t.Errorf("logged event = %+v, want cn_not_allowed failure", event)
+				}
+			}
+		})
+	}
+}
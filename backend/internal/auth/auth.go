@@ -4,6 +4,8 @@ package auth
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"net/http"
 	"time"
 )
@@ -11,12 +13,16 @@ import (
 // UserContext holds authenticated user information extracted from
 // a client certificate or API token.
 type UserContext struct {
-	CN         string    // Common Name - primary identifier
-	DN         string    // Full Distinguished Name (for LDAP lookup)
-	Serial     string    // Certificate serial number (empty for token auth)
-	NotAfter   time.Time // Certificate expiration (zero for token auth)
-	AuthMethod string    // "cert", "token", or "none"
-	TokenID    string    // Token ID if authenticated via token
+	CN             string    // Common Name - primary identifier
+	DN             string    // Full Distinguished Name (for LDAP lookup)
+	OUs            []string  // Certificate subject organizational units (nil for token/none)
+	Serial         string    // Certificate serial number (empty for token auth)
+	NotAfter       time.Time // Certificate expiration (zero for token auth)
+	AuthMethod     string    // "cert", "token", or "none"
+	TokenID        string    // Token ID if authenticated via token
+	TokenExpiresAt time.Time // Token expiration (zero for cert/none)
+	Scopes         []string  // Token scopes (nil for cert/none, or legacy unscoped tokens)
+	TokenInQuery   bool      // Token came from the access_token query parameter
 }
 
 type contextKey string
@@ -37,32 +43,71 @@ func WithUser(ctx context.Context, user *UserContext) context.Context {
 	return context.WithValue(ctx, userContextKey, user)
 }
 
-// ExtractUserFromCert extracts user identity from a verified client certificate.
-// Returns nil if no valid certificate is present.
-func ExtractUserFromCert(r *http.Request) *UserContext {
-	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
-		return nil
+// IdentityField selects the client certificate field that becomes
+// UserContext.CN.
+type IdentityField string
+
+const (
+	IdentityCN     IdentityField = "cn"      // Subject common name (the default)
+	IdentityEmail  IdentityField = "email"   // First email address SAN
+	IdentityURISAN IdentityField = "uri-san" // First URI SAN
+)
+
+// ParseIdentityField validates an identity field name. An empty string
+// selects IdentityCN.
+func ParseIdentityField(v string) (IdentityField, error) {
+	switch f := IdentityField(v); f {
+	case "":
+		return IdentityCN, nil
+	case IdentityCN, IdentityEmail, IdentityURISAN:
+		return f, nil
 	}
-	cert := r.TLS.PeerCertificates[0]
-	return &UserContext{
-		CN:         cert.Subject.CommonName,
-		DN:         cert.Subject.String(),
-		Serial:     cert.SerialNumber.String(),
-		NotAfter:   cert.NotAfter,
-		AuthMethod: "cert",
+	return "", fmt.Errorf("unknown certificate identity field %q (want cn, email, or uri-san)", v)
+}
+
+// identity returns cert's value for f, or "" if the certificate lacks it.
+// The zero IdentityField reads the CN.
+func (f IdentityField) identity(cert *x509.Certificate) string {
+	switch f {
+	case IdentityEmail:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+		return ""
+	case IdentityURISAN:
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+		return ""
+	}
+	return cert.Subject.CommonName
+}
+
+// ExtractUserFromCert extracts user identity from a verified client
+// certificate, taking CN from the given field. Returns nil if no certificate
+// is present or it lacks the field.
+func ExtractUserFromCert(r *http.Request, field IdentityField) *UserContext {
+	if r.TLS == nil {
+		return nil
 	}
+	return ExtractUserFromTLSState(r.TLS, field)
 }
 
 // ExtractUserFromTLSState extracts user identity from a TLS connection state.
 // Useful for testing without an http.Request.
-func ExtractUserFromTLSState(state *tls.ConnectionState) *UserContext {
+func ExtractUserFromTLSState(state *tls.ConnectionState, field IdentityField) *UserContext {
 	if state == nil || len(state.PeerCertificates) == 0 {
 		return nil
 	}
 	cert := state.PeerCertificates[0]
+	cn := field.identity(cert)
+	if cn == "" {
+		return nil
+	}
 	return &UserContext{
-		CN:         cert.Subject.CommonName,
+		CN:         cn,
 		DN:         cert.Subject.String(),
+		OUs:        cert.Subject.OrganizationalUnit,
 		Serial:     cert.SerialNumber.String(),
 		NotAfter:   cert.NotAfter,
 		AuthMethod: "cert",
@@ -1,14 +1,18 @@
 package auth
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/json"
 	"math/big"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 )
@@ -53,7 +57,7 @@ func TestExtractUserFromCert_NoTLS(t *testing.T) {
 	req, _ := http.NewRequest("GET", "/", nil)
 	req.TLS = nil
 
-	user := ExtractUserFromCert(req)
+	user := ExtractUserFromCert(req, IdentityCN)
 	if user != nil {
 		t.Error("expected nil user when TLS is nil")
 	}
@@ -65,7 +69,7 @@ func TestExtractUserFromCert_NoCerts(t *testing.T) {
 		PeerCertificates: nil,
 	}
 
-	user := ExtractUserFromCert(req)
+	user := ExtractUserFromCert(req, IdentityCN)
 	if user != nil {
 		t.Error("expected nil user when no peer certificates")
 	}
@@ -79,7 +83,7 @@ func TestExtractUserFromCert_ValidCert(t *testing.T) {
 		PeerCertificates: []*x509.Certificate{cert},
 	}
 
-	user := ExtractUserFromCert(req)
+	user := ExtractUserFromCert(req, IdentityCN)
 	if user == nil {
 		t.Fatal("expected user from certificate")
 	}
@@ -104,7 +108,7 @@ func TestExtractUserFromTLSState_Valid(t *testing.T) {
 		PeerCertificates: []*x509.Certificate{cert},
 	}
 
-	user := ExtractUserFromTLSState(state)
+	user := ExtractUserFromTLSState(state, IdentityCN)
 	if user == nil {
 		t.Fatal("expected user from TLS state")
 	}
@@ -115,7 +119,7 @@ func TestExtractUserFromTLSState_Valid(t *testing.T) {
 }
 
 func TestExtractUserFromTLSState_Nil(t *testing.T) {
-	user := ExtractUserFromTLSState(nil)
+	user := ExtractUserFromTLSState(nil, IdentityCN)
 	if user != nil {
 		t.Error("expected nil user for nil TLS state")
 	}
@@ -168,3 +172,114 @@ func generateTestCert(t *testing.T, cn, org string) *x509.Certificate {
 
 	return cert
 }
+
+func TestParseIdentityField(t *testing.T) {
+	for in, want := range map[string]IdentityField{"": IdentityCN, "cn": IdentityCN, "email": IdentityEmail, "uri-san": IdentityURISAN} {
+		if got, err := ParseIdentityField(in); err != nil || got != want {
+			t.Errorf("ParseIdentityField(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseIdentityField("ou"); err == nil {
+		t.Error("expected error for unknown field")
+	}
+}
+
+func TestExtractUserFromCert_IdentityField(t *testing.T) {
+	uri, _ := url.Parse("spiffe://example.org/user/alice")
+	cert := generateTestCertWithSANs(t, "alice-laptop", []string{"alice@example.org", "a@example.org"}, []*url.URL{uri})
+	bare := generateTestCert(t, "bob", "TestOrg")
+
+	tests := []struct {
+		field IdentityField
+		cert  *x509.Certificate
+		want  string // Empty means no user
+	}{
+		{IdentityCN, cert, "alice-laptop"},
+		{"", cert, "alice-laptop"},
+		{IdentityEmail, cert, "alice@example.org"},
+		{IdentityURISAN, cert, "spiffe://example.org/user/alice"},
+		{IdentityEmail, bare, ""},
+		{IdentityURISAN, bare, ""},
+	}
+	for _, tc := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tc.cert}}
+		user := ExtractUserFromCert(req, tc.field)
+		switch {
+		case tc.want == "" && user != nil:
+			t.Errorf("%s from %s: got user %q, want none", tc.field, tc.cert.Subject.CommonName, user.CN)
+		case tc.want != "" && (user == nil || user.CN != tc.want):
+			t.Errorf("%s from %s: got %+v, want CN %q", tc.field, tc.cert.Subject.CommonName, user, tc.want)
+		}
+	}
+}
+
+func TestMiddleware_IdentityField(t *testing.T) {
+	cert := generateTestCertWithSANs(t, "alice-laptop", []string{"alice@example.org"}, nil)
+	bare := generateTestCert(t, "bob", "TestOrg")
+
+	var logBuf bytes.Buffer
+	cfg := MiddlewareConfig{
+		AuthEnabled:   true,
+		Secret:        []byte("test-secret-32-bytes-long-key!!"),
+		Logger:        NewSecurityLogger(&logBuf),
+		IdentityField: IdentityEmail,
+	}
+	var got string
+	handler := Middleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = GetUser(r.Context()).CN
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || got != "alice@example.org" {
+		t.Errorf("status = %d, CN = %q, want 200 as alice@example.org", rec.Code, got)
+	}
+
+	// A certificate without the field is rejected, not treated as anonymous
+	logBuf.Reset()
+	req = httptest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{bare}}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("cert without email: status = %d, want 401", rec.Code)
+	}
+	var event SecurityEvent
+	json.Unmarshal(logBuf.Bytes(), &event)
+	if event.Reason != "cert_no_identity" {
+		t.Errorf("logged event = %+v, want cert_no_identity", event)
+	}
+}
+
+// generateTestCertWithSANs creates a self-signed certificate carrying
+// email and URI subject alternative names.
+func generateTestCertWithSANs(t *testing.T, cn string, emails []string, uris []*url.URL) *x509.Certificate {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		Subject:        pkix.Name{CommonName: cn},
+		EmailAddresses: emails,
+		URIs:           uris,
+		NotBefore:      time.Now().Add(-1 * time.Hour),
+		NotAfter:       time.Now().Add(1 * time.Hour),
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return cert
+}
@@ -1,7 +1,10 @@
 package auth
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"regexp"
@@ -17,15 +20,23 @@ type SecurityEvent struct {
 	AuthMethod string `json:"method,omitempty"`
 	TokenID    string `json:"token_id,omitempty"`
 	SourceIP   string `json:"ip,omitempty"`
+	RequestID  string `json:"request_id,omitempty"`
 	Reason     string `json:"reason,omitempty"`
 	Details    string `json:"details,omitempty"`
 }
 
 // FileSecurityLogger writes security events to a file in JSON Lines format.
+// When MaxSizeBytes is set, a write that would grow the file past it first
+// rotates security.log to security.log.1, shifting older backups up to
+// MaxBackups.
 type FileSecurityLogger struct {
 	mu     sync.Mutex
 	writer io.Writer
 	file   *os.File
+
+	MaxSizeBytes int64 // Rotate before exceeding this size; 0 disables rotation
+	MaxBackups   int   // Rotated files kept (security.log.1 .. .N); minimum 1
+	size         int64 // Bytes in the current file, tracked to avoid a stat per write
 }
 
 // secretPattern matches strings that might be secrets (long base64, hex strings).
@@ -33,11 +44,37 @@ var secretPattern = regexp.MustCompile(`(eyJ[A-Za-z0-9_-]{20,}|[A-Fa-f0-9]{32,}|
 
 // NewFileSecurityLogger creates a new logger that writes to the given file path.
 func NewFileSecurityLogger(path string) (*FileSecurityLogger, error) {
-	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	f, size, err := openLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSecurityLogger{file: f, writer: f, size: size}, nil
+}
+
+// NewRotatingFileSecurityLogger creates a file logger that rotates once the
+// file would exceed maxSize bytes, keeping maxBackups old files.
+func NewRotatingFileSecurityLogger(path string, maxSize int64, maxBackups int) (*FileSecurityLogger, error) {
+	l, err := NewFileSecurityLogger(path)
 	if err != nil {
 		return nil, err
 	}
-	return &FileSecurityLogger{file: f, writer: f}, nil
+	l.MaxSizeBytes = maxSize
+	l.MaxBackups = maxBackups
+	return l, nil
+}
+
+// openLogFile opens path for appending and returns its current size.
+func openLogFile(path string) (*os.File, int64, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
 }
 
 // NewSecurityLogger creates a logger that writes to an io.Writer.
@@ -54,45 +91,93 @@ func (l *FileSecurityLogger) Close() error {
 	return nil
 }
 
-// LogAuthSuccess logs a successful authentication event.
-func (l *FileSecurityLogger) LogAuthSuccess(user *UserContext, sourceIP string) {
+// LogAuthSuccess logs a successful authentication event. Tokens read from
+// the query string are logged with method "token_query".
+func (l *FileSecurityLogger) LogAuthSuccess(ctx context.Context, user *UserContext, sourceIP string) {
+	method := user.AuthMethod
+	if user.TokenInQuery {
+		method = "token_query"
+	}
 	l.log(SecurityEvent{
 		Event:      "auth_success",
 		UserCN:     user.CN,
-		AuthMethod: user.AuthMethod,
+		AuthMethod: method,
 		TokenID:    user.TokenID,
 		SourceIP:   sourceIP,
+		RequestID:  RequestID(ctx),
 	})
 }
 
 // LogAuthFailure logs a failed authentication attempt.
-func (l *FileSecurityLogger) LogAuthFailure(reason, details, sourceIP string) {
+func (l *FileSecurityLogger) LogAuthFailure(ctx context.Context, reason, details, sourceIP string) {
 	l.log(SecurityEvent{
-		Event:    "auth_failure",
-		Reason:   reason,
-		Details:  sanitize(details),
-		SourceIP: sourceIP,
+		Event:     "auth_failure",
+		Reason:    reason,
+		Details:   sanitize(details),
+		SourceIP:  sourceIP,
+		RequestID: RequestID(ctx),
 	})
 }
 
 // LogTokenCreated logs when a new API token is created.
-func (l *FileSecurityLogger) LogTokenCreated(userCN, tokenID, tokenName, expiresAt, sourceIP string) {
+func (l *FileSecurityLogger) LogTokenCreated(ctx context.Context, userCN, tokenID, tokenName, expiresAt, sourceIP string) {
 	l.log(SecurityEvent{
-		Event:    "token_created",
-		UserCN:   userCN,
-		TokenID:  tokenID,
-		Details:  "name=" + sanitize(tokenName) + ", expires=" + expiresAt,
-		SourceIP: sourceIP,
+		Event:     "token_created",
+		UserCN:    userCN,
+		TokenID:   tokenID,
+		Details:   "name=" + sanitize(tokenName) + ", expires=" + expiresAt,
+		SourceIP:  sourceIP,
+		RequestID: RequestID(ctx),
 	})
 }
 
 // LogTokenRevoked logs when a token is deleted/revoked.
-func (l *FileSecurityLogger) LogTokenRevoked(userCN, tokenID, sourceIP string) {
+func (l *FileSecurityLogger) LogTokenRevoked(ctx context.Context, userCN, tokenID, sourceIP string) {
 	l.log(SecurityEvent{
-		Event:    "token_revoked",
-		UserCN:   userCN,
-		TokenID:  tokenID,
-		SourceIP: sourceIP,
+		Event:     "token_revoked",
+		UserCN:    userCN,
+		TokenID:   tokenID,
+		SourceIP:  sourceIP,
+		RequestID: RequestID(ctx),
+	})
+}
+
+// LogTokensRevokedAll logs a user revoking all of their tokens at once.
+// When the request itself used a token, its ID is recorded and the reason
+// flags it, since a leaked token can be used to do this.
+func (l *FileSecurityLogger) LogTokensRevokedAll(ctx context.Context, user *UserContext, count int, sourceIP string) {
+	event := SecurityEvent{
+		Event:      "tokens_revoked_all",
+		UserCN:     user.CN,
+		AuthMethod: user.AuthMethod,
+		TokenID:    user.TokenID,
+		Details:    fmt.Sprintf("count=%d", count),
+		SourceIP:   sourceIP,
+		RequestID:  RequestID(ctx),
+	}
+	if user.AuthMethod == "token" {
+		event.Reason = "revoked_via_token"
+	}
+	l.log(event)
+}
+
+// LogTokenUpdated logs when a token's name is changed.
+func (l *FileSecurityLogger) LogTokenUpdated(ctx context.Context, userCN, tokenID, tokenName, sourceIP string) {
+	l.log(SecurityEvent{
+		Event:     "token_updated",
+		UserCN:    userCN,
+		TokenID:   tokenID,
+		Details:   "name=" + sanitize(tokenName),
+		SourceIP:  sourceIP,
+		RequestID: RequestID(ctx),
+	})
+}
+
+// LogTokensPurged logs a cleanup pass that deleted expired tokens.
+func (l *FileSecurityLogger) LogTokensPurged(count int) {
+	l.log(SecurityEvent{
+		Event:   "tokens_purged",
+		Details: fmt.Sprintf("count=%d", count),
 	})
 }
 
@@ -119,10 +204,50 @@ func (l *FileSecurityLogger) LogServerStop(reason string) {
 func (l *FileSecurityLogger) log(event SecurityEvent) {
 	event.Timestamp = time.Now().UTC().Format(time.RFC3339)
 
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(event); err != nil {
+		return
+	}
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	json.NewEncoder(l.writer).Encode(event)
+	if l.file != nil && l.MaxSizeBytes > 0 && l.size > 0 && l.size+int64(buf.Len()) > l.MaxSizeBytes {
+		// Best effort, like the write itself: a failed rotation must not drop the event
+		l.rotate()
+	}
+
+	n, _ := l.writer.Write(buf.Bytes())
+	l.size += int64(n)
+}
+
+// rotate shifts security.log.N-1 -> .N, ..., security.log -> .1 and opens
+// a fresh file. Caller holds mu.
+func (l *FileSecurityLogger) rotate() error {
+	path := l.file.Name()
+	backups := l.MaxBackups
+	if backups < 1 {
+		backups = 1
+	}
+
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", path, backups))
+	for i := backups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	renameErr := os.Rename(path, path+".1")
+
+	f, size, err := openLogFile(path)
+	if err != nil {
+		return err
+	}
+	l.file = f
+	l.writer = f
+	l.size = size
+	return renameErr
 }
 
 // sanitize removes potential secrets and truncates long strings.
@@ -131,32 +256,37 @@ func sanitize(s string) string {
 	if len(s) > 200 {
 		s = s[:200] + "..."
 	}
-	// Mask potential secrets
-	s = secretPattern.ReplaceAllString(s, "[REDACTED]")
-	return s
+	return RedactSecrets(s)
+}
+
+// RedactSecrets masks strings that look like tokens or keys (long base64
+// or hex runs) with [REDACTED], for text headed to a log.
+func RedactSecrets(s string) string {
+	return secretPattern.ReplaceAllString(s, "[REDACTED]")
 }
 
 // Reopen reopens the log file (for log rotation via SIGHUP).
 func (l *FileSecurityLogger) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
 	if l.file == nil {
 		return nil
 	}
 
 	path := l.file.Name()
 
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
 	if err := l.file.Close(); err != nil {
 		return err
 	}
 
-	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	f, size, err := openLogFile(path)
 	if err != nil {
 		return err
 	}
 
 	l.file = f
 	l.writer = f
+	l.size = size
 	return nil
 }
@@ -2,7 +2,10 @@ package auth
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -16,7 +19,7 @@ func TestSecurityLogger_AuthSuccess(t *testing.T) {
 		AuthMethod: "cert",
 	}
 
-	logger.LogAuthSuccess(user, "192.168.1.1")
+	logger.LogAuthSuccess(context.Background(), user, "192.168.1.1")
 
 	output := buf.String()
 	if output == "" {
@@ -53,7 +56,7 @@ func TestSecurityLogger_AuthFailure(t *testing.T) {
 	var buf bytes.Buffer
 	logger := NewSecurityLogger(&buf)
 
-	logger.LogAuthFailure("invalid_token", "token expired", "192.168.1.2")
+	logger.LogAuthFailure(context.Background(), "invalid_token", "token expired", "192.168.1.2")
 
 	output := buf.String()
 	var event SecurityEvent
@@ -74,7 +77,7 @@ func TestSecurityLogger_TokenCreated(t *testing.T) {
 	var buf bytes.Buffer
 	logger := NewSecurityLogger(&buf)
 
-	logger.LogTokenCreated("testuser", "tok_123", "my-automation", "2025-02-01T00:00:00Z", "192.168.1.3")
+	logger.LogTokenCreated(context.Background(), "testuser", "tok_123", "my-automation", "2025-02-01T00:00:00Z", "192.168.1.3")
 
 	output := buf.String()
 	var event SecurityEvent
@@ -91,11 +94,46 @@ func TestSecurityLogger_TokenCreated(t *testing.T) {
 	}
 }
 
+func TestSecurityLogger_TokenUpdated(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewSecurityLogger(&buf)
+
+	logger.LogTokenUpdated(context.Background(), "testuser", "tok_123", "renamed", "192.168.1.5")
+
+	var event SecurityEvent
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatalf("failed to parse log output: %v", err)
+	}
+
+	if event.Event != "token_updated" {
+		t.Errorf("expected event 'token_updated', got %q", event.Event)
+	}
+	if event.TokenID != "tok_123" || event.Details != "name=renamed" {
+		t.Errorf("token_id = %q, details = %q", event.TokenID, event.Details)
+	}
+}
+
+func TestSecurityLogger_TokensPurged(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewSecurityLogger(&buf)
+
+	logger.LogTokensPurged(3)
+
+	var event SecurityEvent
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatalf("failed to parse log output: %v", err)
+	}
+
+	if event.Event != "tokens_purged" || event.Details != "count=3" {
+		t.Errorf("event = %q, details = %q", event.Event, event.Details)
+	}
+}
+
 func TestSecurityLogger_TokenRevoked(t *testing.T) {
 	var buf bytes.Buffer
 	logger := NewSecurityLogger(&buf)
 
-	logger.LogTokenRevoked("testuser", "tok_123", "192.168.1.4")
+	logger.LogTokenRevoked(context.Background(), "testuser", "tok_123", "192.168.1.4")
 
 	output := buf.String()
 	var event SecurityEvent
@@ -170,3 +208,113 @@ func TestSanitize_PreservesNormalStrings(t *testing.T) {
 		t.Errorf("expected normal string to be preserved, got %q", sanitized)
 	}
 }
+
+func TestSecurityLogger_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "security.log")
+	logger, err := NewFileSecurityLogger(path)
+	if err != nil {
+		t.Fatalf("NewFileSecurityLogger: %v", err)
+	}
+	defer logger.Close()
+
+	logger.LogServerStop("before")
+
+	// Simulate logrotate moving the file away, then SIGHUP
+	rotated := path + ".1"
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if err := logger.Reopen(); err != nil {
+		t.Fatalf("Reopen: %v", err)
+	}
+
+	logger.LogServerStop("after")
+
+	old, _ := os.ReadFile(rotated)
+	if !strings.Contains(string(old), "before") || strings.Contains(string(old), "after") {
+		t.Errorf("rotated file = %q, want only the first line", old)
+	}
+	current, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("recreated file: %v", err)
+	}
+	if !strings.Contains(string(current), "after") || strings.Contains(string(current), "before") {
+		t.Errorf("recreated file = %q, want only the second line", current)
+	}
+}
+
+func TestSecurityLogger_ReopenWithoutFile(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewSecurityLogger(&buf)
+
+	if err := logger.Reopen(); err != nil {
+		t.Errorf("Reopen on writer logger: %v", err)
+	}
+}
+
+func TestSecurityLogger_RotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "security.log")
+	logger, err := NewRotatingFileSecurityLogger(path, 200, 2)
+	if err != nil {
+		t.Fatalf("NewRotatingFileSecurityLogger: %v", err)
+	}
+	defer logger.Close()
+
+	// Each event is roughly 80 bytes, so two fit per file
+	for i := 0; i < 8; i++ {
+		logger.LogServerStop(strings.Repeat("x", 20))
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("stat %s: %v", name, err)
+		}
+		if info.Size() > 200 {
+			t.Errorf("%s size = %d, want <= 200", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected at most 2 backups, found %s.3", path)
+	}
+}
+
+func TestSecurityLogger_RotationTracksExistingSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "security.log")
+	if err := os.WriteFile(path, []byte(strings.Repeat("x", 150)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	logger, err := NewRotatingFileSecurityLogger(path, 200, 1)
+	if err != nil {
+		t.Fatalf("NewRotatingFileSecurityLogger: %v", err)
+	}
+	defer logger.Close()
+
+	logger.LogServerStop("rotate-me")
+
+	old, _ := os.ReadFile(path + ".1")
+	if len(old) != 151 {
+		t.Errorf("backup size = %d, want the pre-existing 151 bytes", len(old))
+	}
+	current, _ := os.ReadFile(path)
+	if !strings.Contains(string(current), "rotate-me") {
+		t.Errorf("current file = %q, want the new event", current)
+	}
+}
+
+func TestSecurityLogger_NoRotationByDefault(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "security.log")
+	logger, err := NewFileSecurityLogger(path)
+	if err != nil {
+		t.Fatalf("NewFileSecurityLogger: %v", err)
+	}
+	defer logger.Close()
+
+	for i := 0; i < 20; i++ {
+		logger.LogServerStop("no-rotation")
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("unexpected backup file without MaxSizeBytes")
+	}
+}
@@ -1,31 +1,100 @@
 package auth
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/alanp/cue/internal/clock"
 )
 
 // TokenValidator is called to validate a token and check if it's revoked.
+// sourceIP is the client address without port, for last-use tracking.
 // Returns the token ID if the token is valid, or an error if revoked/invalid.
-type TokenValidator func(token string) (tokenID string, err error)
+type TokenValidator func(token, sourceIP string) (tokenID string, err error)
 
 // SecurityLogger logs authentication events.
 type SecurityLogger interface {
-	LogAuthSuccess(user *UserContext, sourceIP string)
-	LogAuthFailure(reason, details, sourceIP string)
+	LogAuthSuccess(ctx context.Context, user *UserContext, sourceIP string)
+	LogAuthFailure(ctx context.Context, reason, details, sourceIP string)
 }
 
 // MiddlewareConfig configures the authentication middleware.
 type MiddlewareConfig struct {
-	Secret         []byte         // HMAC secret for token validation
-	TokenValidator TokenValidator // Optional: validates token against DB (revocation check)
-	Logger         SecurityLogger // Optional: logs auth events
-	AuthEnabled    bool           // If false, all requests get single-user context
-	TrustProxy     bool           // If true, trust X-Forwarded-For/X-Real-IP headers
+	Secret         []byte             // HMAC secret for token validation
+	TokenValidator TokenValidator     // Optional: validates token against DB (revocation check)
+	Logger         SecurityLogger     // Optional: logs auth events
+	AuthEnabled    bool               // If false, all requests get single-user context
+	TrustProxy     bool               // If true, trust X-Forwarded-For/X-Real-IP headers
+	Revocation     *RevocationChecker // Optional: rejects certificates listed in a CRL
+	IdentityField  IdentityField      // Certificate field used as the user's CN; empty means IdentityCN
+	Allowlist      *CNAllowlist       // Optional: only these CNs may use certificate auth
+
+	// QueryTokenPaths lists request paths that also accept a token in the
+	// access_token query parameter, for clients such as feed readers that
+	// cannot send an Authorization header. The header wins when both are
+	// present.
+	QueryTokenPaths []string
+
+	// AllowQueryToken accepts access_token on every path, for image tags
+	// and download links. Tokens in URLs end up in browser history and
+	// proxy logs, so this is off by default.
+	AllowQueryToken bool
+
+	// TokenExpiryWarning is how close to expiry a token must be before
+	// responses carry X-Token-Expires-In and Warning headers (default
+	// DefaultTokenExpiryWarning; negative disables).
+	TokenExpiryWarning time.Duration
+
+	// TokenLeeway is the clock skew tolerated when checking a token's IAT
+	// and EXP (default DefaultTokenLeeway; negative disables).
+	TokenLeeway time.Duration
+
+	// Clock checks token expiry; nil means clock.Real.
+	Clock clock.Clock
+}
+
+// clock returns cfg.Clock, or clock.Real if it is unset.
+func (cfg MiddlewareConfig) clock() clock.Clock {
+	if cfg.Clock == nil {
+		return clock.Real
+	}
+	return cfg.Clock
+}
+
+// tokenLeeway returns the leeway cfg.TokenLeeway selects.
+func (cfg MiddlewareConfig) tokenLeeway() time.Duration {
+	return EffectiveTokenLeeway(cfg.TokenLeeway)
 }
 
+// EffectiveTokenLeeway returns the leeway a MiddlewareConfig.TokenLeeway of
+// d means: DefaultTokenLeeway for zero and none for negative values. A
+// TokenValidator that checks expiry should allow the same leeway.
+func EffectiveTokenLeeway(d time.Duration) time.Duration {
+	switch {
+	case d == 0:
+		return DefaultTokenLeeway
+	case d < 0:
+		return 0
+	}
+	return d
+}
+
+// DefaultTokenExpiryWarning is the default MiddlewareConfig.TokenExpiryWarning.
+const DefaultTokenExpiryWarning = 72 * time.Hour
+
+// DefaultTokenLeeway is the default MiddlewareConfig.TokenLeeway.
+const DefaultTokenLeeway = 60 * time.Second
+
 // Middleware creates HTTP middleware that authenticates requests.
-// It first checks for a valid client certificate, then falls back to Bearer token.
+// It first checks for a valid client certificate, then falls back to Bearer
+// token, and then (with AllowQueryToken, or on QueryTokenPaths) to an
+// access_token query parameter.
 func Middleware(cfg MiddlewareConfig) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -39,9 +108,14 @@ func Middleware(cfg MiddlewareConfig) func(http.Handler) http.Handler {
 			}
 
 			// Check client certificate first (highest trust)
-			if user := ExtractUserFromCert(r); user != nil {
+			user, err := extractCertUser(r, cfg)
+			if err != nil {
+				certRejected(w, cfg, r, sourceIP, user, err)
+				return
+			}
+			if user != nil {
 				if cfg.Logger != nil {
-					cfg.Logger.LogAuthSuccess(user, sourceIP)
+					cfg.Logger.LogAuthSuccess(r.Context(), user, sourceIP)
 				}
 				ctx := WithUser(r.Context(), user)
 				w.Header().Set("X-Auth-User", user.CN)
@@ -51,14 +125,12 @@ func Middleware(cfg MiddlewareConfig) func(http.Handler) http.Handler {
 			}
 
 			// Fall back to Bearer token
-			auth := r.Header.Get("Authorization")
-			if strings.HasPrefix(auth, "Bearer ") {
-				tokenStr := strings.TrimPrefix(auth, "Bearer ")
-
-				claims, err := ValidateToken(tokenStr, cfg.Secret)
+			tokenStr, inQuery := bearerToken(r, cfg)
+			if tokenStr != "" {
+				claims, err := ValidateTokenWithLeeway(cfg.clock(), tokenStr, cfg.Secret, cfg.tokenLeeway())
 				if err != nil {
 					if cfg.Logger != nil {
-						cfg.Logger.LogAuthFailure("invalid_token", err.Error(), sourceIP)
+						cfg.Logger.LogAuthFailure(r.Context(), "invalid_token", tokenFailureDetails(err, inQuery), sourceIP)
 					}
 					http.Error(w, "Unauthorized", http.StatusUnauthorized)
 					return
@@ -67,10 +139,14 @@ func Middleware(cfg MiddlewareConfig) func(http.Handler) http.Handler {
 				// Check revocation if validator is provided
 				var tokenID string
 				if cfg.TokenValidator != nil {
-					tokenID, err = cfg.TokenValidator(tokenStr)
+					ip := sourceIP
+					if host, _, err := net.SplitHostPort(ip); err == nil {
+						ip = host
+					}
+					tokenID, err = cfg.TokenValidator(tokenStr, ip)
 					if err != nil {
 						if cfg.Logger != nil {
-							cfg.Logger.LogAuthFailure("token_revoked", err.Error(), sourceIP)
+							cfg.Logger.LogAuthFailure(r.Context(), "token_revoked", tokenFailureDetails(err, inQuery), sourceIP)
 						}
 						http.Error(w, "Unauthorized", http.StatusUnauthorized)
 						return
@@ -81,28 +157,57 @@ func Middleware(cfg MiddlewareConfig) func(http.Handler) http.Handler {
 					CN:         claims.CN,
 					AuthMethod: "token",
 					TokenID:    tokenID,
+					Scopes:     claims.Scopes,
+
+					TokenInQuery:   inQuery,
+					TokenExpiresAt: time.Unix(claims.EXP, 0).UTC(),
 				}
 
 				if cfg.Logger != nil {
-					cfg.Logger.LogAuthSuccess(user, sourceIP)
+					cfg.Logger.LogAuthSuccess(r.Context(), user, sourceIP)
 				}
 
 				ctx := WithUser(r.Context(), user)
 				w.Header().Set("X-Auth-User", user.CN)
 				w.Header().Set("X-Auth-Method", "token")
+				warnTokenExpiry(w, cfg, user.TokenExpiresAt)
 				next.ServeHTTP(w, r.WithContext(ctx))
 				return
 			}
 
 			// No valid authentication
 			if cfg.Logger != nil {
-				cfg.Logger.LogAuthFailure("no_credentials", "", sourceIP)
+				cfg.Logger.LogAuthFailure(r.Context(), "no_credentials", "", sourceIP)
 			}
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		})
 	}
 }
 
+// bearerToken returns the request's token from the Authorization header or,
+// where cfg allows it, the access_token query parameter, reporting whether
+// it came from the query.
+func bearerToken(r *http.Request, cfg MiddlewareConfig) (token string, inQuery bool) {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer "), false
+	}
+	if cfg.AllowQueryToken || slices.Contains(cfg.QueryTokenPaths, r.URL.Path) {
+		if token := r.URL.Query().Get("access_token"); token != "" {
+			return token, true
+		}
+	}
+	return "", false
+}
+
+// tokenFailureDetails describes a rejected token for the security log,
+// noting when it was sent as a query parameter.
+func tokenFailureDetails(err error, inQuery bool) string {
+	if inQuery {
+		return err.Error() + " (access_token query parameter)"
+	}
+	return err.Error()
+}
+
 // RequireCertAuth creates middleware that requires client certificate authentication.
 // Token authentication is not accepted. Use for sensitive operations like token creation.
 func RequireCertAuth(cfg MiddlewareConfig) func(http.Handler) http.Handler {
@@ -118,9 +223,14 @@ func RequireCertAuth(cfg MiddlewareConfig) func(http.Handler) http.Handler {
 			}
 
 			// Only accept client certificate
-			if user := ExtractUserFromCert(r); user != nil {
+			user, err := extractCertUser(r, cfg)
+			if err != nil {
+				certRejected(w, cfg, r, sourceIP, user, err)
+				return
+			}
+			if user != nil {
 				if cfg.Logger != nil {
-					cfg.Logger.LogAuthSuccess(user, sourceIP)
+					cfg.Logger.LogAuthSuccess(r.Context(), user, sourceIP)
 				}
 				ctx := WithUser(r.Context(), user)
 				w.Header().Set("X-Auth-User", user.CN)
@@ -130,13 +240,36 @@ func RequireCertAuth(cfg MiddlewareConfig) func(http.Handler) http.Handler {
 			}
 
 			if cfg.Logger != nil {
-				cfg.Logger.LogAuthFailure("cert_required", "token auth not accepted", sourceIP)
+				cfg.Logger.LogAuthFailure(r.Context(), "cert_required", "token auth not accepted", sourceIP)
 			}
 			http.Error(w, "Client certificate required", http.StatusUnauthorized)
 		})
 	}
 }
 
+// certRejected logs and rejects a request presenting a certificate that
+// extractCertUser refused: revoked or lacking the identity field (401), or
+// naming a user missing from the allowlist (403).
+func certRejected(w http.ResponseWriter, cfg MiddlewareConfig, r *http.Request, sourceIP string, user *UserContext, err error) {
+	if err == errCNNotAllowed {
+		if cfg.Logger != nil {
+			cfg.Logger.LogAuthFailure(r.Context(), "cn_not_allowed", "cn="+user.CN, sourceIP)
+		}
+		http.Error(w, "Forbidden: certificate not in allowlist", http.StatusForbidden)
+		return
+	}
+	if cfg.Logger != nil {
+		reason := "cert_revoked"
+		details := "cn=" + r.TLS.PeerCertificates[0].Subject.CommonName
+		if err == errCertNoIdentity {
+			reason = "cert_no_identity"
+			details += " field=" + string(cfg.IdentityField)
+		}
+		cfg.Logger.LogAuthFailure(r.Context(), reason, details, sourceIP)
+	}
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
 // ExtractSourceIP gets the client IP from the request.
 // If trustProxy is true, X-Forwarded-For and X-Real-IP headers are trusted.
 // If trustProxy is false, only r.RemoteAddr is used (prevents IP spoofing).
@@ -158,3 +291,20 @@ func ExtractSourceIP(r *http.Request, trustProxy bool) string {
 	// Fall back to RemoteAddr (always safe, can't be spoofed)
 	return r.RemoteAddr
 }
+
+// warnTokenExpiry sets X-Token-Expires-In (whole seconds remaining) and a
+// Warning header when the token expires within the configured window, so
+// clients can rotate before it stops working.
+func warnTokenExpiry(w http.ResponseWriter, cfg MiddlewareConfig, expiresAt time.Time) {
+	window := cfg.TokenExpiryWarning
+	if window == 0 {
+		window = DefaultTokenExpiryWarning
+	}
+	remaining := expiresAt.Sub(cfg.clock().Now())
+	if window < 0 || remaining > window {
+		return
+	}
+	remaining = max(remaining, 0).Truncate(time.Second)
+	w.Header().Set("X-Token-Expires-In", strconv.FormatInt(int64(remaining.Seconds()), 10))
+	w.Header().Set("Warning", fmt.Sprintf(`299 cue "API token expires in %s"`, remaining))
+}
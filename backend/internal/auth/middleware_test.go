@@ -7,11 +7,19 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"errors"
 	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/alanp/cue/internal/clock"
+	"github.com/alanp/cue/internal/store"
 )
 
 func TestMiddleware_AuthDisabled(t *testing.T) {
@@ -88,7 +96,7 @@ func TestMiddleware_ValidCert(t *testing.T) {
 func TestMiddleware_ValidToken(t *testing.T) {
 	secret := []byte("test-secret-32-bytes-long-key!!")
 
-	token, _, err := GenerateToken("tokenuser", 1*time.Hour, secret)
+	token, _, err := GenerateToken("tokenuser", 1*time.Hour, secret, nil)
 	if err != nil {
 		t.Fatalf("GenerateToken failed: %v", err)
 	}
@@ -158,7 +166,7 @@ func TestMiddleware_InvalidToken(t *testing.T) {
 func TestMiddleware_ExpiredToken(t *testing.T) {
 	secret := []byte("test-secret-32-bytes-long-key!!")
 
-	token, _, err := GenerateToken("tokenuser", -1*time.Hour, secret)
+	token, _, err := GenerateToken("tokenuser", -1*time.Hour, secret, nil)
 	if err != nil {
 		t.Fatalf("GenerateToken failed: %v", err)
 	}
@@ -183,6 +191,97 @@ func TestMiddleware_ExpiredToken(t *testing.T) {
 	}
 }
 
+func TestMiddleware_TokenLeeway(t *testing.T) {
+	secret := []byte("test-secret-32-bytes-long-key!!")
+	issued := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	token, expiresAt, err := GenerateTokenWithClock(clock.NewFake(issued), "tokenuser", time.Hour, secret, nil)
+	if err != nil {
+		t.Fatalf("GenerateTokenWithClock: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		leeway time.Duration
+		at     time.Time
+		want   int
+	}{
+		{"default leeway", 0, expiresAt.Add(DefaultTokenLeeway), http.StatusOK},
+		{"past default leeway", 0, expiresAt.Add(DefaultTokenLeeway + time.Second), http.StatusUnauthorized},
+		{"custom leeway", 5 * time.Minute, expiresAt.Add(4 * time.Minute), http.StatusOK},
+		{"disabled", -1, expiresAt.Add(time.Second), http.StatusUnauthorized},
+		{"issued too far ahead", 0, issued.Add(-2 * DefaultTokenLeeway), http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := MiddlewareConfig{AuthEnabled: true, Secret: secret, TokenLeeway: tc.leeway, Clock: clock.NewFake(tc.at)}
+			handler := Middleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.want {
+				t.Errorf("status = %d, want %d", rec.Code, tc.want)
+			}
+		})
+	}
+}
+
+func TestMiddleware_TokenLeewayStoreValidator(t *testing.T) {
+	secret := []byte("test-secret-32-bytes-long-key!!")
+	issued := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	s, err := store.New(filepath.Join(t.TempDir(), "cue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	token, expiresAt, err := GenerateTokenWithClock(clock.NewFake(issued), "tokenuser", time.Hour, secret, nil)
+	if err != nil {
+		t.Fatalf("GenerateTokenWithClock: %v", err)
+	}
+	if err := s.CreateToken("tok_skew", "tokenuser", "skew", HashToken(token), expiresAt, nil); err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want int
+	}{
+		{"before expiry", expiresAt.Add(-time.Minute), http.StatusOK},
+		{"inside leeway", expiresAt.Add(DefaultTokenLeeway), http.StatusOK},
+		{"outside leeway", expiresAt.Add(DefaultTokenLeeway + time.Second), http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := clock.NewFake(tc.at)
+			s.SetClock(c)
+			cfg := MiddlewareConfig{AuthEnabled: true, Secret: secret, Clock: c}
+			cfg.TokenValidator = func(token, sourceIP string) (string, error) {
+				return s.ValidateTokenHashWithLeeway(HashToken(token), sourceIP, EffectiveTokenLeeway(cfg.TokenLeeway))
+			}
+			handler := Middleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.want {
+				t.Errorf("status = %d, want %d", rec.Code, tc.want)
+			}
+		})
+	}
+}
+
 func TestMiddleware_NoAuth(t *testing.T) {
 	cfg := MiddlewareConfig{
 		AuthEnabled: true,
@@ -206,13 +305,13 @@ func TestMiddleware_NoAuth(t *testing.T) {
 func TestMiddleware_TokenValidator(t *testing.T) {
 	secret := []byte("test-secret-32-bytes-long-key!!")
 
-	token, _, err := GenerateToken("tokenuser", 1*time.Hour, secret)
+	token, _, err := GenerateToken("tokenuser", 1*time.Hour, secret, nil)
 	if err != nil {
 		t.Fatalf("GenerateToken failed: %v", err)
 	}
 
 	// Token validator that rejects the token (simulates revocation)
-	validator := func(tok string) (string, error) {
+	validator := func(tok, sourceIP string) (string, error) {
 		return "", ErrTokenRevoked
 	}
 
@@ -290,7 +389,7 @@ func TestRequireCertAuth_AuthDisabled(t *testing.T) {
 func TestRequireCertAuth_RejectsToken(t *testing.T) {
 	secret := []byte("test-secret-32-bytes-long-key!!")
 
-	token, _, err := GenerateToken("tokenuser", 1*time.Hour, secret)
+	token, _, err := GenerateToken("tokenuser", 1*time.Hour, secret, nil)
 	if err != nil {
 		t.Fatalf("GenerateToken failed: %v", err)
 	}
@@ -344,3 +443,211 @@ func generateTestCertForMiddleware(t *testing.T, cn string) *x509.Certificate {
 
 	return cert
 }
+
+func TestMiddleware_TokenScopes(t *testing.T) {
+	secret := []byte("test-secret-32-bytes-long-key!!")
+	token, _, err := GenerateToken("tokenuser", 1*time.Hour, secret, []string{ScopeItemsRead})
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+
+	cfg := MiddlewareConfig{AuthEnabled: true, Secret: secret}
+	handler := Middleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := GetUser(r.Context())
+		if !user.HasScope(ScopeItemsRead) || user.HasScope(ScopeItemsWrite) {
+			t.Errorf("scopes = %v, want read only", user.Scopes)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_TokenExpiryWarning(t *testing.T) {
+	secret := []byte("test-secret-32-bytes-long-key!!")
+
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		window   time.Duration
+		wantWarn bool
+	}{
+		{"inside default window", 1 * time.Hour, 0, true},
+		{"outside default window", 30 * 24 * time.Hour, 0, false},
+		{"inside custom window", 5 * time.Hour, 6 * time.Hour, true},
+		{"outside custom window", 5 * time.Hour, 4 * time.Hour, false},
+		{"disabled", 1 * time.Hour, -1, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			token, _, err := GenerateToken("tokenuser", tc.ttl, secret, nil)
+			if err != nil {
+				t.Fatalf("GenerateToken: %v", err)
+			}
+
+			cfg := MiddlewareConfig{AuthEnabled: true, Secret: secret, TokenExpiryWarning: tc.window}
+			handler := Middleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			expiresIn := rec.Header().Get("X-Token-Expires-In")
+			warning := rec.Header().Get("Warning")
+			if !tc.wantWarn {
+				if expiresIn != "" || warning != "" {
+					t.Errorf("unexpected headers: X-Token-Expires-In = %q, Warning = %q", expiresIn, warning)
+				}
+				return
+			}
+			secs, err := strconv.Atoi(expiresIn)
+			if err != nil || secs <= 0 || secs > int(tc.ttl.Seconds()) {
+				t.Errorf("X-Token-Expires-In = %q, want seconds up to %d", expiresIn, int(tc.ttl.Seconds()))
+			}
+			if !strings.HasPrefix(warning, `299 cue "API token expires in `) {
+				t.Errorf("Warning = %q", warning)
+			}
+		})
+	}
+}
+
+func TestMiddleware_CertNoExpiryWarning(t *testing.T) {
+	cert := generateTestCertForMiddleware(t, "testuser")
+	cfg := MiddlewareConfig{AuthEnabled: true, Secret: []byte("test-secret-32-bytes-long-key!!")}
+	handler := Middleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if h := rec.Header().Get("X-Token-Expires-In"); h != "" {
+		t.Errorf("cert auth X-Token-Expires-In = %q, want none", h)
+	}
+}
+
+func TestMiddleware_QueryTokenPaths(t *testing.T) {
+	secret := []byte("test-secret-32-bytes-long-key!!")
+	token, _, err := GenerateToken("feeduser", time.Hour, secret, nil)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+
+	var logBuf bytes.Buffer
+	cfg := MiddlewareConfig{
+		AuthEnabled:     true,
+		Secret:          secret,
+		Logger:          NewSecurityLogger(&logBuf),
+		QueryTokenPaths: []string{"/api/feed.atom"},
+	}
+	handler := Middleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := GetUser(r.Context()); user == nil || user.CN != "feeduser" || user.AuthMethod != "token" || !user.TokenInQuery {
+			t.Errorf("user = %+v, want feeduser via query token", user)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("GET", "/api/feed.atom?access_token="+token, nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("listed path: expected 200, got %d", rec.Code)
+	}
+	if !strings.Contains(logBuf.String(), `"method":"token_query"`) {
+		t.Errorf("log = %s, want method token_query", logBuf.String())
+	}
+
+	// Other paths ignore the parameter
+	req = httptest.NewRequest("GET", "/api/items?access_token="+token, nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unlisted path: expected 401, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/feed.atom?access_token=not-a-token", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("invalid query token: expected 401, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_AllowQueryToken(t *testing.T) {
+	secret := []byte("test-secret-32-bytes-long-key!!")
+	queryToken, _, err := GenerateToken("queryuser", time.Hour, secret, nil)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	headerToken, _, err := GenerateToken("headeruser", time.Hour, secret, nil)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+
+	var logBuf bytes.Buffer
+	newHandler := func(allow bool, validator TokenValidator) http.Handler {
+		cfg := MiddlewareConfig{
+			AuthEnabled:     true,
+			Secret:          secret,
+			Logger:          NewSecurityLogger(&logBuf),
+			TokenValidator:  validator,
+			AllowQueryToken: allow,
+		}
+		return Middleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Test-User", GetUser(r.Context()).CN)
+			w.WriteHeader(http.StatusOK)
+		}))
+	}
+	revoked := func(token, sourceIP string) (string, error) {
+		if token == queryToken {
+			return "", errors.New("token revoked")
+		}
+		return "id", nil
+	}
+
+	tests := []struct {
+		name      string
+		allow     bool
+		validator TokenValidator
+		query     string
+		header    string
+		wantCode  int
+		wantUser  string
+		wantLog   string
+	}{
+		{"disabled by default", false, nil, queryToken, "", http.StatusUnauthorized, "", `"reason":"no_credentials"`},
+		{"valid query token", true, nil, queryToken, "", http.StatusOK, "queryuser", `"method":"token_query"`},
+		{"invalid query token", true, nil, "not-a-token", "", http.StatusUnauthorized, "", "(access_token query parameter)"},
+		{"revoked query token", true, revoked, queryToken, "", http.StatusUnauthorized, "", `"reason":"token_revoked"`},
+		{"header wins", true, nil, queryToken, headerToken, http.StatusOK, "headeruser", `"method":"token"`},
+		{"invalid header not rescued by query", true, nil, queryToken, "garbage", http.StatusUnauthorized, "", `"reason":"invalid_token"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logBuf.Reset()
+			req := httptest.NewRequest("GET", "/api/items/x?access_token="+url.QueryEscape(tt.query), nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.header)
+			}
+			rec := httptest.NewRecorder()
+			newHandler(tt.allow, tt.validator).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode || rec.Header().Get("X-Test-User") != tt.wantUser {
+				t.Errorf("status = %d, user = %q, want %d, %q", rec.Code, rec.Header().Get("X-Test-User"), tt.wantCode, tt.wantUser)
+			}
+			if !strings.Contains(logBuf.String(), tt.wantLog) {
+				t.Errorf("log = %s, want it to contain %s", logBuf.String(), tt.wantLog)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExtractUserFromCert_OUs(t *testing.T) {
+	cert := generateTestCertWithOUs(t, "alice", "ops", "admins")
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	user := ExtractUserFromCert(req, IdentityCN)
+	if user == nil {
+		t.Fatal("expected user")
+	}
+	if !user.HasOU("admins") || !user.HasOU("ops") || user.HasOU("sales") {
+		t.Errorf("OUs = %v, want [ops admins]", user.OUs)
+	}
+}
+
+func TestRequireOU(t *testing.T) {
+	cfg := MiddlewareConfig{AuthEnabled: true, Secret: []byte("test-secret-32-bytes-long-key!!")}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	handler := Middleware(cfg)(RequireOU("admins")(ok))
+
+	token, _, err := GenerateToken("alice", time.Hour, cfg.Secret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cert *x509.Certificate
+		auth string
+		want int
+	}{
+		{"cert in OU", generateTestCertWithOUs(t, "alice", "ops", "admins"), "", http.StatusOK},
+		{"cert outside OU", generateTestCertWithOUs(t, "bob", "ops"), "", http.StatusForbidden},
+		{"cert without OU", generateTestCertForMiddleware(t, "carol"), "", http.StatusForbidden},
+		{"token", nil, "Bearer " + token, http.StatusForbidden},
+		{"anonymous", nil, "", http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tc.cert != nil {
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tc.cert}}
+		}
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, rec.Code, tc.want)
+		}
+		if tc.want == http.StatusForbidden {
+			var body struct {
+				Error struct{ Code, Message string } `json:"error"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error.Code != "forbidden" {
+				t.Errorf("%s: body = %+v (%v), want JSON error with code forbidden", tc.name, body, err)
+			}
+		}
+	}
+
+	// Single-user mode and an empty OU restrict nothing
+	single := Middleware(MiddlewareConfig{})(RequireOU("admins")(ok))
+	rec := httptest.NewRecorder()
+	single.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("single-user: status = %d, want 200", rec.Code)
+	}
+	open := Middleware(cfg)(RequireOU("")(ok))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{generateTestCertForMiddleware(t, "carol")}}
+	rec = httptest.NewRecorder()
+	open.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("empty OU: status = %d, want 200", rec.Code)
+	}
+}
+
+// generateTestCertWithOUs creates a self-signed certificate whose subject
+// lists the given organizational units.
+func generateTestCertWithOUs(t *testing.T, cn string, ous ...string) *x509.Certificate {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: cn, OrganizationalUnit: ous},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return cert
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultRateIdleTimeout is how long a bucket may sit unused before it is
+// dropped from the limiter.
+const DefaultRateIdleTimeout = 10 * time.Minute
+
+// RateLimitConfig configures the rate limiting middleware.
+type RateLimitConfig struct {
+	Rate        float64       // Sustained requests per second per key; <= 0 disables limiting
+	Burst       int           // Maximum bucket size; defaults to 1 when Rate > 0
+	TrustProxy  bool          // If true, trust X-Forwarded-For/X-Real-IP headers for the IP key
+	IdleTimeout time.Duration // Buckets idle this long are removed (default DefaultRateIdleTimeout)
+	ExemptPaths []string      // Paths never limited (default: /api/health)
+
+	now func() time.Time // test hook
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	cfg       RateLimitConfig
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// RateLimit creates HTTP middleware applying a token bucket per client.
+// Clients are keyed by authenticated CN when a user is in the request
+// context, otherwise by source IP. Requests over the limit get 429 with a
+// Retry-After header. It must run inside Middleware to see the CN.
+func RateLimit(cfg RateLimitConfig) func(http.Handler) http.Handler {
+	rl := newRateLimiter(cfg)
+	if rl == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	cfg = rl.cfg
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, p := range cfg.ExemptPaths {
+				if r.URL.Path == p {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			if wait, ok := rl.allow(rl.key(r)); !ok {
+				secs := int(math.Ceil(wait.Seconds()))
+				if secs < 1 {
+					secs = 1
+				}
+				w.Header().Set("Retry-After", strconv.Itoa(secs))
+				http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// newRateLimiter applies config defaults. It returns nil when limiting is
+// disabled.
+func newRateLimiter(cfg RateLimitConfig) *rateLimiter {
+	if cfg.Rate <= 0 {
+		return nil
+	}
+	if cfg.Burst < 1 {
+		cfg.Burst = 1
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = DefaultRateIdleTimeout
+	}
+	if cfg.ExemptPaths == nil {
+		cfg.ExemptPaths = []string{"/api/health"}
+	}
+	if cfg.now == nil {
+		cfg.now = time.Now
+	}
+	return &rateLimiter{
+		cfg:       cfg,
+		buckets:   make(map[string]*bucket),
+		lastSweep: cfg.now(),
+	}
+}
+
+// key identifies the client a request is charged to.
+func (rl *rateLimiter) key(r *http.Request) string {
+	if user := GetUser(r.Context()); user != nil && user.AuthMethod != "none" {
+		return "cn:" + user.CN
+	}
+	ip := ExtractSourceIP(r, rl.cfg.TrustProxy)
+	// RemoteAddr carries the client port, which changes per connection
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return "ip:" + ip
+}
+
+// allow takes one token from the key's bucket. When the bucket is empty it
+// returns false and how long until the next token is available.
+func (rl *rateLimiter) allow(key string) (time.Duration, bool) {
+	now := rl.cfg.now()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if now.Sub(rl.lastSweep) >= rl.cfg.IdleTimeout {
+		rl.sweep(now)
+	}
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rl.cfg.Burst), last: now}
+		rl.buckets[key] = b
+	} else {
+		b.tokens = math.Min(float64(rl.cfg.Burst), b.tokens+now.Sub(b.last).Seconds()*rl.cfg.Rate)
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rl.cfg.Rate * float64(time.Second))
+		return wait, false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// sweep drops buckets that have been idle past the timeout. Caller holds mu.
+func (rl *rateLimiter) sweep(now time.Time) {
+	for k, b := range rl.buckets {
+		if now.Sub(b.last) >= rl.cfg.IdleTimeout {
+			delete(rl.buckets, k)
+		}
+	}
+	rl.lastSweep = now
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeClock returns a controllable time source for limiter tests.
+func fakeClock() (func() time.Time, func(time.Duration)) {
+	now := time.Unix(1700000000, 0)
+	return func() time.Time { return now }, func(d time.Duration) { now = now.Add(d) }
+}
+
+func serveLimited(h http.Handler, path, remoteAddr string, user *UserContext) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	req.RemoteAddr = remoteAddr
+	if user != nil {
+		req = req.WithContext(WithUser(req.Context(), user))
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimit_BurstThenReject(t *testing.T) {
+	now, advance := fakeClock()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	h := RateLimit(RateLimitConfig{Rate: 1, Burst: 2, now: now})(ok)
+
+	for i := 0; i < 2; i++ {
+		if rec := serveLimited(h, "/api/items", "10.0.0.1:1000", nil); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, rec.Code)
+		}
+	}
+
+	rec := serveLimited(h, "/api/items", "10.0.0.1:1001", nil)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+
+	// A different IP has its own bucket
+	if rec := serveLimited(h, "/api/items", "10.0.0.2:1000", nil); rec.Code != http.StatusOK {
+		t.Errorf("other IP: expected 200, got %d", rec.Code)
+	}
+
+	// Tokens refill over time
+	advance(time.Second)
+	if rec := serveLimited(h, "/api/items", "10.0.0.1:1002", nil); rec.Code != http.StatusOK {
+		t.Errorf("after refill: expected 200, got %d", rec.Code)
+	}
+}
+
+func TestRateLimit_KeyedByCN(t *testing.T) {
+	now, _ := fakeClock()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	h := RateLimit(RateLimitConfig{Rate: 1, Burst: 1, now: now})(ok)
+
+	alice := &UserContext{CN: "alice", AuthMethod: "token"}
+	if rec := serveLimited(h, "/api/items", "10.0.0.1:1000", alice); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	// Same user from another IP shares the bucket
+	if rec := serveLimited(h, "/api/items", "10.0.0.9:1000", alice); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 for same CN, got %d", rec.Code)
+	}
+	bob := &UserContext{CN: "bob", AuthMethod: "cert"}
+	if rec := serveLimited(h, "/api/items", "10.0.0.1:1000", bob); rec.Code != http.StatusOK {
+		t.Errorf("expected 200 for other CN, got %d", rec.Code)
+	}
+}
+
+func TestRateLimit_HealthExempt(t *testing.T) {
+	now, _ := fakeClock()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	h := RateLimit(RateLimitConfig{Rate: 1, Burst: 1, now: now})(ok)
+
+	for i := 0; i < 5; i++ {
+		if rec := serveLimited(h, "/api/health", "10.0.0.1:1000", nil); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, rec.Code)
+		}
+	}
+}
+
+func TestRateLimit_Disabled(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	h := RateLimit(RateLimitConfig{})(ok)
+
+	for i := 0; i < 100; i++ {
+		if rec := serveLimited(h, "/api/items", "10.0.0.1:1000", nil); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, rec.Code)
+		}
+	}
+}
+
+func TestRateLimit_SweepsIdleBuckets(t *testing.T) {
+	now, advance := fakeClock()
+	rl := newRateLimiter(RateLimitConfig{Rate: 1, Burst: 1, IdleTimeout: time.Minute, now: now})
+
+	rl.allow("ip:10.0.0.1")
+	rl.allow("ip:10.0.0.2")
+	if len(rl.buckets) != 2 {
+		t.Fatalf("expected 2 buckets, got %d", len(rl.buckets))
+	}
+
+	advance(2 * time.Minute)
+	rl.allow("ip:10.0.0.3")
+	if len(rl.buckets) != 1 {
+		t.Errorf("expected idle buckets swept, got %d", len(rl.buckets))
+	}
+}
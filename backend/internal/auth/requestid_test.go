@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestAssignRequestID(t *testing.T) {
+	var seen string
+	h := AssignRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"generated", "", false},
+		{"provided", "client-abc_123.4:5", true},
+		{"uuid provided", "5f0c0a4e-3a43-4d3b-9d0c-0d5b2e1f8f11", true},
+		{"unsafe characters replaced", "evil\nid", false},
+		{"spaces replaced", "a b", false},
+		{"too long replaced", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			got := rec.Header().Get(RequestIDHeader)
+			if got != seen {
+				t.Errorf("header %q != context %q", got, seen)
+			}
+			if tt.keep {
+				if got != tt.incoming {
+					t.Errorf("ID = %q, want provided %q", got, tt.incoming)
+				}
+				return
+			}
+			if _, err := uuid.Parse(got); err != nil {
+				t.Errorf("ID = %q, want a generated UUID", got)
+			}
+		})
+	}
+
+	// Each request gets its own ID
+	a, b := httptest.NewRecorder(), httptest.NewRecorder()
+	h.ServeHTTP(a, httptest.NewRequest("GET", "/", nil))
+	h.ServeHTTP(b, httptest.NewRequest("GET", "/", nil))
+	if a.Header().Get(RequestIDHeader) == b.Header().Get(RequestIDHeader) {
+		t.Error("two requests got the same ID")
+	}
+}
+
+func TestRequestIDInSecurityLog(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := MiddlewareConfig{AuthEnabled: true, Logger: NewSecurityLogger(&buf)}
+	h := AssignRequestID(Middleware(cfg)(http.NotFoundHandler()))
+
+	req := httptest.NewRequest("GET", "/api/items", nil)
+	req.Header.Set(RequestIDHeader, "trace-42")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var event SecurityEvent
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatalf("decode %q: %v", buf.String(), err)
+	}
+	if event.Event != "auth_failure" || event.RequestID != "trace-42" {
+		t.Errorf("event = %+v, want auth_failure with request_id trace-42", event)
+	}
+}
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA is a throwaway CA able to issue client certs and CRLs.
+type testCA struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, cn string, serial int64) *x509.Certificate {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("create cert: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+// writeCRL writes a PEM CRL revoking serials to path.
+func (ca *testCA) writeCRL(t *testing.T, path string, number int64, serials ...int64) {
+	t.Helper()
+	var entries []x509.RevocationListEntry
+	for _, s := range serials {
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: big.NewInt(s), RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(number),
+		ThisUpdate:                time.Now().Add(-time.Minute),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: entries,
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatalf("create CRL: %v", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRevocationChecker_IsRevoked(t *testing.T) {
+	ca := newTestCA(t)
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	ca.writeCRL(t, path, 1, 42)
+
+	rc, err := NewRevocationChecker(path)
+	if err != nil {
+		t.Fatalf("NewRevocationChecker: %v", err)
+	}
+
+	if !rc.IsRevoked(ca.issue(t, "mallory", 42)) {
+		t.Error("expected serial 42 to be revoked")
+	}
+	if rc.IsRevoked(ca.issue(t, "alice", 43)) {
+		t.Error("expected serial 43 to be valid")
+	}
+
+	var nilChecker *RevocationChecker
+	if nilChecker.IsRevoked(ca.issue(t, "alice", 42)) {
+		t.Error("nil checker should revoke nothing")
+	}
+}
+
+func TestRevocationChecker_Reload(t *testing.T) {
+	ca := newTestCA(t)
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	ca.writeCRL(t, path, 1)
+
+	rc, err := NewRevocationChecker(path)
+	if err != nil {
+		t.Fatalf("NewRevocationChecker: %v", err)
+	}
+	cert := ca.issue(t, "bob", 7)
+	if rc.IsRevoked(cert) {
+		t.Fatal("expected cert valid before reload")
+	}
+
+	ca.writeCRL(t, path, 2, 7)
+	if err := rc.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if !rc.IsRevoked(cert) {
+		t.Error("expected cert revoked after reload")
+	}
+
+	// A broken file keeps the previous list
+	os.WriteFile(path, []byte("garbage"), 0600)
+	if err := rc.Reload(); err == nil {
+		t.Error("expected error reloading invalid CRL")
+	}
+	if !rc.IsRevoked(cert) {
+		t.Error("failed reload should keep the previous list")
+	}
+}
+
+func TestNewRevocationChecker_MissingFile(t *testing.T) {
+	if _, err := NewRevocationChecker(filepath.Join(t.TempDir(), "missing.crl")); err == nil {
+		t.Error("expected error for missing CRL")
+	}
+}
+
+func TestMiddleware_RevokedCert(t *testing.T) {
+	ca := newTestCA(t)
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	ca.writeCRL(t, path, 1, 42)
+	rc, err := NewRevocationChecker(path)
+	if err != nil {
+		t.Fatalf("NewRevocationChecker: %v", err)
+	}
+
+	var logBuf bytes.Buffer
+	cfg := MiddlewareConfig{
+		AuthEnabled: true,
+		Secret:      []byte("test-secret-32-bytes-long-key!!"),
+		Logger:      NewSecurityLogger(&logBuf),
+		Revocation:  rc,
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+	}{
+		{"Middleware", Middleware(cfg)(ok)},
+		{"RequireCertAuth", RequireCertAuth(cfg)(ok)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logBuf.Reset()
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{ca.issue(t, "mallory", 42)}}
+			rec := httptest.NewRecorder()
+			tc.handler.ServeHTTP(rec, req)
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("revoked cert: expected 401, got %d", rec.Code)
+			}
+
+			var event SecurityEvent
+			json.Unmarshal(logBuf.Bytes(), &event)
+			if event.Event != "auth_failure" || event.Reason != "cert_revoked" {
+				t.Errorf("logged event = %+v, want cert_revoked failure", event)
+			}
+
+			req = httptest.NewRequest("GET", "/", nil)
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{ca.issue(t, "alice", 43)}}
+			rec = httptest.NewRecorder()
+			tc.handler.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Errorf("valid cert: expected 200, got %d", rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Token scopes limit what an API token may do. Certificate and single-user
+// requests are never scope-restricted.
+const (
+	ScopeItemsRead    = "items:read"    // Read items, search, export
+	ScopeItemsWrite   = "items:write"   // Create, update, delete, import
+	ScopeTokensManage = "tokens:manage" // List the user's tokens
+)
+
+// AllScopes lists every known scope. Tokens created without explicit scopes
+// receive all of them.
+var AllScopes = []string{ScopeItemsRead, ScopeItemsWrite, ScopeTokensManage}
+
+// ValidateScopes returns an error if any scope is unknown.
+func ValidateScopes(scopes []string) error {
+	for _, sc := range scopes {
+		if !slices.Contains(AllScopes, sc) {
+			return fmt.Errorf("unknown scope %q", sc)
+		}
+	}
+	return nil
+}
+
+// HasScope reports whether the user may perform actions requiring scope.
+// Only token users are restricted; tokens issued before scopes existed carry
+// none and keep full access.
+func (u *UserContext) HasScope(scope string) bool {
+	if u.AuthMethod != "token" || len(u.Scopes) == 0 {
+		return true
+	}
+	return slices.Contains(u.Scopes, scope)
+}
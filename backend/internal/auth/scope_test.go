@@ -0,0 +1,37 @@
+package auth
+
+import "testing"
+
+func TestValidateScopes(t *testing.T) {
+	if err := ValidateScopes(AllScopes); err != nil {
+		t.Errorf("AllScopes: %v", err)
+	}
+	if err := ValidateScopes(nil); err != nil {
+		t.Errorf("nil: %v", err)
+	}
+	if err := ValidateScopes([]string{ScopeItemsRead, "items:everything"}); err == nil {
+		t.Error("expected error for unknown scope")
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		name  string
+		user  UserContext
+		scope string
+		want  bool
+	}{
+		{"cert unrestricted", UserContext{AuthMethod: "cert"}, ScopeItemsWrite, true},
+		{"single-user unrestricted", UserContext{AuthMethod: "none"}, ScopeItemsWrite, true},
+		{"legacy token unrestricted", UserContext{AuthMethod: "token"}, ScopeItemsWrite, true},
+		{"token with scope", UserContext{AuthMethod: "token", Scopes: []string{ScopeItemsRead}}, ScopeItemsRead, true},
+		{"token without scope", UserContext{AuthMethod: "token", Scopes: []string{ScopeItemsRead}}, ScopeItemsWrite, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.user.HasScope(tc.scope); got != tc.want {
+				t.Errorf("HasScope(%q) = %v, want %v", tc.scope, got, tc.want)
+			}
+		})
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/alanp/cue/internal/clock"
 )
 
 var (
@@ -17,6 +19,7 @@ var (
 	ErrInvalidSignature = errors.New("invalid token signature")
 	ErrTokenExpired     = errors.New("token has expired")
 	ErrTokenRevoked     = errors.New("token has been revoked")
+	ErrTokenFromFuture  = errors.New("token issued in the future")
 )
 
 // TokenClaims represents the payload of an API token.
@@ -24,18 +27,86 @@ type TokenClaims struct {
 	CN  string `json:"cn"`  // User's Common Name
 	IAT int64  `json:"iat"` // Issued At (Unix timestamp)
 	EXP int64  `json:"exp"` // Expiration (Unix timestamp)
+
+	Scopes []string `json:"scopes,omitempty"` // Permitted scopes; empty means unrestricted (legacy)
+}
+
+// jwtClaims is TokenClaims under the registered JWT claim names, with
+// scopes as the space-separated scope claim of RFC 8693.
+type jwtClaims struct {
+	Sub   string `json:"sub"`
+	IAT   int64  `json:"iat"`
+	EXP   int64  `json:"exp"`
+	Scope string `json:"scope,omitempty"`
 }
 
-// GenerateToken creates a new signed API token for the given user.
+// jwtHeader is the JOSE header of a JWT-format token.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// TokenFormat selects how GenerateTokenWithFormat encodes a token.
+// ValidateToken accepts both formats whichever one is configured.
+type TokenFormat string
+
+const (
+	// TokenFormatCue is base64(payload).base64(hmac-sha256(payload)) (the
+	// default).
+	TokenFormatCue TokenFormat = "cue"
+	// TokenFormatJWT is a standard HS256 JWT, header.payload.signature,
+	// with the claims named sub, iat, exp, and scope.
+	TokenFormatJWT TokenFormat = "jwt"
+)
+
+// ParseTokenFormat validates a token format name. An empty string selects
+// TokenFormatCue.
+func ParseTokenFormat(v string) (TokenFormat, error) {
+	switch f := TokenFormat(v); f {
+	case "":
+		return TokenFormatCue, nil
+	case TokenFormatCue, TokenFormatJWT:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown token format %q (want cue or jwt)", v)
+}
+
+// GenerateToken creates a new signed API token for the given user, with the
+// scopes embedded in the signed payload.
 // The token format is: base64(payload).base64(hmac-sha256(payload))
-func GenerateToken(cn string, expiresIn time.Duration, secret []byte) (string, time.Time, error) {
-	now := time.Now().UTC()
+func GenerateToken(cn string, expiresIn time.Duration, secret []byte, scopes []string) (string, time.Time, error) {
+	return GenerateTokenWithClock(clock.Real, cn, expiresIn, secret, scopes)
+}
+
+// GenerateTokenWithClock is GenerateToken issuing the token at c's time.
+func GenerateTokenWithClock(c clock.Clock, cn string, expiresIn time.Duration, secret []byte, scopes []string) (string, time.Time, error) {
+	return GenerateTokenWithFormat(c, TokenFormatCue, cn, expiresIn, secret, scopes)
+}
+
+// GenerateTokenWithFormat is GenerateTokenWithClock encoding the token in
+// format.
+func GenerateTokenWithFormat(c clock.Clock, format TokenFormat, cn string, expiresIn time.Duration, secret []byte, scopes []string) (string, time.Time, error) {
+	now := c.Now().UTC().Truncate(time.Second)
 	expiresAt := now.Add(expiresIn)
 
+	if format == TokenFormatJWT {
+		token, err := encodeJWT(jwtClaims{
+			Sub:   cn,
+			IAT:   now.Unix(),
+			EXP:   expiresAt.Unix(),
+			Scope: strings.Join(scopes, " "),
+		}, secret)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		return token, expiresAt, nil
+	}
+
 	claims := TokenClaims{
-		CN:  cn,
-		IAT: now.Unix(),
-		EXP: expiresAt.Unix(),
+		CN:     cn,
+		IAT:    now.Unix(),
+		EXP:    expiresAt.Unix(),
+		Scopes: scopes,
 	}
 
 	payload, err := json.Marshal(claims)
@@ -51,14 +122,68 @@ func GenerateToken(cn string, expiresIn time.Duration, secret []byte) (string, t
 	return token, expiresAt, nil
 }
 
+// encodeJWT signs claims as an HS256 JWT.
+func encodeJWT(claims jwtClaims, secret []byte) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", fmt.Errorf("marshal header: %w", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("marshal claims: %w", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig := computeHMAC([]byte(signingInput), secret)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
 // ValidateToken verifies the token signature and checks expiration.
-// Returns the claims if valid, or an error otherwise.
+// Returns the claims if valid, or an error otherwise. A token is valid up to
+// and including the second of its EXP. Both TokenFormats are accepted, told
+// apart by their number of dot-separated parts.
 func ValidateToken(tokenString string, secret []byte) (*TokenClaims, error) {
-	parts := strings.Split(tokenString, ".")
-	if len(parts) != 2 {
+	return ValidateTokenWithClock(clock.Real, tokenString, secret)
+}
+
+// ValidateTokenWithClock is ValidateToken checking expiry against c.
+func ValidateTokenWithClock(c clock.Clock, tokenString string, secret []byte) (*TokenClaims, error) {
+	return ValidateTokenWithLeeway(c, tokenString, secret, 0)
+}
+
+// ValidateTokenWithLeeway is ValidateTokenWithClock tolerating clock skew of
+// up to leeway: the token stays valid for leeway past its EXP, and its IAT
+// may be up to leeway ahead of c. A token issued further in the future is
+// rejected with ErrTokenFromFuture.
+func ValidateTokenWithLeeway(c clock.Clock, tokenString string, secret []byte, leeway time.Duration) (*TokenClaims, error) {
+	var claims *TokenClaims
+	var err error
+	switch parts := strings.Split(tokenString, "."); len(parts) {
+	case 2:
+		claims, err = decodeCueToken(parts, secret)
+	case 3:
+		claims, err = decodeJWT(parts, secret)
+	default:
 		return nil, ErrInvalidToken
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.Now()
+	if claims.IAT > now.Add(leeway).Unix() {
+		return nil, ErrTokenFromFuture
+	}
+	if now.Add(-leeway).Unix() > claims.EXP {
+		return nil, ErrTokenExpired
+	}
+
+	return claims, nil
+}
 
+// decodeCueToken verifies and decodes the payload and signature parts of a
+// TokenFormatCue token.
+func decodeCueToken(parts []string, secret []byte) (*TokenClaims, error) {
 	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
 	if err != nil {
 		return nil, ErrInvalidToken
@@ -78,12 +203,45 @@ func ValidateToken(tokenString string, secret []byte) (*TokenClaims, error) {
 	if err := json.Unmarshal(payload, &claims); err != nil {
 		return nil, ErrInvalidToken
 	}
+	return &claims, nil
+}
 
-	if time.Now().Unix() > claims.EXP {
-		return nil, ErrTokenExpired
+// decodeJWT verifies and decodes the header, payload, and signature parts
+// of a TokenFormatJWT token. Only HS256 is accepted, so a token cannot
+// pick a weaker algorithm such as "none".
+func decodeJWT(parts []string, secret []byte) (*TokenClaims, error) {
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidToken
 	}
 
-	return &claims, nil
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	expectedSig := computeHMAC([]byte(parts[0]+"."+parts[1]), secret)
+	if !hmac.Equal(sig, expectedSig) {
+		return nil, ErrInvalidSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	return &TokenClaims{
+		CN:     claims.Sub,
+		IAT:    claims.IAT,
+		EXP:    claims.EXP,
+		Scopes: strings.Fields(claims.Scope),
+	}, nil
 }
 
 // computeHMAC returns the HMAC-SHA256 of the data using the given secret.
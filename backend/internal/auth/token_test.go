@@ -1,14 +1,22 @@
 package auth
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/alanp/cue/internal/clock"
 )
 
 func TestGenerateToken(t *testing.T) {
 	secret := []byte("test-secret-32-bytes-long-key!!")
 
-	token, expiresAt, err := GenerateToken("testuser", 1*time.Hour, secret)
+	token, expiresAt, err := GenerateToken("testuser", 1*time.Hour, secret, nil)
 	if err != nil {
 		t.Fatalf("GenerateToken failed: %v", err)
 	}
@@ -25,7 +33,7 @@ func TestGenerateToken(t *testing.T) {
 func TestValidateToken_Valid(t *testing.T) {
 	secret := []byte("test-secret-32-bytes-long-key!!")
 
-	token, _, err := GenerateToken("testuser", 1*time.Hour, secret)
+	token, _, err := GenerateToken("testuser", 1*time.Hour, secret, nil)
 	if err != nil {
 		t.Fatalf("GenerateToken failed: %v", err)
 	}
@@ -44,7 +52,7 @@ func TestValidateToken_Expired(t *testing.T) {
 	secret := []byte("test-secret-32-bytes-long-key!!")
 
 	// Create token with negative duration (already expired)
-	token, _, err := GenerateToken("testuser", -1*time.Hour, secret)
+	token, _, err := GenerateToken("testuser", -1*time.Hour, secret, nil)
 	if err != nil {
 		t.Fatalf("GenerateToken failed: %v", err)
 	}
@@ -55,11 +63,79 @@ func TestValidateToken_Expired(t *testing.T) {
 	}
 }
 
+func TestValidateToken_ExpiryBoundary(t *testing.T) {
+	secret := []byte("test-secret-32-bytes-long-key!!")
+	c := clock.NewFake(time.Date(2026, 1, 2, 3, 4, 5, 600e6, time.UTC))
+
+	token, expiresAt, err := GenerateTokenWithClock(c, "testuser", time.Hour, secret, nil)
+	if err != nil {
+		t.Fatalf("GenerateTokenWithClock: %v", err)
+	}
+	claims, _ := ValidateTokenWithClock(c, token, secret)
+	if want := time.Date(2026, 1, 2, 4, 4, 5, 0, time.UTC); !expiresAt.Equal(want) || claims.EXP != want.Unix() {
+		t.Fatalf("expiresAt = %v, EXP = %d, want %v", expiresAt, claims.EXP, want)
+	}
+
+	// Valid through the last nanosecond of the EXP second, expired after
+	for _, tc := range []struct {
+		at   time.Time
+		want error
+	}{
+		{expiresAt.Add(-time.Second), nil},
+		{expiresAt, nil},
+		{expiresAt.Add(time.Second - time.Nanosecond), nil},
+		{expiresAt.Add(time.Second), ErrTokenExpired},
+	} {
+		c.Set(tc.at)
+		if _, err := ValidateTokenWithClock(c, token, secret); err != tc.want {
+			t.Errorf("at %v: err = %v, want %v", tc.at, err, tc.want)
+		}
+	}
+}
+
+func TestValidateToken_Leeway(t *testing.T) {
+	secret := []byte("test-secret-32-bytes-long-key!!")
+	issued := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	leeway := time.Minute
+
+	token, expiresAt, err := GenerateTokenWithClock(clock.NewFake(issued), "testuser", time.Hour, secret, nil)
+	if err != nil {
+		t.Fatalf("GenerateTokenWithClock: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want error
+	}{
+		{"expired inside leeway", expiresAt.Add(leeway), nil},
+		{"expired outside leeway", expiresAt.Add(leeway + time.Second), ErrTokenExpired},
+		{"issued ahead inside leeway", issued.Add(-leeway), nil},
+		{"issued ahead outside leeway", issued.Add(-leeway - time.Second), ErrTokenFromFuture},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := clock.NewFake(tc.at)
+			if _, err := ValidateTokenWithLeeway(c, token, secret, leeway); err != tc.want {
+				t.Errorf("ValidateTokenWithLeeway at %v: err = %v, want %v", tc.at, err, tc.want)
+			}
+		})
+	}
+
+	// Without leeway both skews are rejected
+	if _, err := ValidateTokenWithClock(clock.NewFake(expiresAt.Add(time.Second)), token, secret); err != ErrTokenExpired {
+		t.Errorf("no leeway past EXP: err = %v, want %v", err, ErrTokenExpired)
+	}
+	if _, err := ValidateTokenWithClock(clock.NewFake(issued.Add(-time.Second)), token, secret); err != ErrTokenFromFuture {
+		t.Errorf("no leeway before IAT: err = %v, want %v", err, ErrTokenFromFuture)
+	}
+}
+
 func TestValidateToken_InvalidSignature(t *testing.T) {
 	secret1 := []byte("test-secret-32-bytes-long-key!!")
 	secret2 := []byte("different-secret-also-32-bytes!")
 
-	token, _, err := GenerateToken("testuser", 1*time.Hour, secret1)
+	token, _, err := GenerateToken("testuser", 1*time.Hour, secret1, nil)
 	if err != nil {
 		t.Fatalf("GenerateToken failed: %v", err)
 	}
@@ -79,7 +155,8 @@ func TestValidateToken_InvalidFormat(t *testing.T) {
 	}{
 		{"empty", ""},
 		{"no dot", "invalidtoken"},
-		{"too many dots", "a.b.c"},
+		{"malformed jwt", "a.b.c"},
+		{"too many dots", "a.b.c.d"},
 		{"invalid base64 payload", "!!!.valid"},
 		{"invalid base64 sig", "dmFsaWQ.!!!"},
 	}
@@ -173,3 +250,112 @@ func TestGenerateTokenID(t *testing.T) {
 		t.Error("expected different token IDs")
 	}
 }
+
+func TestGenerateToken_Scopes(t *testing.T) {
+	secret := []byte("test-secret-32-bytes-long-key!!")
+	token, _, err := GenerateToken("testuser", time.Hour, secret, []string{ScopeItemsRead})
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+
+	claims, err := ValidateToken(token, secret)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if len(claims.Scopes) != 1 || claims.Scopes[0] != ScopeItemsRead {
+		t.Errorf("scopes = %v, want [%s]", claims.Scopes, ScopeItemsRead)
+	}
+}
+
+func TestGenerateToken_JWT(t *testing.T) {
+	secret := []byte("test-secret-32-bytes-long-key!!")
+	c := clock.NewFake(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	token, expiresAt, err := GenerateTokenWithFormat(c, TokenFormatJWT, "testuser", time.Hour, secret, []string{ScopeItemsRead, ScopeItemsWrite})
+	if err != nil {
+		t.Fatalf("GenerateTokenWithFormat: %v", err)
+	}
+
+	// Verify as a generic HS256 JWT (RFC 7519) using only the standard library
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token has %d parts, want 3: %s", len(parts), token)
+	}
+	var header map[string]any
+	if b, err := base64.RawURLEncoding.DecodeString(parts[0]); err != nil || json.Unmarshal(b, &header) != nil {
+		t.Fatalf("header does not decode: %v", err)
+	}
+	if header["alg"] != "HS256" || header["typ"] != "JWT" {
+		t.Errorf("header = %v", header)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if sig, _ := base64.RawURLEncoding.DecodeString(parts[2]); !hmac.Equal(sig, mac.Sum(nil)) {
+		t.Error("signature does not verify as HMAC-SHA256 over header.payload")
+	}
+	var payload map[string]any
+	if b, err := base64.RawURLEncoding.DecodeString(parts[1]); err != nil || json.Unmarshal(b, &payload) != nil {
+		t.Fatalf("payload does not decode: %v", err)
+	}
+	if payload["sub"] != "testuser" || payload["iat"] != float64(c.Now().Unix()) ||
+		payload["exp"] != float64(expiresAt.Unix()) || payload["scope"] != "items:read items:write" {
+		t.Errorf("payload = %v", payload)
+	}
+
+	claims, err := ValidateTokenWithClock(c, token, secret)
+	if err != nil {
+		t.Fatalf("ValidateTokenWithClock: %v", err)
+	}
+	if claims.CN != "testuser" || claims.EXP != expiresAt.Unix() || !slices.Equal(claims.Scopes, []string{ScopeItemsRead, ScopeItemsWrite}) {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	c.Advance(time.Hour + time.Second)
+	if _, err := ValidateTokenWithClock(c, token, secret); err != ErrTokenExpired {
+		t.Errorf("expired JWT: err = %v, want %v", err, ErrTokenExpired)
+	}
+}
+
+func TestValidateToken_JWTTampered(t *testing.T) {
+	secret := []byte("test-secret-32-bytes-long-key!!")
+
+	token, _, err := GenerateTokenWithFormat(clock.Real, TokenFormatJWT, "testuser", time.Hour, secret, nil)
+	if err != nil {
+		t.Fatalf("GenerateTokenWithFormat: %v", err)
+	}
+	parts := strings.Split(token, ".")
+	encode := func(v string) string { return base64.RawURLEncoding.EncodeToString([]byte(v)) }
+
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	forged := strings.Replace(string(payload), `"sub":"testuser"`, `"sub":"admin"`, 1)
+
+	tests := []struct {
+		name   string
+		token  string
+		secret []byte
+		want   error
+	}{
+		{"payload changed", parts[0] + "." + encode(forged) + "." + parts[2], secret, ErrInvalidSignature},
+		{"signature changed", parts[0] + "." + parts[1] + "." + encode("not the signature"), secret, ErrInvalidSignature},
+		{"alg none", encode(`{"alg":"none","typ":"JWT"}`) + "." + parts[1] + ".", secret, ErrInvalidToken},
+		{"other secret", token, []byte("another-secret-32-bytes-long!!!"), ErrInvalidSignature},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := ValidateToken(tc.token, tc.secret); err != tc.want {
+				t.Errorf("err = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseTokenFormat(t *testing.T) {
+	for in, want := range map[string]TokenFormat{"": TokenFormatCue, "cue": TokenFormatCue, "jwt": TokenFormatJWT} {
+		if got, err := ParseTokenFormat(in); err != nil || got != want {
+			t.Errorf("ParseTokenFormat(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseTokenFormat("paseto"); err == nil {
+		t.Error("ParseTokenFormat(paseto) succeeded")
+	}
+}
@@ -0,0 +1,29 @@
+package diff
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []Line
+	}{
+		{"both empty", "", "", []Line{}},
+		{"identical", "a\nb", "a\nb", []Line{{Equal, "a"}, {Equal, "b"}}},
+		{"insert", "a\nc", "a\nb\nc", []Line{{Equal, "a"}, {Insert, "b"}, {Equal, "c"}}},
+		{"delete", "a\nb\nc", "a\nc", []Line{{Equal, "a"}, {Delete, "b"}, {Equal, "c"}}},
+		{"replace", "a\nb", "a\nx", []Line{{Equal, "a"}, {Delete, "b"}, {Insert, "x"}}},
+		{"from empty", "", "a", []Line{{Insert, "a"}}},
+		{"to empty", "a", "", []Line{{Delete, "a"}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Lines(tc.a, tc.b); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Lines(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+			}
+		})
+	}
+}
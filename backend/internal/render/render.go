@@ -0,0 +1,35 @@
+// Package render converts item markdown to HTML that is safe to embed.
+package render
+
+import (
+	"bytes"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+var (
+	// Raw HTML in a note is passed through to the sanitizer rather than
+	// omitted by goldmark, which would drop a <script> tag but keep its
+	// body as visible text.
+	md = goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithRendererOptions(html.WithUnsafe()),
+	)
+
+	// policy allows the formatting markdown produces (headings, lists,
+	// tables, links, images, code) and drops scripts with their contents,
+	// event handlers, and javascript: URLs.
+	policy = bluemonday.UGCPolicy()
+)
+
+// HTML renders markdown source as sanitized HTML.
+func HTML(source string) (string, error) {
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(source), &buf); err != nil {
+		return "", err
+	}
+	return policy.Sanitize(buf.String()), nil
+}
@@ -0,0 +1,47 @@
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"heading", "# Title", "<h1>Title</h1>"},
+		{"emphasis", "some *text*", "<p>some <em>text</em></p>"},
+		{"link", "[docs](https://example.com)", `<a href="https://example.com" rel="nofollow">docs</a>`},
+		{"code", "`a < b`", "<code>a &lt; b</code>"},
+		{"table", "| a |\n|---|\n| 1 |", "<td>1</td>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := HTML(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("HTML(%q) = %q, want it to contain %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTMLStripsScripts(t *testing.T) {
+	source := "# Notes\n\n<script>alert('x')</script>\n\nHello <img src=x onerror=alert(1)> <script>steal()</script> and [click](javascript:alert(1))\n"
+	got, err := HTML(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"<script", "alert('x')", "steal()", "onerror", "javascript:"} {
+		if strings.Contains(got, bad) {
+			t.Errorf("output contains %q: %s", bad, got)
+		}
+	}
+	if !strings.Contains(got, "<h1>Notes</h1>") {
+		t.Errorf("output lost the heading: %s", got)
+	}
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// migrateV18 adds the backlinks table: one row per [[title]] reference in
+// an item's content. to_id is the item holding that title, or NULL while
+// no item does. Existing items are parsed to fill it.
+func migrateV18(db *sql.DB) error {
+	schema := `
+		CREATE TABLE IF NOT EXISTS backlinks (
+			from_id TEXT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+			target_title TEXT NOT NULL COLLATE NOCASE,
+			to_id TEXT REFERENCES items(id) ON DELETE SET NULL,
+			PRIMARY KEY (from_id, target_title)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_backlinks_to ON backlinks(to_id);
+		CREATE INDEX IF NOT EXISTS idx_backlinks_title ON backlinks(target_title);
+	`
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+
+	rows, err := db.Query("SELECT id, title, content FROM items")
+	if err != nil {
+		return fmt.Errorf("query content: %w", err)
+	}
+	type source struct{ id, title, content string }
+	var sources []source
+	for rows.Next() {
+		var src source
+		if err := rows.Scan(&src.id, &src.title, &src.content); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan content: %w", err)
+		}
+		sources = append(sources, src)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, src := range sources {
+		if err := insertReferences(context.Background(), db, src.id, src.title, src.content); err != nil {
+			return fmt.Errorf("backfill backlinks: %w", err)
+		}
+	}
+	return nil
+}
+
+// referencePattern matches [[title]] and [[title|label]] wiki-links.
+var referencePattern = regexp.MustCompile(`\[\[([^\[\]\n]+)\]\]`)
+
+// parseReferences returns the titles content links to with [[title]],
+// cleaned like item titles and without repeats (ignoring case). A label
+// after "|" is dropped, as are links to self, the item titled self.
+func parseReferences(content, self string) []string {
+	var titles []string
+	seen := make(map[string]bool)
+	for _, m := range referencePattern.FindAllStringSubmatch(content, -1) {
+		title, _, _ := strings.Cut(m[1], "|")
+		title = CleanTitle(title)
+		key := strings.ToLower(title)
+		if title == "" || seen[key] || strings.EqualFold(title, self) {
+			continue
+		}
+		seen[key] = true
+		titles = append(titles, title)
+	}
+	return titles
+}
+
+// insertReferences records the references in an item's content, resolving
+// each title to the item that holds it.
+func insertReferences(ctx context.Context, db querier, id, title, content string) error {
+	for _, target := range parseReferences(content, title) {
+		_, err := db.ExecContext(ctx,
+			"INSERT INTO backlinks (from_id, target_title, to_id) VALUES (?, ?, (SELECT id FROM items WHERE title = ? COLLATE NOCASE))",
+			id, target, target,
+		)
+		if err != nil {
+			return fmt.Errorf("insert backlink: %w", err)
+		}
+	}
+	return nil
+}
+
+// updateReferences brings the backlinks table up to date after item id was
+// written with title and content, within tx: its own references are
+// parsed again, references to a title it gave up become unresolved, and
+// unresolved references to its title now point at it.
+func updateReferences(ctx context.Context, tx *sql.Tx, id, title, content string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM backlinks WHERE from_id = ?", id); err != nil {
+		return fmt.Errorf("clear backlinks: %w", err)
+	}
+	if err := insertReferences(ctx, tx, id, title, content); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE backlinks SET to_id = NULL WHERE to_id = ? AND target_title <> ?", id, title); err != nil {
+		return fmt.Errorf("unresolve backlinks: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE backlinks SET to_id = ? WHERE to_id IS NULL AND target_title = ? AND from_id <> ?", id, title, id); err != nil {
+		return fmt.Errorf("resolve backlinks: %w", err)
+	}
+	return nil
+}
+
+// Backlinks returns the live items whose content links to item id with
+// [[title]], most recently updated first. References follow the title: a
+// renamed item loses the links made to its old title, and whichever item
+// takes a title next picks them up. Returns sql.ErrNoRows if the item is
+// missing or trashed.
+func (s *Store) Backlinks(id string) ([]Item, error) {
+	return s.BacklinksContext(context.Background(), id)
+}
+
+// BacklinksContext is Backlinks with a context that cancels the query.
+func (s *Store) BacklinksContext(ctx context.Context, id string) ([]Item, error) {
+	var exists int
+	if err := s.db.QueryRowContext(ctx, "SELECT 1 FROM items WHERE id = ? AND deleted_at IS NULL", id).Scan(&exists); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+selectItemColumns("")+" FROM items WHERE id IN (SELECT from_id FROM backlinks WHERE to_id = ?) AND deleted_at IS NULL ORDER BY "+sortClauses[SortUpdatedDesc],
+		id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	items, err := scanItems(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadTags(ctx, items); err != nil {
+		return nil, err
+	}
+	if items == nil {
+		items = []Item{}
+	}
+	return items, nil
+}
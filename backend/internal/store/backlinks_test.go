@@ -0,0 +1,92 @@
+package store
+
+import (
+	"database/sql"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestParseReferences(t *testing.T) {
+	content := "See [[Go  Notes]] and [[go notes|the notes]], [[Self]], [[]], [[ Missing ]].\n[[not\nclosed]]"
+	got := parseReferences(content, "self")
+	if want := []string{"Go Notes", "Missing"}; !slices.Equal(got, want) {
+		t.Errorf("parseReferences = %q, want %q", got, want)
+	}
+}
+
+func TestBacklinks(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-backlinks-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	backlinkIDs := func(id string) []string {
+		t.Helper()
+		items, err := s.Backlinks(id)
+		if err != nil {
+			t.Fatalf("Backlinks: %v", err)
+		}
+		var ids []string
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+		return ids
+	}
+
+	// A reference to a title nobody holds yet is kept unresolved
+	ref, _ := s.Create("Referencer", "Background in [[Target]].", nil, nil, "")
+	var unresolved int
+	s.db.QueryRow("SELECT COUNT(*) FROM backlinks WHERE from_id = ? AND to_id IS NULL", ref.ID).Scan(&unresolved)
+	if unresolved != 1 {
+		t.Errorf("unresolved references = %d, want 1", unresolved)
+	}
+
+	target, _ := s.Create("Target", "", nil, nil, "")
+	if got := backlinkIDs(target.ID); !slices.Equal(got, []string{ref.ID}) {
+		t.Errorf("backlinks after creating target = %v, want [%s]", got, ref.ID)
+	}
+
+	other, _ := s.Create("Other", "Also [[target]]", nil, nil, "")
+	if got := backlinkIDs(target.ID); len(got) != 2 {
+		t.Errorf("backlinks with two referencers = %v", got)
+	}
+
+	// Editing the referencer recomputes its references
+	s.Update(other.ID, "Other", "No links now", nil, nil, 0)
+	if got := backlinkIDs(target.ID); !slices.Equal(got, []string{ref.ID}) {
+		t.Errorf("backlinks after removing a link = %v, want [%s]", got, ref.ID)
+	}
+
+	// Renaming the target leaves [[Target]] unresolved until the title is
+	// taken again
+	s.Update(target.ID, "Renamed", "", nil, nil, 0)
+	if got := backlinkIDs(target.ID); len(got) != 0 {
+		t.Errorf("backlinks after rename = %v, want none", got)
+	}
+	title := "Target"
+	if _, err := s.Patch(other.ID, PatchFields{Title: &title}); err != nil {
+		t.Fatalf("Patch: %v", err)
+	}
+	if got := backlinkIDs(other.ID); !slices.Equal(got, []string{ref.ID}) {
+		t.Errorf("backlinks of the new Target = %v, want [%s]", got, ref.ID)
+	}
+
+	// A trashed referencer drops out; purging it removes its references
+	s.Delete(ref.ID)
+	if got := backlinkIDs(other.ID); len(got) != 0 {
+		t.Errorf("backlinks with referencer trashed = %v, want none", got)
+	}
+	s.PurgeDeleted(0)
+	var n int
+	s.db.QueryRow("SELECT COUNT(*) FROM backlinks WHERE from_id = ?", ref.ID).Scan(&n)
+	if n != 0 {
+		t.Errorf("backlinks rows after purge = %d, want 0", n)
+	}
+
+	if _, err := s.Backlinks("missing"); err != sql.ErrNoRows {
+		t.Errorf("Backlinks(missing) err = %v, want sql.ErrNoRows", err)
+	}
+}
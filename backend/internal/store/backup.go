@@ -0,0 +1,24 @@
+package store
+
+import (
+	"fmt"
+	"os"
+)
+
+// Backup writes a consistent snapshot of the database to destPath using
+// VACUUM INTO, which runs while other connections keep reading and writing.
+// The WAL is checkpointed first so the main file is current. destPath must
+// not already exist.
+func (s *Store) Backup(destPath string) error {
+	if _, err := os.Stat(destPath); err == nil {
+		return fmt.Errorf("backup destination %s already exists", destPath)
+	}
+
+	if _, err := s.db.Exec("PRAGMA wal_checkpoint(FULL)"); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	if _, err := s.db.Exec("VACUUM INTO ?", destPath); err != nil {
+		return fmt.Errorf("vacuum into: %w", err)
+	}
+	return nil
+}
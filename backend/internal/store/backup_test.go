@@ -0,0 +1,45 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackup(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-backup-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	s.Create("Backed Up", "searchable snapshot", nil, []string{"safe"}, "")
+
+	dest := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := s.Backup(dest); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+
+	if err := s.Backup(dest); err == nil {
+		t.Error("expected error when destination exists")
+	}
+
+	b, err := New(dest)
+	if err != nil {
+		t.Fatalf("open backup: %v", err)
+	}
+	defer b.Close()
+
+	item, err := b.GetByTitle("Backed Up")
+	if err != nil {
+		t.Fatalf("GetByTitle in backup: %v", err)
+	}
+	if len(item.Tags) != 1 || item.Tags[0] != "safe" {
+		t.Errorf("tags = %q, want [safe]", item.Tags)
+	}
+	results, _ := b.Search("snapshot", 10, 0)
+	if len(results) != 1 {
+		t.Errorf("backup search len = %d, want 1", len(results))
+	}
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// migrateV16 adds the color label that clients use to group items
+// visually. Empty means no color.
+func migrateV16(db *sql.DB) error {
+	return addColumnIfMissing(db, "items", "color", "TEXT NOT NULL DEFAULT ''")
+}
+
+// Colors lists the accepted item colors.
+var Colors = []string{"red", "orange", "yellow", "green", "blue", "purple", "pink", "gray"}
+
+// ErrInvalidColor is returned for a color not in Colors.
+var ErrInvalidColor = errors.New("color must be empty or one of " + strings.Join(Colors, ", "))
+
+// ValidColor reports whether color is empty (no color) or one of Colors.
+func ValidColor(color string) bool {
+	return color == "" || slices.Contains(Colors, color)
+}
+
+// SetColor sets a live item's color; empty clears it. A change of color
+// alone, here or as a Patch of only Color, leaves rev, updated_at, and the
+// version history alone. Returns ErrInvalidColor for a color not in Colors
+// and sql.ErrNoRows for missing or trashed items.
+func (s *Store) SetColor(id, color string) error {
+	return s.SetColorContext(context.Background(), id, color)
+}
+
+// SetColorContext is SetColor with a context that cancels the write.
+func (s *Store) SetColorContext(ctx context.Context, id, color string) error {
+	if !ValidColor(color) {
+		return ErrInvalidColor
+	}
+	return s.setColor(ctx, id, color, 0)
+}
+
+// setColor writes a valid color. A non-zero expectedRev makes the write
+// conditional like Patch's.
+func (s *Store) setColor(ctx context.Context, id, color string, expectedRev int) error {
+	var title string
+	err := s.db.QueryRowContext(ctx,
+		"UPDATE items SET color = ?, changed_at = ? WHERE id = ? AND deleted_at IS NULL AND (? = 0 OR rev = ?) RETURNING title",
+		color, s.now().Format(time.RFC3339), id, expectedRev, expectedRev,
+	).Scan(&title)
+	if err == sql.ErrNoRows {
+		if expectedRev != 0 {
+			if _, err := s.GetContext(ctx, id); err == nil {
+				return ErrRevConflict
+			}
+		}
+		return sql.ErrNoRows
+	}
+	if err != nil {
+		return fmt.Errorf("set color: %w", err)
+	}
+	s.publish(EventUpdated, id, title)
+	return nil
+}
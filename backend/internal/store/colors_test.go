@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+)
+
+func TestSetColor(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-colors-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	item, _ := s.Create("Colored", "", nil, nil, "")
+	if item.Color != "" {
+		t.Errorf("new item color = %q, want none", item.Color)
+	}
+
+	if err := s.SetColor(item.ID, "green"); err != nil {
+		t.Fatalf("SetColor: %v", err)
+	}
+	got, _ := s.Get(item.ID)
+	if got.Color != "green" || got.Rev != item.Rev {
+		t.Errorf("after SetColor: color = %q, rev = %d, want green at rev %d", got.Color, got.Rev, item.Rev)
+	}
+
+	if err := s.SetColor(item.ID, "#00ff00"); err != ErrInvalidColor {
+		t.Errorf("invalid color err = %v, want ErrInvalidColor", err)
+	}
+	if err := s.SetColor("missing", "red"); err != sql.ErrNoRows {
+		t.Errorf("missing item err = %v, want sql.ErrNoRows", err)
+	}
+
+	// Updates leave the color alone
+	s.Update(item.ID, "Colored", "edited", nil, nil, 0)
+	if got, _ := s.Get(item.ID); got.Color != "green" {
+		t.Errorf("after Update: color = %q, want green", got.Color)
+	}
+
+	// A color-only patch is applied like SetColor
+	before, _ := s.Get(item.ID)
+	empty := ""
+	patched, err := s.Patch(item.ID, PatchFields{Color: &empty, ExpectedRev: before.Rev})
+	if err != nil || patched.Color != "" || patched.Rev != before.Rev || !patched.UpdatedAt.Equal(before.UpdatedAt) {
+		t.Errorf("Patch clearing color = %+v, %v; want rev %d unchanged", patched, err, before.Rev)
+	}
+	if versions, _ := s.ListVersions(item.ID); len(versions) != 1 {
+		t.Errorf("color-only patch recorded a version: %d versions, want 1", len(versions))
+	}
+	red := "red"
+	if _, err := s.Patch(item.ID, PatchFields{Color: &red, ExpectedRev: before.Rev + 1}); err != ErrRevConflict {
+		t.Errorf("stale color patch err = %v, want ErrRevConflict", err)
+	}
+	bad := "teal"
+	if _, err := s.Patch(item.ID, PatchFields{Color: &bad}); err != ErrInvalidColor {
+		t.Errorf("Patch invalid color err = %v, want ErrInvalidColor", err)
+	}
+}
+
+func TestCreateAndUpdateWithColor(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-colors-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+	ctx := context.Background()
+
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	item, err := s.CreateWithColorContext(ctx, "Colored", "", nil, nil, "", "blue")
+	if err != nil || item.Color != "blue" {
+		t.Fatalf("CreateWithColorContext = %+v, %v", item, err)
+	}
+	if got, _ := s.Get(item.ID); got.Color != "blue" {
+		t.Errorf("stored color = %q, want blue", got.Color)
+	}
+
+	yellow := "yellow"
+	updated, err := s.UpdateWithColorContext(ctx, item.ID, "Colored", "body", nil, nil, &yellow, 0)
+	if err != nil || updated.Color != "yellow" || updated.Rev != 2 {
+		t.Errorf("UpdateWithColorContext = %+v, %v", updated, err)
+	}
+
+	// One event per write, not one for the item and another for its color
+	for _, typ := range []string{EventCreated, EventUpdated} {
+		if ev := <-events; ev.Type != typ {
+			t.Errorf("event = %+v, want %q", ev, typ)
+		}
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("extra event %+v", ev)
+	default:
+	}
+
+	// An invalid color writes nothing
+	bad := "teal"
+	if _, err := s.UpdateWithColorContext(ctx, item.ID, "Renamed", "", nil, nil, &bad, 0); err != ErrInvalidColor {
+		t.Errorf("invalid update color err = %v, want ErrInvalidColor", err)
+	}
+	if _, err := s.CreateWithColorContext(ctx, "Teal", "", nil, nil, "", "teal"); err != ErrInvalidColor {
+		t.Errorf("invalid create color err = %v, want ErrInvalidColor", err)
+	}
+	if got, _ := s.Get(item.ID); got.Title != "Colored" || got.Rev != 2 {
+		t.Errorf("after invalid update: %+v", got)
+	}
+	if _, err := s.GetByTitle("Teal"); err != sql.ErrNoRows {
+		t.Errorf("invalid create stored an item: %v", err)
+	}
+}
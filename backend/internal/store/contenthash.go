@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// migrateV14 adds the content_hash column used to spot items saved twice
+// and fills it in for existing items.
+func migrateV14(db *sql.DB) error {
+	if err := addColumnIfMissing(db, "items", "content_hash", "BLOB"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_items_content_hash ON items(content_hash)"); err != nil {
+		return fmt.Errorf("create content hash index: %w", err)
+	}
+
+	rows, err := db.Query("SELECT id, content FROM items WHERE content_hash IS NULL")
+	if err != nil {
+		return fmt.Errorf("query content: %w", err)
+	}
+	hashes := make(map[string][]byte)
+	for rows.Next() {
+		var id, content string
+		if err := rows.Scan(&id, &content); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan content: %w", err)
+		}
+		if hash := ContentHash(content); hash != nil {
+			hashes[id] = hash
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, hash := range hashes {
+		if _, err := db.Exec("UPDATE items SET content_hash = ? WHERE id = ?", hash, id); err != nil {
+			return fmt.Errorf("backfill content hash: %w", err)
+		}
+	}
+	return nil
+}
+
+// ContentHash returns the SHA-256 of content after normalizeContent, or nil
+// for blank content, which never counts as a duplicate.
+func ContentHash(content string) []byte {
+	normalized := normalizeContent(content)
+	if normalized == "" {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(normalized))
+	return sum[:]
+}
+
+// normalizeContent irons out differences that do not change what a note
+// says: line endings, trailing whitespace on each line, and leading or
+// trailing blank lines.
+func normalizeContent(content string) string {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// FindByContentHash returns the oldest live item whose content hashes to
+// hash, or sql.ErrNoRows if there is none.
+func (s *Store) FindByContentHash(hash []byte) (*Item, error) {
+	return s.FindByContentHashContext(context.Background(), hash)
+}
+
+// FindByContentHashContext is FindByContentHash with a context that
+// cancels the query.
+func (s *Store) FindByContentHashContext(ctx context.Context, hash []byte) (*Item, error) {
+	if len(hash) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	row := s.db.QueryRowContext(ctx,
+		"SELECT "+selectItemColumns("")+" FROM items WHERE content_hash = ? AND deleted_at IS NULL ORDER BY created_at, id LIMIT 1",
+		hash,
+	)
+	return s.scanItemWithTags(ctx, row)
+}
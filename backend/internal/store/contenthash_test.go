@@ -0,0 +1,65 @@
+package store
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"testing"
+)
+
+func TestContentHash(t *testing.T) {
+	base := ContentHash("line one\nline two")
+	for _, variant := range []string{"line one\r\nline two", "line one  \nline two\t", "\nline one\nline two\n\n"} {
+		if !bytes.Equal(ContentHash(variant), base) {
+			t.Errorf("ContentHash(%q) differs from the normalized form", variant)
+		}
+	}
+	if bytes.Equal(ContentHash("line one\nline three"), base) {
+		t.Error("different content should hash differently")
+	}
+	if ContentHash(" \n\t\n") != nil {
+		t.Error("blank content should have no hash")
+	}
+}
+
+func TestFindByContentHash(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-hash-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	article, _ := s.Create("Saved article", "The body of the article.\n", nil, []string{"reading"}, "")
+	s.Create("Blank", "", nil, nil, "")
+
+	got, err := s.FindByContentHash(ContentHash("The body of the article."))
+	if err != nil {
+		t.Fatalf("FindByContentHash: %v", err)
+	}
+	if got.ID != article.ID || len(got.Tags) != 1 {
+		t.Errorf("found %+v, want %s with its tags", got, article.ID)
+	}
+
+	if _, err := s.FindByContentHash(ContentHash("Something else")); err != sql.ErrNoRows {
+		t.Errorf("non-match err = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := s.FindByContentHash(ContentHash("")); err != sql.ErrNoRows {
+		t.Errorf("blank content err = %v, want sql.ErrNoRows", err)
+	}
+
+	// Edits move the hash with the content
+	s.Update(article.ID, "Saved article", "Rewritten body", nil, nil, 0)
+	if _, err := s.FindByContentHash(ContentHash("The body of the article.")); err != sql.ErrNoRows {
+		t.Errorf("old content err = %v, want sql.ErrNoRows", err)
+	}
+	if got, err := s.FindByContentHash(ContentHash("Rewritten body")); err != nil || got.ID != article.ID {
+		t.Errorf("new content = %v, %v", got, err)
+	}
+
+	// Trashed items are not matched
+	s.Delete(article.ID)
+	if _, err := s.FindByContentHash(ContentHash("Rewritten body")); err != sql.ErrNoRows {
+		t.Errorf("trashed err = %v, want sql.ErrNoRows", err)
+	}
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// Item event types published to subscribers.
+const (
+	EventCreated  = "created"
+	EventUpdated  = "updated"
+	EventDeleted  = "deleted"
+	EventRestored = "restored"
+)
+
+// eventBuffer is the per-subscriber channel size. Events beyond it are
+// dropped for that subscriber rather than blocking writers.
+const eventBuffer = 32
+
+// ItemEvent describes a change to an item.
+type ItemEvent struct {
+	Type   string    `json:"type"`
+	ItemID string    `json:"itemId"`
+	Title  string    `json:"title,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// eventHub fans item events out to in-process subscribers.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan ItemEvent]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan ItemEvent]struct{})}
+}
+
+// Subscribe registers for item events. The returned function unsubscribes
+// and closes the channel; it is safe to call more than once. A subscriber
+// that falls behind misses events instead of stalling the store.
+func (s *Store) Subscribe() (<-chan ItemEvent, func()) {
+	ch := make(chan ItemEvent, eventBuffer)
+
+	s.events.mu.Lock()
+	s.events.subs[ch] = struct{}{}
+	s.events.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.events.mu.Lock()
+			delete(s.events.subs, ch)
+			s.events.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// publish delivers an event to every subscriber without blocking.
+func (s *Store) publish(typ, id, title string) {
+	ev := ItemEvent{Type: typ, ItemID: id, Title: title, At: s.now()}
+
+	s.events.mu.Lock()
+	defer s.events.mu.Unlock()
+	for ch := range s.events.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// tagSeparator joins tags in group_concat so they can be split back apart;
+// it is the ASCII unit separator, which never appears in normalized tags.
+const tagSeparator = "\x1f"
+
+// ExportEach calls fn for every live item, ordered by creation time, without
+// loading the whole set into memory. Iteration stops at the first error.
+func (s *Store) ExportEach(fn func(Item) error) error {
+	return s.ExportEachContext(context.Background(), fn)
+}
+
+// ExportEachContext is ExportEach with a context that stops iteration, so
+// an export streamed to a client that goes away does not run to the end.
+func (s *Store) ExportEachContext(ctx context.Context, fn func(Item) error) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+selectItemColumns("i")+`,
+			(SELECT group_concat(tag, char(31)) FROM item_tags WHERE item_id = i.id)
+		FROM items i
+		WHERE i.deleted_at IS NULL
+		ORDER BY i.created_at ASC, i.id ASC
+	`)
+	if err != nil {
+		return fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tags *string
+		item, err := scanItemRow(rows, &tags)
+		if err != nil {
+			return fmt.Errorf("scan: %w", err)
+		}
+		item.Tags = []string{}
+		if tags != nil {
+			item.Tags = strings.Split(*tags, tagSeparator)
+			sort.Strings(item.Tags)
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ExportAll returns every live item ordered by creation time.
+func (s *Store) ExportAll() ([]Item, error) {
+	items := []Item{}
+	err := s.ExportEach(func(item Item) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"os"
+	"testing"
+)
+
+func TestExportAll(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-export-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	link := "https://example.com"
+	first, _ := s.Create("First", "one", &link, []string{"b", "a"}, "")
+	second, _ := s.Create("Second", "two", nil, nil, "")
+	trashed, _ := s.Create("Trashed", "gone", nil, nil, "")
+	s.Delete(trashed.ID)
+
+	// Force a known creation order independent of clock resolution
+	s.db.Exec("UPDATE items SET created_at = '2020-01-01T00:00:00Z' WHERE id = ?", second.ID)
+	s.db.Exec("UPDATE items SET created_at = '2021-01-01T00:00:00Z' WHERE id = ?", first.ID)
+
+	items, err := s.ExportAll()
+	if err != nil {
+		t.Fatalf("ExportAll: %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("len = %d, want 2", len(items))
+	}
+	if items[0].ID != second.ID || items[1].ID != first.ID {
+		t.Errorf("order = [%s %s], want created_at ascending", items[0].Title, items[1].Title)
+	}
+	if len(items[1].Tags) != 2 || items[1].Tags[0] != "a" || items[1].Tags[1] != "b" {
+		t.Errorf("tags = %q, want [a b]", items[1].Tags)
+	}
+	if items[0].Tags == nil {
+		t.Error("untagged item should export an empty tags slice")
+	}
+	if items[1].Link == nil || *items[1].Link != link {
+		t.Errorf("link = %v, want %q", items[1].Link, link)
+	}
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migrateV12 adds the table that remembers which item each Idempotency-Key
+// created. Keys are scoped to the owner, so clients cannot see each other's
+// items by guessing keys.
+func migrateV12(db *sql.DB) error {
+	schema := `
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+			owner TEXT NOT NULL,
+			key TEXT NOT NULL,
+			item_id TEXT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+			created_at TEXT NOT NULL,
+			PRIMARY KEY (owner, key)
+		);
+	`
+	_, err := db.Exec(schema)
+	return err
+}
+
+// IdempotentItemContext returns the live item that createdBy created with
+// key within the last window, or sql.ErrNoRows if there is none.
+func (s *Store) IdempotentItemContext(ctx context.Context, createdBy, key string, window time.Duration) (*Item, error) {
+	id, err := idempotentItemID(ctx, s.db, createdBy, key, s.now().Add(-window))
+	if err != nil {
+		return nil, err
+	}
+	return s.GetContext(ctx, id)
+}
+
+// CreateIdempotentContext is CreateWithColorContext for a request carrying
+// an Idempotency-Key. If createdBy already created a live item with key within
+// the last window, that item is returned with created false and nothing is
+// written; otherwise the item is created and the key recorded with it.
+// Expired keys are purged on the way.
+func (s *Store) CreateIdempotentContext(ctx context.Context, key string, window time.Duration, title, content string, link *string, tags []string, createdBy, color string) (item *Item, created bool, err error) {
+	if !ValidColor(color) {
+		return nil, false, ErrInvalidColor
+	}
+	if createdBy == "" {
+		createdBy = DefaultOwner
+	}
+
+	// Transactions take the write lock up front (_txlock=immediate), so a
+	// concurrent retry waits here and then sees the first request's key.
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	id, err := idempotentItemID(ctx, tx, createdBy, key, s.now().Add(-window))
+	if err == nil {
+		tx.Rollback()
+		item, err := s.GetContext(ctx, id)
+		return item, false, err
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, err
+	}
+
+	cutoff := s.now().Add(-window).Format(time.RFC3339)
+	if _, err := tx.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE created_at <= ?", cutoff); err != nil {
+		return nil, false, fmt.Errorf("purge idempotency keys: %w", err)
+	}
+
+	item, err = s.insertItem(ctx, tx, title, content, link, tags, createdBy, color)
+	if err != nil {
+		return nil, false, err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT OR REPLACE INTO idempotency_keys (owner, key, item_id, created_at) VALUES (?, ?, ?, ?)",
+		createdBy, key, item.ID, item.CreatedAt.Format(time.RFC3339),
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("record idempotency key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("commit: %w", err)
+	}
+	s.publish(EventCreated, item.ID, item.Title)
+	return item, true, nil
+}
+
+// queryRower is the QueryRowContext method shared by *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// idempotentItemID looks up a key recorded after cutoff whose item is
+// still live.
+func idempotentItemID(ctx context.Context, q queryRower, createdBy, key string, cutoff time.Time) (string, error) {
+	if createdBy == "" {
+		createdBy = DefaultOwner
+	}
+	var id string
+	err := q.QueryRowContext(ctx, `
+		SELECT k.item_id FROM idempotency_keys k JOIN items i ON i.id = k.item_id
+		WHERE k.owner = ? AND k.key = ? AND k.created_at > ? AND i.deleted_at IS NULL
+	`, createdBy, key, cutoff.Format(time.RFC3339)).Scan(&id)
+	return id, err
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCreateIdempotent(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-idempotency-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, err := New(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	const window = time.Hour
+
+	first, created, err := s.CreateIdempotentContext(ctx, "k1", window, "Note", "body", nil, []string{"a"}, "alice", "")
+	if err != nil || !created {
+		t.Fatalf("first create: created = %v, err = %v", created, err)
+	}
+
+	// A repeat returns the original even with a different body
+	again, created, err := s.CreateIdempotentContext(ctx, "k1", window, "Other", "other", nil, nil, "alice", "")
+	if err != nil || created || again.ID != first.ID || again.Title != "Note" {
+		t.Errorf("repeat: item = %+v, created = %v, err = %v; want original", again, created, err)
+	}
+	if got, err := s.IdempotentItemContext(ctx, "alice", "k1", window); err != nil || got.ID != first.ID {
+		t.Errorf("IdempotentItemContext = %v, %v; want original", got, err)
+	}
+
+	// Keys are per owner
+	if _, err := s.IdempotentItemContext(ctx, "bob", "k1", window); err != sql.ErrNoRows {
+		t.Errorf("other owner: err = %v, want sql.ErrNoRows", err)
+	}
+
+	second, created, err := s.CreateIdempotentContext(ctx, "k2", window, "Second", "body", nil, nil, "alice", "")
+	if err != nil || !created || second.ID == first.ID {
+		t.Errorf("distinct key: item = %+v, created = %v, err = %v; want a new item", second, created, err)
+	}
+
+	// An expired key no longer matches and is replaced by the next create
+	old := time.Now().UTC().Add(-2 * window).Format(time.RFC3339)
+	if _, err := s.db.Exec("UPDATE idempotency_keys SET created_at = ? WHERE key = 'k1'", old); err != nil {
+		t.Fatal(err)
+	}
+	third, created, err := s.CreateIdempotentContext(ctx, "k1", window, "Third", "body", nil, nil, "alice", "")
+	if err != nil || !created || third.ID == first.ID {
+		t.Errorf("expired key: item = %+v, created = %v, err = %v; want a new item", third, created, err)
+	}
+
+	// Trashing the item releases its key
+	if err := s.Delete(third.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.IdempotentItemContext(ctx, "alice", "k1", window); err != sql.ErrNoRows {
+		t.Errorf("trashed item: err = %v, want sql.ErrNoRows", err)
+	}
+}
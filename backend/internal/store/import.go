@@ -0,0 +1,214 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ConflictMode controls what ImportItems does when an imported item's title
+// already exists.
+type ConflictMode string
+
+const (
+	ConflictSkip    ConflictMode = "skip"    // Keep the existing item
+	ConflictReplace ConflictMode = "replace" // Overwrite the existing item
+	ConflictFail    ConflictMode = "fail"    // Abort and roll back the import
+)
+
+// ErrImportConflict is returned by ImportItems in ConflictFail mode.
+var ErrImportConflict = errors.New("title already exists")
+
+// ErrImportQuota is returned by ImportItemsWithQuota when the import would
+// take an owner past the quota.
+var ErrImportQuota = errors.New("item quota exceeded")
+
+// ImportResult reports what ImportItems did.
+type ImportResult struct {
+	Created  int `json:"created"`
+	Skipped  int `json:"skipped"`
+	Replaced int `json:"replaced"`
+}
+
+// ImportItems inserts items in a single transaction. IDs and timestamps are
+// preserved when present and unused; otherwise new ones are assigned. Title
+// conflicts (including with trashed items) are resolved according to mode.
+// A replaced item is edited like Update: its prior content is kept as a
+// version and its updated_at becomes the import time. Created items are owned
+// by their CreatedBy, or DefaultOwner when it is empty; replaced items keep
+// their owner. Events are published once the import commits.
+func (s *Store) ImportItems(items []Item, mode ConflictMode) (ImportResult, error) {
+	return s.importItems(items, mode, 0, false)
+}
+
+// DryRunImport reports what ImportItems would do, including any
+// ErrImportConflict, by running the import in a transaction that is always
+// rolled back.
+func (s *Store) DryRunImport(items []Item, mode ConflictMode) (ImportResult, error) {
+	return s.importItems(items, mode, 0, true)
+}
+
+// ImportItemsWithQuota is ImportItems, except that it rolls back with
+// ErrImportQuota if any owner of created items would end up with more than
+// quota live items, counted like CountByUser. Skipped and replaced items
+// don't count. A non-positive quota imposes no limit.
+func (s *Store) ImportItemsWithQuota(items []Item, mode ConflictMode, quota int) (ImportResult, error) {
+	return s.importItems(items, mode, quota, false)
+}
+
+// DryRunImportWithQuota is DryRunImport with the quota check of
+// ImportItemsWithQuota.
+func (s *Store) DryRunImportWithQuota(items []Item, mode ConflictMode, quota int) (ImportResult, error) {
+	return s.importItems(items, mode, quota, true)
+}
+
+func (s *Store) importItems(items []Item, mode ConflictMode, quota int, dryRun bool) (ImportResult, error) {
+	var res ImportResult
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return res, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := s.now()
+	nowStr := now.Format(time.RFC3339)
+	var events []ItemEvent
+	created := make(map[string]int) // Items created per owner
+	for _, item := range items {
+		item.Title = CleanTitle(item.Title)
+		if item.Title == "" {
+			return ImportResult{}, errors.New("title is required")
+		}
+
+		createdAt, updatedAt := item.CreatedAt, item.UpdatedAt
+		if createdAt.IsZero() {
+			createdAt = now
+		}
+		if updatedAt.IsZero() {
+			updatedAt = createdAt
+		}
+		tags := normalizeTags(item.Tags)
+		owner := item.CreatedBy
+		if owner == "" {
+			owner = DefaultOwner
+		}
+
+		var existingID string
+		err := tx.QueryRow("SELECT id FROM items WHERE title = ? COLLATE NOCASE", item.Title).Scan(&existingID)
+		switch {
+		case err == sql.ErrNoRows:
+			id, err := importID(tx, item.ID)
+			if err != nil {
+				return ImportResult{}, err
+			}
+			_, err = tx.Exec(
+				"INSERT INTO items (id, title, link, link_key, content, content_hash, created_by, created_at, updated_at, changed_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+				id, item.Title, item.Link, linkKey(item.Link), item.Content, ContentHash(item.Content), owner,
+				createdAt.UTC().Format(time.RFC3339), updatedAt.UTC().Format(time.RFC3339), now.Format(time.RFC3339),
+			)
+			if err != nil {
+				return ImportResult{}, fmt.Errorf("insert: %w", err)
+			}
+			if err := setTags(tx, id, tags); err != nil {
+				return ImportResult{}, err
+			}
+			if err := updateReferences(context.Background(), tx, id, item.Title, item.Content); err != nil {
+				return ImportResult{}, err
+			}
+			events = append(events, ItemEvent{Type: EventCreated, ItemID: id, Title: item.Title})
+			created[owner]++
+			res.Created++
+
+		case err != nil:
+			return ImportResult{}, fmt.Errorf("lookup title: %w", err)
+
+		case mode == ConflictFail:
+			return ImportResult{}, fmt.Errorf("%w: %q", ErrImportConflict, item.Title)
+
+		case mode == ConflictReplace:
+			if err := s.snapshotVersion(tx, existingID, nowStr); err != nil {
+				return ImportResult{}, err
+			}
+			_, err = tx.Exec(
+				"UPDATE items SET link = ?, link_key = ?, content = ?, content_hash = ?, created_at = ?, updated_at = ?, changed_at = ?, deleted_at = NULL, rev = rev + 1 WHERE id = ?",
+				item.Link, linkKey(item.Link), item.Content, ContentHash(item.Content),
+				createdAt.UTC().Format(time.RFC3339), nowStr, nowStr, existingID,
+			)
+			if err != nil {
+				return ImportResult{}, fmt.Errorf("replace: %w", err)
+			}
+			if _, err := tx.Exec("DELETE FROM item_tags WHERE item_id = ?", existingID); err != nil {
+				return ImportResult{}, fmt.Errorf("clear tags: %w", err)
+			}
+			if err := setTags(tx, existingID, tags); err != nil {
+				return ImportResult{}, err
+			}
+			var title string
+			if err := tx.QueryRow("SELECT title FROM items WHERE id = ?", existingID).Scan(&title); err != nil {
+				return ImportResult{}, fmt.Errorf("read title: %w", err)
+			}
+			if err := updateReferences(context.Background(), tx, existingID, title, item.Content); err != nil {
+				return ImportResult{}, err
+			}
+			events = append(events, ItemEvent{Type: EventUpdated, ItemID: existingID, Title: title})
+			res.Replaced++
+
+		default:
+			res.Skipped++
+		}
+	}
+
+	if quota > 0 {
+		if err := checkImportQuota(tx, created, quota); err != nil {
+			return ImportResult{}, err
+		}
+	}
+
+	if dryRun {
+		return res, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return ImportResult{}, fmt.Errorf("commit: %w", err)
+	}
+	for _, ev := range events {
+		s.publish(ev.Type, ev.ItemID, ev.Title)
+	}
+	return res, nil
+}
+
+// checkImportQuota fails with ErrImportQuota if an owner in created now has
+// more than quota live items. It runs after the inserts, inside the import's
+// transaction, so the count includes them and cannot race another write.
+func checkImportQuota(tx *sql.Tx, created map[string]int, quota int) error {
+	for owner, adding := range created {
+		where, args := listFilter(ListOptions{CreatedBy: owner})
+		var n int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM items WHERE "+where, args...).Scan(&n); err != nil {
+			return fmt.Errorf("count: %w", err)
+		}
+		if n > quota {
+			return fmt.Errorf("%w: %d of %d items used, %d more requested", ErrImportQuota, n-adding, quota, adding)
+		}
+	}
+	return nil
+}
+
+// importID returns id if it is set and not already taken, or a fresh UUID.
+func importID(tx *sql.Tx, id string) (string, error) {
+	if id == "" {
+		return uuid.New().String(), nil
+	}
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM items WHERE id = ?", id).Scan(&n); err != nil {
+		return "", fmt.Errorf("lookup id: %w", err)
+	}
+	if n > 0 {
+		return uuid.New().String(), nil
+	}
+	return id, nil
+}
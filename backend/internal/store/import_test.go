@@ -0,0 +1,175 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestImportItems(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-import-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	existing, _ := s.Create("Existing", "original", nil, []string{"old"}, "")
+
+	created := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	batch := []Item{
+		{ID: "import-1", Title: "Fresh", Content: "imported", Tags: []string{"new"}, CreatedAt: created, UpdatedAt: created},
+		{Title: "Existing", Content: "from import", Tags: []string{"imported"}},
+	}
+
+	t.Run("Skip", func(t *testing.T) {
+		res, err := s.ImportItems(batch, ConflictSkip)
+		if err != nil {
+			t.Fatalf("ImportItems: %v", err)
+		}
+		if res != (ImportResult{Created: 1, Skipped: 1}) {
+			t.Errorf("result = %+v", res)
+		}
+		fresh, err := s.Get("import-1")
+		if err != nil {
+			t.Fatalf("Get imported: %v", err)
+		}
+		if !fresh.CreatedAt.Equal(created) {
+			t.Errorf("createdAt = %v, want %v", fresh.CreatedAt, created)
+		}
+		got, _ := s.Get(existing.ID)
+		if got.Content != "original" {
+			t.Errorf("content = %q, want original", got.Content)
+		}
+		results, _ := s.Search("imported", 10, 0)
+		if len(results) != 1 {
+			t.Errorf("search len = %d, want 1", len(results))
+		}
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		events, unsubscribe := s.Subscribe()
+		defer unsubscribe()
+		start := time.Now().Add(-time.Second)
+
+		res, err := s.ImportItems(batch, ConflictReplace)
+		if err != nil {
+			t.Fatalf("ImportItems: %v", err)
+		}
+		if res != (ImportResult{Replaced: 2}) {
+			t.Errorf("result = %+v", res)
+		}
+		got, _ := s.Get(existing.ID)
+		if got.Content != "from import" {
+			t.Errorf("content = %q, want %q", got.Content, "from import")
+		}
+		if len(got.Tags) != 1 || got.Tags[0] != "imported" {
+			t.Errorf("tags = %q, want [imported]", got.Tags)
+		}
+
+		// Replacing is an edit: the old content is kept as a version, and
+		// the item moves forward for sync even if the import is older
+		versions, _ := s.ListVersions(existing.ID)
+		if len(versions) != 1 || versions[0].Content != "original" {
+			t.Errorf("versions = %+v, want the original content", versions)
+		}
+		fresh, _ := s.Get("import-1")
+		if fresh.UpdatedAt.Before(start) {
+			t.Errorf("replaced updatedAt = %v, want the import time", fresh.UpdatedAt)
+		}
+		if changed, _ := s.ListSince(start, 10, 0); len(changed) != 2 {
+			t.Errorf("ListSince after replace = %d items, want 2", len(changed))
+		}
+		for range 2 {
+			select {
+			case ev := <-events:
+				if ev.Type != EventUpdated {
+					t.Errorf("event = %+v, want %q", ev, EventUpdated)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("timed out waiting for replace events")
+			}
+		}
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		before, _ := s.Count()
+		dry := append(batch, Item{Title: "Brand New", Content: "never saved"})
+		res, err := s.DryRunImport(dry, ConflictReplace)
+		if err != nil {
+			t.Fatalf("DryRunImport: %v", err)
+		}
+		if res != (ImportResult{Created: 1, Replaced: 2}) {
+			t.Errorf("result = %+v", res)
+		}
+		if n, _ := s.Count(); n != before {
+			t.Errorf("count = %d, want %d", n, before)
+		}
+		if _, err := s.GetByTitle("Brand New"); err == nil {
+			t.Error("dry run should not persist new items")
+		}
+		if got, _ := s.Get(existing.ID); got.Rev != 2 {
+			t.Errorf("rev = %d, dry run should not replace", got.Rev)
+		}
+
+		if _, err := s.DryRunImport(dry, ConflictFail); !errors.Is(err, ErrImportConflict) {
+			t.Errorf("fail mode err = %v, want ErrImportConflict", err)
+		}
+	})
+
+	t.Run("FailRollsBack", func(t *testing.T) {
+		_, err := s.ImportItems([]Item{
+			{Title: "Would Be New", Content: "x"},
+			{Title: "Existing", Content: "y"},
+		}, ConflictFail)
+		if !errors.Is(err, ErrImportConflict) {
+			t.Fatalf("err = %v, want ErrImportConflict", err)
+		}
+		if _, err := s.GetByTitle("Would Be New"); err == nil {
+			t.Error("failed import should have been rolled back")
+		}
+	})
+
+	t.Run("Quota", func(t *testing.T) {
+		s.Create("Alice One", "", nil, nil, "alice")
+		batch := []Item{
+			{Title: "Alice One", CreatedBy: "alice"},
+			{Title: "Alice Two", CreatedBy: "alice"},
+			{Title: "Alice Three", CreatedBy: "alice"},
+		}
+		if _, err := s.ImportItemsWithQuota(batch, ConflictSkip, 2); !errors.Is(err, ErrImportQuota) {
+			t.Fatalf("over quota err = %v, want ErrImportQuota", err)
+		}
+		if _, err := s.DryRunImportWithQuota(batch, ConflictSkip, 2); !errors.Is(err, ErrImportQuota) {
+			t.Errorf("dry run over quota err = %v, want ErrImportQuota", err)
+		}
+		if n, _ := s.CountByUser("alice"); n != 1 {
+			t.Errorf("alice owns %d items after rejected import, want 1", n)
+		}
+
+		// The skipped title doesn't count, so two more fit in three
+		res, err := s.ImportItemsWithQuota(batch, ConflictSkip, 3)
+		if err != nil || res.Created != 2 || res.Skipped != 1 {
+			t.Fatalf("res = %+v, err = %v", res, err)
+		}
+		if n, _ := s.CountByUser("alice"); n != 3 {
+			t.Errorf("alice owns %d items, want 3", n)
+		}
+	})
+
+	t.Run("DuplicateIDGetsNewID", func(t *testing.T) {
+		res, err := s.ImportItems([]Item{{ID: existing.ID, Title: "Other Title"}}, ConflictSkip)
+		if err != nil || res.Created != 1 {
+			t.Fatalf("res = %+v, err = %v", res, err)
+		}
+		other, _ := s.GetByTitle("Other Title")
+		if other.ID == existing.ID {
+			t.Error("colliding ID should have been replaced")
+		}
+	})
+
+	if _, err := s.db.Exec("INSERT INTO items_fts(items_fts) VALUES('integrity-check')"); err != nil {
+		t.Errorf("fts integrity-check: %v", err)
+	}
+}
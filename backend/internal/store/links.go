@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// migrateV15 adds link_key, the normalized form of each item's link that
+// FindByLink matches on, and fills it in for existing items.
+func migrateV15(db *sql.DB) error {
+	if err := addColumnIfMissing(db, "items", "link_key", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_items_link_key ON items(link_key)"); err != nil {
+		return fmt.Errorf("create link key index: %w", err)
+	}
+
+	rows, err := db.Query("SELECT id, link FROM items WHERE link IS NOT NULL AND link_key IS NULL")
+	if err != nil {
+		return fmt.Errorf("query links: %w", err)
+	}
+	keys := make(map[string]string)
+	for rows.Next() {
+		var id, link string
+		if err := rows.Scan(&id, &link); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan link: %w", err)
+		}
+		if key := NormalizeLink(link); key != "" {
+			keys[id] = key
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, key := range keys {
+		if _, err := db.Exec("UPDATE items SET link_key = ? WHERE id = ?", key, id); err != nil {
+			return fmt.Errorf("backfill link key: %w", err)
+		}
+	}
+	return nil
+}
+
+// NormalizeLink returns the form of link that FindByLink compares: trimmed,
+// and for URLs with a host, the scheme and host lowercased and a trailing
+// slash dropped from the path. Other links, such as file paths, are only
+// trimmed.
+func NormalizeLink(link string) string {
+	link = strings.TrimSpace(link)
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return link
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = strings.TrimSuffix(u.RawPath, "/")
+	return u.String()
+}
+
+// linkKey is the link_key column value for link: NULL when there is no
+// link.
+func linkKey(link *string) any {
+	if link == nil {
+		return nil
+	}
+	if key := NormalizeLink(*link); key != "" {
+		return key
+	}
+	return nil
+}
+
+// FindByLink returns the live items whose link matches link after
+// NormalizeLink, most recently updated first. Unlike search, the whole URL
+// must match.
+func (s *Store) FindByLink(link string) ([]Item, error) {
+	return s.FindByLinkContext(context.Background(), link)
+}
+
+// FindByLinkContext is FindByLink with a context that cancels the query.
+func (s *Store) FindByLinkContext(ctx context.Context, link string) ([]Item, error) {
+	key := NormalizeLink(link)
+	if key == "" {
+		return []Item{}, nil
+	}
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+selectItemColumns("")+" FROM items WHERE link_key = ? AND deleted_at IS NULL ORDER BY "+sortClauses[SortUpdatedDesc],
+		key,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	items, err := scanItems(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadTags(ctx, items); err != nil {
+		return nil, err
+	}
+	if items == nil {
+		items = []Item{}
+	}
+	return items, nil
+}
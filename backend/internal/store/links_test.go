@@ -0,0 +1,60 @@
+package store
+
+import (
+	"os"
+	"testing"
+)
+
+func TestNormalizeLink(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"  https://Example.COM/Path/  ", "https://example.com/Path"},
+		{"HTTPS://example.com/", "https://example.com"},
+		{"https://example.com/a?q=1#frag", "https://example.com/a?q=1#frag"},
+		{"/home/me/notes/", "/home/me/notes/"},
+		{"  ", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeLink(tt.in); got != tt.want {
+			t.Errorf("NormalizeLink(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFindByLink(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-links-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	link := "https://Example.com/article/"
+	other := "https://example.com/article/comments"
+	first, _ := s.Create("First", "", &link, []string{"web"}, "")
+	s.Create("Other", "", &other, nil, "")
+	s.Create("No link", "https://example.com/article", nil, nil, "")
+
+	items, err := s.FindByLink("https://example.com/article")
+	if err != nil {
+		t.Fatalf("FindByLink: %v", err)
+	}
+	if len(items) != 1 || items[0].ID != first.ID || *items[0].Link != link || len(items[0].Tags) != 1 {
+		t.Errorf("FindByLink = %+v, want only %s with its link and tags", items, first.ID)
+	}
+
+	// Patching the link moves the item to the new URL
+	moved := "https://example.com/moved"
+	s.Patch(first.ID, PatchFields{SetLink: true, Link: &moved})
+	if items, _ := s.FindByLink(link); len(items) != 0 {
+		t.Errorf("old link = %d items, want 0", len(items))
+	}
+	if items, _ := s.FindByLink("https://EXAMPLE.com/moved/"); len(items) != 1 {
+		t.Errorf("new link = %d items, want 1", len(items))
+	}
+
+	if items, err := s.FindByLink("https://example.com/missing"); err != nil || items == nil || len(items) != 0 {
+		t.Errorf("no match = %v, %v, want an empty slice", items, err)
+	}
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// IntegrityCheck runs SQLite's integrity_check and the FTS5 integrity-check
+// command, then confirms items_fts indexes exactly the live items (trashed
+// items are deliberately left out, see migrateV3). It returns the problems
+// found, or nil if the database is healthy.
+func (s *Store) IntegrityCheck() ([]string, error) {
+	rows, err := s.db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, fmt.Errorf("integrity check: %w", err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return nil, fmt.Errorf("integrity check: %w", err)
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("integrity check: %w", err)
+	}
+
+	// FTS5 reports a damaged index as SQLITE_CORRUPT. Comparing against the
+	// content table (rank 1) would flag every trashed item, so membership
+	// is checked separately below.
+	_, err = s.db.Exec("INSERT INTO items_fts(items_fts) VALUES ('integrity-check')")
+	var sqliteErr sqlite3.Error
+	switch {
+	case errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrCorrupt:
+		problems = append(problems, "items_fts: "+err.Error())
+	case err != nil:
+		return nil, fmt.Errorf("fts integrity check: %w", err)
+	}
+
+	var missing, stale int
+	err = s.db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM items WHERE deleted_at IS NULL
+				AND rowid NOT IN (SELECT id FROM items_fts_docsize)),
+			(SELECT COUNT(*) FROM items_fts_docsize
+				WHERE id NOT IN (SELECT rowid FROM items WHERE deleted_at IS NULL))`,
+	).Scan(&missing, &stale)
+	if err != nil {
+		return nil, fmt.Errorf("fts membership check: %w", err)
+	}
+	if missing > 0 {
+		problems = append(problems, fmt.Sprintf("items_fts: live items missing from the index: %d", missing))
+	}
+	if stale > 0 {
+		problems = append(problems, fmt.Sprintf("items_fts: index entries for trashed or missing items: %d", stale))
+	}
+	return problems, nil
+}
+
+// RebuildFTS regenerates items_fts from the items table, repairing an index
+// that has drifted after manual edits. Trashed items are dropped from the
+// rebuilt index to match the triggers. Returns the number of items indexed.
+func (s *Store) RebuildFTS() (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("INSERT INTO items_fts(items_fts) VALUES ('rebuild')"); err != nil {
+		return 0, fmt.Errorf("rebuild: %w", err)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO items_fts(items_fts, rowid, title, content, link)
+		SELECT 'delete', rowid, title, content, link FROM items WHERE deleted_at IS NOT NULL`)
+	if err != nil {
+		return 0, fmt.Errorf("unindex trashed: %w", err)
+	}
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM items WHERE deleted_at IS NULL").Scan(&n); err != nil {
+		return 0, fmt.Errorf("count: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return n, nil
+}
+
+// Vacuum rebuilds the database file to return the space left by deleted
+// rows, then checkpoints the WAL so the file on disk shrinks too. Writers
+// wait on the busy timeout while it runs and may fail on a large database,
+// so run it when the server is quiet. Compare Size before and after to see
+// what was reclaimed.
+func (s *Store) Vacuum() error {
+	if _, err := s.db.Exec("VACUUM"); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	if _, err := s.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	return nil
+}
+
+// SetTokenizer switches items_fts to TokenizerFolded or TokenizerExact,
+// rebuilding the index if it currently uses the other one. Call it after
+// New, before serving requests.
+func (s *Store) SetTokenizer(tokenize string) error {
+	if tokenize != TokenizerFolded && tokenize != TokenizerExact {
+		return fmt.Errorf("unknown tokenizer %q", tokenize)
+	}
+	var schema string
+	if err := s.db.QueryRow("SELECT sql FROM sqlite_master WHERE name = 'items_fts'").Scan(&schema); err != nil {
+		return fmt.Errorf("read fts schema: %w", err)
+	}
+	if strings.Contains(schema, "tokenize='"+tokenize+"'") {
+		return nil
+	}
+	return recreateFTS(s.db, tokenize)
+}
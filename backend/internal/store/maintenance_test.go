@@ -0,0 +1,174 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestIntegrityCheck(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-integrity-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	s.Create("Healthy", "indexed content", nil, []string{"ok"}, "")
+	trashed, _ := s.Create("Trashed", "still indexed", nil, nil, "")
+	s.Delete(trashed.ID)
+
+	problems, err := s.IntegrityCheck()
+	if err != nil {
+		t.Fatalf("IntegrityCheck: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("problems = %q, want none", problems)
+	}
+
+	// Removing an index entry out of band leaves the index out of step
+	unindex(t, s, "Healthy")
+	problems, err = s.IntegrityCheck()
+	if err != nil {
+		t.Fatalf("IntegrityCheck: %v", err)
+	}
+	if len(problems) != 1 || !strings.Contains(problems[0], "missing from the index: 1") {
+		t.Errorf("problems = %q, want the unindexed item reported", problems)
+	}
+}
+
+func TestRebuildFTS(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-reindex-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	s.Create("Drifted", "findable needle", nil, nil, "")
+	s.Create("Steady", "other text", nil, nil, "")
+	trashed, _ := s.Create("Trashed", "needle in the bin", nil, nil, "")
+	s.Delete(trashed.ID)
+
+	unindex(t, s, "Drifted")
+	if results, _ := s.Search("needle", 10, 0); len(results) != 0 {
+		t.Fatalf("search found %d results before rebuild, want 0", len(results))
+	}
+
+	n, err := s.RebuildFTS()
+	if err != nil {
+		t.Fatalf("RebuildFTS: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("reindexed %d items, want 2", n)
+	}
+	results, _ := s.Search("needle", 10, 0)
+	if len(results) != 1 || results[0].Item.Title != "Drifted" {
+		t.Errorf("results = %v, want [Drifted]", results)
+	}
+	if problems, _ := s.IntegrityCheck(); len(problems) != 0 {
+		t.Errorf("problems after rebuild = %q", problems)
+	}
+
+	// The trashed item stays out of the index and restores cleanly
+	if _, err := s.Restore(trashed.ID); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if results, _ := s.Search("needle", 10, 0); len(results) != 2 {
+		t.Errorf("after restore: %d results, want 2", len(results))
+	}
+	if problems, _ := s.IntegrityCheck(); len(problems) != 0 {
+		t.Errorf("problems after restore = %q", problems)
+	}
+}
+
+func TestSetTokenizer(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-tokenizer-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	s.Create("Café", "", nil, nil, "")
+	count := func(q string) int {
+		results, _ := s.Search(q, 10, 0)
+		return len(results)
+	}
+	if count("cafe") != 1 {
+		t.Fatal("new stores should fold diacritics")
+	}
+
+	if err := s.SetTokenizer(TokenizerExact); err != nil {
+		t.Fatalf("SetTokenizer(exact): %v", err)
+	}
+	if count("cafe") != 0 || count("café") != 1 {
+		t.Errorf("exact: cafe = %d, café = %d results, want 0 and 1", count("cafe"), count("café"))
+	}
+	// Setting the current tokenizer again is a no-op
+	if err := s.SetTokenizer(TokenizerExact); err != nil {
+		t.Fatalf("SetTokenizer(exact) again: %v", err)
+	}
+
+	if err := s.SetTokenizer(TokenizerFolded); err != nil {
+		t.Fatalf("SetTokenizer(folded): %v", err)
+	}
+	if count("cafe") != 1 {
+		t.Errorf("folded: cafe = %d results, want 1", count("cafe"))
+	}
+
+	if err := s.SetTokenizer("porter"); err == nil {
+		t.Error("expected error for unknown tokenizer")
+	}
+}
+
+// unindex deletes the FTS entry for the item titled title, bypassing the
+// triggers that keep items_fts in step with items.
+func unindex(t *testing.T, s *Store, title string) {
+	t.Helper()
+	_, err := s.db.Exec(`
+		INSERT INTO items_fts(items_fts, rowid, title, content, link)
+		SELECT 'delete', rowid, title, content, link FROM items WHERE title = ?`, title)
+	if err != nil {
+		t.Fatalf("unindex: %v", err)
+	}
+}
+
+func TestVacuum(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-vacuum-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	body := strings.Repeat("filler text ", 500)
+	var ids []string
+	for i := 0; i < 200; i++ {
+		item, err := s.Create(fmt.Sprintf("Item %d", i), body, nil, nil, "")
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		ids = append(ids, item.ID)
+	}
+	if _, err := s.DeleteMany(ids); err != nil {
+		t.Fatalf("DeleteMany: %v", err)
+	}
+	if _, err := s.PurgeDeleted(0); err != nil {
+		t.Fatalf("PurgeDeleted: %v", err)
+	}
+
+	before, _ := s.Size(context.Background())
+	if err := s.Vacuum(); err != nil {
+		t.Fatalf("Vacuum: %v", err)
+	}
+	after, _ := s.Size(context.Background())
+	if after >= before {
+		t.Errorf("size after vacuum = %d, want below %d", after, before)
+	}
+	if problems, err := s.IntegrityCheck(); err != nil || len(problems) != 0 {
+		t.Errorf("IntegrityCheck after vacuum = %v, %v", problems, err)
+	}
+}
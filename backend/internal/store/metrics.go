@@ -0,0 +1,20 @@
+package store
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// storeOps counts calls to the store's mutating and search operations.
+var storeOps = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "cue_store_operations_total",
+	Help: "Store operations by type.",
+}, []string{"op"})
+
+var (
+	opCreate = storeOps.WithLabelValues("create")
+	opUpdate = storeOps.WithLabelValues("update")
+	opDelete = storeOps.WithLabelValues("delete")
+	opSearch = storeOps.WithLabelValues("search")
+)
+
+func init() {
+	prometheus.MustRegister(storeOps)
+}
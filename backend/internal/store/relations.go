@@ -0,0 +1,180 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// migrateV17 adds item_relations, typed links from one item to another.
+// Both ends cascade, so purging an item removes its relations.
+func migrateV17(db *sql.DB) error {
+	schema := `
+		CREATE TABLE IF NOT EXISTS item_relations (
+			from_id TEXT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+			to_id TEXT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+			relation_type TEXT NOT NULL,
+			created_at TEXT NOT NULL,
+			PRIMARY KEY (from_id, to_id, relation_type)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_item_relations_to ON item_relations(to_id);
+	`
+	_, err := db.Exec(schema)
+	return err
+}
+
+// DefaultRelationType is used when AddRelation is given no type.
+const DefaultRelationType = "related"
+
+// Relation is a typed link from one item to another.
+type Relation struct {
+	FromID    string    `json:"fromId"`
+	ToID      string    `json:"toId"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+var (
+	// ErrSelfRelation is returned when an item is related to itself.
+	ErrSelfRelation = errors.New("an item cannot be related to itself")
+	// ErrInvalidRelationType is returned for a type that is not 1-50
+	// lowercase letters, digits, "-", or "_".
+	ErrInvalidRelationType = errors.New("relation type must be 1-50 lowercase letters, digits, - or _")
+)
+
+var relationTypePattern = regexp.MustCompile(`^[a-z0-9_-]{1,50}$`)
+
+// normalizeRelationType trims and lowercases relType, falling back to
+// DefaultRelationType when it is empty.
+func normalizeRelationType(relType string) (string, error) {
+	relType = strings.ToLower(strings.TrimSpace(relType))
+	if relType == "" {
+		return DefaultRelationType, nil
+	}
+	if !relationTypePattern.MatchString(relType) {
+		return "", ErrInvalidRelationType
+	}
+	return relType, nil
+}
+
+// AddRelation relates fromID to toID with relType (DefaultRelationType if
+// empty). Adding a relation that already exists returns it unchanged with
+// created false. Returns sql.ErrNoRows unless both items are live.
+func (s *Store) AddRelation(fromID, toID, relType string) (rel *Relation, created bool, err error) {
+	return s.AddRelationContext(context.Background(), fromID, toID, relType)
+}
+
+// AddRelationContext is AddRelation with a context that cancels the write.
+func (s *Store) AddRelationContext(ctx context.Context, fromID, toID, relType string) (rel *Relation, created bool, err error) {
+	relType, err = normalizeRelationType(relType)
+	if err != nil {
+		return nil, false, err
+	}
+	if fromID == toID {
+		return nil, false, ErrSelfRelation
+	}
+
+	// Both items must be live; the foreign keys alone would accept trashed ones
+	var live int
+	err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM items WHERE id IN (?, ?) AND deleted_at IS NULL", fromID, toID).Scan(&live)
+	if err != nil {
+		return nil, false, fmt.Errorf("query: %w", err)
+	}
+	if live != 2 {
+		return nil, false, sql.ErrNoRows
+	}
+
+	now := s.now().Format(time.RFC3339)
+	result, err := s.db.ExecContext(ctx,
+		"INSERT OR IGNORE INTO item_relations (from_id, to_id, relation_type, created_at) VALUES (?, ?, ?, ?)",
+		fromID, toID, relType, now,
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("insert relation: %w", err)
+	}
+	n, _ := result.RowsAffected()
+
+	rel = &Relation{FromID: fromID, ToID: toID, Type: relType}
+	var createdAt string
+	err = s.db.QueryRowContext(ctx,
+		"SELECT created_at FROM item_relations WHERE from_id = ? AND to_id = ? AND relation_type = ?",
+		fromID, toID, relType,
+	).Scan(&createdAt)
+	if err != nil {
+		return nil, false, fmt.Errorf("query: %w", err)
+	}
+	rel.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	return rel, n > 0, nil
+}
+
+// RemoveRelation deletes the relation from fromID to toID with relType
+// (DefaultRelationType if empty), or returns sql.ErrNoRows if there is none.
+func (s *Store) RemoveRelation(fromID, toID, relType string) error {
+	return s.RemoveRelationContext(context.Background(), fromID, toID, relType)
+}
+
+// RemoveRelationContext is RemoveRelation with a context that cancels the
+// write.
+func (s *Store) RemoveRelationContext(ctx context.Context, fromID, toID, relType string) error {
+	relType, err := normalizeRelationType(relType)
+	if err != nil {
+		return err
+	}
+	result, err := s.db.ExecContext(ctx,
+		"DELETE FROM item_relations WHERE from_id = ? AND to_id = ? AND relation_type = ?",
+		fromID, toID, relType,
+	)
+	if err != nil {
+		return fmt.Errorf("delete relation: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListRelations returns the relations from and to a live item, oldest
+// first. Relations whose other end is in the trash are left out until it
+// is restored; purging it removes them. Returns sql.ErrNoRows if the item
+// is missing or trashed.
+func (s *Store) ListRelations(id string) ([]Relation, error) {
+	return s.ListRelationsContext(context.Background(), id)
+}
+
+// ListRelationsContext is ListRelations with a context that cancels the
+// query.
+func (s *Store) ListRelationsContext(ctx context.Context, id string) ([]Relation, error) {
+	var exists int
+	if err := s.db.QueryRowContext(ctx, "SELECT 1 FROM items WHERE id = ? AND deleted_at IS NULL", id).Scan(&exists); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT r.from_id, r.to_id, r.relation_type, r.created_at
+		FROM item_relations r
+		JOIN items other ON other.id = CASE WHEN r.from_id = ? THEN r.to_id ELSE r.from_id END
+		WHERE (r.from_id = ? OR r.to_id = ?) AND other.deleted_at IS NULL
+		ORDER BY r.created_at, r.rowid
+	`, id, id, id)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	relations := []Relation{}
+	for rows.Next() {
+		var rel Relation
+		var createdAt string
+		if err := rows.Scan(&rel.FromID, &rel.ToID, &rel.Type, &createdAt); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		rel.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		relations = append(relations, rel)
+	}
+	return relations, rows.Err()
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+)
+
+func TestRelations(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-relations-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	a, _ := s.Create("A", "", nil, nil, "")
+	b, _ := s.Create("B", "", nil, nil, "")
+	c, _ := s.Create("C", "", nil, nil, "")
+
+	rel, created, err := s.AddRelation(a.ID, b.ID, "")
+	if err != nil || !created {
+		t.Fatalf("AddRelation = %v, %v", created, err)
+	}
+	if rel.Type != DefaultRelationType || rel.FromID != a.ID || rel.ToID != b.ID || rel.CreatedAt.IsZero() {
+		t.Errorf("relation = %+v", rel)
+	}
+	if again, created, err := s.AddRelation(a.ID, b.ID, "related"); err != nil || created || !again.CreatedAt.Equal(rel.CreatedAt) {
+		t.Errorf("re-adding an existing relation = %+v, %v, %v", again, created, err)
+	}
+	if _, _, err := s.AddRelation(c.ID, a.ID, " Depends-On "); err != nil {
+		t.Fatalf("AddRelation typed: %v", err)
+	}
+
+	if _, _, err := s.AddRelation(a.ID, a.ID, ""); err != ErrSelfRelation {
+		t.Errorf("self relation err = %v, want ErrSelfRelation", err)
+	}
+	if _, _, err := s.AddRelation(a.ID, b.ID, "no spaces"); err != ErrInvalidRelationType {
+		t.Errorf("bad type err = %v, want ErrInvalidRelationType", err)
+	}
+	if _, _, err := s.AddRelation(a.ID, "missing", ""); err != sql.ErrNoRows {
+		t.Errorf("missing target err = %v, want sql.ErrNoRows", err)
+	}
+
+	// A sees its outgoing and incoming relations
+	rels, err := s.ListRelations(a.ID)
+	if err != nil {
+		t.Fatalf("ListRelations: %v", err)
+	}
+	if len(rels) != 2 || rels[0].ToID != b.ID || rels[1].FromID != c.ID || rels[1].Type != "depends-on" {
+		t.Errorf("relations of A = %+v", rels)
+	}
+	if _, err := s.ListRelations("missing"); err != sql.ErrNoRows {
+		t.Errorf("ListRelations(missing) err = %v, want sql.ErrNoRows", err)
+	}
+
+	if err := s.RemoveRelation(c.ID, a.ID, "depends-on"); err != nil {
+		t.Errorf("RemoveRelation: %v", err)
+	}
+	if err := s.RemoveRelation(c.ID, a.ID, "depends-on"); err != sql.ErrNoRows {
+		t.Errorf("removing again err = %v, want sql.ErrNoRows", err)
+	}
+
+	// Trashing hides the relation; purging removes it through the cascade
+	s.Delete(b.ID)
+	if rels, _ := s.ListRelations(a.ID); len(rels) != 0 {
+		t.Errorf("relations with B trashed = %+v, want none", rels)
+	}
+	s.Restore(b.ID)
+	if rels, _ := s.ListRelations(a.ID); len(rels) != 1 {
+		t.Errorf("relations with B restored = %+v, want 1", rels)
+	}
+	s.Delete(b.ID)
+	s.PurgeDeleted(0)
+	var n int
+	s.db.QueryRow("SELECT COUNT(*) FROM item_relations").Scan(&n)
+	if n != 0 {
+		t.Errorf("item_relations rows after purge = %d, want 0", n)
+	}
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// StatsDays is how many days CorpusStats.CreatedPerDay covers.
+const StatsDays = 30
+
+// StatsTopTags is how many tags CorpusStats.TopTags lists.
+const StatsTopTags = 10
+
+// DayCount is the number of items created on one UTC day.
+type DayCount struct {
+	Date  string `json:"date"` // YYYY-MM-DD
+	Count int    `json:"count"`
+}
+
+// TagCount is the number of live items carrying a tag.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// CorpusStats summarizes the live items.
+type CorpusStats struct {
+	Items            int        `json:"items"`
+	ContentBytes     int64      `json:"content_bytes"`
+	AvgContentLength float64    `json:"avg_content_length"` // Bytes per item; 0 with no items
+	CreatedPerDay    []DayCount `json:"created_per_day"`    // The StatsDays days up to now, oldest first, with zero days included
+	TopTags          []TagCount `json:"top_tags"`           // Up to StatsTopTags, most used first
+}
+
+// CorpusStats aggregates the live items as of now: totals, the creation
+// histogram for the StatsDays UTC days ending on now's day, and the most
+// used tags. Trashed items are not counted.
+func (s *Store) CorpusStats(now time.Time) (*CorpusStats, error) {
+	return s.CorpusStatsContext(context.Background(), now)
+}
+
+// CorpusStatsContext is CorpusStats with a context that cancels the
+// queries.
+func (s *Store) CorpusStatsContext(ctx context.Context, now time.Time) (*CorpusStats, error) {
+	stats := &CorpusStats{CreatedPerDay: []DayCount{}, TopTags: []TagCount{}}
+
+	err := s.db.QueryRowContext(ctx,
+		"SELECT COUNT(*), COALESCE(SUM(length(CAST(content AS BLOB))), 0) FROM items WHERE deleted_at IS NULL",
+	).Scan(&stats.Items, &stats.ContentBytes)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	if stats.Items > 0 {
+		stats.AvgContentLength = float64(stats.ContentBytes) / float64(stats.Items)
+	}
+
+	// created_at is RFC3339 in UTC, so its first ten characters are the day
+	today := now.UTC().Truncate(24 * time.Hour)
+	first := today.AddDate(0, 0, -(StatsDays - 1))
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT substr(created_at, 1, 10) AS day, COUNT(*)
+		FROM items
+		WHERE deleted_at IS NULL AND created_at >= ? AND created_at < ?
+		GROUP BY day
+	`, first.Format(time.RFC3339), today.AddDate(0, 0, 1).Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	perDay := make(map[string]int)
+	for rows.Next() {
+		var day string
+		var n int
+		if err := rows.Scan(&day, &n); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		perDay[day] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for d := first; !d.After(today); d = d.AddDate(0, 0, 1) {
+		day := d.Format(time.DateOnly)
+		stats.CreatedPerDay = append(stats.CreatedPerDay, DayCount{Date: day, Count: perDay[day]})
+	}
+
+	rows, err = s.db.QueryContext(ctx, `
+		SELECT t.tag, COUNT(*) AS n
+		FROM item_tags t JOIN items i ON i.id = t.item_id
+		WHERE i.deleted_at IS NULL
+		GROUP BY t.tag
+		ORDER BY n DESC, t.tag
+		LIMIT ?
+	`, StatsTopTags)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tc TagCount
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		stats.TopTags = append(stats.TopTags, tc)
+	}
+	return stats, rows.Err()
+}
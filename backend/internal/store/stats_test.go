@@ -0,0 +1,65 @@
+package store
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCorpusStats(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-stats-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+	at := func(s string) time.Time {
+		ts, _ := time.Parse(time.RFC3339, s)
+		return ts
+	}
+	_, err := s.ImportItems([]Item{
+		{Title: "Today early", Content: "1234", Tags: []string{"go", "db"}, CreatedAt: at("2026-03-15T00:30:00Z")},
+		{Title: "Today late", Content: "12345678", Tags: []string{"go"}, CreatedAt: at("2026-03-15T11:00:00Z")},
+		{Title: "Yesterday", Content: "café", Tags: []string{"go"}, CreatedAt: at("2026-03-14T23:59:59Z")},
+		{Title: "First day", Content: "", Tags: []string{"db"}, CreatedAt: at("2026-02-14T00:00:00Z")},
+		{Title: "Too old", Content: "12", CreatedAt: at("2026-02-13T23:59:59Z")},
+		{Title: "Trashed", Content: "trashed content", Tags: []string{"db", "old"}, CreatedAt: at("2026-03-10T08:00:00Z")},
+	}, ConflictFail)
+	if err != nil {
+		t.Fatalf("ImportItems: %v", err)
+	}
+	trashed, _ := s.GetByTitle("Trashed")
+	s.Delete(trashed.ID)
+
+	stats, err := s.CorpusStats(now)
+	if err != nil {
+		t.Fatalf("CorpusStats: %v", err)
+	}
+
+	// "café" is 5 bytes
+	if stats.Items != 5 || stats.ContentBytes != 19 || stats.AvgContentLength != 3.8 {
+		t.Errorf("totals = %d items, %d bytes, %v average; want 5, 19, 3.8", stats.Items, stats.ContentBytes, stats.AvgContentLength)
+	}
+
+	days := stats.CreatedPerDay
+	if len(days) != StatsDays {
+		t.Fatalf("len(CreatedPerDay) = %d, want %d", len(days), StatsDays)
+	}
+	if days[0] != (DayCount{"2026-02-14", 1}) || days[StatsDays-2] != (DayCount{"2026-03-14", 1}) || days[StatsDays-1] != (DayCount{"2026-03-15", 2}) {
+		t.Errorf("buckets = first %+v, yesterday %+v, today %+v", days[0], days[StatsDays-2], days[StatsDays-1])
+	}
+	total := 0
+	for _, d := range days {
+		total += d.Count
+	}
+	if total != 4 {
+		t.Errorf("histogram total = %d, want 4 (the old and trashed items are left out)", total)
+	}
+
+	want := []TagCount{{"go", 3}, {"db", 2}}
+	if len(stats.TopTags) != len(want) || stats.TopTags[0] != want[0] || stats.TopTags[1] != want[1] {
+		t.Errorf("TopTags = %+v, want %+v", stats.TopTags, want)
+	}
+}
@@ -1,48 +1,196 @@
 package store
 
 import (
+	"context"
 	cryptoRand "crypto/rand"
 	"database/sql"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 	"unicode"
 
+	"github.com/alanp/cue/internal/clock"
 	"github.com/google/uuid"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 type Item struct {
-	ID        string    `json:"id"`
-	Title     string    `json:"title"`
-	Link      *string   `json:"link,omitempty"` // Optional primary link (URL or file path)
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID        string     `json:"id"`
+	Title     string     `json:"title"`
+	Link      *string    `json:"link,omitempty"` // Optional primary link (URL or file path)
+	Content   string     `json:"content"`
+	Tags      []string   `json:"tags"`
+	Rev       int        `json:"rev"`       // Incremented by every Update; used for lost-update detection
+	CreatedBy string     `json:"createdBy"` // CN of the creating user (DefaultOwner in single-user mode)
+	Pinned    bool       `json:"pinned"`    // Listed ahead of unpinned items in the default order
+	Archived  bool       `json:"archived"`  // Hidden from the default list, but not trashed
+	Color     string     `json:"color"`     // One of Colors, or empty for none
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	ChangedAt time.Time  `json:"changedAt"`           // Last change of any kind, including pin, archive, color, and trash; the ?since= sync position
+	DeletedAt *time.Time `json:"deletedAt,omitempty"` // Set while the item is in the trash
+}
+
+// itemColumns lists the items columns read by scanItemRow, in scan order.
+var itemColumns = []string{"id", "title", "link", "content", "rev", "created_by", "pinned", "archived", "color", "created_at", "updated_at", "changed_at", "deleted_at"}
+
+// selectItemColumns returns itemColumns as a SELECT list, optionally
+// qualified with a table alias (e.g. "i").
+func selectItemColumns(alias string) string {
+	if alias == "" {
+		return strings.Join(itemColumns, ", ")
+	}
+	cols := make([]string, len(itemColumns))
+	for i, c := range itemColumns {
+		cols[i] = alias + "." + c
+	}
+	return strings.Join(cols, ", ")
+}
+
+// selectItemColumnsNoContent is selectItemColumns with content read as an
+// empty string, for results that don't need it. alias must be set.
+func selectItemColumnsNoContent(alias string) string {
+	cols := make([]string, len(itemColumns))
+	for i, c := range itemColumns {
+		cols[i] = alias + "." + c
+		if c == "content" {
+			cols[i] = "''"
+		}
+	}
+	return strings.Join(cols, ", ")
 }
 
 type Store struct {
-	db *sql.DB
+	db          *sql.DB
+	maxVersions int // Versions kept per item; <= 0 keeps all
+	events      *eventHub
+	clock       clock.Clock // Source of timestamps and expiry checks
+}
+
+// busyTimeout is how long (ms) a connection waits on a locked database
+// before failing with SQLITE_BUSY.
+const busyTimeout = 5000
+
+// Config tunes the connection pool. Zero values keep the database/sql
+// defaults: unlimited open connections, two idle, no lifetime limit.
+//
+// SQLite allows one writer at a time, so extra connections only help
+// readers; writers still queue on the busy timeout. A cap a little above
+// the number of concurrent readers expected, with MaxIdleConns equal to it
+// so connections are not churned between bursts, suits most deployments.
+type Config struct {
+	MaxOpenConns    int           // Open connections; 0 is unlimited
+	MaxIdleConns    int           // Idle connections kept; 0 keeps the default of 2, negative keeps none
+	ConnMaxLifetime time.Duration // Close connections after this long; 0 never does
+
+	// ReadOnly opens the file with SQLite's mode=ro, so every write fails.
+	// Migrations cannot run, so the database must already be current.
+	ReadOnly bool
 }
 
+// New opens (creating if needed) and migrates the database at dbPath with
+// the default Config.
 func New(dbPath string) (*Store, error) {
-	db, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on")
+	return NewWithConfig(dbPath, Config{})
+}
+
+// NewWithConfig is New with the pool tuned by cfg.
+//
+// Concurrency: the pool keeps multiple connections so reads can proceed in
+// parallel under WAL. Pragmas are set through the DSN so they apply to every
+// pooled connection, not just the first. Transactions use BEGIN IMMEDIATE
+// (_txlock) so writers queue on the busy timeout up front instead of
+// failing when a read transaction tries to upgrade to a write lock.
+func NewWithConfig(dbPath string, cfg Config) (*Store, error) {
+	dsn := fmt.Sprintf("%s?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=%d&_txlock=immediate", dbPath, busyTimeout)
+	if cfg.ReadOnly {
+		// mode is a SQLite URI parameter, only passed through for file: DSNs
+		dsn = "file:" + dsn + "&mode=ro"
+	}
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("open db: %w", err)
 	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	if cfg.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	var journalMode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("query journal_mode: %w", err)
+	}
+	if !strings.EqualFold(journalMode, "wal") {
+		db.Close()
+		return nil, fmt.Errorf("journal_mode = %q, want wal", journalMode)
+	}
 
-	if err := migrate(db); err != nil {
+	if cfg.ReadOnly {
+		if err := checkCurrent(db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	} else if err := migrate(db); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("migrate: %w", err)
 	}
 
-	return &Store{db: db}, nil
+	return &Store{db: db, maxVersions: DefaultMaxVersions, events: newEventHub(), clock: clock.Real}, nil
+}
+
+// SetClock replaces the clock used for timestamps, token expiry, and
+// retention cutoffs, so tests can pin or advance time. Migrations always
+// use the system clock.
+func (s *Store) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// now returns the store clock's current time in UTC.
+func (s *Store) now() time.Time {
+	return s.clock.Now().UTC()
+}
+
+// Now returns the store clock's current time in UTC, the time item
+// timestamps are taken from. Callers comparing against them should use it
+// rather than time.Now.
+func (s *Store) Now() time.Time {
+	return s.now()
 }
 
 func (s *Store) Close() error {
 	return s.db.Close()
 }
 
+// Ping verifies the database is reachable: the connection is alive and
+// answers a trivial query.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return err
+	}
+	var one int
+	return s.db.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+}
+
+// Size returns the size of the main database file in bytes, from SQLite's
+// page count. Pages still in the WAL are not included.
+func (s *Store) Size(ctx context.Context) (int64, error) {
+	var size int64
+	err := s.db.QueryRowContext(ctx, "SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()").Scan(&size)
+	if err != nil {
+		return 0, fmt.Errorf("size: %w", err)
+	}
+	return size, nil
+}
+
+// Stats returns the connection pool statistics.
+func (s *Store) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
 // Schema versioning
 
 type migration struct {
@@ -53,6 +201,24 @@ type migration struct {
 
 var migrations = []migration{
 	{1, "initial_schema", migrateV1},
+	{2, "item_tags", migrateV2},
+	{3, "soft_delete", migrateV3},
+	{4, "item_versions", migrateV4},
+	{5, "token_scopes", migrateV5},
+	{6, "item_rev", migrateV6},
+	{7, "item_owner", migrateV7},
+	{8, "token_last_used_ip", migrateV8},
+	{9, "item_pinned", migrateV9},
+	{10, "title_nocase", migrateV10},
+	{11, "fts_remove_diacritics", migrateV11},
+	{12, "idempotency_keys", migrateV12},
+	{13, "item_archived", migrateV13},
+	{14, "item_content_hash", migrateV14},
+	{15, "item_link_key", migrateV15},
+	{16, "item_color", migrateV16},
+	{17, "item_relations", migrateV17},
+	{18, "backlinks", migrateV18},
+	{19, "item_changed_at", migrateV19},
 }
 
 func migrate(db *sql.DB) error {
@@ -103,6 +269,19 @@ func migrate(db *sql.DB) error {
 	return nil
 }
 
+// checkCurrent fails unless every migration has been applied, for databases
+// opened read-only where migrate cannot run.
+func checkCurrent(db *sql.DB) error {
+	version, err := getCurrentVersion(db)
+	if err != nil {
+		return fmt.Errorf("get current version: %w", err)
+	}
+	if latest := migrations[len(migrations)-1].version; version != latest {
+		return fmt.Errorf("schema version %d, want %d: open once read-write to migrate", version, latest)
+	}
+	return nil
+}
+
 func getCurrentVersion(db *sql.DB) (int, error) {
 	var version int
 	err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
@@ -185,247 +364,1775 @@ func migrateV1(db *sql.DB) error {
 	return err
 }
 
-func (s *Store) Create(title, content string, link *string) (*Item, error) {
-	id := uuid.New().String()
-	now := time.Now().UTC()
-	nowStr := now.Format(time.RFC3339)
+func migrateV2(db *sql.DB) error {
+	schema := `
+		CREATE TABLE IF NOT EXISTS item_tags (
+			item_id TEXT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+			tag TEXT NOT NULL,
+			PRIMARY KEY (item_id, tag)
+		);
 
-	_, err := s.db.Exec(
-		"INSERT INTO items (id, title, link, content, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
-		id, title, link, content, nowStr, nowStr,
-	)
+		CREATE INDEX IF NOT EXISTS idx_item_tags_tag ON item_tags(tag);
+	`
+	_, err := db.Exec(schema)
+	return err
+}
+
+// migrateV3 adds soft-deletion. Trashed items are removed from the FTS index
+// and re-added on restore, so the triggers only touch rows that are live.
+func migrateV3(db *sql.DB) error {
+	if err := addColumnIfMissing(db, "items", "deleted_at", "TEXT"); err != nil {
+		return err
+	}
+
+	schema := `
+		CREATE INDEX IF NOT EXISTS idx_items_deleted_at ON items(deleted_at);
+
+		DROP TRIGGER IF EXISTS items_ai;
+		DROP TRIGGER IF EXISTS items_ad;
+		DROP TRIGGER IF EXISTS items_au;
+
+		CREATE TRIGGER IF NOT EXISTS items_ai AFTER INSERT ON items
+		WHEN NEW.deleted_at IS NULL BEGIN
+			INSERT INTO items_fts(rowid, title, content, link)
+			VALUES (NEW.rowid, NEW.title, NEW.content, NEW.link);
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS items_ad AFTER DELETE ON items
+		WHEN OLD.deleted_at IS NULL BEGIN
+			INSERT INTO items_fts(items_fts, rowid, title, content, link)
+			VALUES ('delete', OLD.rowid, OLD.title, OLD.content, OLD.link);
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS items_au_old AFTER UPDATE ON items
+		WHEN OLD.deleted_at IS NULL BEGIN
+			INSERT INTO items_fts(items_fts, rowid, title, content, link)
+			VALUES ('delete', OLD.rowid, OLD.title, OLD.content, OLD.link);
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS items_au_new AFTER UPDATE ON items
+		WHEN NEW.deleted_at IS NULL BEGIN
+			INSERT INTO items_fts(rowid, title, content, link)
+			VALUES (NEW.rowid, NEW.title, NEW.content, NEW.link);
+		END;
+	`
+	_, err := db.Exec(schema)
+	return err
+}
+
+// addColumnIfMissing adds a column unless it already exists, so migrations
+// can be re-run safely against partially upgraded databases.
+func addColumnIfMissing(db *sql.DB, table, column, decl string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
 	if err != nil {
-		return nil, fmt.Errorf("insert: %w", err)
+		return fmt.Errorf("table info: %w", err)
 	}
+	defer rows.Close()
 
-	return &Item{
-		ID:        id,
-		Title:     title,
-		Link:      link,
-		Content:   content,
-		CreatedAt: now,
-		UpdatedAt: now,
-	}, nil
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("scan table info: %w", err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	if _, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + decl); err != nil {
+		return fmt.Errorf("add column %s.%s: %w", table, column, err)
+	}
+	return nil
 }
 
-func (s *Store) Get(id string) (*Item, error) {
-	row := s.db.QueryRow(
-		"SELECT id, title, link, content, created_at, updated_at FROM items WHERE id = ?",
-		id,
-	)
-	return scanItem(row)
+// migrateV5 adds token scopes, stored space-separated. Existing tokens get
+// an empty list, which means unrestricted.
+func migrateV5(db *sql.DB) error {
+	return addColumnIfMissing(db, "tokens", "scopes", "TEXT NOT NULL DEFAULT ''")
 }
 
-func (s *Store) GetByTitle(title string) (*Item, error) {
-	row := s.db.QueryRow(
-		"SELECT id, title, link, content, created_at, updated_at FROM items WHERE title = ?",
-		title,
-	)
-	return scanItem(row)
+// migrateV6 adds a revision counter to items for optimistic concurrency.
+func migrateV6(db *sql.DB) error {
+	return addColumnIfMissing(db, "items", "rev", "INTEGER NOT NULL DEFAULT 1")
 }
 
-func (s *Store) Update(id, title, content string, link *string) (*Item, error) {
-	now := time.Now().UTC()
-	nowStr := now.Format(time.RFC3339)
+// DefaultOwner is the created_by value for items created without an
+// authenticated user, matching the column default.
+const DefaultOwner = "single-user-mode"
 
-	result, err := s.db.Exec(
-		"UPDATE items SET title = ?, link = ?, content = ?, updated_at = ? WHERE id = ?",
-		title, link, content, nowStr, id,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("update: %w", err)
+// migrateV7 backfills created_by on rows that lack an owner and indexes it
+// for ?mine=true listings.
+func migrateV7(db *sql.DB) error {
+	if err := addColumnIfMissing(db, "items", "created_by", "TEXT NOT NULL DEFAULT '"+DefaultOwner+"'"); err != nil {
+		return err
 	}
-
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return nil, sql.ErrNoRows
+	schema := `
+		UPDATE items SET created_by = '` + DefaultOwner + `' WHERE created_by IS NULL OR created_by = '';
+		CREATE INDEX IF NOT EXISTS idx_items_created_by ON items(created_by);
+	`
+	if _, err := db.Exec(schema); err != nil {
+		return fmt.Errorf("backfill created_by: %w", err)
 	}
+	return nil
+}
 
-	return s.Get(id)
+// migrateV8 records the source IP of each token's most recent use.
+func migrateV8(db *sql.DB) error {
+	return addColumnIfMissing(db, "tokens", "last_used_ip", "TEXT")
 }
 
-func (s *Store) Delete(id string) error {
-	result, err := s.db.Exec("DELETE FROM items WHERE id = ?", id)
+// migrateV9 adds the pinned flag that floats items to the top of the
+// default list order.
+func migrateV9(db *sql.DB) error {
+	return addColumnIfMissing(db, "items", "pinned", "INTEGER NOT NULL DEFAULT 0")
+}
+
+// migrateV10 makes title uniqueness case-insensitive. Existing titles that
+// differ only in case would make the index fail to build, so they are
+// listed in the error for the operator to rename first.
+func migrateV10(db *sql.DB) error {
+	rows, err := db.Query(`
+		SELECT group_concat(title, ' / ') FROM items
+		GROUP BY title COLLATE NOCASE HAVING COUNT(*) > 1
+	`)
 	if err != nil {
-		return fmt.Errorf("delete: %w", err)
+		return fmt.Errorf("find case-only duplicate titles: %w", err)
+	}
+	var dups []string
+	for rows.Next() {
+		var group string
+		if err := rows.Scan(&group); err != nil {
+			rows.Close()
+			return err
+		}
+		dups = append(dups, "["+group+"]")
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(dups) > 0 {
+		return fmt.Errorf("titles differ only in case, rename all but one of each group (trashed items count): %s", strings.Join(dups, ", "))
 	}
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return sql.ErrNoRows
+	if _, err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_items_title_nocase ON items(title COLLATE NOCASE)"); err != nil {
+		return fmt.Errorf("create title index: %w", err)
 	}
 	return nil
 }
 
-func (s *Store) List(limit, offset int) ([]Item, error) {
-	if limit <= 0 {
-		limit = 50
-	}
+// FTS5 tokenizers for items_fts. TokenizerFolded, the default since
+// migrateV11, matches "cafe" against "café" and also folds stacked
+// diacritics ("pho" finds "phở"), which the older unicode61 default missed.
+// TokenizerExact keeps all diacritics significant.
+const (
+	TokenizerFolded = "unicode61 remove_diacritics 2"
+	TokenizerExact  = "unicode61 remove_diacritics 0"
+)
 
-	rows, err := s.db.Query(
-		"SELECT id, title, link, content, created_at, updated_at FROM items ORDER BY updated_at DESC LIMIT ? OFFSET ?",
-		limit, offset,
-	)
+// migrateV11 rebuilds the search index with diacritics folded.
+func migrateV11(db *sql.DB) error {
+	return recreateFTS(db, TokenizerFolded)
+}
+
+// recreateFTS drops items_fts and recreates it with the given tokenizer,
+// then reindexes the live items. The triggers refer to the table by name
+// and carry on working against the new one.
+func recreateFTS(db *sql.DB, tokenize string) error {
+	tx, err := db.Begin()
 	if err != nil {
-		return nil, fmt.Errorf("query: %w", err)
+		return fmt.Errorf("begin: %w", err)
 	}
-	defer rows.Close()
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		DROP TABLE IF EXISTS items_fts;
+
+		CREATE VIRTUAL TABLE items_fts USING fts5(
+			title,
+			content,
+			link,
+			content='items',
+			content_rowid='rowid',
+			tokenize='` + tokenize + `'
+		);
 
-	return scanItems(rows)
+		INSERT INTO items_fts(items_fts) VALUES ('rebuild');
+		INSERT INTO items_fts(items_fts, rowid, title, content, link)
+		SELECT 'delete', rowid, title, content, link FROM items WHERE deleted_at IS NOT NULL;
+	`)
+	if err != nil {
+		return fmt.Errorf("recreate fts: %w", err)
+	}
+	return tx.Commit()
 }
 
-type SearchResult struct {
-	Item    Item    `json:"item"`
-	Rank    float64 `json:"rank"`
-	Snippet string  `json:"snippet"`
+// migrateV13 adds the archived flag that hides items from the default list
+// without trashing them.
+func migrateV13(db *sql.DB) error {
+	return addColumnIfMissing(db, "items", "archived", "INTEGER NOT NULL DEFAULT 0")
 }
 
-func (s *Store) Search(query string, limit int) ([]SearchResult, error) {
-	if limit <= 0 {
-		limit = 20
+// migrateV19 adds changed_at, the time of an item's last change of any
+// kind, which ListSince reads. Pinning, archiving, and color changes leave
+// updated_at alone, so sync cannot rely on it.
+func migrateV19(db *sql.DB) error {
+	if err := addColumnIfMissing(db, "items", "changed_at", "TEXT"); err != nil {
+		return err
 	}
-
-	ftsQuery := buildFTSQuery(query)
-	if ftsQuery == "" {
-		return []SearchResult{}, nil
+	if _, err := db.Exec("UPDATE items SET changed_at = MAX(updated_at, COALESCE(deleted_at, updated_at)) WHERE changed_at IS NULL"); err != nil {
+		return fmt.Errorf("backfill changed_at: %w", err)
 	}
-
-	// FTS5 search with BM25 ranking
-	rows, err := s.db.Query(`
-		SELECT i.id, i.title, i.link, i.content, i.created_at, i.updated_at,
-			   bm25(items_fts) as rank,
-			   snippet(items_fts, 1, '<mark>', '</mark>', '...', 20) as snippet
-		FROM items_fts
-		JOIN items i ON items_fts.rowid = i.rowid
-		WHERE items_fts MATCH ?
-		ORDER BY rank
-		LIMIT ?
-	`, ftsQuery, limit)
-	if err != nil {
-		return nil, fmt.Errorf("search: %w", err)
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_items_changed_at ON items(changed_at)"); err != nil {
+		return fmt.Errorf("create changed_at index: %w", err)
 	}
-	defer rows.Close()
-
-	var results []SearchResult
-	for rows.Next() {
-		var item Item
-		var createdAt, updatedAt string
-		var link sql.NullString
-		var r SearchResult
+	return nil
+}
 
-		err := rows.Scan(
-			&item.ID, &item.Title, &link, &item.Content,
-			&createdAt, &updatedAt, &r.Rank, &r.Snippet,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("scan: %w", err)
-		}
+// ErrRevConflict is returned by Update when the item exists but its rev no
+// longer matches the caller's expected rev.
+var ErrRevConflict = errors.New("item was modified by another update")
 
-		item.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
-		item.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
-		if link.Valid {
-			item.Link = &link.String
-		}
+// CleanTitle trims surrounding whitespace from a title and collapses
+// internal runs of whitespace to single spaces. Create, Update, and
+// ImportItems store titles in this form, so "Notes " and "Notes" collide.
+func CleanTitle(title string) string {
+	return strings.Join(strings.Fields(title), " ")
+}
 
-		r.Item = item
-		results = append(results, r)
-	}
+// Create inserts a new item and its tags in a single transaction. createdBy
+// records the owner's CN; empty means DefaultOwner. The title is stored as
+// CleanTitle returns it.
+func (s *Store) Create(title, content string, link *string, tags []string, createdBy string) (*Item, error) {
+	return s.CreateContext(context.Background(), title, content, link, tags, createdBy)
+}
 
-	return results, rows.Err()
+// CreateContext is Create with a context that cancels the insert.
+func (s *Store) CreateContext(ctx context.Context, title, content string, link *string, tags []string, createdBy string) (*Item, error) {
+	return s.CreateWithColorContext(ctx, title, content, link, tags, createdBy, "")
 }
 
-// buildFTSQuery transforms user search input into a safe FTS5 query.
-// - Unquoted terms are OR'd together: "foo bar" → "foo" OR "bar"
-// - Quoted phrases are preserved: `"foo bar"` → "foo bar"
-// - All tokens are quoted to escape FTS5 special characters (*, ^, NEAR, etc.)
-// - Embedded quotes are escaped by doubling: `say "hi"` → "say" OR "hi"
-func buildFTSQuery(query string) string {
-	if strings.TrimSpace(query) == "" {
-		return ""
+// CreateWithColorContext is CreateContext for an item that starts with
+// color, which must pass ValidColor.
+func (s *Store) CreateWithColorContext(ctx context.Context, title, content string, link *string, tags []string, createdBy, color string) (*Item, error) {
+	if !ValidColor(color) {
+		return nil, ErrInvalidColor
 	}
-
-	var tokens []string
-	var buf strings.Builder
-	inQuote := false
-
-	flush := func() {
-		if buf.Len() == 0 {
-			return
-		}
-		token := strings.TrimSpace(buf.String())
-		buf.Reset()
-		if token == "" {
-			return
-		}
-		token = strings.ReplaceAll(token, `"`, `""`)
-		tokens = append(tokens, `"`+token+`"`)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
 	}
+	defer tx.Rollback()
 
-	for _, r := range query {
-		switch {
-		case r == '"':
-			if inQuote {
-				flush()
-				inQuote = false
-			} else {
-				flush()
-				inQuote = true
-			}
-		case unicode.IsSpace(r):
-			if inQuote {
-				buf.WriteRune(r)
-			} else {
-				flush()
-			}
-		default:
-			buf.WriteRune(r)
-		}
+	item, err := s.insertItem(ctx, tx, title, content, link, tags, createdBy, color)
+	if err != nil {
+		return nil, err
 	}
-	flush()
 
-	if len(tokens) == 0 {
-		return ""
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
 	}
-	return strings.Join(tokens, " OR ")
+	s.publish(EventCreated, item.ID, item.Title)
+	return item, nil
 }
 
-func scanItem(row *sql.Row) (*Item, error) {
-	var item Item
-	var createdAt, updatedAt string
-	var link sql.NullString
+// insertItem adds a new item and its tags within tx. The caller validates
+// color, commits, and publishes EventCreated.
+func (s *Store) insertItem(ctx context.Context, tx *sql.Tx, title, content string, link *string, tags []string, createdBy, color string) (*Item, error) {
+	opCreate.Inc()
+	title = CleanTitle(title)
+	if createdBy == "" {
+		createdBy = DefaultOwner
+	}
+	id := uuid.New().String()
+	now := s.now()
+	nowStr := now.Format(time.RFC3339)
+	tags = normalizeTags(tags)
 
-	err := row.Scan(&item.ID, &item.Title, &link, &item.Content, &createdAt, &updatedAt)
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO items (id, title, link, link_key, content, content_hash, color, created_by, created_at, updated_at, changed_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		id, title, link, linkKey(link), content, ContentHash(content), color, createdBy, nowStr, nowStr, nowStr,
+	)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("insert: %w", err)
 	}
 
-	item.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
-	item.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
-	if link.Valid {
-		item.Link = &link.String
+	if err := setTags(tx, id, tags); err != nil {
+		return nil, err
+	}
+	if err := updateReferences(ctx, tx, id, title, content); err != nil {
+		return nil, err
 	}
 
-	return &item, nil
+	return &Item{
+		ID:        id,
+		Title:     title,
+		Link:      link,
+		Content:   content,
+		Tags:      tags,
+		Color:     color,
+		Rev:       1,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		UpdatedAt: now,
+		ChangedAt: now,
+	}, nil
 }
 
-func scanItems(rows *sql.Rows) ([]Item, error) {
-	var items []Item
-	for rows.Next() {
-		var item Item
-		var createdAt, updatedAt string
-		var link sql.NullString
+// Duplicate creates a new item with the content, link, and tags of a live
+// item. An empty newTitle derives one with CopyTitle; either way a taken
+// title fails like Create. The copy is owned by createdBy and starts
+// unpinned with no history.
+func (s *Store) Duplicate(id, newTitle, createdBy string) (*Item, error) {
+	src, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if newTitle == "" {
+		newTitle = CopyTitle(src.Title)
+	}
+	return s.Create(newTitle, src.Content, src.Link, src.Tags, createdBy)
+}
 
-		err := rows.Scan(&item.ID, &item.Title, &link, &item.Content, &createdAt, &updatedAt)
-		if err != nil {
-			return nil, fmt.Errorf("scan: %w", err)
-		}
+// CopyTitle is the title Duplicate gives a copy of an item titled title.
+func CopyTitle(title string) string {
+	return title + " (copy)"
+}
 
-		item.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
-		item.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
-		if link.Valid {
-			item.Link = &link.String
-		}
+// Get returns a live (non-trashed) item by ID.
+func (s *Store) Get(id string) (*Item, error) {
+	return s.GetContext(context.Background(), id)
+}
+
+// GetContext is Get with a context that cancels the query.
+func (s *Store) GetContext(ctx context.Context, id string) (*Item, error) {
+	row := s.db.QueryRowContext(ctx,
+		"SELECT "+selectItemColumns("")+" FROM items WHERE id = ? AND deleted_at IS NULL",
+		id,
+	)
+	return s.scanItemWithTags(ctx, row)
+}
+
+// GetByTitle returns a live (non-trashed) item by exact title.
+func (s *Store) GetByTitle(title string) (*Item, error) {
+	return s.GetByTitleContext(context.Background(), title)
+}
+
+// GetByTitleContext is GetByTitle with a context that cancels the query.
+func (s *Store) GetByTitleContext(ctx context.Context, title string) (*Item, error) {
+	row := s.db.QueryRowContext(ctx,
+		"SELECT "+selectItemColumns("")+" FROM items WHERE title = ? AND deleted_at IS NULL",
+		title,
+	)
+	return s.scanItemWithTags(ctx, row)
+}
+
+// GetMany returns the live items with the given ids in the order the ids
+// are listed, in one query. Unknown, trashed, and repeated ids are skipped.
+func (s *Store) GetMany(ids []string) ([]Item, error) {
+	return s.GetManyContext(context.Background(), ids)
+}
+
+// GetManyContext is GetMany with a context that cancels the query.
+func (s *Store) GetManyContext(ctx context.Context, ids []string) ([]Item, error) {
+	if len(ids) == 0 {
+		return []Item{}, nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	query := "SELECT " + selectItemColumns("") + " FROM items WHERE deleted_at IS NULL AND id IN (?" +
+		strings.Repeat(", ?", len(ids)-1) + ")"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	found, err := scanItems(rows)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]Item, len(found))
+	for _, item := range found {
+		byID[item.ID] = item
+	}
+	items := make([]Item, 0, len(found))
+	for _, id := range ids {
+		if item, ok := byID[id]; ok {
+			items = append(items, item)
+			delete(byID, id)
+		}
+	}
+	if err := s.loadTags(ctx, items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// TitleOwner returns the id of the item holding title under the uniqueness
+// rule Create enforces: the title is cleaned and compared ignoring case, and
+// trashed items still hold theirs. It returns sql.ErrNoRows if the title is
+// free.
+func (s *Store) TitleOwner(title string) (id string, trashed bool, err error) {
+	return s.TitleOwnerContext(context.Background(), title)
+}
+
+// TitleOwnerContext is TitleOwner with a context that cancels the query.
+func (s *Store) TitleOwnerContext(ctx context.Context, title string) (id string, trashed bool, err error) {
+	err = s.db.QueryRowContext(ctx,
+		"SELECT id, deleted_at IS NOT NULL FROM items WHERE title = ? COLLATE NOCASE",
+		CleanTitle(title),
+	).Scan(&id, &trashed)
+	return id, trashed, err
+}
+
+// Update replaces an item's fields, recording the prior title, link, and
+// content as a new version. A nil tags slice leaves the existing tags
+// untouched; pass an empty slice to clear them. A non-zero expectedRev
+// makes the update conditional: if the stored rev differs, Update returns
+// ErrRevConflict and changes nothing.
+func (s *Store) Update(id, title, content string, link *string, tags []string, expectedRev int) (*Item, error) {
+	return s.UpdateContext(context.Background(), id, title, content, link, tags, expectedRev)
+}
+
+// UpdateContext is Update with a context that cancels the write.
+func (s *Store) UpdateContext(ctx context.Context, id, title, content string, link *string, tags []string, expectedRev int) (*Item, error) {
+	return s.UpdateWithColorContext(ctx, id, title, content, link, tags, nil, expectedRev)
+}
+
+// UpdateWithColorContext is UpdateContext that also sets the color when
+// color is non-nil; empty clears it. Returns ErrInvalidColor for a color
+// not in Colors.
+func (s *Store) UpdateWithColorContext(ctx context.Context, id, title, content string, link *string, tags []string, color *string, expectedRev int) (*Item, error) {
+	if color != nil && !ValidColor(*color) {
+		return nil, ErrInvalidColor
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	title, err = s.updateItem(ctx, tx, id, title, content, link, tags, color, expectedRev)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	s.publish(EventUpdated, id, title)
+
+	return s.GetContext(ctx, id)
+}
+
+// updateItem replaces an item's fields within tx and returns the stored
+// title. A nil color is left as it is. The caller validates color, commits,
+// and publishes EventUpdated.
+func (s *Store) updateItem(ctx context.Context, tx *sql.Tx, id, title, content string, link *string, tags []string, color *string, expectedRev int) (string, error) {
+	opUpdate.Inc()
+	title = CleanTitle(title)
+	nowStr := s.now().Format(time.RFC3339)
+
+	if err := s.snapshotVersion(tx, id, nowStr); err != nil {
+		return "", err
+	}
+
+	// The rev check lives in the WHERE clause so it is atomic with the write
+	result, err := tx.ExecContext(ctx,
+		"UPDATE items SET title = ?, link = ?, link_key = ?, content = ?, content_hash = ?, color = COALESCE(?, color), updated_at = ?, changed_at = ?, rev = rev + 1 WHERE id = ? AND deleted_at IS NULL AND (? = 0 OR rev = ?)",
+		title, link, linkKey(link), content, ContentHash(content), color, nowStr, nowStr, id, expectedRev, expectedRev,
+	)
+	if err != nil {
+		return "", fmt.Errorf("update: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		if expectedRev != 0 {
+			var exists int
+			err := tx.QueryRow("SELECT 1 FROM items WHERE id = ? AND deleted_at IS NULL", id).Scan(&exists)
+			if err == nil {
+				return "", ErrRevConflict
+			}
+		}
+		return "", sql.ErrNoRows
+	}
+
+	if tags != nil {
+		if _, err := tx.Exec("DELETE FROM item_tags WHERE item_id = ?", id); err != nil {
+			return "", fmt.Errorf("clear tags: %w", err)
+		}
+		if err := setTags(tx, id, normalizeTags(tags)); err != nil {
+			return "", err
+		}
+	}
+	if err := updateReferences(ctx, tx, id, title, content); err != nil {
+		return "", err
+	}
+	return title, nil
+}
+
+// PatchFields lists the fields a Patch changes. Nil fields, and the link
+// unless SetLink is true, are left as they are.
+type PatchFields struct {
+	Title   *string
+	Content *string
+	SetLink bool     // Replace the link with Link; a nil Link clears it
+	Link    *string  // Used only when SetLink is true
+	Tags    []string // Non-nil replaces the tags; empty clears them
+	Color   *string  // One of Colors; empty clears it
+
+	ExpectedRev int // If non-zero, ErrRevConflict unless it matches the stored rev
+}
+
+// empty reports whether the patch changes nothing.
+func (f PatchFields) empty() bool {
+	return f.Title == nil && f.Content == nil && !f.SetLink && f.Tags == nil && f.Color == nil
+}
+
+// colorOnly reports whether the patch changes only the color, which
+// SetColor handles without a new rev or version.
+func (f PatchFields) colorOnly() bool {
+	return f.Color != nil && f.Title == nil && f.Content == nil && !f.SetLink && f.Tags == nil
+}
+
+// Patch updates only the fields set in fields, so a client changing the
+// link cannot clobber a concurrent content edit. Like Update it bumps rev
+// and records a version, except that a patch of only Color is applied as
+// SetColor; an empty patch changes nothing and returns the item. Returns
+// sql.ErrNoRows for missing or trashed items.
+func (s *Store) Patch(id string, fields PatchFields) (*Item, error) {
+	return s.PatchContext(context.Background(), id, fields)
+}
+
+// PatchContext is Patch with a context that cancels the write.
+func (s *Store) PatchContext(ctx context.Context, id string, fields PatchFields) (*Item, error) {
+	if fields.Color != nil && !ValidColor(*fields.Color) {
+		return nil, ErrInvalidColor
+	}
+	if fields.empty() {
+		item, err := s.GetContext(ctx, id)
+		if err == nil && fields.ExpectedRev != 0 && item.Rev != fields.ExpectedRev {
+			return nil, ErrRevConflict
+		}
+		return item, err
+	}
+	if fields.colorOnly() {
+		if err := s.setColor(ctx, id, *fields.Color, fields.ExpectedRev); err != nil {
+			return nil, err
+		}
+		return s.GetContext(ctx, id)
+	}
+
+	opUpdate.Inc()
+	nowStr := s.now().Format(time.RFC3339)
+
+	sets := []string{"updated_at = ?", "changed_at = ?", "rev = rev + 1"}
+	args := []any{nowStr, nowStr}
+	if fields.Title != nil {
+		sets = append(sets, "title = ?")
+		args = append(args, CleanTitle(*fields.Title))
+	}
+	if fields.Content != nil {
+		sets = append(sets, "content = ?", "content_hash = ?")
+		args = append(args, *fields.Content, ContentHash(*fields.Content))
+	}
+	if fields.SetLink {
+		sets = append(sets, "link = ?", "link_key = ?")
+		args = append(args, fields.Link, linkKey(fields.Link))
+	}
+	if fields.Color != nil {
+		sets = append(sets, "color = ?")
+		args = append(args, *fields.Color)
+	}
+	args = append(args, id, fields.ExpectedRev, fields.ExpectedRev)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.snapshotVersion(tx, id, nowStr); err != nil {
+		return nil, err
+	}
+
+	result, err := tx.ExecContext(ctx,
+		"UPDATE items SET "+strings.Join(sets, ", ")+" WHERE id = ? AND deleted_at IS NULL AND (? = 0 OR rev = ?)",
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("update: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		if fields.ExpectedRev != 0 {
+			var exists int
+			err := tx.QueryRow("SELECT 1 FROM items WHERE id = ? AND deleted_at IS NULL", id).Scan(&exists)
+			if err == nil {
+				return nil, ErrRevConflict
+			}
+		}
+		return nil, sql.ErrNoRows
+	}
+
+	if fields.Tags != nil {
+		if _, err := tx.Exec("DELETE FROM item_tags WHERE item_id = ?", id); err != nil {
+			return nil, fmt.Errorf("clear tags: %w", err)
+		}
+		if err := setTags(tx, id, normalizeTags(fields.Tags)); err != nil {
+			return nil, err
+		}
+	}
+
+	var title, content string
+	if err := tx.QueryRow("SELECT title, content FROM items WHERE id = ?", id).Scan(&title, &content); err != nil {
+		return nil, fmt.Errorf("read title: %w", err)
+	}
+	if fields.Title != nil || fields.Content != nil {
+		if err := updateReferences(ctx, tx, id, title, content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	s.publish(EventUpdated, id, title)
+
+	return s.GetContext(ctx, id)
+}
+
+// Delete moves an item to the trash. Trashed items keep their title
+// reserved until purged.
+func (s *Store) Delete(id string) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+// DeleteContext is Delete with a context that cancels the write.
+func (s *Store) DeleteContext(ctx context.Context, id string) error {
+	if err := s.trashItem(ctx, s.db, id); err != nil {
+		return err
+	}
+	s.publish(EventDeleted, id, "")
+	return nil
+}
+
+// trashItem sets deleted_at on a live item. The caller publishes
+// EventDeleted.
+func (s *Store) trashItem(ctx context.Context, q querier, id string) error {
+	opDelete.Inc()
+	now := s.now().Format(time.RFC3339)
+	result, err := q.ExecContext(ctx, "UPDATE items SET deleted_at = ?, changed_at = ? WHERE id = ? AND deleted_at IS NULL", now, now, id)
+	if err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteResult reports what DeleteMany did.
+type DeleteResult struct {
+	Deleted  int      `json:"deleted"`
+	NotFound []string `json:"not_found"` // IDs that were missing or already trashed
+}
+
+// DeleteMany moves several items to the trash in one transaction, with the
+// same semantics as Delete for each. Unknown IDs are reported rather than
+// failing the batch; duplicates are counted once.
+func (s *Store) DeleteMany(ids []string) (DeleteResult, error) {
+	return s.DeleteManyContext(context.Background(), ids)
+}
+
+// DeleteManyContext is DeleteMany with a context that cancels the
+// transaction.
+func (s *Store) DeleteManyContext(ctx context.Context, ids []string) (DeleteResult, error) {
+	res := DeleteResult{NotFound: []string{}}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return DeleteResult{}, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	var deleted []string
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		err := s.trashItem(ctx, tx, id)
+		if err == sql.ErrNoRows {
+			res.NotFound = append(res.NotFound, id)
+			continue
+		}
+		if err != nil {
+			return DeleteResult{}, err
+		}
+		deleted = append(deleted, id)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return DeleteResult{}, fmt.Errorf("commit: %w", err)
+	}
+	for _, id := range deleted {
+		s.publish(EventDeleted, id, "")
+	}
+	res.Deleted = len(deleted)
+	return res, nil
+}
+
+// SetPinned pins or unpins a live item. Pinning is not an edit: rev,
+// updated_at, and version history are left alone, and only changed_at
+// moves so sync picks it up.
+func (s *Store) SetPinned(id string, pinned bool) error {
+	var title string
+	err := s.db.QueryRow(
+		"UPDATE items SET pinned = ?, changed_at = ? WHERE id = ? AND deleted_at IS NULL RETURNING title",
+		pinned, s.now().Format(time.RFC3339), id,
+	).Scan(&title)
+	if err == sql.ErrNoRows {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("set pinned: %w", err)
+	}
+	s.publish(EventUpdated, id, title)
+	return nil
+}
+
+// SetArchived archives or unarchives a live item. Archiving is not an
+// edit: like SetPinned it moves only changed_at.
+func (s *Store) SetArchived(id string, archived bool) error {
+	var title string
+	err := s.db.QueryRow(
+		"UPDATE items SET archived = ?, changed_at = ? WHERE id = ? AND deleted_at IS NULL RETURNING title",
+		archived, s.now().Format(time.RFC3339), id,
+	).Scan(&title)
+	if err == sql.ErrNoRows {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("set archived: %w", err)
+	}
+	s.publish(EventUpdated, id, title)
+	return nil
+}
+
+// Restore moves a trashed item back into the live set. It bumps updated_at
+// so ListSince reports the item to clients that saw its tombstone.
+func (s *Store) Restore(id string) (*Item, error) {
+	now := s.now().Format(time.RFC3339)
+	result, err := s.db.Exec("UPDATE items SET deleted_at = NULL, updated_at = ?, changed_at = ? WHERE id = ? AND deleted_at IS NOT NULL", now, now, id)
+	if err != nil {
+		return nil, fmt.Errorf("restore: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return nil, sql.ErrNoRows
+	}
+	item, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	s.publish(EventRestored, id, item.Title)
+	return item, nil
+}
+
+// PurgeDeleted permanently removes items that have been in the trash for
+// longer than olderThan. Returns the number of items removed.
+func (s *Store) PurgeDeleted(olderThan time.Duration) (int64, error) {
+	cutoff := s.now().Add(-olderThan).Format(time.RFC3339)
+	result, err := s.db.Exec("DELETE FROM items WHERE deleted_at IS NOT NULL AND deleted_at <= ?", cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("purge: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// DefaultListLimit is the page size used when ListOptions.Limit is unset.
+const DefaultListLimit = 50
+
+// SortOption selects the ordering of ListWithOptions results.
+type SortOption string
+
+const (
+	SortUpdatedDesc SortOption = "updated_desc" // Default
+	SortUpdatedAsc  SortOption = "updated_asc"
+	SortCreatedDesc SortOption = "created_desc"
+	SortCreatedAsc  SortOption = "created_asc"
+	SortTitleAsc    SortOption = "title_asc"
+	SortTitleDesc   SortOption = "title_desc"
+)
+
+// ErrInvalidSort is returned by ParseSortOption for unknown values.
+var ErrInvalidSort = errors.New("invalid sort option")
+
+// sortClauses maps each SortOption to its ORDER BY clause. Title sorting is
+// case-insensitive; id breaks ties so paging is stable.
+var sortClauses = map[SortOption]string{
+	SortUpdatedDesc: "updated_at DESC, id",
+	SortUpdatedAsc:  "updated_at ASC, id",
+	SortCreatedDesc: "created_at DESC, id",
+	SortCreatedAsc:  "created_at ASC, id",
+	SortTitleAsc:    "title COLLATE NOCASE ASC, id",
+	SortTitleDesc:   "title COLLATE NOCASE DESC, id",
+}
+
+// ParseSortOption validates a sort value. An empty string selects the
+// default ordering.
+func ParseSortOption(v string) (SortOption, error) {
+	if v == "" {
+		return "", nil
+	}
+	opt := SortOption(v)
+	if _, ok := sortClauses[opt]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrInvalidSort, v)
+	}
+	return opt, nil
+}
+
+// ListOptions controls filtering and paging for ListWithOptions.
+type ListOptions struct {
+	Limit     int
+	Offset    int
+	Tags      []string   // Only return items carrying all of these tags
+	Trashed   bool       // List trashed items instead of live ones
+	Sort      SortOption // Empty means pinned first, then updated_desc (deleted_at DESC for trash)
+	CreatedBy string     // Only return items created by this CN
+
+	// Archived items are left out of live lists unless IncludeArchived is
+	// set; ArchivedOnly lists nothing else. The trash includes them.
+	IncludeArchived bool
+	ArchivedOnly    bool
+}
+
+func (s *Store) List(limit, offset int) ([]Item, error) {
+	return s.ListWithOptions(ListOptions{Limit: limit, Offset: offset})
+}
+
+func (s *Store) ListWithOptions(opts ListOptions) ([]Item, error) {
+	return s.ListContext(context.Background(), opts)
+}
+
+// ListContext is ListWithOptions with a context that cancels the query.
+func (s *Store) ListContext(ctx context.Context, opts ListOptions) ([]Item, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultListLimit
+	}
+
+	where, args := listFilter(opts)
+	order, ok := sortClauses[opts.Sort]
+	switch {
+	case opts.Sort == "" && opts.Trashed:
+		order = "deleted_at DESC, id"
+	case opts.Sort == "":
+		order = "pinned DESC, " + sortClauses[SortUpdatedDesc]
+	case !ok:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSort, opts.Sort)
+	}
+	query := "SELECT " + selectItemColumns("") + " FROM items WHERE " + where +
+		" ORDER BY " + order + " LIMIT ? OFFSET ?"
+	args = append(args, opts.Limit, opts.Offset)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	items, err := scanItems(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadTags(ctx, items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// ListSince returns items whose ChangedAt is after ts, oldest change
+// first, for incremental sync. Edits, trashing, restoring, pinning,
+// archiving, and color changes all count. Trashed items are included with
+// DeletedAt set as tombstones; items purged from the trash are not
+// reported.
+func (s *Store) ListSince(ts time.Time, limit, offset int) ([]Item, error) {
+	return s.ListSinceContext(context.Background(), ts, limit, offset)
+}
+
+// ListSinceContext is ListSince with a context that cancels the query.
+func (s *Store) ListSinceContext(ctx context.Context, ts time.Time, limit, offset int) ([]Item, error) {
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	query := "SELECT " + selectItemColumns("") + " FROM items WHERE changed_at > ?" +
+		" ORDER BY changed_at ASC, id LIMIT ? OFFSET ?"
+	rows, err := s.db.QueryContext(ctx, query, ts.UTC().Format(time.RFC3339), limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	items, err := scanItems(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadTags(ctx, items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// CountSinceContext returns the number of items ListSince would report,
+// ignoring paging.
+func (s *Store) CountSinceContext(ctx context.Context, ts time.Time) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM items WHERE changed_at > ?", ts.UTC().Format(time.RFC3339)).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("count: %w", err)
+	}
+	return n, nil
+}
+
+// Cursor is a position in the updated_desc order of live items: the
+// updated_at and id of the last item a client has seen. The zero Cursor is
+// the start of the list.
+type Cursor struct {
+	UpdatedAt time.Time
+	ID        string
+}
+
+// IsZero reports whether c is the start of the list.
+func (c Cursor) IsZero() bool {
+	return c.ID == "" && c.UpdatedAt.IsZero()
+}
+
+// ListAfter returns up to limit live, unarchived items following cursor,
+// most recently updated first with id breaking ties. Unlike offset paging, items created
+// or moved to the front while a client pages through cannot shift the rest
+// of the list, so no item is repeated or skipped.
+func (s *Store) ListAfter(cursor Cursor, limit int) ([]Item, error) {
+	return s.ListAfterContext(context.Background(), cursor, limit)
+}
+
+// ListAfterContext is ListAfter with a context that cancels the query.
+func (s *Store) ListAfterContext(ctx context.Context, cursor Cursor, limit int) ([]Item, error) {
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	where := "deleted_at IS NULL AND archived = 0"
+	var args []any
+	if !cursor.IsZero() {
+		ts := cursor.UpdatedAt.UTC().Format(time.RFC3339)
+		where += " AND (updated_at < ? OR (updated_at = ? AND id > ?))"
+		args = append(args, ts, ts, cursor.ID)
+	}
+	query := "SELECT " + selectItemColumns("") + " FROM items WHERE " + where +
+		" ORDER BY " + sortClauses[SortUpdatedDesc] + " LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	items, err := scanItems(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadTags(ctx, items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Count returns the number of live items.
+func (s *Store) Count() (int, error) {
+	return s.CountWithOptions(ListOptions{})
+}
+
+// CountByUser returns the number of live (not trashed) items created by
+// userCN.
+func (s *Store) CountByUser(userCN string) (int, error) {
+	return s.CountByUserContext(context.Background(), userCN)
+}
+
+// CountByUserContext is CountByUser with a context that cancels the query.
+func (s *Store) CountByUserContext(ctx context.Context, userCN string) (int, error) {
+	return s.CountContext(ctx, ListOptions{CreatedBy: userCN})
+}
+
+// CountWithOptions returns the number of items matching the filters in opts,
+// ignoring Limit and Offset, so callers can compute pagination totals.
+func (s *Store) CountWithOptions(opts ListOptions) (int, error) {
+	return s.CountContext(context.Background(), opts)
+}
+
+// CountContext is CountWithOptions with a context that cancels the query.
+func (s *Store) CountContext(ctx context.Context, opts ListOptions) (int, error) {
+	where, args := listFilter(opts)
+	var n int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM items WHERE "+where, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("count: %w", err)
+	}
+	return n, nil
+}
+
+// listFilter builds the WHERE clause shared by ListWithOptions and
+// CountWithOptions.
+func listFilter(opts ListOptions) (string, []any) {
+	where := "deleted_at IS NULL"
+	if opts.Trashed {
+		where = "deleted_at IS NOT NULL"
+	}
+	var args []any
+	if tags := normalizeTags(opts.Tags); len(tags) > 0 {
+		clause, tagArgs := tagFilter("id", tags)
+		where += " AND " + clause
+		args = append(args, tagArgs...)
+	}
+	if opts.CreatedBy != "" {
+		where += " AND created_by = ?"
+		args = append(args, opts.CreatedBy)
+	}
+	switch {
+	case opts.ArchivedOnly:
+		where += " AND archived = 1"
+	case !opts.IncludeArchived && !opts.Trashed:
+		where += " AND archived = 0"
+	}
+	return where, args
+}
+
+type SearchResult struct {
+	Item           Item            `json:"item"`
+	Rank           float64         `json:"rank"`
+	Snippet        string          `json:"snippet"`
+	Highlights     []HighlightSpan `json:"highlights,omitempty"`      // Matched spans in Snippet (Highlights only)
+	DuplicateCount int             `json:"duplicate_count,omitempty"` // Results collapsed into this one (dedupe only)
+}
+
+// HighlightSpan is a matched range of a snippet, in characters (Unicode
+// code points), with End exclusive.
+type HighlightSpan struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// SearchOptions controls filtering and post-processing for SearchWithOptions.
+type SearchOptions struct {
+	Limit         int
+	Offset        int      // Results to skip, after ranking and dedupe
+	Tags          []string // Only match items carrying all of these tags
+	Dedupe        bool     // Collapse results sharing a normalized title
+	Prefix        bool     // Treat the last bare term as a prefix (type-ahead)
+	SnippetTokens int      // Snippet length in tokens (default DefaultSnippetTokens, capped at MaxSnippetTokens)
+	SnippetColumn int      // Field the snippet is taken from: SnippetContent (default), SnippetTitle, or SnippetLink
+	MarkOpen      string   // Inserted before each match (default DefaultMarkOpen)
+	MarkClose     string   // Inserted after each match (default DefaultMarkClose)
+	Highlights    bool     // Return a plain snippet with match offsets in Highlights instead of markers
+	Columns       []string // Only match these SearchFields (default all)
+	Mode          string   // How the query is interpreted: QueryModeDefault, QueryModeSimple, or QueryModeRaw
+
+	ExcludeArchived bool // Leave out archived items, which match by default
+	OmitSnippet     bool // Skip snippet generation and item content; Snippet and Item.Content are empty and the snippet options are ignored
+}
+
+// Query modes for SearchOptions.Mode.
+const (
+	QueryModeDefault = ""       // Terms OR'd, every word literal (buildFTSQuery)
+	QueryModeSimple  = "simple" // and/or/not words act as operators (buildSimpleFTSQuery)
+	QueryModeRaw     = "raw"    // Passed to FTS5 unchanged, for power users
+)
+
+// Snippet columns for SearchOptions.SnippetColumn.
+const (
+	SnippetContent = iota
+	SnippetTitle
+	SnippetLink
+)
+
+// snippetColumns maps SnippetColumn values to items_fts column indexes.
+var snippetColumns = map[int]int{SnippetTitle: 0, SnippetContent: 1, SnippetLink: 2}
+
+const (
+	// DefaultSearchLimit is the result count used when SearchOptions.Limit
+	// is unset.
+	DefaultSearchLimit = 20
+	// DefaultSnippetTokens is the snippet length when SnippetTokens is unset.
+	DefaultSnippetTokens = 20
+	// MaxSnippetTokens is the largest snippet FTS5 will produce.
+	MaxSnippetTokens = 64
+
+	DefaultMarkOpen  = "<mark>"
+	DefaultMarkClose = "</mark>"
+	// MaxMarkLen bounds custom highlight markers.
+	MaxMarkLen = 16
+)
+
+// ErrInvalidMarker is returned for a highlight marker that is too long or
+// contains characters outside the allowed set.
+var ErrInvalidMarker = errors.New("invalid highlight marker")
+
+// markerChars are the characters allowed in custom highlight markers: enough
+// for tags like <em> or markers like [[ ]], but no quotes, ampersands, or
+// whitespace.
+const markerChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789<>/[]{}()*_=~^|#@!+-.:"
+
+// ValidateMarker checks a custom highlight marker.
+func ValidateMarker(m string) error {
+	if m == "" || len(m) > MaxMarkLen || strings.Trim(m, markerChars) != "" {
+		return fmt.Errorf("%w %q: use up to %d letters, digits, or <>/[]{}()*_=~^|#@!+-.:", ErrInvalidMarker, m, MaxMarkLen)
+	}
+	return nil
+}
+
+// Sentinels bracket matches when Highlights is set; splitHighlights strips
+// them and records the offsets.
+const (
+	highlightOpen  = "\x01"
+	highlightClose = "\x02"
+)
+
+// dedupeCandidateFactor controls how many extra candidates a deduplicated
+// search fetches so that collapsing duplicates still fills the limit.
+const dedupeCandidateFactor = 5
+
+func (s *Store) Search(query string, limit, offset int) ([]SearchResult, error) {
+	return s.SearchWithOptions(query, SearchOptions{Limit: limit, Offset: offset})
+}
+
+func (s *Store) SearchWithOptions(query string, opts SearchOptions) ([]SearchResult, error) {
+	return s.SearchContext(context.Background(), query, opts)
+}
+
+// SearchContext is SearchWithOptions with a context that cancels the query.
+func (s *Store) SearchContext(ctx context.Context, query string, opts SearchOptions) ([]SearchResult, error) {
+	opSearch.Inc()
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultSearchLimit
+	}
+
+	ftsQuery, err := searchQuery(query, opts)
+	if err != nil {
+		return nil, err
+	}
+	if ftsQuery == "" {
+		return []SearchResult{}, nil
+	}
+
+	// Dedupe runs after the query, so its candidate window covers every
+	// page up to the requested one and the offset is applied afterwards
+	fetch, offset := opts.Limit, opts.Offset
+	if opts.Dedupe {
+		fetch, offset = (opts.Offset+opts.Limit)*dedupeCandidateFactor, 0
+	}
+
+	tokens := opts.SnippetTokens
+	if tokens <= 0 {
+		tokens = DefaultSnippetTokens
+	}
+	tokens = min(tokens, MaxSnippetTokens)
+	column, ok := snippetColumns[opts.SnippetColumn]
+	if !ok {
+		return nil, fmt.Errorf("search: unknown snippet column %d", opts.SnippetColumn)
+	}
+
+	markOpen, markClose := DefaultMarkOpen, DefaultMarkClose
+	if opts.MarkOpen != "" {
+		if err := ValidateMarker(opts.MarkOpen); err != nil {
+			return nil, err
+		}
+		markOpen = opts.MarkOpen
+	}
+	if opts.MarkClose != "" {
+		if err := ValidateMarker(opts.MarkClose); err != nil {
+			return nil, err
+		}
+		markClose = opts.MarkClose
+	}
+	if opts.Highlights {
+		markOpen, markClose = highlightOpen, highlightClose
+	}
+
+	// snippet() re-tokenizes the matched column of every returned row,
+	// which dominates the cost of short-result searches. Light results
+	// skip it and leave out content, the bulk of each row, too.
+	columns := selectItemColumns("i")
+	snippetExpr := "snippet(items_fts, ?, ?, ?, '...', ?)"
+	var snippetArgs []any
+	if opts.OmitSnippet {
+		columns = selectItemColumnsNoContent("i")
+		snippetExpr = "''"
+	} else {
+		snippetArgs = []any{column, markOpen, markClose, tokens}
+	}
+
+	where, args := searchFilter(ftsQuery, opts)
+	sqlQuery := `
+		SELECT ` + columns + `,
+			   bm25(items_fts) as rank,
+			   ` + snippetExpr + ` as snippet
+		FROM items_fts
+		JOIN items i ON items_fts.rowid = i.rowid
+		WHERE ` + where + `
+		ORDER BY rank, i.created_at, i.id
+		LIMIT ? OFFSET ?`
+	args = append(snippetArgs, args...)
+	args = append(args, fetch, offset)
+
+	// FTS5 search with BM25 ranking
+	rows, err := s.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, s.matchError(ctx, ftsQuery, fmt.Errorf("search: %w", err))
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		item, err := scanItemRow(rows, &r.Rank, &r.Snippet)
+		if err != nil {
+			return nil, s.matchError(ctx, ftsQuery, fmt.Errorf("scan: %w", err))
+		}
+		r.Item = item
+		if opts.Highlights && !opts.OmitSnippet {
+			r.Snippet, r.Highlights = splitHighlights(r.Snippet)
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, s.matchError(ctx, ftsQuery, err)
+	}
+
+	if opts.Dedupe {
+		results = dedupeResults(results)
+		results = results[min(opts.Offset, len(results)):]
+		if len(results) > opts.Limit {
+			results = results[:opts.Limit]
+		}
+	}
+
+	if err := s.loadResultTags(ctx, results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// splitHighlights removes the highlight sentinels from a snippet and returns
+// the plain text with the offsets of each marked span.
+func splitHighlights(snippet string) (string, []HighlightSpan) {
+	var b strings.Builder
+	var spans []HighlightSpan
+	n, start := 0, -1
+	for _, r := range snippet {
+		switch string(r) {
+		case highlightOpen:
+			start = n
+		case highlightClose:
+			if start >= 0 {
+				spans = append(spans, HighlightSpan{Start: start, End: n})
+				start = -1
+			}
+		default:
+			b.WriteRune(r)
+			n++
+		}
+	}
+	return b.String(), spans
+}
+
+// CountSearch returns the number of live items matching query and the tag
+// filter in opts, ignoring Limit and Dedupe, so callers can report a total.
+func (s *Store) CountSearch(query string, opts SearchOptions) (int, error) {
+	return s.CountSearchContext(context.Background(), query, opts)
+}
+
+// CountSearchContext is CountSearch with a context that cancels the query.
+func (s *Store) CountSearchContext(ctx context.Context, query string, opts SearchOptions) (int, error) {
+	ftsQuery, err := searchQuery(query, opts)
+	if err != nil {
+		return 0, err
+	}
+	if ftsQuery == "" {
+		return 0, nil
+	}
+
+	where, args := searchFilter(ftsQuery, opts)
+	var n int
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM items_fts
+		JOIN items i ON items_fts.rowid = i.rowid
+		WHERE `+where, args...).Scan(&n)
+	if err != nil {
+		return 0, s.matchError(ctx, ftsQuery, fmt.Errorf("count search: %w", err))
+	}
+	return n, nil
+}
+
+// Suggestion limits for SuggestTitles.
+const (
+	DefaultSuggestLimit = 10
+	MaxSuggestLimit     = 50
+)
+
+// SuggestTitles returns titles of live items matching prefix for
+// type-ahead. Every word of prefix must appear in the title, the last as a
+// word prefix, ignoring case; "sql gu" suggests "SQLite
+// Guide". Titles that start with prefix come first, then by rank and
+// recency. Limit defaults to DefaultSuggestLimit and is capped at
+// MaxSuggestLimit.
+func (s *Store) SuggestTitles(prefix string, limit int) ([]string, error) {
+	return s.SuggestTitlesContext(context.Background(), prefix, limit)
+}
+
+// SuggestTitlesContext is SuggestTitles with a context that cancels the
+// query.
+func (s *Store) SuggestTitlesContext(ctx context.Context, prefix string, limit int) ([]string, error) {
+	if limit <= 0 {
+		limit = DefaultSuggestLimit
+	}
+	limit = min(limit, MaxSuggestLimit)
+
+	terms, _ := parseFTSTerms(prefix, true)
+	if len(terms) == 0 {
+		return []string{}, nil
+	}
+	tokens := make([]string, len(terms))
+	words := make([]string, len(terms))
+	for i, t := range terms {
+		// Field prefixes and quotes have no meaning in a title prefix
+		t.column, t.phrase = "", false
+		tokens[i] = t.render(i == len(terms)-1)
+		words[i] = t.text
+	}
+	ftsQuery := "{title} : (" + strings.Join(tokens, " ") + ")"
+
+	likePrefix := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.Join(words, " ")) + "%"
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT i.title
+		FROM items_fts
+		JOIN items i ON items_fts.rowid = i.rowid
+		WHERE items_fts MATCH ? AND i.deleted_at IS NULL
+		ORDER BY i.title LIKE ? ESCAPE '\' DESC, bm25(items_fts), i.updated_at DESC, i.id
+		LIMIT ?`, ftsQuery, likePrefix, limit)
+	if err != nil {
+		return nil, s.matchError(ctx, ftsQuery, fmt.Errorf("suggest: %w", err))
+	}
+	defer rows.Close()
+
+	titles := []string{}
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		titles = append(titles, title)
+	}
+	return titles, rows.Err()
+}
+
+// ErrSearchSyntax is returned when FTS5 rejects a search query, which is
+// only possible in QueryModeRaw since the other modes quote every term.
+var ErrSearchSyntax = errors.New("invalid search query")
+
+// matchError classifies a failed search. SQLite reports FTS5 parse errors
+// as a generic SQLITE_ERROR, so when err is one, the FTS query is run alone
+// against items_fts: if that also fails, the query itself is at fault and
+// ErrSearchSyntax is returned. Anything else keeps err.
+func (s *Store) matchError(ctx context.Context, ftsQuery string, err error) error {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrError {
+		return err
+	}
+	var rowid int64
+	verr := s.db.QueryRowContext(ctx, "SELECT rowid FROM items_fts WHERE items_fts MATCH ? LIMIT 1", ftsQuery).Scan(&rowid)
+	if !errors.As(verr, &sqliteErr) || sqliteErr.Code != sqlite3.ErrError {
+		return err
+	}
+	return fmt.Errorf("%w: %s", ErrSearchSyntax, strings.TrimPrefix(sqliteErr.Error(), "fts5: "))
+}
+
+// searchFilter builds the WHERE clause shared by SearchWithOptions and
+// CountSearch.
+func searchFilter(ftsQuery string, opts SearchOptions) (string, []any) {
+	where := "items_fts MATCH ? AND i.deleted_at IS NULL"
+	args := []any{ftsQuery}
+	if tags := normalizeTags(opts.Tags); len(tags) > 0 {
+		clause, tagArgs := tagFilter("i.id", tags)
+		where += " AND " + clause
+		args = append(args, tagArgs...)
+	}
+	if opts.ExcludeArchived {
+		where += " AND i.archived = 0"
+	}
+	return where, args
+}
+
+// dedupeResults collapses results sharing a normalized title. Input must be
+// ordered by rank; the first result of each group is kept and its
+// DuplicateCount records how many others were dropped.
+func dedupeResults(results []SearchResult) []SearchResult {
+	var out []SearchResult
+	seen := make(map[string]int)
+	for _, r := range results {
+		key := normalizeTitle(r.Item.Title)
+		if idx, ok := seen[key]; ok {
+			out[idx].DuplicateCount++
+			continue
+		}
+		seen[key] = len(out)
+		out = append(out, r)
+	}
+	return out
+}
+
+// normalizeTitle reduces a title to a comparison key: lowercased, with
+// punctuation dropped and runs of whitespace collapsed to a single space.
+func normalizeTitle(title string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r):
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// ftsOperators are FTS5 keywords. buildFTSQuery quotes them like any other
+// term, but prefix mode never turns them into prefix queries.
+var ftsOperators = map[string]bool{"AND": true, "OR": true, "NOT": true, "NEAR": true}
+
+// SearchFields are the items_fts columns a query may scope terms to with
+// field:term.
+var SearchFields = []string{"title", "content", "link"}
+
+// ErrUnknownSearchField is returned for a field:term filter naming a column
+// that isn't in SearchFields.
+var ErrUnknownSearchField = errors.New("unknown search field")
+
+// ftsTerm is one parsed search term before rendering to FTS5 syntax.
+type ftsTerm struct {
+	column string // Restrict to this FTS column; empty matches all
+	text   string
+	phrase bool // From a quoted phrase
+}
+
+// buildFTSQuery transforms user search input into a safe FTS5 query.
+// - Unquoted terms are OR'd together: "foo bar" → "foo" OR "bar"
+// - Quoted phrases are preserved: `"foo bar"` → "foo bar"
+// - All tokens are quoted to escape FTS5 special characters (*, ^, NEAR, etc.)
+// - Embedded quotes are escaped by doubling: `say "hi"` → "say" OR "hi"
+// - field:term scopes a term or phrase to a column: title:foo → {title}:"foo"
+// - With prefix, a trailing bare term becomes a prefix query: "sqli" → "sqli"*
+func buildFTSQuery(query string, prefix bool) (string, error) {
+	terms, err := parseFTSTerms(query, false)
+	if err != nil || len(terms) == 0 {
+		return "", err
+	}
+
+	tokens := make([]string, len(terms))
+	for i, t := range terms {
+		tokens[i] = t.render(prefix && i == len(terms)-1)
+	}
+	return strings.Join(tokens, " OR "), nil
+}
+
+// EscapeFTSQuery turns arbitrary text into an FTS5 query that always
+// parses: each bare word is quoted, embedded quotes are doubled, quoted
+// phrases stay grouped, and the terms are OR'd. Known field prefixes
+// (title:go) scope a term as in the default mode; any other colon,
+// parenthesis, asterisk, or operator keyword is matched literally.
+func EscapeFTSQuery(raw string) string {
+	terms, _ := parseFTSTerms(raw, true)
+	tokens := make([]string, len(terms))
+	for i, t := range terms {
+		tokens[i] = t.render(false)
+	}
+	return strings.Join(tokens, " OR ")
+}
+
+// buildSimpleFTSQuery is the QueryModeSimple query: input is escaped as by
+// EscapeFTSQuery, so pasted text never fails to parse, except that the bare
+// words and, or, and not (any case) between two terms become FTS5
+// operators, and "and not" means NOT. An operator word with no term on one
+// side, or following another operator, is searched for as a plain term.
+// Terms without an operator between them are OR'd as in the default mode.
+func buildSimpleFTSQuery(query string, prefix bool) string {
+	terms, _ := parseFTSTerms(query, true)
+
+	var out []string
+	pending := "" // Operator waiting for its right-hand term
+	for i := 0; i < len(terms); i++ {
+		// An operator needs a term before it (not another operator) and
+		// one after it
+		canJoin := func(skip int) bool {
+			return len(out) > 0 && pending == "" && i+skip+1 < len(terms)
+		}
+		op, skip := terms[i].operator(), 0
+		if op == "AND" && i+1 < len(terms) && terms[i+1].operator() == "NOT" && canJoin(1) {
+			op, skip = "NOT", 1
+		}
+		if op != "" && canJoin(skip) {
+			pending = op
+			i += skip
+			continue
+		}
+
+		if len(out) > 0 {
+			if pending == "" {
+				pending = "OR"
+			}
+			out = append(out, pending)
+		}
+		pending = ""
+		out = append(out, terms[i].render(prefix && i == len(terms)-1))
+	}
+	return strings.Join(out, " ")
+}
+
+// parseFTSTerms splits search input into terms, phrases, and field-scoped
+// terms for buildFTSQuery and buildSimpleFTSQuery. An unknown field name is
+// an error unless lenient is set, in which case "owner:me" is searched for
+// as written.
+func parseFTSTerms(query string, lenient bool) ([]ftsTerm, error) {
+	var terms []ftsTerm
+	var buf strings.Builder
+	inQuote := false
+	column := "" // Field named just before an opening quote
+
+	flush := func(phrase bool) error {
+		text := strings.TrimSpace(buf.String())
+		buf.Reset()
+		col := ""
+		if phrase {
+			col, column = column, ""
+		} else if name, rest, ok := splitField(text); ok {
+			switch {
+			case slices.Contains(SearchFields, name):
+				col, text = name, rest
+			case !lenient:
+				return fmt.Errorf("%w %q (searchable: %s)", ErrUnknownSearchField, name, strings.Join(SearchFields, ", "))
+			}
+		}
+		if text != "" {
+			terms = append(terms, ftsTerm{column: col, text: text, phrase: phrase})
+		}
+		return nil
+	}
+
+	for _, r := range query {
+		var err error
+		switch {
+		case r == '"':
+			if inQuote {
+				err = flush(true)
+				inQuote = false
+			} else {
+				// title:"a phrase" arrives as a bare "title:" then the phrase
+				if name, ok := strings.CutSuffix(strings.TrimSpace(buf.String()), ":"); ok && isFieldName(name) {
+					name = strings.ToLower(name)
+					switch {
+					case slices.Contains(SearchFields, name):
+						buf.Reset()
+						column = name
+					case !lenient:
+						return nil, fmt.Errorf("%w %q (searchable: %s)", ErrUnknownSearchField, name, strings.Join(SearchFields, ", "))
+					}
+				}
+				err = flush(false)
+				inQuote = true
+			}
+		case unicode.IsSpace(r):
+			if inQuote {
+				buf.WriteRune(r)
+			} else {
+				err = flush(false)
+			}
+		default:
+			buf.WriteRune(r)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	// An unclosed quote still counts as a phrase
+	if err := flush(inQuote); err != nil {
+		return nil, err
+	}
+	return terms, nil
+}
+
+// operator returns the FTS5 operator a bare and/or/not term stands for in
+// QueryModeSimple, or "".
+func (t ftsTerm) operator() string {
+	if t.phrase || t.column != "" {
+		return ""
+	}
+	switch op := strings.ToUpper(t.text); op {
+	case "AND", "OR", "NOT":
+		return op
+	}
+	return ""
+}
+
+// render quotes the term for FTS5, as a prefix query if prefix is set and
+// the term is a bare word.
+func (t ftsTerm) render(prefix bool) string {
+	token := `"` + strings.ReplaceAll(t.text, `"`, `""`) + `"`
+	if prefix && !t.phrase {
+		token = prefixToken(t.text, token)
+	}
+	if t.column != "" {
+		token = "{" + t.column + "}:" + token
+	}
+	return token
+}
+
+// ErrInvalidSearchColumn is returned for a SearchOptions.Columns entry that
+// is not one of SearchFields.
+var ErrInvalidSearchColumn = errors.New("invalid search column")
+
+// ErrInvalidQueryMode is returned for an unknown SearchOptions.Mode.
+var ErrInvalidQueryMode = errors.New("invalid query mode")
+
+// searchQuery builds the FTS5 query for a search according to opts.Mode,
+// restricting it to opts.Columns with a column filter when a subset is
+// requested.
+func searchQuery(query string, opts SearchOptions) (string, error) {
+	var columns []string
+	for _, c := range opts.Columns {
+		if !slices.Contains(SearchFields, c) {
+			return "", fmt.Errorf("%w %q (use %s)", ErrInvalidSearchColumn, c, strings.Join(SearchFields, ", "))
+		}
+		if !slices.Contains(columns, c) {
+			columns = append(columns, c)
+		}
+	}
+
+	var ftsQuery string
+	var err error
+	switch opts.Mode {
+	case QueryModeDefault:
+		ftsQuery, err = buildFTSQuery(query, opts.Prefix)
+	case QueryModeSimple:
+		ftsQuery = buildSimpleFTSQuery(query, opts.Prefix)
+	case QueryModeRaw:
+		ftsQuery = strings.TrimSpace(query)
+	default:
+		return "", fmt.Errorf("%w %q", ErrInvalidQueryMode, opts.Mode)
+	}
+	if err != nil || ftsQuery == "" || len(columns) == 0 || len(columns) == len(SearchFields) {
+		return ftsQuery, err
+	}
+	return "{" + strings.Join(columns, " ") + "} : (" + ftsQuery + ")", nil
+}
+
+// splitField splits a bare field:term token. Only a letters-only name
+// followed by a non-empty term counts, so URLs like https://host stay
+// ordinary search terms.
+func splitField(text string) (name, rest string, ok bool) {
+	name, rest, found := strings.Cut(text, ":")
+	if !found || rest == "" || strings.HasPrefix(rest, "/") || !isFieldName(name) {
+		return "", "", false
+	}
+	return strings.ToLower(name), rest, true
+}
+
+func isFieldName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// prefixToken turns a bare term into an FTS5 prefix query, leaving operator
+// keywords and terms without any letters or digits as the quoted token.
+func prefixToken(text, token string) string {
+	term := strings.TrimRight(text, "*")
+	if ftsOperators[term] || !strings.ContainsFunc(term, func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsNumber(r)
+	}) {
+		return token
+	}
+	return `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanItemRow scans the columns in itemColumns, followed by any extra
+// destinations for trailing computed columns.
+func scanItemRow(sc rowScanner, extra ...any) (Item, error) {
+	var item Item
+	var createdAt, updatedAt string
+	var link, changedAt, deletedAt sql.NullString
+
+	dest := append([]any{&item.ID, &item.Title, &link, &item.Content, &item.Rev, &item.CreatedBy, &item.Pinned, &item.Archived, &item.Color, &createdAt, &updatedAt, &changedAt, &deletedAt}, extra...)
+	if err := sc.Scan(dest...); err != nil {
+		return Item{}, err
+	}
+
+	item.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	item.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+	item.ChangedAt = item.UpdatedAt
+	if changedAt.Valid {
+		item.ChangedAt, _ = time.Parse(time.RFC3339, changedAt.String)
+	}
+	if link.Valid {
+		item.Link = &link.String
+	}
+	if deletedAt.Valid {
+		t, _ := time.Parse(time.RFC3339, deletedAt.String)
+		item.DeletedAt = &t
+	}
+
+	return item, nil
+}
 
+func scanItem(row *sql.Row) (*Item, error) {
+	item, err := scanItemRow(row)
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func scanItems(rows *sql.Rows) ([]Item, error) {
+	var items []Item
+	for rows.Next() {
+		item, err := scanItemRow(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
 		items = append(items, item)
 	}
 	return items, rows.Err()
@@ -441,6 +2148,8 @@ type TokenInfo struct {
 	CreatedAt  time.Time  `json:"created_at"`
 	ExpiresAt  time.Time  `json:"expires_at"`
 	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"` // Source IP of the most recent use
+	Scopes     []string   `json:"scopes"`                 // Empty for legacy unrestricted tokens
 }
 
 // GetOrCreateTokenSecret retrieves the HMAC secret for token signing,
@@ -466,14 +2175,14 @@ func (s *Store) GetOrCreateTokenSecret() ([]byte, error) {
 	return secret, nil
 }
 
-// CreateToken stores a new token's metadata and hash.
-func (s *Store) CreateToken(id, userCN, name string, tokenHash []byte, expiresAt time.Time) error {
-	now := time.Now().UTC().Format(time.RFC3339)
+// CreateToken stores a new token's metadata, hash, and scopes.
+func (s *Store) CreateToken(id, userCN, name string, tokenHash []byte, expiresAt time.Time, scopes []string) error {
+	now := s.now().Format(time.RFC3339)
 	expiresAtStr := expiresAt.Format(time.RFC3339)
 
 	_, err := s.db.Exec(
-		"INSERT INTO tokens (id, user_cn, name, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
-		id, userCN, name, tokenHash, now, expiresAtStr,
+		"INSERT INTO tokens (id, user_cn, name, token_hash, created_at, expires_at, scopes) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		id, userCN, name, tokenHash, now, expiresAtStr, strings.Join(scopes, " "),
 	)
 	if err != nil {
 		return fmt.Errorf("insert token: %w", err)
@@ -484,7 +2193,7 @@ func (s *Store) CreateToken(id, userCN, name string, tokenHash []byte, expiresAt
 // ListTokens returns all tokens for a given user.
 func (s *Store) ListTokens(userCN string) ([]TokenInfo, error) {
 	rows, err := s.db.Query(
-		"SELECT id, user_cn, name, created_at, expires_at, last_used_at FROM tokens WHERE user_cn = ? ORDER BY created_at DESC",
+		"SELECT id, user_cn, name, created_at, expires_at, last_used_at, last_used_ip, scopes FROM tokens WHERE user_cn = ? ORDER BY created_at DESC",
 		userCN,
 	)
 	if err != nil {
@@ -494,24 +2203,32 @@ func (s *Store) ListTokens(userCN string) ([]TokenInfo, error) {
 
 	var tokens []TokenInfo
 	for rows.Next() {
-		var t TokenInfo
-		var createdAt, expiresAt string
-		var lastUsedAt sql.NullString
-
-		if err := rows.Scan(&t.ID, &t.UserCN, &t.Name, &createdAt, &expiresAt, &lastUsedAt); err != nil {
+		t, err := scanToken(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scan token: %w", err)
 		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
 
-		t.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
-		t.ExpiresAt, _ = time.Parse(time.RFC3339, expiresAt)
-		if lastUsedAt.Valid {
-			lu, _ := time.Parse(time.RFC3339, lastUsedAt.String)
-			t.LastUsedAt = &lu
-		}
+// CountTokens returns the number of stored API tokens, including expired
+// ones that have not yet been removed.
+func (s *Store) CountTokens() (int, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM tokens").Scan(&n)
+	return n, err
+}
 
-		tokens = append(tokens, t)
+// CountActiveTokens returns the number of unexpired tokens owned by userCN.
+func (s *Store) CountActiveTokens(userCN string) (int, error) {
+	now := s.now().Format(time.RFC3339)
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM tokens WHERE user_cn = ? AND expires_at > ?", userCN, now).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("count active tokens: %w", err)
 	}
-	return tokens, rows.Err()
+	return n, nil
 }
 
 // DeleteToken removes a token by ID (only if owned by the given user).
@@ -528,38 +2245,106 @@ func (s *Store) DeleteToken(id, userCN string) error {
 	return nil
 }
 
+// DeleteAllTokens revokes every token belonging to userCN, expired or
+// not, and returns how many were deleted.
+func (s *Store) DeleteAllTokens(userCN string) (int, error) {
+	result, err := s.db.Exec("DELETE FROM tokens WHERE user_cn = ?", userCN)
+	if err != nil {
+		return 0, fmt.Errorf("delete tokens: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// DeleteExpiredTokens removes every token whose expiry has passed and
+// returns how many were deleted.
+func (s *Store) DeleteExpiredTokens() (int, error) {
+	return s.DeleteExpiredTokensWithLeeway(0)
+}
+
+// DeleteExpiredTokensWithLeeway is DeleteExpiredTokens for tokens validated
+// with leeway: it keeps tokens LookupTokenHash would still accept, deleting
+// only those more than leeway past their expiry.
+func (s *Store) DeleteExpiredTokensWithLeeway(leeway time.Duration) (int, error) {
+	cutoff := s.now().Add(-leeway).Format(time.RFC3339)
+	result, err := s.db.Exec("DELETE FROM tokens WHERE expires_at < ?", cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired tokens: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// UpdateTokenName renames a token (only if owned by the given user).
+func (s *Store) UpdateTokenName(id, userCN, newName string) error {
+	result, err := s.db.Exec("UPDATE tokens SET name = ? WHERE id = ? AND user_cn = ?", newName, id, userCN)
+	if err != nil {
+		return fmt.Errorf("rename token: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 // ValidateTokenHash checks if a token hash exists in the database and is not expired.
 // Returns the token ID if found and valid, or sql.ErrNoRows if not found/expired.
-func (s *Store) ValidateTokenHash(tokenHash []byte) (string, error) {
+// On success it records the use time and sourceIP.
+func (s *Store) ValidateTokenHash(tokenHash []byte, sourceIP string) (string, error) {
+	return s.ValidateTokenHashWithLeeway(tokenHash, sourceIP, 0)
+}
+
+// ValidateTokenHashWithLeeway is ValidateTokenHash accepting a token until
+// leeway past its expiry, so the database check agrees with the signed
+// claims checked under auth.MiddlewareConfig.TokenLeeway. Like the claims,
+// a token is valid through the second it expires.
+func (s *Store) ValidateTokenHashWithLeeway(tokenHash []byte, sourceIP string, leeway time.Duration) (string, error) {
+	id, err := s.LookupTokenHash(tokenHash, leeway)
+	if err != nil {
+		return "", err
+	}
+
+	// Update last_used_at and last_used_ip
+	s.db.Exec("UPDATE tokens SET last_used_at = ?, last_used_ip = ? WHERE token_hash = ?", s.now().Format(time.RFC3339), sourceIP, tokenHash)
+
+	return id, nil
+}
+
+// LookupTokenHash is ValidateTokenHashWithLeeway without recording a use,
+// for checking a token on someone else's behalf.
+func (s *Store) LookupTokenHash(tokenHash []byte, leeway time.Duration) (string, error) {
 	var id string
-	now := time.Now().UTC().Format(time.RFC3339)
 
 	// Check both existence and expiration in one query for defense-in-depth
 	err := s.db.QueryRow(
-		"SELECT id FROM tokens WHERE token_hash = ? AND expires_at > ?",
-		tokenHash, now,
+		"SELECT id FROM tokens WHERE token_hash = ? AND expires_at >= ?",
+		tokenHash, s.now().Add(-leeway).Format(time.RFC3339),
 	).Scan(&id)
 	if err != nil {
 		return "", err
 	}
-
-	// Update last_used_at
-	s.db.Exec("UPDATE tokens SET last_used_at = ? WHERE token_hash = ?", now, tokenHash)
-
 	return id, nil
 }
 
 // GetTokenByID retrieves a token by its ID.
 func (s *Store) GetTokenByID(id string) (*TokenInfo, error) {
+	row := s.db.QueryRow(
+		"SELECT id, user_cn, name, created_at, expires_at, last_used_at, last_used_ip, scopes FROM tokens WHERE id = ?",
+		id,
+	)
+	return scanToken(row)
+}
+
+// scanToken scans id, user_cn, name, created_at, expires_at, last_used_at,
+// last_used_ip, and scopes into a TokenInfo.
+func scanToken(sc rowScanner) (*TokenInfo, error) {
 	var t TokenInfo
-	var createdAt, expiresAt string
-	var lastUsedAt sql.NullString
+	var createdAt, expiresAt, scopes string
+	var lastUsedAt, lastUsedIP sql.NullString
 
-	err := s.db.QueryRow(
-		"SELECT id, user_cn, name, created_at, expires_at, last_used_at FROM tokens WHERE id = ?",
-		id,
-	).Scan(&t.ID, &t.UserCN, &t.Name, &createdAt, &expiresAt, &lastUsedAt)
-	if err != nil {
+	if err := sc.Scan(&t.ID, &t.UserCN, &t.Name, &createdAt, &expiresAt, &lastUsedAt, &lastUsedIP, &scopes); err != nil {
 		return nil, err
 	}
 
@@ -569,6 +2354,13 @@ func (s *Store) GetTokenByID(id string) (*TokenInfo, error) {
 		lu, _ := time.Parse(time.RFC3339, lastUsedAt.String)
 		t.LastUsedAt = &lu
 	}
+	if lastUsedIP.Valid {
+		t.LastUsedIP = lastUsedIP.String
+	}
+	t.Scopes = strings.Fields(scopes)
+	if t.Scopes == nil {
+		t.Scopes = []string{}
+	}
 
 	return &t, nil
 }
@@ -1,9 +1,19 @@
 package store
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"math/rand/v2"
 	"os"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/alanp/cue/internal/clock"
 )
 
 func TestIntegrationStore(t *testing.T) {
@@ -23,7 +33,7 @@ func TestIntegrationStore(t *testing.T) {
 
 	// Test Create
 	t.Run("Create", func(t *testing.T) {
-		item, err := s.Create("Test Item", "# Hello\n\nThis is content", nil)
+		item, err := s.Create("Test Item", "# Hello\n\nThis is content", nil, nil, "")
 		if err != nil {
 			t.Fatalf("Create: %v", err)
 		}
@@ -38,7 +48,7 @@ func TestIntegrationStore(t *testing.T) {
 	// Test Create with link
 	t.Run("CreateWithLink", func(t *testing.T) {
 		link := "https://example.com"
-		item, err := s.Create("Linked Item", "content", &link)
+		item, err := s.Create("Linked Item", "content", &link, nil, "")
 		if err != nil {
 			t.Fatalf("Create: %v", err)
 		}
@@ -49,7 +59,7 @@ func TestIntegrationStore(t *testing.T) {
 
 	// Test Get
 	t.Run("Get", func(t *testing.T) {
-		created, _ := s.Create("Get Test", "content", nil)
+		created, _ := s.Create("Get Test", "content", nil, nil, "")
 		item, err := s.Get(created.ID)
 		if err != nil {
 			t.Fatalf("Get: %v", err)
@@ -61,7 +71,7 @@ func TestIntegrationStore(t *testing.T) {
 
 	// Test GetByTitle
 	t.Run("GetByTitle", func(t *testing.T) {
-		s.Create("Unique Title", "content", nil)
+		s.Create("Unique Title", "content", nil, nil, "")
 		item, err := s.GetByTitle("Unique Title")
 		if err != nil {
 			t.Fatalf("GetByTitle: %v", err)
@@ -73,9 +83,9 @@ func TestIntegrationStore(t *testing.T) {
 
 	// Test Update
 	t.Run("Update", func(t *testing.T) {
-		created, _ := s.Create("Update Test", "old content", nil)
+		created, _ := s.Create("Update Test", "old content", nil, nil, "")
 		link := "~/docs/test.md"
-		updated, err := s.Update(created.ID, "Updated Title", "new content", &link)
+		updated, err := s.Update(created.ID, "Updated Title", "new content", &link, nil, 0)
 		if err != nil {
 			t.Fatalf("Update: %v", err)
 		}
@@ -92,7 +102,7 @@ func TestIntegrationStore(t *testing.T) {
 
 	// Test Delete
 	t.Run("Delete", func(t *testing.T) {
-		created, _ := s.Create("Delete Test", "content", nil)
+		created, _ := s.Create("Delete Test", "content", nil, nil, "")
 		err := s.Delete(created.ID)
 		if err != nil {
 			t.Fatalf("Delete: %v", err)
@@ -113,9 +123,9 @@ func TestIntegrationStore(t *testing.T) {
 		s2, _ := New(tmpFile2.Name())
 		defer s2.Close()
 
-		s2.Create("Item 1", "content 1", nil)
-		s2.Create("Item 2", "content 2", nil)
-		s2.Create("Item 3", "content 3", nil)
+		s2.Create("Item 1", "content 1", nil, nil, "")
+		s2.Create("Item 2", "content 2", nil, nil, "")
+		s2.Create("Item 3", "content 3", nil, nil, "")
 
 		items, err := s2.List(10, 0)
 		if err != nil {
@@ -136,11 +146,11 @@ func TestIntegrationStore(t *testing.T) {
 		s3, _ := New(tmpFile3.Name())
 		defer s3.Close()
 
-		s3.Create("SQLite Guide", "Full-text search with FTS5", nil)
-		s3.Create("Go Patterns", "HTTP middleware patterns", nil)
-		s3.Create("React Tips", "Server components and hooks", nil)
+		s3.Create("SQLite Guide", "Full-text search with FTS5", nil, nil, "")
+		s3.Create("Go Patterns", "HTTP middleware patterns", nil, nil, "")
+		s3.Create("React Tips", "Server components and hooks", nil, nil, "")
 
-		results, err := s3.Search("SQLite", 10)
+		results, err := s3.Search("SQLite", 10, 0)
 		if err != nil {
 			t.Fatalf("Search: %v", err)
 		}
@@ -161,9 +171,9 @@ func TestIntegrationStore(t *testing.T) {
 		s4, _ := New(tmpFile4.Name())
 		defer s4.Close()
 
-		s4.Create("Guide", "Full-text search with FTS5 extension", nil)
+		s4.Create("Guide", "Full-text search with FTS5 extension", nil, nil, "")
 
-		results, err := s4.Search("FTS5", 10)
+		results, err := s4.Search("FTS5", 10, 0)
 		if err != nil {
 			t.Fatalf("Search: %v", err)
 		}
@@ -173,6 +183,51 @@ func TestIntegrationStore(t *testing.T) {
 	})
 }
 
+func TestCreatedBy(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-owner-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	anon, _ := s.Create("Anonymous", "", nil, nil, "")
+	mine, _ := s.Create("Mine", "", nil, nil, "alice")
+	s.Create("Theirs", "", nil, nil, "bob")
+
+	if anon.CreatedBy != DefaultOwner {
+		t.Errorf("default CreatedBy = %q, want %q", anon.CreatedBy, DefaultOwner)
+	}
+	got, err := s.Get(mine.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.CreatedBy != "alice" {
+		t.Errorf("CreatedBy = %q, want alice", got.CreatedBy)
+	}
+
+	items, err := s.ListWithOptions(ListOptions{CreatedBy: "alice"})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(items) != 1 || items[0].ID != mine.ID {
+		t.Errorf("alice's items = %v, want [Mine]", items)
+	}
+	if n, _ := s.CountWithOptions(ListOptions{CreatedBy: "bob"}); n != 1 {
+		t.Errorf("bob's count = %d, want 1", n)
+	}
+
+	// The migration backfills rows written without an owner
+	s.db.Exec("UPDATE items SET created_by = '' WHERE id = ?", anon.ID)
+	if err := migrateV7(s.db); err != nil {
+		t.Fatalf("migrateV7: %v", err)
+	}
+	got, _ = s.Get(anon.ID)
+	if got.CreatedBy != DefaultOwner {
+		t.Errorf("backfilled CreatedBy = %q, want %q", got.CreatedBy, DefaultOwner)
+	}
+}
+
 func TestDuplicateTitle(t *testing.T) {
 	tmpFile, _ := os.CreateTemp("", "cue-dup-*.db")
 	tmpFile.Close()
@@ -181,13 +236,133 @@ func TestDuplicateTitle(t *testing.T) {
 	s, _ := New(tmpFile.Name())
 	defer s.Close()
 
-	s.Create("Same Title", "content 1", nil)
-	_, err := s.Create("Same Title", "content 2", nil)
+	s.Create("Same Title", "content 1", nil, nil, "")
+	_, err := s.Create("Same Title", "content 2", nil, nil, "")
 	if err == nil {
 		t.Error("expected error for duplicate title")
 	}
 }
 
+func TestDuplicateTitleIgnoresCase(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-dup-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	s.Create("Foo", "", nil, nil, "")
+	if _, err := s.Create("foo", "", nil, nil, ""); err == nil || !strings.Contains(err.Error(), "UNIQUE constraint") {
+		t.Errorf("Create(foo) err = %v, want UNIQUE constraint error", err)
+	}
+
+	other, _ := s.Create("Bar", "", nil, nil, "")
+	if _, err := s.Update(other.ID, "FOO", "", nil, nil, 0); err == nil || !strings.Contains(err.Error(), "UNIQUE constraint") {
+		t.Errorf("Update to FOO err = %v, want UNIQUE constraint error", err)
+	}
+
+	// Changing only the case of an item's own title is fine
+	if _, err := s.Update(other.ID, "BAR", "", nil, nil, 0); err != nil {
+		t.Errorf("Update own title case: %v", err)
+	}
+}
+
+func TestMigrateV10ReportsCaseDuplicates(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-dup-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	// Recreate a pre-V10 database holding case-only duplicates
+	s.db.Exec("DROP INDEX idx_items_title_nocase")
+	s.Create("SQLite Guide", "", nil, nil, "")
+	s.Create("sqlite guide", "", nil, nil, "")
+	s.Create("Unique", "", nil, nil, "")
+
+	err := migrateV10(s.db)
+	if err == nil {
+		t.Fatal("expected migrateV10 to report duplicates")
+	}
+	if !strings.Contains(err.Error(), "SQLite Guide") || !strings.Contains(err.Error(), "sqlite guide") || strings.Contains(err.Error(), "Unique") {
+		t.Errorf("err = %v, want both colliding titles and nothing else", err)
+	}
+
+	item, _ := s.GetByTitle("sqlite guide")
+	s.Update(item.ID, "SQLite Guide (old)", "", nil, nil, 0)
+	if err := migrateV10(s.db); err != nil {
+		t.Errorf("migrateV10 after rename: %v", err)
+	}
+}
+
+func TestMigrateV11FoldsDiacritics(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-diacritics-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	// Recreate a pre-V11 index using the default tokenizer
+	_, err := s.db.Exec(`
+		DROP TABLE items_fts;
+		CREATE VIRTUAL TABLE items_fts USING fts5(title, content, link, content='items', content_rowid='rowid');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Create("Café Notes", "crème brûlée, phở", nil, nil, "")
+	trashed, _ := s.Create("Old Café", "", nil, nil, "")
+	s.Delete(trashed.ID)
+	// The default strips single diacritics but not stacked ones like ở
+	if results, _ := s.Search("pho", 10, 0); len(results) != 0 {
+		t.Fatalf("pre-V11 search for pho found %d results, want 0", len(results))
+	}
+
+	if err := migrateV11(s.db); err != nil {
+		t.Fatalf("migrateV11: %v", err)
+	}
+	for _, q := range []string{"cafe", "café", "creme brulee", "pho", "phở"} {
+		results, _ := s.Search(q, 10, 0)
+		if len(results) != 1 || results[0].Item.Title != "Café Notes" {
+			t.Errorf("Search(%q) = %v, want [Café Notes]", q, results)
+		}
+	}
+	if problems, _ := s.IntegrityCheck(); len(problems) != 0 {
+		t.Errorf("problems after migration = %q", problems)
+	}
+}
+
+func TestCleanTitle(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-title-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	item, err := s.Create(" Hello  World ", "", nil, nil, "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if item.Title != "Hello World" {
+		t.Errorf("stored title = %q, want %q", item.Title, "Hello World")
+	}
+	if _, err := s.Create("Hello World", "", nil, nil, ""); err == nil || !strings.Contains(err.Error(), "UNIQUE constraint") {
+		t.Errorf("Create(Hello World) err = %v, want UNIQUE constraint error", err)
+	}
+
+	other, _ := s.Create("Other", "", nil, nil, "")
+	if _, err := s.Update(other.ID, "hello\tworld\n", "", nil, nil, 0); err == nil {
+		t.Error("Update to a whitespace variant of an existing title should conflict")
+	}
+	updated, err := s.Update(other.ID, "  Other   Title", "", nil, nil, 0)
+	if err != nil || updated.Title != "Other Title" {
+		t.Errorf("Update title = %v, %v; want %q", updated, err, "Other Title")
+	}
+}
+
 func TestGetNonExistent(t *testing.T) {
 	tmpFile, _ := os.CreateTemp("", "cue-get-*.db")
 	tmpFile.Close()
@@ -238,7 +413,7 @@ func TestUpdateNonExistent(t *testing.T) {
 	s, _ := New(tmpFile.Name())
 	defer s.Close()
 
-	_, err := s.Update("nonexistent-id", "Title", "Content", nil)
+	_, err := s.Update("nonexistent-id", "Title", "Content", nil, nil, 0)
 	if err == nil {
 		t.Error("expected error for updating non-existent ID")
 	}
@@ -252,7 +427,7 @@ func TestEmptyContent(t *testing.T) {
 	s, _ := New(tmpFile.Name())
 	defer s.Close()
 
-	item, err := s.Create("Empty Content Item", "", nil)
+	item, err := s.Create("Empty Content Item", "", nil, nil, "")
 	if err != nil {
 		t.Fatalf("Create with empty content: %v", err)
 	}
@@ -273,7 +448,7 @@ func TestUnicodeContent(t *testing.T) {
 	content := "こんにちは世界!\n\nEmoji: 🚀 🌍 ❤️\n\nMath: ∑∫∂√"
 	link := "https://例え.jp/パス"
 
-	item, err := s.Create(title, content, &link)
+	item, err := s.Create(title, content, &link, nil, "")
 	if err != nil {
 		t.Fatalf("Create with unicode: %v", err)
 	}
@@ -301,9 +476,9 @@ func TestSearchNoResults(t *testing.T) {
 	s, _ := New(tmpFile.Name())
 	defer s.Close()
 
-	s.Create("Alpha", "content about alpha", nil)
+	s.Create("Alpha", "content about alpha", nil, nil, "")
 
-	results, err := s.Search("zzzznonexistent", 10)
+	results, err := s.Search("zzzznonexistent", 10, 0)
 	if err != nil {
 		t.Fatalf("Search: %v", err)
 	}
@@ -321,9 +496,9 @@ func TestSearchInLink(t *testing.T) {
 	defer s.Close()
 
 	link := "https://github.com/unique-repo"
-	s.Create("My Item", "basic content", &link)
+	s.Create("My Item", "basic content", &link, nil, "")
 
-	results, err := s.Search("github", 10)
+	results, err := s.Search("github", 10, 0)
 	if err != nil {
 		t.Fatalf("Search: %v", err)
 	}
@@ -340,12 +515,12 @@ func TestSearchWithQuotes(t *testing.T) {
 	s, _ := New(tmpFile.Name())
 	defer s.Close()
 
-	s.Create("Exact Match Test", "the quick brown fox jumps", nil)
-	s.Create("Partial Match", "quick ideas and brown thoughts", nil)
-	s.Create("Single Term", "just quick here", nil)
+	s.Create("Exact Match Test", "the quick brown fox jumps", nil, nil, "")
+	s.Create("Partial Match", "quick ideas and brown thoughts", nil, nil, "")
+	s.Create("Single Term", "just quick here", nil, nil, "")
 
 	// Unquoted terms should match either term
-	results, err := s.Search("quick brown", 10)
+	results, err := s.Search("quick brown", 10, 0)
 	if err != nil {
 		t.Fatalf("Search: %v", err)
 	}
@@ -354,7 +529,7 @@ func TestSearchWithQuotes(t *testing.T) {
 	}
 
 	// Phrase search with quotes should be precise
-	results, err = s.Search(`"quick brown"`, 10)
+	results, err = s.Search(`"quick brown"`, 10, 0)
 	if err != nil {
 		t.Fatalf("Search: %v", err)
 	}
@@ -372,7 +547,7 @@ func TestListPagination(t *testing.T) {
 	defer s.Close()
 
 	for i := 0; i < 10; i++ {
-		s.Create(fmt.Sprintf("Item %d", i), "content", nil)
+		s.Create(fmt.Sprintf("Item %d", i), "content", nil, nil, "")
 	}
 
 	// First page
@@ -402,7 +577,7 @@ func TestListDefaultLimit(t *testing.T) {
 	s, _ := New(tmpFile.Name())
 	defer s.Close()
 
-	s.Create("Single Item", "content", nil)
+	s.Create("Single Item", "content", nil, nil, "")
 
 	// Zero limit should use default (50)
 	items, err := s.List(0, 0)
@@ -432,10 +607,10 @@ func TestClearLinkOnUpdate(t *testing.T) {
 	defer s.Close()
 
 	link := "https://example.com"
-	item, _ := s.Create("Has Link", "content", &link)
+	item, _ := s.Create("Has Link", "content", &link, nil, "")
 
 	// Update with nil link to clear it
-	updated, err := s.Update(item.ID, "Has Link", "content", nil)
+	updated, err := s.Update(item.ID, "Has Link", "content", nil, nil, 0)
 	if err != nil {
 		t.Fatalf("Update: %v", err)
 	}
@@ -452,11 +627,11 @@ func TestUpdateToDuplicateTitle(t *testing.T) {
 	s, _ := New(tmpFile.Name())
 	defer s.Close()
 
-	s.Create("First Title", "content 1", nil)
-	item2, _ := s.Create("Second Title", "content 2", nil)
+	s.Create("First Title", "content 1", nil, nil, "")
+	item2, _ := s.Create("Second Title", "content 2", nil, nil, "")
 
 	// Try to update second item to have first item's title
-	_, err := s.Update(item2.ID, "First Title", "content 2", nil)
+	_, err := s.Update(item2.ID, "First Title", "content 2", nil, nil, 0)
 	if err == nil {
 		t.Error("expected error for updating to duplicate title")
 	}
@@ -470,10 +645,10 @@ func TestSearchDefaultLimit(t *testing.T) {
 	s, _ := New(tmpFile.Name())
 	defer s.Close()
 
-	s.Create("Test Alpha", "alpha content", nil)
+	s.Create("Test Alpha", "alpha content", nil, nil, "")
 
 	// Zero limit should use default (20)
-	results, err := s.Search("alpha", 0)
+	results, err := s.Search("alpha", 0, 0)
 	if err != nil {
 		t.Fatalf("Search: %v", err)
 	}
@@ -499,13 +674,1828 @@ func TestBuildFTSQuery(t *testing.T) {
 		{"unclosed quote", `foo "bar`, `"foo" OR "bar"`},
 		{"extra whitespace", "  foo   bar  ", `"foo" OR "bar"`},
 		{"whitespace in quotes", `"foo  bar"`, `"foo  bar"`},
+		{"title field", "title:sqlite", `{title}:"sqlite"`},
+		{"content field", "content:wal", `{content}:"wal"`},
+		{"link field", "link:example.com", `{link}:"example.com"`},
+		{"field case-insensitive", "Title:sqlite", `{title}:"sqlite"`},
+		{"field with phrase", `title:"full text"`, `{title}:"full text"`},
+		{"field mixed with terms", "notes title:sqlite", `"notes" OR {title}:"sqlite"`},
+		{"url is not a field", "https://example.com", `"https://example.com"`},
+		{"bare field name", "title:", `"title:"`},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got := buildFTSQuery(tc.input)
+			got, err := buildFTSQuery(tc.input, false)
+			if err != nil {
+				t.Fatalf("buildFTSQuery(%q): %v", tc.input, err)
+			}
 			if got != tc.want {
 				t.Errorf("buildFTSQuery(%q) = %q, want %q", tc.input, got, tc.want)
 			}
 		})
 	}
 }
+
+func TestBuildFTSQueryPrefix(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"single term", "sqli", `"sqli"*`},
+		{"last term only", "notes sqli", `"notes" OR "sqli"*`},
+		{"explicit star", "sqli*", `"sqli"*`},
+		{"quoted phrase untouched", `"full text"`, `"full text"`},
+		{"trailing phrase untouched", `sqlite "full text"`, `"sqlite" OR "full text"`},
+		{"unclosed quote untouched", `foo "bar`, `"foo" OR "bar"`},
+		{"trailing operator untouched", "sqlite AND", `"sqlite" OR "AND"`},
+		{"punctuation only untouched", "sqlite -", `"sqlite" OR "-"`},
+		{"embedded quote", `say"`, `"say"*`},
+		{"field prefix", "title:sqli", `{title}:"sqli"*`},
+		{"empty", "  ", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := buildFTSQuery(tc.input, true)
+			if err != nil {
+				t.Fatalf("buildFTSQuery(%q, true): %v", tc.input, err)
+			}
+			if got != tc.want {
+				t.Errorf("buildFTSQuery(%q, true) = %q, want %q", tc.input, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBuildSimpleFTSQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"and", "sqlite and fts", `"sqlite" AND "fts"`},
+		{"or", "sqlite OR postgres", `"sqlite" OR "postgres"`},
+		{"not", "sqlite not postgres", `"sqlite" NOT "postgres"`},
+		{"and not", "sqlite AND NOT postgres", `"sqlite" NOT "postgres"`},
+		{"mixed", "a and b or c", `"a" AND "b" OR "c"`},
+		{"implicit or", "sqlite fts", `"sqlite" OR "fts"`},
+		{"phrases and fields", `"write ahead" and title:wal`, `"write ahead" AND {title}:"wal"`},
+		// Operators missing an operand stay plain terms
+		{"leading operator", "and sqlite", `"and" OR "sqlite"`},
+		{"leading not", "not sqlite", `"not" OR "sqlite"`},
+		{"trailing operator", "sqlite and", `"sqlite" OR "and"`},
+		{"lone operator", "or", `"or"`},
+		{"doubled operator", "sqlite and or fts", `"sqlite" AND "or" OR "fts"`},
+		{"trailing and not", "sqlite and not", `"sqlite" AND "not"`},
+		{"quoted operator", `sqlite "and" fts`, `"sqlite" OR "and" OR "fts"`},
+		{"field operator", "sqlite title:and fts", `"sqlite" OR {title}:"and" OR "fts"`},
+		{"words containing operators", "android ornament", `"android" OR "ornament"`},
+		{"unknown field is literal", "owner:me and go", `"owner:me" AND "go"`},
+		// Special characters are quoted like any other term
+		{"stray specials", `sqlite and (fts* ^x) "`, `"sqlite" AND "(fts*" OR "^x)"`},
+		{"near", "NEAR(a b)", `"NEAR(a" OR "b)"`},
+		{"empty", "  ", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := buildSimpleFTSQuery(tc.input, false); got != tc.want {
+				t.Errorf("buildSimpleFTSQuery(%q) = %q, want %q", tc.input, got, tc.want)
+			}
+		})
+	}
+
+	if got := buildSimpleFTSQuery("sqlite and fts", true); got != `"sqlite" AND "fts"*` {
+		t.Errorf("prefix = %q", got)
+	}
+}
+
+func TestEscapeFTSQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"plain", "sqlite wal", `"sqlite" OR "wal"`},
+		{"phrase kept", `"write ahead" log`, `"write ahead" OR "log"`},
+		{"embedded quote", `say"hi`, `"say" OR "hi"`},
+		{"doubled quotes", `it''s "a ""b""`, `"it''s" OR "a" OR "b"`},
+		{"unclosed quote", `sqlite "write ahead`, `"sqlite" OR "write ahead"`},
+		{"lone quote", `"`, ``},
+		{"parentheses", "(sqlite OR wal)", `"(sqlite" OR "OR" OR "wal)"`},
+		{"asterisks", "sql* *", `"sql*" OR "*"`},
+		{"caret and minus", "^sqlite -wal", `"^sqlite" OR "-wal"`},
+		{"known field", "title:sqlite", `{title}:"sqlite"`},
+		{"unknown field", "owner:me", `"owner:me"`},
+		{"unknown field phrase", `author:"jane doe"`, `"author:" OR "jane doe"`},
+		{"url", "https://example.com/a:b", `"https://example.com/a:b"`},
+		{"bare colon", "a : b", `"a" OR ":" OR "b"`},
+		{"operators literal", "NOT sqlite AND", `"NOT" OR "sqlite" OR "AND"`},
+		{"empty", "   ", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := EscapeFTSQuery(tc.input); got != tc.want {
+				t.Errorf("EscapeFTSQuery(%q) = %q, want %q", tc.input, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSearchQueryModes(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-modes-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	s.Create("SQLite FTS", "", nil, nil, "")
+	s.Create("SQLite WAL", "", nil, nil, "")
+	s.Create("Postgres FTS", "", nil, nil, "")
+
+	count := func(query, mode string) int {
+		t.Helper()
+		results, err := s.SearchWithOptions(query, SearchOptions{Mode: mode})
+		if err != nil {
+			t.Fatalf("Search(%q, %q): %v", query, mode, err)
+		}
+		return len(results)
+	}
+
+	if n := count("sqlite and fts", QueryModeDefault); n != 3 {
+		t.Errorf("default: %d results, want 3 (every word OR'd)", n)
+	}
+	if n := count("sqlite and fts", QueryModeSimple); n != 1 {
+		t.Errorf("simple and: %d results, want 1", n)
+	}
+	if n := count("sqlite not wal", QueryModeSimple); n != 1 {
+		t.Errorf("simple not: %d results, want 1", n)
+	}
+	if n := count("sqli* NOT postgres", QueryModeRaw); n != 2 {
+		t.Errorf("raw: %d results, want 2", n)
+	}
+	if _, err := s.SearchWithOptions("sqlite", SearchOptions{Mode: "fancy"}); !errors.Is(err, ErrInvalidQueryMode) {
+		t.Errorf("unknown mode err = %v, want ErrInvalidQueryMode", err)
+	}
+}
+
+func TestSearchSyntaxError(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-syntax-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	s.Create("SQLite", "", nil, []string{"db"}, "")
+
+	raw := SearchOptions{Mode: QueryModeRaw}
+	for _, q := range []string{`"unclosed`, "sqlite AND", "{nosuch}:x", "NEAR(", "sqlite)"} {
+		if _, err := s.SearchWithOptions(q, raw); !errors.Is(err, ErrSearchSyntax) {
+			t.Errorf("Search(%q) err = %v, want ErrSearchSyntax", q, err)
+		}
+		if _, err := s.CountSearch(q, raw); !errors.Is(err, ErrSearchSyntax) {
+			t.Errorf("CountSearch(%q) err = %v, want ErrSearchSyntax", q, err)
+		}
+	}
+
+	// The escaping modes never produce invalid FTS5
+	for _, q := range []string{`"unclosed`, "sqlite AND", "NEAR(", `a"b"c*^`} {
+		if _, err := s.Search(q, 10, 0); err != nil {
+			t.Errorf("Search(%q): %v", q, err)
+		}
+	}
+
+	// Other SQLite errors are not mistaken for bad queries
+	if _, err := s.db.Exec("DROP TABLE item_tags"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.SearchWithOptions("sqlite", SearchOptions{Tags: []string{"db"}})
+	if err == nil || errors.Is(err, ErrSearchSyntax) {
+		t.Errorf("err = %v, want an internal error", err)
+	}
+}
+
+func TestBuildFTSQueryUnknownField(t *testing.T) {
+	for _, q := range []string{"foo:bar", `author:"jane doe"`, "notes Tags:go"} {
+		if _, err := buildFTSQuery(q, false); !errors.Is(err, ErrUnknownSearchField) {
+			t.Errorf("buildFTSQuery(%q) err = %v, want ErrUnknownSearchField", q, err)
+		}
+	}
+}
+
+func TestSearchFieldScoped(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-fields-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	link := "https://sqlite.org/wal.html"
+	s.Create("SQLite Tips", "use postgres-style vacuum", nil, nil, "")
+	s.Create("Postgres Tips", "sqlite is embedded", nil, nil, "")
+	s.Create("Journal Modes", "write-ahead logging", &link, nil, "")
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"title:sqlite", "SQLite Tips"},
+		{"content:sqlite", "Postgres Tips"},
+		{"link:wal", "Journal Modes"},
+		{`title:"postgres tips"`, "Postgres Tips"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			results, err := s.Search(tc.query, 10, 0)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			if len(results) != 1 || results[0].Item.Title != tc.want {
+				var got []string
+				for _, r := range results {
+					got = append(got, r.Item.Title)
+				}
+				t.Errorf("results = %v, want [%s]", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := s.Search("owner:me", 10, 0); !errors.Is(err, ErrUnknownSearchField) {
+		t.Errorf("unknown field err = %v, want ErrUnknownSearchField", err)
+	}
+}
+
+func TestSearchColumns(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-columns-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	link := "https://example.com/kubernetes"
+	s.Create("Kubernetes Notes", "", nil, nil, "")
+	s.Create("Bookmarks", "", &link, nil, "")
+	s.Create("Cluster Guide", "running kubernetes", nil, nil, "")
+
+	titles := func(columns ...string) []string {
+		results, err := s.SearchWithOptions("kubernetes", SearchOptions{Columns: columns})
+		if err != nil {
+			t.Fatalf("Search(%v): %v", columns, err)
+		}
+		var got []string
+		for _, r := range results {
+			got = append(got, r.Item.Title)
+		}
+		slices.Sort(got)
+		return got
+	}
+
+	if got := titles(); len(got) != 3 {
+		t.Errorf("all columns: %v, want 3 results", got)
+	}
+	if got := titles("title", "link", "content"); len(got) != 3 {
+		t.Errorf("explicit all columns: %v, want 3 results", got)
+	}
+	if got := titles("title", "content"); !slices.Equal(got, []string{"Cluster Guide", "Kubernetes Notes"}) {
+		t.Errorf("without link: %v, want the link-only match excluded", got)
+	}
+	if got := titles("link"); !slices.Equal(got, []string{"Bookmarks"}) {
+		t.Errorf("link only: %v, want [Bookmarks]", got)
+	}
+	// A field filter outside the chosen columns matches nothing
+	results, _ := s.SearchWithOptions("link:kubernetes", SearchOptions{Columns: []string{"title"}})
+	if len(results) != 0 {
+		t.Errorf("link: filter within title column: %d results, want 0", len(results))
+	}
+
+	if _, err := s.SearchWithOptions("kubernetes", SearchOptions{Columns: []string{"tags"}}); !errors.Is(err, ErrInvalidSearchColumn) {
+		t.Errorf("unknown column err = %v, want ErrInvalidSearchColumn", err)
+	}
+}
+
+func TestSearchSnippetOptions(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-snippet-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	words := make([]string, 100)
+	for i := range words {
+		words[i] = fmt.Sprintf("w%d", i)
+	}
+	words[50] = "needle"
+	s.Create("Needle Title", strings.Join(words, " "), nil, nil, "")
+
+	results, err := s.Search("needle", 10, 0)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Search: %v, %d results", err, len(results))
+	}
+	if n := len(strings.Fields(results[0].Snippet)); n != DefaultSnippetTokens {
+		t.Errorf("default snippet has %d tokens, want %d: %q", n, DefaultSnippetTokens, results[0].Snippet)
+	}
+
+	results, _ = s.SearchWithOptions("needle", SearchOptions{SnippetTokens: 40})
+	if n := len(strings.Fields(results[0].Snippet)); n != 40 {
+		t.Errorf("snippet has %d tokens, want 40", n)
+	}
+
+	results, _ = s.SearchWithOptions("needle", SearchOptions{SnippetTokens: 1000})
+	if n := len(strings.Fields(results[0].Snippet)); n != MaxSnippetTokens {
+		t.Errorf("capped snippet has %d tokens, want %d", n, MaxSnippetTokens)
+	}
+
+	results, _ = s.SearchWithOptions("needle", SearchOptions{SnippetColumn: SnippetTitle})
+	if results[0].Snippet != "<mark>Needle</mark> Title" {
+		t.Errorf("title snippet = %q", results[0].Snippet)
+	}
+
+	if _, err := s.SearchWithOptions("needle", SearchOptions{SnippetColumn: 9}); err == nil {
+		t.Error("expected error for unknown snippet column")
+	}
+}
+
+func TestSearchMarkers(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-markers-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	s.Create("Notes", "café sqlite tips and sqlite tricks", nil, nil, "")
+
+	results, _ := s.Search("sqlite", 10, 0)
+	if want := "café <mark>sqlite</mark> tips and <mark>sqlite</mark> tricks"; results[0].Snippet != want {
+		t.Errorf("default snippet = %q, want %q", results[0].Snippet, want)
+	}
+
+	results, err := s.SearchWithOptions("sqlite", SearchOptions{MarkOpen: "[[", MarkClose: "]]"})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if want := "café [[sqlite]] tips and [[sqlite]] tricks"; results[0].Snippet != want {
+		t.Errorf("custom snippet = %q, want %q", results[0].Snippet, want)
+	}
+
+	results, err = s.SearchWithOptions("sqlite", SearchOptions{Highlights: true, MarkOpen: "[["})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	r := results[0]
+	if r.Snippet != "café sqlite tips and sqlite tricks" {
+		t.Errorf("plain snippet = %q", r.Snippet)
+	}
+	want := []HighlightSpan{{Start: 5, End: 11}, {Start: 21, End: 27}}
+	if fmt.Sprint(r.Highlights) != fmt.Sprint(want) {
+		t.Errorf("highlights = %v, want %v", r.Highlights, want)
+	}
+	runes := []rune(r.Snippet)
+	for _, h := range r.Highlights {
+		if got := string(runes[h.Start:h.End]); got != "sqlite" {
+			t.Errorf("span %v = %q, want sqlite", h, got)
+		}
+	}
+
+	for _, m := range []string{`"`, "a b", "&amp;", "<mark class=x>", strings.Repeat("*", MaxMarkLen+1)} {
+		if _, err := s.SearchWithOptions("sqlite", SearchOptions{MarkOpen: m}); !errors.Is(err, ErrInvalidMarker) {
+			t.Errorf("marker %q: err = %v, want ErrInvalidMarker", m, err)
+		}
+	}
+}
+
+func TestCountSearch(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-countsearch-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	for i := 0; i < 5; i++ {
+		tags := []string{"odd"}
+		if i%2 == 0 {
+			tags = []string{"even"}
+		}
+		s.Create(fmt.Sprintf("Deploy %d", i), "deploy notes", nil, tags, "")
+	}
+	s.Create("Unrelated", "nothing here", nil, nil, "")
+	trashed, _ := s.Create("Deploy trashed", "deploy", nil, nil, "")
+	s.Delete(trashed.ID)
+
+	tests := []struct {
+		name string
+		opts SearchOptions
+		want int
+	}{
+		{"all matches", SearchOptions{Limit: 2}, 5},
+		{"with tag", SearchOptions{Tags: []string{"even"}}, 3},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			n, err := s.CountSearch("deploy", tc.opts)
+			if err != nil {
+				t.Fatalf("CountSearch: %v", err)
+			}
+			if n != tc.want {
+				t.Errorf("CountSearch = %d, want %d", n, tc.want)
+			}
+		})
+	}
+}
+
+func TestSearchPrefix(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-prefix-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	s.Create("SQLite Tips", "use WAL mode", nil, nil, "")
+	s.Create("Postgres Tips", "vacuum regularly", nil, nil, "")
+
+	results, err := s.SearchWithOptions("sqli", SearchOptions{})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("literal search found %d results, want 0", len(results))
+	}
+
+	for _, q := range []string{"sqli", "sqli*", `tips sqli`, `sqlite "`, "sqlite OR"} {
+		results, err := s.SearchWithOptions(q, SearchOptions{Prefix: true})
+		if err != nil {
+			t.Errorf("prefix search %q: %v", q, err)
+			continue
+		}
+		found := false
+		for _, r := range results {
+			if r.Item.Title == "SQLite Tips" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("prefix search %q did not find SQLite Tips", q)
+		}
+	}
+}
+
+func TestSearchDeduped(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-dedupe-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	s.Create("Deploy Notes", "deploy steps", nil, nil, "")
+	s.Create("Deploy: Notes", "deploy again", nil, nil, "")
+	s.Create("Deploy Notes!", "deploy once more", nil, nil, "")
+	s.Create("Release Checklist", "deploy checklist", nil, nil, "")
+
+	results, err := s.Search("deploy", 10, 0)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(results) != 4 {
+		t.Errorf("plain search len = %d, want 4", len(results))
+	}
+
+	results, err = s.SearchWithOptions("deploy", SearchOptions{Limit: 10, Dedupe: true})
+	if err != nil {
+		t.Fatalf("SearchWithOptions: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("deduped len = %d, want 2", len(results))
+	}
+
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[normalizeTitle(r.Item.Title)] = r.DuplicateCount
+	}
+	if counts["deploy notes"] != 2 {
+		t.Errorf("deploy notes duplicate_count = %d, want 2", counts["deploy notes"])
+	}
+	if counts["release checklist"] != 0 {
+		t.Errorf("release checklist duplicate_count = %d, want 0", counts["release checklist"])
+	}
+}
+
+func TestNormalizeTitle(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Foo Bar", "foo bar"},
+		{"  foo   BAR ", "foo bar"},
+		{"Foo-Bar!", "foobar"},
+		{"Café Notes", "café notes"},
+		{"", ""},
+	}
+	for _, tc := range tests {
+		if got := normalizeTitle(tc.input); got != tc.want {
+			t.Errorf("normalizeTitle(%q) = %q, want %q", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-trash-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	item, _ := s.Create("Trash Me", "recoverable content", nil, nil, "")
+	s.Create("Keep Me", "recoverable content", nil, nil, "")
+
+	if err := s.Delete(item.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	if _, err := s.Get(item.ID); err == nil {
+		t.Error("trashed item should not be returned by Get")
+	}
+	if err := s.Delete(item.ID); err == nil {
+		t.Error("deleting a trashed item should fail")
+	}
+
+	items, _ := s.List(10, 0)
+	if len(items) != 1 {
+		t.Errorf("live len = %d, want 1", len(items))
+	}
+	results, _ := s.Search("recoverable", 10, 0)
+	if len(results) != 1 {
+		t.Errorf("search len = %d, want 1", len(results))
+	}
+
+	trashed, _ := s.ListWithOptions(ListOptions{Trashed: true})
+	if len(trashed) != 1 || trashed[0].DeletedAt == nil {
+		t.Fatalf("trashed = %v, want one item with deletedAt", trashed)
+	}
+
+	restored, err := s.Restore(item.ID)
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if restored.DeletedAt != nil {
+		t.Error("restored item should have no deletedAt")
+	}
+	results, _ = s.Search("recoverable", 10, 0)
+	if len(results) != 2 {
+		t.Errorf("search after restore len = %d, want 2", len(results))
+	}
+	if _, err := s.Restore(item.ID); err == nil {
+		t.Error("restoring a live item should fail")
+	}
+
+	if _, err := s.db.Exec("INSERT INTO items_fts(items_fts) VALUES('integrity-check')"); err != nil {
+		t.Errorf("fts integrity-check: %v", err)
+	}
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-purge-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	old, _ := s.Create("Old Trash", "content", nil, nil, "")
+	recent, _ := s.Create("Recent Trash", "content", nil, nil, "")
+	s.Delete(old.ID)
+	s.Delete(recent.ID)
+	s.db.Exec("UPDATE items SET deleted_at = ? WHERE id = ?", "2000-01-01T00:00:00Z", old.ID)
+
+	n, err := s.PurgeDeleted(24 * time.Hour)
+	if err != nil {
+		t.Fatalf("PurgeDeleted: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("purged = %d, want 1", n)
+	}
+
+	trashed, _ := s.ListWithOptions(ListOptions{Trashed: true})
+	if len(trashed) != 1 || trashed[0].ID != recent.ID {
+		t.Errorf("trashed = %v, want only recent", trashed)
+	}
+
+	if _, err := s.db.Exec("INSERT INTO items_fts(items_fts) VALUES('integrity-check')"); err != nil {
+		t.Errorf("fts integrity-check: %v", err)
+	}
+}
+
+func TestCount(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-count-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	s.Create("One", "content", nil, []string{"red"}, "")
+	s.Create("Two", "content", nil, []string{"red", "blue"}, "")
+	three, _ := s.Create("Three", "content", nil, nil, "")
+	s.Delete(three.ID)
+
+	if n, _ := s.Count(); n != 2 {
+		t.Errorf("Count = %d, want 2", n)
+	}
+	if n, _ := s.CountWithOptions(ListOptions{Tags: []string{"blue"}}); n != 1 {
+		t.Errorf("Count(blue) = %d, want 1", n)
+	}
+	if n, _ := s.CountWithOptions(ListOptions{Trashed: true}); n != 1 {
+		t.Errorf("Count(trashed) = %d, want 1", n)
+	}
+	// Limit and offset are ignored
+	if n, _ := s.CountWithOptions(ListOptions{Limit: 1, Offset: 5}); n != 2 {
+		t.Errorf("Count(paged) = %d, want 2", n)
+	}
+}
+
+func TestCountByUser(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-count-user-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	s.Create("One", "", nil, nil, "alice")
+	two, _ := s.Create("Two", "", nil, nil, "alice")
+	s.Create("Three", "", nil, nil, "bob")
+	s.Delete(two.ID)
+
+	// Trashed items do not count
+	if n, err := s.CountByUser("alice"); err != nil || n != 1 {
+		t.Errorf("CountByUser(alice) = %d, %v, want 1", n, err)
+	}
+	if n, _ := s.CountByUser("carol"); n != 0 {
+		t.Errorf("CountByUser(carol) = %d, want 0", n)
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-get-many-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	a, _ := s.Create("A", "", nil, []string{"x"}, "")
+	b, _ := s.Create("B", "", nil, nil, "")
+	c, _ := s.Create("C", "", nil, nil, "")
+	s.Delete(c.ID)
+
+	// Request order wins; unknown, trashed, and repeated ids are skipped
+	items, err := s.GetMany([]string{b.ID, "missing", c.ID, a.ID, b.ID})
+	if err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+	if len(items) != 2 || items[0].ID != b.ID || items[1].ID != a.ID {
+		t.Fatalf("GetMany = %v, want [B A]", items)
+	}
+	if len(items[1].Tags) != 1 || items[1].Tags[0] != "x" {
+		t.Errorf("tags = %v, want [x]", items[1].Tags)
+	}
+
+	if items, err := s.GetMany(nil); err != nil || len(items) != 0 {
+		t.Errorf("GetMany(nil) = %v, %v, want empty", items, err)
+	}
+}
+
+func TestTitleOwner(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-title-owner-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	live, _ := s.Create("Hello World", "", nil, nil, "")
+	gone, _ := s.Create("Gone", "", nil, nil, "")
+	s.Delete(gone.ID)
+
+	// Matches the way Create compares titles
+	if id, trashed, err := s.TitleOwner("  hello   WORLD "); err != nil || id != live.ID || trashed {
+		t.Errorf("TitleOwner(live) = %q, %v, %v, want %q, false", id, trashed, err, live.ID)
+	}
+	if id, trashed, err := s.TitleOwner("gone"); err != nil || id != gone.ID || !trashed {
+		t.Errorf("TitleOwner(trashed) = %q, %v, %v, want %q, true", id, trashed, err, gone.ID)
+	}
+	if _, _, err := s.TitleOwner("Free"); err != sql.ErrNoRows {
+		t.Errorf("TitleOwner(free) err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestListSince(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-since-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	c := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	s.SetClock(c)
+
+	old, _ := s.Create("Old", "", nil, nil, "")
+	gone, _ := s.Create("Gone", "", nil, nil, "")
+	// A and B share a timestamp, so id breaks the tie
+	c.Set(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	b, _ := s.Create("B", "", nil, nil, "")
+	a, _ := s.Create("A", "", nil, nil, "")
+	c.Set(time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC))
+	s.Delete(gone.ID)
+
+	since := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	items, err := s.ListSince(since, 10, 0)
+	if err != nil {
+		t.Fatalf("ListSince: %v", err)
+	}
+	first, second := a.ID, b.ID
+	if second < first {
+		first, second = second, first
+	}
+	want := []string{first, second, gone.ID}
+	if len(items) != len(want) {
+		t.Fatalf("got %d items, want %d", len(items), len(want))
+	}
+	for i, item := range items {
+		if item.ID != want[i] {
+			t.Errorf("items[%d] = %s, want %s", i, item.Title, want[i])
+		}
+	}
+	if items[2].DeletedAt == nil {
+		t.Error("trashed item should be returned as a tombstone")
+	}
+
+	// Paging walks the same order
+	page, _ := s.ListSince(since, 1, 1)
+	if len(page) != 1 || page[0].ID != second {
+		t.Errorf("page = %v, want [%s]", page, second)
+	}
+
+	// The boundary is exclusive
+	items, _ = s.ListSince(time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), 10, 0)
+	if len(items) != 0 {
+		t.Errorf("got %d items at the boundary, want 0", len(items))
+	}
+
+	// Restoring counts as a change
+	c.Set(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	if _, err := s.Restore(gone.ID); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	items, _ = s.ListSince(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), 10, 0)
+	if len(items) != 1 || items[0].ID != gone.ID || items[0].DeletedAt != nil {
+		t.Errorf("after restore: %v, want the live item", items)
+	}
+
+	// So do pinning, archiving, and color, though they leave updated_at alone
+	changes := map[string]func() error{
+		"pin":     func() error { return s.SetPinned(old.ID, true) },
+		"archive": func() error { return s.SetArchived(old.ID, true) },
+		"color":   func() error { return s.SetColor(old.ID, "red") },
+	}
+	for _, name := range []string{"pin", "archive", "color"} {
+		since := c.Now()
+		c.Advance(time.Hour)
+		if err := changes[name](); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		items, _ := s.ListSince(since, 10, 0)
+		if len(items) != 1 || items[0].ID != old.ID {
+			t.Errorf("after %s: %v, want [%s]", name, items, old.ID)
+			continue
+		}
+		if !items[0].ChangedAt.Equal(c.Now()) || !items[0].UpdatedAt.Equal(old.UpdatedAt) {
+			t.Errorf("after %s: changedAt = %v, updatedAt = %v", name, items[0].ChangedAt, items[0].UpdatedAt)
+		}
+		if n, _ := s.CountSinceContext(context.Background(), since); n != 1 {
+			t.Errorf("after %s: CountSince = %d, want 1", name, n)
+		}
+	}
+}
+
+func TestListAfter(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-after-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	// Five items, two of which share a timestamp so id breaks the tie
+	want := map[string]bool{}
+	for i, ts := range []string{"2024-01-05", "2024-01-04", "2024-01-04", "2024-01-02", "2024-01-01"} {
+		item, _ := s.Create(fmt.Sprintf("Item %d", i), "", nil, nil, "")
+		s.db.Exec("UPDATE items SET updated_at = ? WHERE id = ?", ts+"T00:00:00Z", item.ID)
+		want[item.ID] = true
+	}
+
+	seen := map[string]bool{}
+	var cursor Cursor
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("paging did not terminate")
+		}
+		items, err := s.ListAfter(cursor, 2)
+		if err != nil {
+			t.Fatalf("ListAfter: %v", err)
+		}
+		if len(items) == 0 {
+			break
+		}
+		for _, item := range items {
+			if seen[item.ID] {
+				t.Errorf("%s returned twice", item.Title)
+			}
+			seen[item.ID] = true
+		}
+		last := items[len(items)-1]
+		cursor = Cursor{UpdatedAt: last.UpdatedAt, ID: last.ID}
+
+		// New items land in front of the cursor and must not shift later pages
+		s.Create(fmt.Sprintf("Inserted %d", pages), "", nil, nil, "")
+	}
+	for id := range want {
+		if !seen[id] {
+			t.Errorf("item %s was skipped", id)
+		}
+	}
+	if len(seen) != len(want) {
+		t.Errorf("saw %d items, want %d", len(seen), len(want))
+	}
+
+	// The zero cursor starts at the front, where the inserts went
+	items, _ := s.ListAfter(Cursor{}, 1)
+	if len(items) != 1 || !strings.HasPrefix(items[0].Title, "Inserted") {
+		t.Errorf("first page = %v, want an inserted item", items)
+	}
+}
+
+func TestListSort(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-sort-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	banana, _ := s.Create("Banana", "c", nil, nil, "")
+	apple, _ := s.Create("apple", "c", nil, nil, "")
+	cherry, _ := s.Create("Cherry", "c", nil, nil, "")
+
+	// Pin timestamps so ordering doesn't depend on clock resolution
+	s.db.Exec("UPDATE items SET created_at = '2020-01-01T00:00:00Z', updated_at = '2020-01-03T00:00:00Z' WHERE id = ?", banana.ID)
+	s.db.Exec("UPDATE items SET created_at = '2020-01-02T00:00:00Z', updated_at = '2020-01-01T00:00:00Z' WHERE id = ?", apple.ID)
+	s.db.Exec("UPDATE items SET created_at = '2020-01-03T00:00:00Z', updated_at = '2020-01-02T00:00:00Z' WHERE id = ?", cherry.ID)
+
+	tests := []struct {
+		sort SortOption
+		want []string
+	}{
+		{"", []string{"Banana", "Cherry", "apple"}},
+		{SortUpdatedDesc, []string{"Banana", "Cherry", "apple"}},
+		{SortUpdatedAsc, []string{"apple", "Cherry", "Banana"}},
+		{SortCreatedDesc, []string{"Cherry", "apple", "Banana"}},
+		{SortCreatedAsc, []string{"Banana", "apple", "Cherry"}},
+		{SortTitleAsc, []string{"apple", "Banana", "Cherry"}},
+		{SortTitleDesc, []string{"Cherry", "Banana", "apple"}},
+	}
+	for _, tc := range tests {
+		t.Run(string(tc.sort), func(t *testing.T) {
+			items, err := s.ListWithOptions(ListOptions{Sort: tc.sort})
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			var got []string
+			for _, it := range items {
+				got = append(got, it.Title)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("order = %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := ParseSortOption("random"); err == nil {
+		t.Error("expected error for unknown sort")
+	}
+	if _, err := s.ListWithOptions(ListOptions{Sort: "random"}); err == nil {
+		t.Error("expected List error for unknown sort")
+	}
+}
+
+func TestWALEnabled(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-wal-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, err := New(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer s.Close()
+
+	var mode string
+	var timeout int
+	s.db.QueryRow("PRAGMA journal_mode").Scan(&mode)
+	s.db.QueryRow("PRAGMA busy_timeout").Scan(&timeout)
+	if mode != "wal" {
+		t.Errorf("journal_mode = %q, want wal", mode)
+	}
+	if timeout != busyTimeout {
+		t.Errorf("busy_timeout = %d, want %d", timeout, busyTimeout)
+	}
+}
+
+func TestConcurrentCreate(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-concurrent-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	const workers, perWorker = 8, 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*perWorker)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				item, err := s.Create(fmt.Sprintf("Item %d-%d", w, i), "content", nil, []string{"load"}, "")
+				if err == nil {
+					_, err = s.Update(item.ID, item.Title, "updated", nil, nil, 0)
+				}
+				if err == nil {
+					_, err = s.List(10, 0)
+				}
+				if err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("concurrent write failed: %v", err)
+	}
+	if n, _ := s.Count(); n != workers*perWorker {
+		t.Errorf("count = %d, want %d", n, workers*perWorker)
+	}
+}
+
+func TestPoolConfig(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-pool-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, err := NewWithConfig(tmpFile.Name(), Config{MaxOpenConns: 2, MaxIdleConns: 1})
+	if err != nil {
+		t.Fatalf("NewWithConfig: %v", err)
+	}
+	defer s.Close()
+
+	// Hold both allowed connections; a third caller has to wait
+	ctx := context.Background()
+	c1, err := s.db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("Conn: %v", err)
+	}
+	c2, err := s.db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("Conn: %v", err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.ListContext(waitCtx, ListOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("List with pool exhausted err = %v, want context.DeadlineExceeded", err)
+	}
+
+	stats := s.Stats()
+	if stats.MaxOpenConnections != 2 || stats.InUse != 2 || stats.WaitCount != 1 {
+		t.Errorf("stats while held = %+v, want 2 max, 2 in use, 1 wait", stats)
+	}
+
+	c1.Close()
+	c2.Close()
+	stats = s.Stats()
+	if stats.Idle != 1 || stats.MaxIdleClosed != 1 {
+		t.Errorf("stats after release = %+v, want 1 idle, 1 closed", stats)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-ro-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	rw, err := New(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	item, _ := rw.Create("Mirrored", "searchable content", nil, nil, "")
+	rw.Close()
+
+	s, err := NewWithConfig(tmpFile.Name(), Config{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("NewWithConfig read-only: %v", err)
+	}
+	defer s.Close()
+
+	if got, err := s.Get(item.ID); err != nil || got.Title != "Mirrored" {
+		t.Errorf("Get = %v, %v", got, err)
+	}
+	if results, err := s.Search("searchable", 10, 0); err != nil || len(results) != 1 {
+		t.Errorf("Search = %v, %v", results, err)
+	}
+	if _, err := s.Create("Another", "", nil, nil, ""); err == nil {
+		t.Error("Create succeeded on a read-only store")
+	}
+	if err := s.Delete(item.ID); err == nil {
+		t.Error("Delete succeeded on a read-only store")
+	}
+}
+
+func TestReadOnlyRequiresCurrentSchema(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-ro-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	rw, _ := New(tmpFile.Name())
+	rw.db.Exec("DELETE FROM schema_version WHERE version = (SELECT MAX(version) FROM schema_version)")
+	rw.Close()
+
+	if s, err := NewWithConfig(tmpFile.Name(), Config{ReadOnly: true}); err == nil {
+		s.Close()
+		t.Error("read-only open of an outdated schema succeeded")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-events-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	item, _ := s.Create("Evented", "", nil, nil, "")
+	s.Update(item.ID, "Evented 2", "", nil, nil, 0)
+	s.Delete(item.ID)
+	s.Restore(item.ID)
+
+	want := []string{EventCreated, EventUpdated, EventDeleted, EventRestored}
+	for _, typ := range want {
+		select {
+		case ev := <-events:
+			if ev.Type != typ || ev.ItemID != item.ID {
+				t.Errorf("event = %+v, want type %q for %s", ev, typ, item.ID)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q event", typ)
+		}
+	}
+}
+
+func TestSubscribeSlowConsumerDoesNotBlock(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-events-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	events, unsubscribe := s.Subscribe()
+
+	// Never read; writes beyond the buffer must not block
+	for i := 0; i < eventBuffer+10; i++ {
+		if _, err := s.Create(fmt.Sprintf("Item %d", i), "", nil, nil, ""); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+	if len(events) != eventBuffer {
+		t.Errorf("buffered = %d, want %d", len(events), eventBuffer)
+	}
+
+	unsubscribe()
+	unsubscribe()
+	for range events {
+	}
+}
+
+func TestUpdateRev(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-rev-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	item, _ := s.Create("Rev", "v1", nil, nil, "")
+	if item.Rev != 1 {
+		t.Fatalf("initial rev = %d, want 1", item.Rev)
+	}
+
+	updated, err := s.Update(item.ID, "Rev", "v2", nil, nil, 1)
+	if err != nil {
+		t.Fatalf("Update with current rev: %v", err)
+	}
+	if updated.Rev != 2 {
+		t.Errorf("rev after update = %d, want 2", updated.Rev)
+	}
+
+	// A stale rev is a conflict and leaves the item alone
+	if _, err := s.Update(item.ID, "Rev", "stale", nil, nil, 1); err != ErrRevConflict {
+		t.Errorf("stale rev err = %v, want ErrRevConflict", err)
+	}
+	got, _ := s.Get(item.ID)
+	if got.Content != "v2" || got.Rev != 2 {
+		t.Errorf("after conflict: content = %q rev = %d, want v2 rev 2", got.Content, got.Rev)
+	}
+	if versions, _ := s.ListVersions(item.ID); len(versions) != 1 {
+		t.Errorf("versions = %d, want 1 (conflict must not snapshot)", len(versions))
+	}
+
+	// rev 0 skips the check
+	if updated, err = s.Update(item.ID, "Rev", "v3", nil, nil, 0); err != nil || updated.Rev != 3 {
+		t.Errorf("unconditional update: rev = %v err = %v", updated, err)
+	}
+
+	// A deleted item is still not found, not a conflict
+	s.Delete(item.ID)
+	if _, err := s.Update(item.ID, "Rev", "gone", nil, nil, 3); err != sql.ErrNoRows {
+		t.Errorf("deleted item err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestQueryCancelledByDeadline(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-timeout-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	// An unbounded recursive CTE never finishes on its own, so only an
+	// interrupt from the expired context can end it
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	var n int
+	err := s.db.QueryRowContext(ctx, "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT count(*) FROM c").Scan(&n)
+	if err == nil {
+		t.Fatal("expected the slow query to be cancelled")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("slow query ran for %v after its deadline", elapsed)
+	}
+
+	// The connection is usable again once the query is interrupted
+	item, err := s.Create("After timeout", "", nil, nil, "")
+	if err != nil {
+		t.Fatalf("Create after cancelled query: %v", err)
+	}
+	if _, err := s.GetContext(context.Background(), item.ID); err != nil {
+		t.Errorf("GetContext after cancelled query: %v", err)
+	}
+}
+
+func TestContextMethodsHonorCancellation(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-ctx-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	item, _ := s.Create("Cancelled", "sqlite notes", nil, nil, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := s.GetContext(ctx, item.ID); err == nil {
+		t.Error("GetContext: expected error for cancelled context")
+	}
+	if _, err := s.ListContext(ctx, ListOptions{}); err == nil {
+		t.Error("ListContext: expected error for cancelled context")
+	}
+	if _, err := s.SearchContext(ctx, "sqlite", SearchOptions{}); err == nil {
+		t.Error("SearchContext: expected error for cancelled context")
+	}
+	if _, err := s.CreateContext(ctx, "Other", "", nil, nil, ""); err == nil {
+		t.Error("CreateContext: expected error for cancelled context")
+	}
+	if _, err := s.UpdateContext(ctx, item.ID, "Renamed", "", nil, nil, 0); err == nil {
+		t.Error("UpdateContext: expected error for cancelled context")
+	}
+	if err := s.DeleteContext(ctx, item.ID); err == nil {
+		t.Error("DeleteContext: expected error for cancelled context")
+	}
+
+	// Nothing was written
+	got, err := s.Get(item.ID)
+	if err != nil || got.Title != "Cancelled" {
+		t.Errorf("item after cancelled writes = %+v, %v", got, err)
+	}
+}
+
+func TestPinnedSortsFirst(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-pinned-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	old, _ := s.Create("Old reference", "", nil, nil, "")
+	mid, _ := s.Create("Middle", "", nil, nil, "")
+	recent, _ := s.Create("Recent", "", nil, nil, "")
+	for id, ts := range map[string]string{old.ID: "2020-01-01T00:00:00Z", mid.ID: "2021-01-01T00:00:00Z", recent.ID: "2022-01-01T00:00:00Z"} {
+		s.db.Exec("UPDATE items SET updated_at = ? WHERE id = ?", ts, id)
+	}
+
+	if err := s.SetPinned(old.ID, true); err != nil {
+		t.Fatalf("SetPinned: %v", err)
+	}
+
+	items, _ := s.List(10, 0)
+	var titles []string
+	for _, it := range items {
+		titles = append(titles, it.Title)
+	}
+	if strings.Join(titles, ",") != "Old reference,Recent,Middle" {
+		t.Errorf("order = %v, want pinned item first then updated_desc", titles)
+	}
+	if !items[0].Pinned || items[1].Pinned {
+		t.Errorf("pinned flags = %v, %v", items[0].Pinned, items[1].Pinned)
+	}
+
+	// Pinning is not an edit
+	got, _ := s.Get(old.ID)
+	if got.Rev != 1 || got.UpdatedAt.Year() != 2020 {
+		t.Errorf("rev = %d, updatedAt = %v; pinning should leave both alone", got.Rev, got.UpdatedAt)
+	}
+
+	// Explicit sorts ignore the pin
+	items, _ = s.ListWithOptions(ListOptions{Sort: SortTitleAsc})
+	if items[0].Title != "Middle" {
+		t.Errorf("title_asc first = %q, want Middle", items[0].Title)
+	}
+
+	s.SetPinned(old.ID, false)
+	items, _ = s.List(10, 0)
+	if items[0].Title != "Recent" || items[2].Pinned {
+		t.Errorf("after unpin first = %q, want Recent", items[0].Title)
+	}
+
+	if err := s.SetPinned("missing", true); err != sql.ErrNoRows {
+		t.Errorf("SetPinned(missing) = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestArchivedListFiltering(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-archived-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	active, _ := s.Create("Active note", "shared word", nil, nil, "")
+	old, _ := s.Create("Old note", "shared word", nil, nil, "")
+
+	if err := s.SetArchived(old.ID, true); err != nil {
+		t.Fatalf("SetArchived: %v", err)
+	}
+	got, _ := s.Get(old.ID)
+	if !got.Archived || got.Rev != 1 {
+		t.Errorf("archived = %v, rev = %d; want archived without an edit", got.Archived, got.Rev)
+	}
+
+	titles := func(opts ListOptions) string {
+		items, err := s.ListWithOptions(opts)
+		if err != nil {
+			t.Fatalf("ListWithOptions(%+v): %v", opts, err)
+		}
+		var names []string
+		for _, it := range items {
+			names = append(names, it.Title)
+		}
+		slices.Sort(names)
+		return strings.Join(names, ",")
+	}
+	if got := titles(ListOptions{}); got != "Active note" {
+		t.Errorf("default list = %q, want only the active item", got)
+	}
+	if got := titles(ListOptions{IncludeArchived: true}); got != "Active note,Old note" {
+		t.Errorf("include archived = %q", got)
+	}
+	if got := titles(ListOptions{ArchivedOnly: true}); got != "Old note" {
+		t.Errorf("archived only = %q", got)
+	}
+	if n, _ := s.CountWithOptions(ListOptions{}); n != 1 {
+		t.Errorf("default count = %d, want 1", n)
+	}
+	if items, _ := s.ListAfter(Cursor{}, 10); len(items) != 1 || items[0].ID != active.ID {
+		t.Errorf("ListAfter = %v, want only the active item", items)
+	}
+
+	// Trashing an archived item puts it in the trash listing
+	s.Delete(old.ID)
+	if got := titles(ListOptions{Trashed: true}); got != "Old note" {
+		t.Errorf("trash = %q, want the archived item", got)
+	}
+	s.Restore(old.ID)
+
+	// Search matches archived items unless asked not to
+	if results, _ := s.SearchWithOptions("shared", SearchOptions{}); len(results) != 2 {
+		t.Errorf("search = %d results, want 2", len(results))
+	}
+	results, _ := s.SearchWithOptions("shared", SearchOptions{ExcludeArchived: true})
+	if len(results) != 1 || results[0].Item.ID != active.ID {
+		t.Errorf("search excluding archived = %v", results)
+	}
+	if n, _ := s.CountSearch("shared", SearchOptions{ExcludeArchived: true}); n != 1 {
+		t.Errorf("count excluding archived = %d, want 1", n)
+	}
+
+	s.SetArchived(old.ID, false)
+	if got := titles(ListOptions{}); got != "Active note,Old note" {
+		t.Errorf("after unarchive = %q", got)
+	}
+
+	if err := s.SetArchived("missing", true); err != sql.ErrNoRows {
+		t.Errorf("SetArchived(missing) = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestDeleteMany(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-bulk-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	a, _ := s.Create("Old note one", "stale", nil, nil, "")
+	b, _ := s.Create("Old note two", "stale", nil, nil, "")
+	keep, _ := s.Create("Keeper", "stale", nil, nil, "")
+	trashed, _ := s.Create("Already trashed", "stale", nil, nil, "")
+	s.Delete(trashed.ID)
+
+	res, err := s.DeleteMany([]string{a.ID, b.ID, a.ID, "missing", trashed.ID})
+	if err != nil {
+		t.Fatalf("DeleteMany: %v", err)
+	}
+	if res.Deleted != 2 {
+		t.Errorf("deleted = %d, want 2", res.Deleted)
+	}
+	if strings.Join(res.NotFound, ",") != "missing,"+trashed.ID {
+		t.Errorf("not_found = %v, want [missing %s]", res.NotFound, trashed.ID)
+	}
+
+	// Deleted items go to the trash and drop out of search
+	if _, err := s.Get(a.ID); err != sql.ErrNoRows {
+		t.Errorf("Get(deleted) err = %v, want sql.ErrNoRows", err)
+	}
+	results, _ := s.Search("stale", 10, 0)
+	if len(results) != 1 || results[0].Item.ID != keep.ID {
+		t.Errorf("search after bulk delete = %+v, want only Keeper", results)
+	}
+	if _, err := s.Restore(b.ID); err != nil {
+		t.Errorf("Restore after bulk delete: %v", err)
+	}
+
+	// A cancelled context trashes nothing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.DeleteManyContext(ctx, []string{b.ID, keep.ID}); err == nil {
+		t.Error("DeleteManyContext with cancelled context: want error")
+	}
+	if _, err := s.Get(keep.ID); err != nil {
+		t.Errorf("Get(keep) after cancelled delete: %v", err)
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-duplicate-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	link := "https://example.com/template"
+	src, _ := s.Create("Meeting Template", "## Agenda", &link, []string{"template", "work"}, "alice")
+	s.SetPinned(src.ID, true)
+
+	cp, err := s.Duplicate(src.ID, "", "bob")
+	if err != nil {
+		t.Fatalf("Duplicate: %v", err)
+	}
+	if cp.ID == src.ID || cp.Title != "Meeting Template (copy)" || cp.Content != "## Agenda" {
+		t.Errorf("copy = %+v", cp)
+	}
+	if cp.Link == nil || *cp.Link != link || strings.Join(cp.Tags, ",") != "template,work" {
+		t.Errorf("copy link = %v, tags = %v", cp.Link, cp.Tags)
+	}
+	if cp.CreatedBy != "bob" || cp.Pinned || cp.Rev != 1 {
+		t.Errorf("copy createdBy = %q, pinned = %v, rev = %d", cp.CreatedBy, cp.Pinned, cp.Rev)
+	}
+
+	// The derived title is now taken
+	if _, err := s.Duplicate(src.ID, "", "bob"); err == nil || !strings.Contains(err.Error(), "UNIQUE constraint") {
+		t.Errorf("second Duplicate err = %v, want UNIQUE constraint error", err)
+	}
+	named, err := s.Duplicate(src.ID, "Standup 2026-10-14", "")
+	if err != nil || named.Title != "Standup 2026-10-14" {
+		t.Errorf("Duplicate with title = %v, %v", named, err)
+	}
+
+	if _, err := s.Duplicate("missing", "", ""); err != sql.ErrNoRows {
+		t.Errorf("Duplicate(missing) err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestSearchOffsetStableOrder(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-search-offset-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	// Identical lengths and content give every item the same BM25 rank
+	for i := 0; i < 12; i++ {
+		s.Create(fmt.Sprintf("Page %02d", i), "paging needle", nil, nil, "")
+	}
+
+	all, err := s.Search("needle", 12, 0)
+	if err != nil || len(all) != 12 {
+		t.Fatalf("Search all = %d results, %v", len(all), err)
+	}
+
+	var paged []SearchResult
+	for offset := 0; offset < 12; offset += 5 {
+		page, err := s.Search("needle", 5, offset)
+		if err != nil {
+			t.Fatalf("Search offset %d: %v", offset, err)
+		}
+		paged = append(paged, page...)
+	}
+	if len(paged) != len(all) {
+		t.Fatalf("paged = %d results, want %d", len(paged), len(all))
+	}
+	for i := range all {
+		if paged[i].Item.ID != all[i].Item.ID {
+			t.Errorf("position %d: paged %q, unpaged %q", i, paged[i].Item.Title, all[i].Item.Title)
+		}
+	}
+
+	if page, _ := s.Search("needle", 5, 50); len(page) != 0 {
+		t.Errorf("offset past the end = %d results, want 0", len(page))
+	}
+
+	// Dedupe applies the offset to the collapsed list
+	s.Create("Page 00!", "paging needle", nil, nil, "")
+	deduped, _ := s.SearchWithOptions("needle", SearchOptions{Limit: 20, Dedupe: true})
+	page, _ := s.SearchWithOptions("needle", SearchOptions{Limit: 2, Offset: 11, Dedupe: true})
+	if len(deduped) != 12 || len(page) != 1 || page[0].Item.ID != deduped[11].Item.ID {
+		t.Errorf("deduped = %d, last page = %+v", len(deduped), page)
+	}
+}
+
+func TestSuggestTitles(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-suggest-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	s.Create("Learning SQLite", "", nil, nil, "")
+	s.Create("SQLite Guide", "", nil, nil, "")
+	s.Create("Postgres", "sqlite is mentioned only in the content", nil, nil, "")
+	trashed, _ := s.Create("SQLite Trash", "", nil, nil, "")
+	s.Delete(trashed.ID)
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		// Titles starting with the prefix come first
+		{"sql", []string{"SQLite Guide", "Learning SQLite"}},
+		{"SQLITE", []string{"SQLite Guide", "Learning SQLite"}},
+		{"sqlite gu", []string{"SQLite Guide"}},
+		{"learn", []string{"Learning SQLite"}},
+		{"pos", []string{"Postgres"}},
+		{"mentioned", []string{}},
+		{"ite", []string{}},
+		{`"sq`, []string{"SQLite Guide", "Learning SQLite"}},
+		{"   ", []string{}},
+	}
+	for _, tc := range tests {
+		got, err := s.SuggestTitles(tc.prefix, 0)
+		if err != nil {
+			t.Errorf("SuggestTitles(%q): %v", tc.prefix, err)
+			continue
+		}
+		if !slices.Equal(got, tc.want) || got == nil {
+			t.Errorf("SuggestTitles(%q) = %q, want %q", tc.prefix, got, tc.want)
+		}
+	}
+
+	for i := range MaxSuggestLimit + 5 {
+		s.Create(fmt.Sprintf("Note %d", i), "", nil, nil, "")
+	}
+	if got, _ := s.SuggestTitles("note", 3); len(got) != 3 {
+		t.Errorf("limit 3: got %d titles", len(got))
+	}
+	if got, _ := s.SuggestTitles("note", 0); len(got) != DefaultSuggestLimit {
+		t.Errorf("default limit: got %d titles, want %d", len(got), DefaultSuggestLimit)
+	}
+	if got, _ := s.SuggestTitles("note", 1000); len(got) != MaxSuggestLimit {
+		t.Errorf("limit 1000: got %d titles, want cap of %d", len(got), MaxSuggestLimit)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-patch-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	str := func(v string) *string { return &v }
+	tests := []struct {
+		name        string
+		fields      PatchFields
+		wantTitle   string
+		wantContent string
+		wantLink    *string
+		wantTags    []string
+	}{
+		{"title", PatchFields{Title: str("  Renamed   title ")}, "Renamed title", "body", str("https://a"), []string{"x", "y"}},
+		{"content", PatchFields{Content: str("new body")}, "", "new body", str("https://a"), []string{"x", "y"}},
+		{"link", PatchFields{SetLink: true, Link: str("https://b")}, "", "body", str("https://b"), []string{"x", "y"}},
+		{"clear link", PatchFields{SetLink: true}, "", "body", nil, []string{"x", "y"}},
+		{"tags", PatchFields{Tags: []string{"Z", "x"}}, "", "body", str("https://a"), []string{"x", "z"}},
+		{"clear tags", PatchFields{Tags: []string{}}, "", "body", str("https://a"), []string{}},
+		{"title and link", PatchFields{Title: str("Both"), SetLink: true}, "Both", "body", nil, []string{"x", "y"}},
+		{"everything", PatchFields{Title: str("All"), Content: str("c"), SetLink: true, Link: str("l"), Tags: []string{"t"}}, "All", "c", str("l"), []string{"t"}},
+	}
+	for i, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			title := fmt.Sprintf("Patch %d", i)
+			item, _ := s.Create(title, "body", str("https://a"), []string{"x", "y"}, "")
+			if tc.wantTitle == "" {
+				tc.wantTitle = title
+			}
+
+			got, err := s.Patch(item.ID, tc.fields)
+			if err != nil {
+				t.Fatalf("Patch: %v", err)
+			}
+			if got.Title != tc.wantTitle || got.Content != tc.wantContent {
+				t.Errorf("title = %q, content = %q, want %q, %q", got.Title, got.Content, tc.wantTitle, tc.wantContent)
+			}
+			if (got.Link == nil) != (tc.wantLink == nil) || (got.Link != nil && *got.Link != *tc.wantLink) {
+				t.Errorf("link = %v, want %v", got.Link, tc.wantLink)
+			}
+			if !slices.Equal(got.Tags, tc.wantTags) {
+				t.Errorf("tags = %q, want %q", got.Tags, tc.wantTags)
+			}
+			if got.Rev != 2 {
+				t.Errorf("rev = %d, want 2", got.Rev)
+			}
+			if versions, _ := s.ListVersions(item.ID); len(versions) != 1 || versions[0].Content != "body" {
+				t.Errorf("versions = %+v, want one snapshot of the original", versions)
+			}
+		})
+	}
+
+	item, _ := s.Create("Patch rev", "v1", nil, nil, "")
+
+	// An empty patch changes nothing
+	got, err := s.Patch(item.ID, PatchFields{Link: str("ignored without SetLink")})
+	if err != nil || got.Rev != 1 || got.Link != nil {
+		t.Errorf("empty patch: item = %+v, err = %v, want it unchanged", got, err)
+	}
+	if _, err := s.Patch(item.ID, PatchFields{ExpectedRev: 5}); err != ErrRevConflict {
+		t.Errorf("empty patch with stale rev err = %v, want ErrRevConflict", err)
+	}
+
+	if _, err := s.Patch(item.ID, PatchFields{Content: str("v2"), ExpectedRev: 1}); err != nil {
+		t.Fatalf("Patch with current rev: %v", err)
+	}
+	if _, err := s.Patch(item.ID, PatchFields{Content: str("stale"), ExpectedRev: 1}); err != ErrRevConflict {
+		t.Errorf("stale rev err = %v, want ErrRevConflict", err)
+	}
+
+	s.Create("Taken", "", nil, nil, "")
+	if _, err := s.Patch(item.ID, PatchFields{Title: str("taken")}); err == nil || !strings.Contains(err.Error(), "UNIQUE constraint") {
+		t.Errorf("duplicate title err = %v, want UNIQUE constraint", err)
+	}
+
+	s.Delete(item.ID)
+	if _, err := s.Patch(item.ID, PatchFields{Content: str("gone")}); err != sql.ErrNoRows {
+		t.Errorf("deleted item err = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := s.Patch(item.ID, PatchFields{}); err != sql.ErrNoRows {
+		t.Errorf("empty patch of deleted item err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestSearchOmitSnippet(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-nosnippet-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	s.Create("Deploy guide", "how to deploy the service", nil, []string{"ops"}, "")
+
+	full, _ := s.SearchWithOptions("deploy", SearchOptions{Highlights: true})
+	light, err := s.SearchWithOptions("deploy", SearchOptions{Highlights: true, OmitSnippet: true})
+	if err != nil {
+		t.Fatalf("SearchWithOptions: %v", err)
+	}
+	if len(full) != 1 || len(light) != 1 {
+		t.Fatalf("results = %d and %d, want 1 each", len(full), len(light))
+	}
+	if full[0].Snippet == "" || len(full[0].Highlights) == 0 {
+		t.Errorf("default result = %+v, want a snippet with highlights", full[0])
+	}
+	if light[0].Snippet != "" || light[0].Highlights != nil {
+		t.Errorf("snippet = %q, highlights = %v, want neither", light[0].Snippet, light[0].Highlights)
+	}
+	if light[0].Item.Title != "Deploy guide" || len(light[0].Item.Tags) != 1 || light[0].Rank != full[0].Rank {
+		t.Errorf("light result = %+v, want the same item and rank", light[0])
+	}
+	if full[0].Item.Content == "" || light[0].Item.Content != "" {
+		t.Errorf("content = %q and %q, want it only in the default result", full[0].Item.Content, light[0].Item.Content)
+	}
+}
+
+// BenchmarkSearchSnippet compares searches with and without snippets on a
+// corpus where every item matches, so snippet() runs once per result.
+func BenchmarkSearchSnippet(b *testing.B) {
+	tmpFile, _ := os.CreateTemp("", "cue-bench-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	body := strings.Repeat("lorem ipsum dolor sit amet consectetur ", 100) + "needle " + strings.Repeat("adipiscing elit sed do ", 100)
+	err := s.WithTx(func(tx *Tx) error {
+		for i := 0; i < 2000; i++ {
+			if _, err := tx.Create(fmt.Sprintf("Note %d", i), body, nil, nil, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bc := range []struct {
+		name string
+		opts SearchOptions
+	}{
+		{"snippet", SearchOptions{Limit: 100}},
+		{"no_snippet", SearchOptions{Limit: 100, OmitSnippet: true}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := s.SearchWithOptions("needle", bc.opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// benchWords is the vocabulary for BenchmarkSearch notes. The first few
+// words are common; later ones are rare, as in real notes.
+var benchWords = strings.Fields(`the a to and of in for with on deploy service config database
+	backup query index release build test server client cache token user
+	migration rollback latency timeout retry queue worker cluster replica
+	kubernetes postgres sqlite terraform grafana prometheus nginx certificate`)
+
+// benchNote returns a markdown note of roughly 300 words built from r.
+func benchNote(r *rand.Rand) string {
+	var b strings.Builder
+	word := func() string {
+		// Squaring skews picks towards the common words at the front
+		f := r.Float64()
+		return benchWords[int(f*f*float64(len(benchWords)))]
+	}
+	for section := 0; section < 3; section++ {
+		fmt.Fprintf(&b, "## %s %s\n\n", word(), word())
+		for i := 0; i < 60; i++ {
+			b.WriteString(word())
+			b.WriteString(" ")
+		}
+		fmt.Fprintf(&b, "\n\n- `%s %s`\n- [%s](https://example.com/%s)\n\n", word(), word(), word(), word())
+	}
+	return b.String()
+}
+
+// BenchmarkSearch guards search latency on a 5,000-note corpus where
+// common terms match most notes and "zookeeper" matches 1%. See
+// docs/REFERENCE.md for the expected figures.
+func BenchmarkSearch(b *testing.B) {
+	tmpFile, _ := os.CreateTemp("", "cue-bench-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	r := rand.New(rand.NewPCG(1, 2))
+	err := s.WithTx(func(tx *Tx) error {
+		for i := 0; i < 5000; i++ {
+			note := benchNote(r)
+			if i%100 == 0 {
+				note += "zookeeper\n"
+			}
+			if _, err := tx.Create(fmt.Sprintf("Note %d", i), note, nil, nil, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bc := range []struct {
+		name  string
+		query string
+		opts  SearchOptions
+	}{
+		{"term_common", "deploy", SearchOptions{}},
+		{"term_rare", "zookeeper", SearchOptions{}},
+		{"phrase", `"rollback latency"`, SearchOptions{}},
+		{"prefix", "kube", SearchOptions{Prefix: true}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := s.SearchWithOptions(bc.query, bc.opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestSetClock(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	c := clock.NewFake(time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC))
+	s.SetClock(c)
+
+	item, err := s.Create("Clocked", "", nil, nil, "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if want := c.Now(); !item.CreatedAt.Equal(want) || !item.UpdatedAt.Equal(want) {
+		t.Errorf("timestamps = %v, %v, want %v", item.CreatedAt, item.UpdatedAt, want)
+	}
+
+	c.Advance(time.Hour)
+	updated, err := s.Update(item.ID, "Clocked", "body", nil, nil, 0)
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if want := c.Now(); !updated.UpdatedAt.Equal(want) || !updated.CreatedAt.Equal(item.CreatedAt) {
+		t.Errorf("after update = %v, %v, want created %v, updated %v", updated.CreatedAt, updated.UpdatedAt, item.CreatedAt, want)
+	}
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// normalizeTags trims, lowercases, and de-duplicates tags, dropping empty
+// ones. The result is sorted and never nil.
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	out := []string{}
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// setTags inserts tags for an item within a transaction.
+func setTags(tx *sql.Tx, itemID string, tags []string) error {
+	for _, tag := range tags {
+		if _, err := tx.Exec("INSERT INTO item_tags (item_id, tag) VALUES (?, ?)", itemID, tag); err != nil {
+			return fmt.Errorf("insert tag: %w", err)
+		}
+	}
+	return nil
+}
+
+// tagFilter returns a WHERE clause fragment matching rows whose idColumn
+// refers to an item carrying every one of tags.
+func tagFilter(idColumn string, tags []string) (string, []any) {
+	placeholders := make([]string, len(tags))
+	args := make([]any, 0, len(tags)+1)
+	for i, t := range tags {
+		placeholders[i] = "?"
+		args = append(args, t)
+	}
+	args = append(args, len(tags))
+	clause := idColumn + ` IN (
+		SELECT item_id FROM item_tags
+		WHERE tag IN (` + strings.Join(placeholders, ", ") + `)
+		GROUP BY item_id
+		HAVING COUNT(*) = ?
+	)`
+	return clause, args
+}
+
+// tagsFor returns the tags for each of the given item IDs.
+func (s *Store) tagsFor(ctx context.Context, ids []string) (map[string][]string, error) {
+	return queryTags(ctx, s.db, ids)
+}
+
+// queryTags is tagsFor reading through q, so a transaction sees its own
+// writes.
+func queryTags(ctx context.Context, q querier, ids []string) (map[string][]string, error) {
+	out := make(map[string][]string, len(ids))
+	if len(ids) == 0 {
+		return out, nil
+	}
+
+	placeholders := make([]string, len(ids))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+
+	rows, err := q.QueryContext(ctx,
+		"SELECT item_id, tag FROM item_tags WHERE item_id IN ("+strings.Join(placeholders, ", ")+") ORDER BY tag",
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query tags: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return nil, fmt.Errorf("scan tag: %w", err)
+		}
+		out[id] = append(out[id], tag)
+	}
+	return out, rows.Err()
+}
+
+// loadTags fills in the Tags field of each item.
+func (s *Store) loadTags(ctx context.Context, items []Item) error {
+	ids := make([]string, len(items))
+	for i := range items {
+		ids[i] = items[i].ID
+	}
+	tags, err := s.tagsFor(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		items[i].Tags = tags[items[i].ID]
+		if items[i].Tags == nil {
+			items[i].Tags = []string{}
+		}
+	}
+	return nil
+}
+
+// loadResultTags fills in the Tags field of each search result's item.
+func (s *Store) loadResultTags(ctx context.Context, results []SearchResult) error {
+	ids := make([]string, len(results))
+	for i := range results {
+		ids[i] = results[i].Item.ID
+	}
+	tags, err := s.tagsFor(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for i := range results {
+		results[i].Item.Tags = tags[results[i].Item.ID]
+		if results[i].Item.Tags == nil {
+			results[i].Item.Tags = []string{}
+		}
+	}
+	return nil
+}
+
+// scanItemWithTags scans a single item row and loads its tags.
+func (s *Store) scanItemWithTags(ctx context.Context, row *sql.Row) (*Item, error) {
+	item, err := scanItem(row)
+	if err != nil {
+		return nil, err
+	}
+	items := []Item{*item}
+	if err := s.loadTags(ctx, items); err != nil {
+		return nil, err
+	}
+	return &items[0], nil
+}
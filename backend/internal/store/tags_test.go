@@ -0,0 +1,144 @@
+package store
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []string
+		want  []string
+	}{
+		{"nil", nil, []string{}},
+		{"trims and lowercases", []string{" Go ", "SQL"}, []string{"go", "sql"}},
+		{"drops empty", []string{"", "  ", "a"}, []string{"a"}},
+		{"dedupes", []string{"x", "X", "x "}, []string{"x"}},
+		{"sorts", []string{"b", "a", "c"}, []string{"a", "b", "c"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := normalizeTags(tc.input); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("normalizeTags(%q) = %q, want %q", tc.input, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTags(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-tags-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	a, err := s.Create("Alpha", "shared content", nil, []string{"go", "Work"}, "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if !reflect.DeepEqual(a.Tags, []string{"go", "work"}) {
+		t.Errorf("tags = %q, want [go work]", a.Tags)
+	}
+	s.Create("Beta", "shared content", nil, []string{"go"}, "")
+	s.Create("Gamma", "shared content", nil, nil, "")
+
+	got, _ := s.Get(a.ID)
+	if !reflect.DeepEqual(got.Tags, []string{"go", "work"}) {
+		t.Errorf("Get tags = %q, want [go work]", got.Tags)
+	}
+
+	t.Run("ListSingleTag", func(t *testing.T) {
+		items, err := s.ListWithOptions(ListOptions{Tags: []string{"go"}})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if len(items) != 2 {
+			t.Errorf("len = %d, want 2", len(items))
+		}
+	})
+
+	t.Run("ListAllTags", func(t *testing.T) {
+		items, _ := s.ListWithOptions(ListOptions{Tags: []string{"go", "work"}})
+		if len(items) != 1 || items[0].Title != "Alpha" {
+			t.Errorf("items = %v, want only Alpha", items)
+		}
+	})
+
+	t.Run("ListUntaggedHasEmptySlice", func(t *testing.T) {
+		items, _ := s.List(10, 0)
+		for _, it := range items {
+			if it.Tags == nil {
+				t.Errorf("%s: tags = nil, want empty slice", it.Title)
+			}
+		}
+	})
+
+	t.Run("SearchByTag", func(t *testing.T) {
+		results, err := s.SearchWithOptions("shared", SearchOptions{Tags: []string{"work"}})
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		if len(results) != 1 || results[0].Item.Title != "Alpha" {
+			t.Errorf("results = %v, want only Alpha", results)
+		}
+		if !reflect.DeepEqual(results[0].Item.Tags, []string{"go", "work"}) {
+			t.Errorf("result tags = %q", results[0].Item.Tags)
+		}
+	})
+
+	t.Run("UpdateNilKeepsTags", func(t *testing.T) {
+		updated, err := s.Update(a.ID, "Alpha", "shared content", nil, nil, 0)
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if len(updated.Tags) != 2 {
+			t.Errorf("tags = %q, want 2 tags kept", updated.Tags)
+		}
+	})
+
+	t.Run("UpdateReplacesTags", func(t *testing.T) {
+		updated, _ := s.Update(a.ID, "Alpha", "shared content", nil, []string{"home"}, 0)
+		if !reflect.DeepEqual(updated.Tags, []string{"home"}) {
+			t.Errorf("tags = %q, want [home]", updated.Tags)
+		}
+		updated, _ = s.Update(a.ID, "Alpha", "shared content", nil, []string{}, 0)
+		if len(updated.Tags) != 0 {
+			t.Errorf("tags = %q, want none", updated.Tags)
+		}
+	})
+
+	t.Run("PurgeCascades", func(t *testing.T) {
+		b, _ := s.GetByTitle("Beta")
+		s.Delete(b.ID)
+		s.PurgeDeleted(0)
+		var n int
+		s.db.QueryRow("SELECT COUNT(*) FROM item_tags WHERE item_id = ?", b.ID).Scan(&n)
+		if n != 0 {
+			t.Errorf("orphan tags = %d, want 0", n)
+		}
+	})
+}
+
+func TestCreateRollsBackOnTagFailure(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-tagsrollback-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	// Break tag inserts so the item insert must be rolled back
+	s.db.Exec("DROP TABLE item_tags")
+
+	if _, err := s.Create("Doomed", "content", nil, []string{"x"}, ""); err == nil {
+		t.Fatal("expected error")
+	}
+	var n int
+	s.db.QueryRow("SELECT COUNT(*) FROM items WHERE title = 'Doomed'").Scan(&n)
+	if n != 0 {
+		t.Error("item should not exist after rolled back create")
+	}
+}
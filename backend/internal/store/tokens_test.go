@@ -0,0 +1,214 @@
+package store
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alanp/cue/internal/clock"
+)
+
+func TestTokenScopes(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-tokens-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	expires := time.Now().Add(time.Hour)
+	if err := s.CreateToken("tok_a", "alice", "ci", []byte("hash-a"), expires, []string{"items:read"}); err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+	if err := s.CreateToken("tok_b", "alice", "legacy", []byte("hash-b"), expires, nil); err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+
+	tok, err := s.GetTokenByID("tok_a")
+	if err != nil {
+		t.Fatalf("GetTokenByID: %v", err)
+	}
+	if len(tok.Scopes) != 1 || tok.Scopes[0] != "items:read" {
+		t.Errorf("scopes = %v, want [items:read]", tok.Scopes)
+	}
+
+	tokens, _ := s.ListTokens("alice")
+	for _, tk := range tokens {
+		if tk.ID == "tok_b" && (tk.Scopes == nil || len(tk.Scopes) != 0) {
+			t.Errorf("legacy scopes = %#v, want empty slice", tk.Scopes)
+		}
+	}
+}
+
+func TestUpdateTokenName(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-tokens-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	s.CreateToken("tok_a", "alice", "ci", []byte("hash-a"), time.Now().Add(time.Hour), nil)
+
+	if err := s.UpdateTokenName("tok_a", "bob", "stolen"); err != sql.ErrNoRows {
+		t.Errorf("rename by non-owner err = %v, want sql.ErrNoRows", err)
+	}
+	if err := s.UpdateTokenName("tok_missing", "alice", "x"); err != sql.ErrNoRows {
+		t.Errorf("rename missing err = %v, want sql.ErrNoRows", err)
+	}
+	if err := s.UpdateTokenName("tok_a", "alice", "deploy"); err != nil {
+		t.Fatalf("UpdateTokenName: %v", err)
+	}
+	tok, _ := s.GetTokenByID("tok_a")
+	if tok.Name != "deploy" {
+		t.Errorf("name = %q, want deploy", tok.Name)
+	}
+}
+
+func TestValidateTokenHashRecordsIP(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-tokens-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	s.CreateToken("tok_a", "alice", "ci", []byte("hash-a"), time.Now().Add(time.Hour), nil)
+
+	tok, _ := s.GetTokenByID("tok_a")
+	if tok.LastUsedIP != "" || tok.LastUsedAt != nil {
+		t.Errorf("unused token: ip = %q, at = %v", tok.LastUsedIP, tok.LastUsedAt)
+	}
+
+	for _, ip := range []string{"192.0.2.1", "198.51.100.7"} {
+		if _, err := s.ValidateTokenHash([]byte("hash-a"), ip); err != nil {
+			t.Fatalf("ValidateTokenHash: %v", err)
+		}
+	}
+
+	tok, _ = s.GetTokenByID("tok_a")
+	if tok.LastUsedIP != "198.51.100.7" {
+		t.Errorf("last_used_ip = %q, want 198.51.100.7", tok.LastUsedIP)
+	}
+	if tok.LastUsedAt == nil {
+		t.Error("last_used_at not set")
+	}
+}
+
+func TestDeleteExpiredTokens(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-tokens-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	s.CreateToken("tok_old", "alice", "old", []byte("hash-old"), time.Now().Add(-time.Hour), nil)
+	s.CreateToken("tok_new", "alice", "new", []byte("hash-new"), time.Now().Add(time.Hour), nil)
+
+	n, err := s.DeleteExpiredTokens()
+	if err != nil {
+		t.Fatalf("DeleteExpiredTokens: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("deleted = %d, want 1", n)
+	}
+	if _, err := s.GetTokenByID("tok_old"); err != sql.ErrNoRows {
+		t.Errorf("expired token: err = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := s.GetTokenByID("tok_new"); err != nil {
+		t.Errorf("valid token was removed: %v", err)
+	}
+
+	if n, _ := s.DeleteExpiredTokens(); n != 0 {
+		t.Errorf("second pass deleted = %d, want 0", n)
+	}
+}
+
+func TestDeleteExpiredTokensWithLeeway(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-tokens-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+	c := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	s.SetClock(c)
+
+	hash := []byte("hash-recent")
+	s.CreateToken("tok_recent", "alice", "recent", hash, c.Now().Add(-30*time.Second), nil)
+	s.CreateToken("tok_stale", "alice", "stale", []byte("hash-stale"), c.Now().Add(-2*time.Minute), nil)
+
+	// A token still accepted within the leeway survives cleanup
+	n, err := s.DeleteExpiredTokensWithLeeway(time.Minute)
+	if err != nil || n != 1 {
+		t.Fatalf("deleted = %d, err = %v, want 1", n, err)
+	}
+	if _, err := s.LookupTokenHash(hash, time.Minute); err != nil {
+		t.Errorf("token inside the leeway after cleanup: %v", err)
+	}
+
+	c.Advance(31 * time.Second)
+	if n, _ := s.DeleteExpiredTokensWithLeeway(time.Minute); n != 1 {
+		t.Errorf("deleted = %d once past the leeway, want 1", n)
+	}
+}
+
+func TestCountActiveTokens(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-tokens-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	s.CreateToken("tok_a", "alice", "a", []byte("hash-a"), time.Now().Add(time.Hour), nil)
+	s.CreateToken("tok_b", "alice", "b", []byte("hash-b"), time.Now().Add(time.Hour), nil)
+	s.CreateToken("tok_old", "alice", "old", []byte("hash-old"), time.Now().Add(-time.Hour), nil)
+	s.CreateToken("tok_bob", "bob", "bob", []byte("hash-bob"), time.Now().Add(time.Hour), nil)
+
+	if n, err := s.CountActiveTokens("alice"); err != nil || n != 2 {
+		t.Fatalf("CountActiveTokens = %d, %v, want 2", n, err)
+	}
+
+	// A token that expires frees its slot
+	past := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	s.db.Exec("UPDATE tokens SET expires_at = ? WHERE id = 'tok_a'", past)
+	if n, _ := s.CountActiveTokens("alice"); n != 1 {
+		t.Errorf("after expiry = %d, want 1", n)
+	}
+	if n, _ := s.CountActiveTokens("carol"); n != 0 {
+		t.Errorf("unknown user = %d, want 0", n)
+	}
+}
+
+func TestDeleteAllTokens(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-tokens-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	s.CreateToken("tok_a", "alice", "a", []byte("hash-a"), time.Now().Add(time.Hour), nil)
+	s.CreateToken("tok_old", "alice", "old", []byte("hash-old"), time.Now().Add(-time.Hour), nil)
+	s.CreateToken("tok_bob", "bob", "bob", []byte("hash-bob"), time.Now().Add(time.Hour), nil)
+
+	n, err := s.DeleteAllTokens("alice")
+	if err != nil {
+		t.Fatalf("DeleteAllTokens: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("deleted = %d, want 2", n)
+	}
+	if tokens, _ := s.ListTokens("alice"); len(tokens) != 0 {
+		t.Errorf("alice tokens = %+v", tokens)
+	}
+	if _, err := s.GetTokenByID("tok_bob"); err != nil {
+		t.Errorf("bob's token was removed: %v", err)
+	}
+	if n, _ := s.DeleteAllTokens("alice"); n != 0 {
+		t.Errorf("second pass deleted = %d, want 0", n)
+	}
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// querier is the subset of *sql.DB and *sql.Tx shared by code that runs
+// both inside and outside a transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Tx is a transaction opened by WithTx. Its methods behave like the Store
+// methods of the same name, but nothing they write is visible to other
+// readers until the transaction commits, and their events are published
+// only then.
+type Tx struct {
+	s   *Store
+	tx  *sql.Tx
+	ctx context.Context
+
+	events []ItemEvent // Published in order after commit
+}
+
+// WithTx runs fn in a transaction, committing if fn returns nil and rolling
+// back otherwise. fn must use tx rather than the Store: the transaction
+// holds the write lock, so Store writes would wait for it until the busy
+// timeout.
+func (s *Store) WithTx(fn func(tx *Tx) error) error {
+	return s.WithTxContext(context.Background(), fn)
+}
+
+// WithTxContext is WithTx with a context that cancels the transaction.
+func (s *Store) WithTxContext(ctx context.Context, fn func(tx *Tx) error) error {
+	sqlTx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer sqlTx.Rollback()
+
+	tx := &Tx{s: s, tx: sqlTx, ctx: ctx}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := sqlTx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	for _, ev := range tx.events {
+		s.publish(ev.Type, ev.ItemID, ev.Title)
+	}
+	return nil
+}
+
+// Create is Store.Create within the transaction.
+func (t *Tx) Create(title, content string, link *string, tags []string, createdBy string) (*Item, error) {
+	item, err := t.s.insertItem(t.ctx, t.tx, title, content, link, tags, createdBy, "")
+	if err != nil {
+		return nil, err
+	}
+	t.queue(EventCreated, item.ID, item.Title)
+	return item, nil
+}
+
+// Get is Store.Get within the transaction, so it sees the transaction's
+// own writes.
+func (t *Tx) Get(id string) (*Item, error) {
+	item, err := scanItem(t.tx.QueryRowContext(t.ctx,
+		"SELECT "+selectItemColumns("")+" FROM items WHERE id = ? AND deleted_at IS NULL",
+		id,
+	))
+	if err != nil {
+		return nil, err
+	}
+	tags, err := queryTags(t.ctx, t.tx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	item.Tags = tags[id]
+	if item.Tags == nil {
+		item.Tags = []string{}
+	}
+	return item, nil
+}
+
+// Update is Store.Update within the transaction.
+func (t *Tx) Update(id, title, content string, link *string, tags []string, expectedRev int) (*Item, error) {
+	title, err := t.s.updateItem(t.ctx, t.tx, id, title, content, link, tags, nil, expectedRev)
+	if err != nil {
+		return nil, err
+	}
+	t.queue(EventUpdated, id, title)
+	return t.Get(id)
+}
+
+// Delete is Store.Delete within the transaction.
+func (t *Tx) Delete(id string) error {
+	if err := t.s.trashItem(t.ctx, t.tx, id); err != nil {
+		return err
+	}
+	t.queue(EventDeleted, id, "")
+	return nil
+}
+
+func (t *Tx) queue(typ, id, title string) {
+	t.events = append(t.events, ItemEvent{Type: typ, ItemID: id, Title: title})
+}
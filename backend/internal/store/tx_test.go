@@ -0,0 +1,100 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWithTxRollback(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-tx-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	errAbort := errors.New("abort")
+	err := s.WithTx(func(tx *Tx) error {
+		a, err := tx.Create("First", "", nil, []string{"x"}, "")
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Create("Second", "", nil, nil, ""); err != nil {
+			return err
+		}
+		// The transaction sees its own writes
+		if got, err := tx.Get(a.ID); err != nil || got.Title != "First" || len(got.Tags) != 1 {
+			t.Errorf("Get in tx = %v, %v", got, err)
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("WithTx err = %v, want %v", err, errAbort)
+	}
+
+	if n, _ := s.Count(); n != 0 {
+		t.Errorf("Count after rollback = %d, want 0", n)
+	}
+	for _, title := range []string{"First", "Second"} {
+		if _, err := s.GetByTitle(title); err != sql.ErrNoRows {
+			t.Errorf("GetByTitle(%q) err = %v, want sql.ErrNoRows", title, err)
+		}
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("rolled-back transaction published %+v", ev)
+	default:
+	}
+}
+
+func TestWithTxCommit(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-tx-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	old, _ := s.Create("Old", "", nil, nil, "")
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	var created *Item
+	err := s.WithTx(func(tx *Tx) error {
+		var err error
+		if created, err = tx.Create("New", "", nil, nil, ""); err != nil {
+			return err
+		}
+		if _, err := tx.Update(created.ID, "Renamed", "body", nil, nil, 0); err != nil {
+			return err
+		}
+		return tx.Delete(old.ID)
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+
+	if got, err := s.Get(created.ID); err != nil || got.Title != "Renamed" || got.Rev != 2 {
+		t.Errorf("Get after commit = %v, %v", got, err)
+	}
+	if _, err := s.Get(old.ID); err != sql.ErrNoRows {
+		t.Errorf("deleted item err = %v, want sql.ErrNoRows", err)
+	}
+
+	for _, typ := range []string{EventCreated, EventUpdated, EventDeleted} {
+		select {
+		case ev := <-events:
+			if ev.Type != typ {
+				t.Errorf("event = %+v, want %q", ev, typ)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q event", typ)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DefaultMaxVersions is the number of versions retained per item unless
+// changed with SetMaxVersions.
+const DefaultMaxVersions = 50
+
+// ItemVersion is a snapshot of an item's state before an update.
+type ItemVersion struct {
+	ItemID    string    `json:"itemId"`
+	Version   int       `json:"version"`
+	Title     string    `json:"title"`
+	Link      *string   `json:"link,omitempty"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"` // When the snapshot was taken
+}
+
+// SetMaxVersions caps how many versions are kept per item. Older versions
+// are pruned on the next update. n <= 0 keeps every version.
+func (s *Store) SetMaxVersions(n int) {
+	s.maxVersions = n
+}
+
+func migrateV4(db *sql.DB) error {
+	schema := `
+		CREATE TABLE IF NOT EXISTS item_versions (
+			item_id TEXT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+			version INTEGER NOT NULL,
+			title TEXT NOT NULL,
+			link TEXT,
+			content TEXT NOT NULL,
+			created_at TEXT NOT NULL,
+			PRIMARY KEY (item_id, version)
+		);
+	`
+	_, err := db.Exec(schema)
+	return err
+}
+
+// snapshotVersion records the current state of a live item as a new version
+// and prunes versions beyond the retention cap. Must run before the update.
+func (s *Store) snapshotVersion(tx *sql.Tx, id string, now string) error {
+	_, err := tx.Exec(`
+		INSERT INTO item_versions (item_id, version, title, link, content, created_at)
+		SELECT id,
+			COALESCE((SELECT MAX(version) FROM item_versions WHERE item_id = items.id), 0) + 1,
+			title, link, content, ?
+		FROM items WHERE id = ? AND deleted_at IS NULL
+	`, now, id)
+	if err != nil {
+		return fmt.Errorf("snapshot version: %w", err)
+	}
+
+	if s.maxVersions <= 0 {
+		return nil
+	}
+	_, err = tx.Exec(`
+		DELETE FROM item_versions
+		WHERE item_id = ? AND version <= (SELECT MAX(version) FROM item_versions WHERE item_id = ?) - ?
+	`, id, id, s.maxVersions)
+	if err != nil {
+		return fmt.Errorf("prune versions: %w", err)
+	}
+	return nil
+}
+
+// ListVersions returns the retained versions of a live item, newest first.
+// Returns sql.ErrNoRows if the item does not exist.
+func (s *Store) ListVersions(id string) ([]ItemVersion, error) {
+	if _, err := s.Get(id); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(
+		"SELECT item_id, version, title, link, content, created_at FROM item_versions WHERE item_id = ? ORDER BY version DESC",
+		id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []ItemVersion{}
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan version: %w", err)
+		}
+		versions = append(versions, *v)
+	}
+	return versions, rows.Err()
+}
+
+// GetVersion returns a single version of a live item.
+func (s *Store) GetVersion(id string, version int) (*ItemVersion, error) {
+	row := s.db.QueryRow(`
+		SELECT v.item_id, v.version, v.title, v.link, v.content, v.created_at
+		FROM item_versions v
+		JOIN items i ON i.id = v.item_id
+		WHERE v.item_id = ? AND v.version = ? AND i.deleted_at IS NULL
+	`, id, version)
+	return scanVersion(row)
+}
+
+// RestoreVersion brings back an old version's title, link, and content.
+// It goes through Update, so the current state is itself kept as a new
+// version and history is never rewritten.
+func (s *Store) RestoreVersion(id string, version int) (*Item, error) {
+	v, err := s.GetVersion(id, version)
+	if err != nil {
+		return nil, err
+	}
+	return s.Update(id, v.Title, v.Content, v.Link, nil, 0)
+}
+
+func scanVersion(sc rowScanner) (*ItemVersion, error) {
+	var v ItemVersion
+	var link sql.NullString
+	var createdAt string
+	if err := sc.Scan(&v.ItemID, &v.Version, &v.Title, &link, &v.Content, &createdAt); err != nil {
+		return nil, err
+	}
+	if link.Valid {
+		v.Link = &link.String
+	}
+	v.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	return &v, nil
+}
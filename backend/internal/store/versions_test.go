@@ -0,0 +1,84 @@
+package store
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+)
+
+func TestVersions(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-versions-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+
+	item, _ := s.Create("Draft", "v1", nil, nil, "")
+	s.Update(item.ID, "Draft", "v2", nil, nil, 0)
+	s.Update(item.ID, "Final", "v3", nil, nil, 0)
+
+	versions, err := s.ListVersions(item.ID)
+	if err != nil {
+		t.Fatalf("ListVersions: %v", err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("len = %d, want 2", len(versions))
+	}
+	if versions[0].Version != 2 || versions[0].Content != "v2" {
+		t.Errorf("newest = %+v, want version 2 with v2", versions[0])
+	}
+	if versions[1].Version != 1 || versions[1].Content != "v1" {
+		t.Errorf("oldest = %+v, want version 1 with v1", versions[1])
+	}
+
+	v, err := s.GetVersion(item.ID, 1)
+	if err != nil {
+		t.Fatalf("GetVersion: %v", err)
+	}
+	if v.Title != "Draft" {
+		t.Errorf("title = %q, want Draft", v.Title)
+	}
+	if _, err := s.GetVersion(item.ID, 99); err != sql.ErrNoRows {
+		t.Errorf("missing version err = %v, want sql.ErrNoRows", err)
+	}
+
+	restored, err := s.RestoreVersion(item.ID, 1)
+	if err != nil {
+		t.Fatalf("RestoreVersion: %v", err)
+	}
+	if restored.Title != "Draft" || restored.Content != "v1" {
+		t.Errorf("restored = %q/%q, want Draft/v1", restored.Title, restored.Content)
+	}
+	versions, _ = s.ListVersions(item.ID)
+	if len(versions) != 3 || versions[0].Content != "v3" {
+		t.Errorf("restore should add a version of the prior state, got %+v", versions)
+	}
+
+	if _, err := s.ListVersions("missing"); err != sql.ErrNoRows {
+		t.Errorf("missing item err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestVersionsCap(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "cue-versioncap-*.db")
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, _ := New(tmpFile.Name())
+	defer s.Close()
+	s.SetMaxVersions(2)
+
+	item, _ := s.Create("Capped", "0", nil, nil, "")
+	for _, c := range []string{"1", "2", "3", "4"} {
+		s.Update(item.ID, "Capped", c, nil, nil, 0)
+	}
+
+	versions, _ := s.ListVersions(item.ID)
+	if len(versions) != 2 {
+		t.Fatalf("len = %d, want 2", len(versions))
+	}
+	if versions[0].Version != 4 || versions[1].Version != 3 {
+		t.Errorf("kept versions %d,%d, want 4,3", versions[0].Version, versions[1].Version)
+	}
+}
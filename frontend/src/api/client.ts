@@ -3,8 +3,22 @@ export interface Item {
   title: string;
   link?: string;
   content: string;
+  tags?: string[];
+  rev?: number;
+  createdBy?: string;
+  pinned?: boolean;
+  archived?: boolean;
+  color?: string;
   createdAt: string;
   updatedAt: string;
+  changedAt: string;
+}
+
+export interface Relation {
+  fromId: string;
+  toId: string;
+  type: string;
+  createdAt: string;
 }
 
 export interface SearchResult {
@@ -23,6 +37,7 @@ export interface UpdateItemRequest {
   title: string;
   content: string;
   link?: string;
+  rev?: number;
 }
 
 // Auth types
@@ -42,6 +57,15 @@ export interface TokenInfo {
   created_at: string;
   expires_at: string;
   last_used_at?: string;
+  last_used_ip?: string;
+  scopes?: string[];
+}
+
+export interface TokenIntrospection {
+  active: boolean;
+  cn?: string;
+  exp?: number;
+  scopes?: string[];
 }
 
 export interface CreateTokenRequest {
@@ -57,6 +81,15 @@ export interface CreateTokenResponse {
   expires_at: string;
 }
 
+// Every API error body has this shape; branch on code, not message
+export interface ErrorResponse {
+  error: {
+    code: string;
+    message: string;
+    param?: string;
+  };
+}
+
 export interface StatusResponse {
   version: string;
   status: string;
@@ -67,13 +100,13 @@ class ApiClient {
 
   async listItems(limit = 50, offset = 0): Promise<Item[]> {
     const res = await fetch(`${this.baseUrl}/items?limit=${limit}&offset=${offset}`);
-    if (!res.ok) throw new Error(await res.text());
+    if (!res.ok) throw await ApiError.from(res);
     return res.json();
   }
 
   async getItem(id: string): Promise<Item> {
     const res = await fetch(`${this.baseUrl}/items/${id}`);
-    if (!res.ok) throw new Error(await res.text());
+    if (!res.ok) throw await ApiError.from(res);
     return res.json();
   }
 
@@ -83,7 +116,7 @@ class ApiClient {
       headers: { 'Content-Type': 'application/json' },
       body: JSON.stringify(req),
     });
-    if (!res.ok) throw new Error(await res.text());
+    if (!res.ok) throw await ApiError.from(res);
     return res.json();
   }
 
@@ -93,30 +126,87 @@ class ApiClient {
       headers: { 'Content-Type': 'application/json' },
       body: JSON.stringify(req),
     });
-    if (!res.ok) throw new Error(await res.text());
+    if (!res.ok) throw await ApiError.from(res);
     return res.json();
   }
 
   async deleteItem(id: string): Promise<void> {
     const res = await fetch(`${this.baseUrl}/items/${id}`, { method: 'DELETE' });
-    if (!res.ok) throw new Error(await res.text());
+    if (!res.ok) throw await ApiError.from(res);
+  }
+
+  async deleteItems(ids: string[]): Promise<{ deleted: number; not_found: string[] }> {
+    const res = await fetch(`${this.baseUrl}/items/delete`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ ids }),
+    });
+    if (!res.ok) throw await ApiError.from(res);
+    return res.json();
+  }
+
+  async duplicateItem(id: string, title?: string): Promise<Item> {
+    const query = title === undefined ? '' : `?title=${encodeURIComponent(title)}`;
+    const res = await fetch(`${this.baseUrl}/items/${id}/duplicate${query}`, { method: 'POST' });
+    if (!res.ok) throw await ApiError.from(res);
+    return res.json();
+  }
+
+  async setPinned(id: string, pinned: boolean): Promise<Item> {
+    const res = await fetch(`${this.baseUrl}/items/${id}/pin`, { method: pinned ? 'POST' : 'DELETE' });
+    if (!res.ok) throw await ApiError.from(res);
+    return res.json();
+  }
+
+  async setArchived(id: string, archived: boolean): Promise<Item> {
+    const res = await fetch(`${this.baseUrl}/items/${id}/archive`, { method: archived ? 'POST' : 'DELETE' });
+    if (!res.ok) throw await ApiError.from(res);
+    return res.json();
   }
 
-  async search(query: string, limit = 20): Promise<SearchResult[]> {
-    const res = await fetch(`${this.baseUrl}/search?q=${encodeURIComponent(query)}&limit=${limit}`);
-    if (!res.ok) throw new Error(await res.text());
+  async listBacklinks(id: string): Promise<Item[]> {
+    const res = await fetch(`${this.baseUrl}/items/${id}/backlinks`);
+    if (!res.ok) throw await ApiError.from(res);
+    return res.json();
+  }
+
+  async listRelations(id: string): Promise<Relation[]> {
+    const res = await fetch(`${this.baseUrl}/items/${id}/relations`);
+    if (!res.ok) throw await ApiError.from(res);
+    return res.json();
+  }
+
+  async addRelation(id: string, to: string, type?: string): Promise<Relation> {
+    const res = await fetch(`${this.baseUrl}/items/${id}/relations`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ to, type }),
+    });
+    if (!res.ok) throw await ApiError.from(res);
+    return res.json();
+  }
+
+  async removeRelation(id: string, to: string, type?: string): Promise<void> {
+    const query = `?to=${encodeURIComponent(to)}` + (type === undefined ? '' : `&type=${encodeURIComponent(type)}`);
+    const res = await fetch(`${this.baseUrl}/items/${id}/relations${query}`, { method: 'DELETE' });
+    if (!res.ok) throw await ApiError.from(res);
+  }
+
+  async search(query: string, limit = 20, offset = 0): Promise<SearchResult[]> {
+    const res = await fetch(`${this.baseUrl}/search?q=${encodeURIComponent(query)}&limit=${limit}&offset=${offset}`);
+    if (!res.ok) throw await ApiError.from(res);
     return res.json();
   }
 
   async health(): Promise<{ status: string }> {
     const res = await fetch(`${this.baseUrl}/health`);
-    if (!res.ok) throw new Error(await res.text());
+    if (!res.ok) throw await ApiError.from(res);
     return res.json();
   }
 
   async status(): Promise<StatusResponse> {
     const res = await fetch(`${this.baseUrl}/status`);
-    if (!res.ok) throw new Error(await res.text());
+    if (!res.ok) throw await ApiError.from(res);
     return res.json();
   }
 
@@ -127,14 +217,14 @@ class ApiClient {
       // Auth required but not provided
       throw new AuthRequiredError();
     }
-    if (!res.ok) throw new Error(await res.text());
+    if (!res.ok) throw await ApiError.from(res);
     return res.json();
   }
 
   async listTokens(): Promise<TokenInfo[]> {
     const res = await fetch(`${this.baseUrl}/tokens`);
     if (res.status === 401) throw new AuthRequiredError();
-    if (!res.ok) throw new Error(await res.text());
+    if (!res.ok) throw await ApiError.from(res);
     return res.json();
   }
 
@@ -145,14 +235,44 @@ class ApiClient {
       body: JSON.stringify(req),
     });
     if (res.status === 401) throw new AuthRequiredError();
-    if (!res.ok) throw new Error(await res.text());
+    if (!res.ok) throw await ApiError.from(res);
+    return res.json();
+  }
+
+  async renameToken(id: string, name: string): Promise<TokenInfo> {
+    const res = await fetch(`${this.baseUrl}/tokens/${id}`, {
+      method: 'PATCH',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ name }),
+    });
+    if (res.status === 401) throw new AuthRequiredError();
+    if (!res.ok) throw await ApiError.from(res);
     return res.json();
   }
 
   async deleteToken(id: string): Promise<void> {
     const res = await fetch(`${this.baseUrl}/tokens/${id}`, { method: 'DELETE' });
     if (res.status === 401) throw new AuthRequiredError();
-    if (!res.ok) throw new Error(await res.text());
+    if (!res.ok) throw await ApiError.from(res);
+  }
+
+  async introspectToken(token: string): Promise<TokenIntrospection> {
+    const res = await fetch(`${this.baseUrl}/tokens/introspect`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ token }),
+    });
+    if (res.status === 401) throw new AuthRequiredError();
+    if (!res.ok) throw await ApiError.from(res);
+    return res.json();
+  }
+
+  async deleteAllTokens(): Promise<number> {
+    const res = await fetch(`${this.baseUrl}/tokens`, { method: 'DELETE' });
+    if (res.status === 401) throw new AuthRequiredError();
+    if (!res.ok) throw await ApiError.from(res);
+    const body: { revoked: number } = await res.json();
+    return body.revoked;
   }
 }
 
@@ -163,4 +283,26 @@ export class AuthRequiredError extends Error {
   }
 }
 
+export class ApiError extends Error {
+  constructor(
+    public status: number,
+    public code: string,
+    message: string,
+    public param?: string,
+  ) {
+    super(message);
+    this.name = 'ApiError';
+  }
+
+  static async from(res: Response): Promise<ApiError> {
+    const text = await res.text();
+    try {
+      const body = JSON.parse(text) as ErrorResponse;
+      return new ApiError(res.status, body.error.code, body.error.message, body.error.param);
+    } catch {
+      return new ApiError(res.status, 'unknown', text || res.statusText);
+    }
+  }
+}
+
 export const api = new ApiClient();
@@ -254,15 +254,15 @@ func TestSystemFullStack(t *testing.T) {
 
 	// Edge case tests
 	t.Run("InvalidFTS5Query", func(t *testing.T) {
-		resp, err := http.Get(baseURL + "/api/search?q=\"unclosed")
+		// Raw mode passes the unbalanced quote to FTS5, which rejects it
+		resp, err := http.Get(baseURL + "/api/search?mode=raw&q=\"unclosed")
 		if err != nil {
 			t.Fatal(err)
 		}
 		defer resp.Body.Close()
 
-		// Accept either 400 or 500 - depends on whether error contains "fts5"
-		if resp.StatusCode != 400 && resp.StatusCode != 500 {
-			t.Errorf("status = %d, want 400 or 500 for invalid FTS5 query", resp.StatusCode)
+		if resp.StatusCode != 400 {
+			t.Errorf("status = %d, want 400 for invalid FTS5 query", resp.StatusCode)
 		}
 	})
 